@@ -0,0 +1,433 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BetTypeAccumulator is a bet chaining win picks across several races; its legs are stored in bet_legs.
+const BetTypeAccumulator = "Accumulator"
+
+// minAccumulatorLegs is the fewest legs an accumulator can have; a single leg is just a win bet.
+const minAccumulatorLegs = 2
+
+// Leg statuses, stored in bet_legs.status.
+const (
+	LegStatusPending = "Pending"
+	LegStatusWon     = "Won"
+	LegStatusLost    = "Lost"
+	LegStatusVoid    = "Void" // Never settled: the accumulator lost on another leg or was voided
+)
+
+var (
+	// errAccumulatorLegs is returned when an accumulator does not have a pick in enough different races.
+	errAccumulatorLegs = errors.New("an accumulator needs a win pick in at least two different races")
+	// errLegRaceClosed is returned when a leg names a race that is not open for accumulator betting.
+	errLegRaceClosed = errors.New("leg race is not open for accumulator betting")
+)
+
+// AccumulatorLeg is one win pick of an accumulator.
+type AccumulatorLeg struct {
+	RaceID      int
+	RaceName    string
+	RaceDate    time.Time
+	ChickenID   int
+	ChickenName string
+	Odds        float64 // Win price taken for the leg
+}
+
+// AccumulatorRace is an upcoming race that accumulator legs can be picked from.
+type AccumulatorRace struct {
+	RaceInfo
+	Market *RaceMarket
+}
+
+// getAccumulatorRaces returns the scheduled fixed-odds races, soonest first.
+// Tote races have no fixed price to multiply and cannot be legs.
+func getAccumulatorRaces(q querier) ([]AccumulatorRace, error) {
+	rows, err := q.Query("SELECT id FROM races WHERE status = ? AND bet_mode = ? ORDER BY date ASC LIMIT ?",
+		RaceStatusScheduled, BetModeFixedOdds, max(scheduledRacesAhead, 1))
+	if err != nil {
+		return nil, fmt.Errorf("error querying accumulator races: %w", err)
+	}
+	var raceIDs []int
+	for rows.Next() {
+		var raceID int
+		if err := rows.Scan(&raceID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning accumulator race: %w", err)
+		}
+		raceIDs = append(raceIDs, raceID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating accumulator races: %w", err)
+	}
+
+	races := make([]AccumulatorRace, 0, len(raceIDs))
+	for _, raceID := range raceIDs {
+		info, err := getRaceDetails(q, raceID)
+		if err != nil {
+			return nil, err
+		}
+		market, err := getRaceMarket(q, raceID)
+		if err != nil {
+			return nil, err
+		}
+		races = append(races, AccumulatorRace{RaceInfo: *info, Market: market})
+	}
+	return races, nil
+}
+
+// parseAccumulatorPicks reads the legs of an accumulator form, each a "raceID:chickenID" value.
+// Empty values are races the punter left out. It returns the picked chicken keyed by race ID.
+func parseAccumulatorPicks(values []string) (map[int]int, error) {
+	picks := make(map[int]int)
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		raceStr, chickenStr, ok := strings.Cut(v, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid accumulator leg %q", v)
+		}
+		raceID, err := strconv.Atoi(raceStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid race ID in accumulator leg %q: %w", v, err)
+		}
+		chickenID, err := strconv.Atoi(chickenStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chicken ID in accumulator leg %q: %w", v, err)
+		}
+		if _, dup := picks[raceID]; dup {
+			return nil, errAccumulatorLegs
+		}
+		picks[raceID] = chickenID
+	}
+	return picks, nil
+}
+
+// priceAccumulator prices each pick at its chicken's live win price and returns the legs in race order
+// with their combined odds, rounded down to 2 decimals.
+func priceAccumulator(q querier, picks map[int]int) ([]AccumulatorLeg, float64, error) {
+	if len(picks) < minAccumulatorLegs {
+		return nil, 0, errAccumulatorLegs
+	}
+	legs := make([]AccumulatorLeg, 0, len(picks))
+	combined := 1.0
+	for raceID, chickenID := range picks {
+		var name, dateStr, status, betMode string
+		err := q.QueryRow("SELECT name, date, status, bet_mode FROM races WHERE id = ?", raceID).Scan(&name, &dateStr, &status, &betMode)
+		if err == sql.ErrNoRows {
+			return nil, 0, errLegRaceClosed
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("error fetching accumulator race %d: %w", raceID, err)
+		}
+		if status != RaceStatusScheduled || betMode != BetModeFixedOdds {
+			return nil, 0, errLegRaceClosed
+		}
+		raceDate, err := parseRaceDate(dateStr)
+		if err != nil {
+			return nil, 0, err
+		}
+		ch, err := findRaceEntrant(q, raceID, chickenID)
+		if err != nil {
+			return nil, 0, err
+		}
+		legs = append(legs, AccumulatorLeg{RaceID: raceID, RaceName: name, RaceDate: raceDate, ChickenID: chickenID, ChickenName: ch.Name, Odds: ch.CurrentOdds})
+		combined *= ch.CurrentOdds
+	}
+	sort.Slice(legs, func(i, j int) bool {
+		if !legs[i].RaceDate.Equal(legs[j].RaceDate) {
+			return legs[i].RaceDate.Before(legs[j].RaceDate)
+		}
+		return legs[i].RaceID < legs[j].RaceID
+	})
+	// The epsilon keeps exact products such as 4.5 from flooring to 4.49 through float error.
+	return legs, math.Floor(combined*oddsPrecision+1e-9) / oddsPrecision, nil
+}
+
+// insertAccumulatorLegs stores the legs of an accumulator, numbered in race order.
+func insertAccumulatorLegs(ex execer, betID int64, legs []AccumulatorLeg) error {
+	for i, leg := range legs {
+		_, err := ex.Exec("INSERT INTO bet_legs (bet_id, leg, race_id, chicken_id, odds, status) VALUES (?, ?, ?, ?, ?, ?)",
+			betID, i+1, leg.RaceID, leg.ChickenID, leg.Odds, LegStatusPending)
+		if err != nil {
+			return fmt.Errorf("error storing leg %d of accumulator %d: %w", i+1, betID, err)
+		}
+	}
+	return nil
+}
+
+// getAccumulatorLegs returns the legs of an accumulator, in race order.
+func getAccumulatorLegs(q querier, betID int) ([]AccumulatorLeg, error) {
+	rows, err := q.Query(`
+        SELECT l.race_id, r.name, r.date, l.chicken_id, c.name, l.odds
+        FROM bet_legs l
+        JOIN races r ON l.race_id = r.id
+        JOIN chickens c ON l.chicken_id = c.id
+        WHERE l.bet_id = ?
+        ORDER BY l.leg
+    `, betID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying legs of accumulator %d: %w", betID, err)
+	}
+	defer rows.Close()
+
+	var legs []AccumulatorLeg
+	for rows.Next() {
+		var leg AccumulatorLeg
+		var dateStr string
+		if err := rows.Scan(&leg.RaceID, &leg.RaceName, &dateStr, &leg.ChickenID, &leg.ChickenName, &leg.Odds); err != nil {
+			return nil, fmt.Errorf("error scanning leg of accumulator %d: %w", betID, err)
+		}
+		leg.RaceDate, _ = parseRaceDate(dateStr)
+		legs = append(legs, leg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating legs of accumulator %d: %w", betID, err)
+	}
+	return legs, nil
+}
+
+// legStakes returns what rides on each leg of an accumulator, in leg order: the stake on the first leg
+// and, on each later leg, the return of the legs before it.
+func legStakes(stake Money, legs []AccumulatorLeg) []Money {
+	riding := make([]Money, len(legs))
+	for i, leg := range legs {
+		riding[i] = stake
+		stake = fixedOddsPayout(stake, leg.Odds)
+	}
+	return riding
+}
+
+// checkAccumulatorLiability rejects an accumulator that would take the house over the liability cap of
+// any race it has a leg in. Each leg counts in its race's book as a win bet of what rides on it.
+// With errLiabilityCap it also returns the largest stake every race can still take.
+func checkAccumulatorLiability(q querier, legs []AccumulatorLeg, stake Money, limit Money) (Money, error) {
+	riding := legStakes(stake, legs)
+	maxStake := stake
+	for i, leg := range legs {
+		book, err := getRaceBook(q, leg.RaceID)
+		if err != nil {
+			return 0, err
+		}
+		selection := []int{leg.ChickenID}
+		if book.checkLiability(BetTypeWin, selection, riding[i], leg.Odds, limit) == nil {
+			continue
+		}
+		// What rides on a leg grows with the stake, so search for the largest stake that fits.
+		most := book.maxAcceptedStake(BetTypeWin, selection, leg.Odds, limit)
+		lo, hi := Money(0), maxStake
+		for lo < hi {
+			mid := lo + (hi-lo+1)/2
+			if legStakes(mid, legs)[i] <= most {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+		maxStake = lo
+	}
+	if maxStake < stake {
+		return maxStake, errLiabilityCap
+	}
+	return stake, nil
+}
+
+// getLegBets returns the pending accumulator legs run in a race as win bets for its book, each staking
+// what rides on it from the legs before. Legs of excludeUserID's accumulators are left out (none when 0).
+func getLegBets(q querier, raceID int, excludeUserID int) ([]bookBet, error) {
+	pendingStatusID, err := getPendingBetStatusID(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(`
+        SELECT l.bet_id, l.chicken_id, l.odds, b.bet_amount, p.odds
+        FROM bet_legs l
+        JOIN bets b ON l.bet_id = b.id
+        LEFT JOIN bet_legs p ON p.bet_id = l.bet_id AND p.leg < l.leg
+        WHERE l.race_id = ? AND l.status = ? AND b.bet_status_id = ? AND b.user_id != ?
+        ORDER BY l.bet_id, p.leg
+    `, raceID, LegStatusPending, pendingStatusID, excludeUserID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying accumulator legs in the book of race %d: %w", raceID, err)
+	}
+	defer rows.Close()
+
+	var bets []bookBet
+	var odds []float64
+	lastBetID := 0
+	for rows.Next() {
+		var betID, chickenID int
+		var legOdds float64
+		var stake Money
+		var earlierOdds sql.NullFloat64
+		if err := rows.Scan(&betID, &chickenID, &legOdds, &stake, &earlierOdds); err != nil {
+			return nil, fmt.Errorf("error scanning accumulator leg in the book of race %d: %w", raceID, err)
+		}
+		if betID != lastBetID {
+			bets = append(bets, bookBet{BetType: BetTypeWin, Selection: []int{chickenID}, Stake: stake})
+			odds = append(odds, legOdds)
+			lastBetID = betID
+		}
+		if earlierOdds.Valid {
+			b := &bets[len(bets)-1]
+			b.Stake = fixedOddsPayout(b.Stake, earlierOdds.Float64)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating accumulator legs in the book of race %d: %w", raceID, err)
+	}
+	for i := range bets {
+		bets[i].Payout = fixedOddsPayout(bets[i].Stake, odds[i])
+	}
+	return bets, nil
+}
+
+// pendingLeg is an unsettled accumulator leg together with its parent bet.
+type pendingLeg struct {
+	BetID, Leg, ChickenID, UserID int
+	Stake                         Money   // The accumulator's stake
+	Odds                          float64 // Combined odds
+}
+
+// getPendingLegs returns the unsettled legs run in a race whose accumulator is still pending.
+func getPendingLegs(q querier, raceID int) ([]pendingLeg, error) {
+	pendingStatusID, err := getPendingBetStatusID(q)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(`
+        SELECT l.bet_id, l.leg, l.chicken_id, b.user_id, b.bet_amount, b.odds
+        FROM bet_legs l
+        JOIN bets b ON l.bet_id = b.id
+        WHERE l.race_id = ? AND l.status = ? AND b.bet_status_id = ?
+        ORDER BY l.bet_id
+    `, raceID, LegStatusPending, pendingStatusID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying accumulator legs for race %d: %w", raceID, err)
+	}
+	defer rows.Close()
+
+	var legs []pendingLeg
+	for rows.Next() {
+		var l pendingLeg
+		if err := rows.Scan(&l.BetID, &l.Leg, &l.ChickenID, &l.UserID, &l.Stake, &l.Odds); err != nil {
+			return nil, fmt.Errorf("error scanning accumulator leg for race %d: %w", raceID, err)
+		}
+		legs = append(legs, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating accumulator legs for race %d: %w", raceID, err)
+	}
+	return legs, nil
+}
+
+// settleAccumulatorLegs settles the accumulator legs run in a finished race.
+// A losing leg loses the whole accumulator. A winning leg carries it forward to its next race,
+// and once every leg has won the stake is paid at the combined odds.
+func settleAccumulatorLegs(tx *sql.Tx, raceID int, positions map[int]int) error {
+	legs, err := getPendingLegs(tx, raceID)
+	if err != nil {
+		return err
+	}
+	if len(legs) == 0 {
+		return nil
+	}
+	wonStatusID, err := getBetStatusID(tx, "Won")
+	if err != nil {
+		return err
+	}
+	lostStatusID, err := getBetStatusID(tx, "Lost")
+	if err != nil {
+		return err
+	}
+
+	for _, l := range legs {
+		if positions[l.ChickenID] != 1 {
+			if _, err := tx.Exec("UPDATE bet_legs SET status = ? WHERE bet_id = ? AND leg = ?", LegStatusLost, l.BetID, l.Leg); err != nil {
+				return fmt.Errorf("failed to settle leg %d of accumulator %d: %w", l.Leg, l.BetID, err)
+			}
+			if _, err := tx.Exec("UPDATE bet_legs SET status = ? WHERE bet_id = ? AND status = ?", LegStatusVoid, l.BetID, LegStatusPending); err != nil {
+				return fmt.Errorf("failed to void remaining legs of accumulator %d: %w", l.BetID, err)
+			}
+			if _, err := tx.Exec("UPDATE bets SET bet_status_id = ?, settled_at = CURRENT_TIMESTAMP, actual_payout = 0 WHERE id = ?", lostStatusID, l.BetID); err != nil {
+				return fmt.Errorf("failed to update status for accumulator %d: %w", l.BetID, err)
+			}
+			if err := recordBonusWagering(tx, l.UserID, l.Stake); err != nil {
+				return err
+			}
+			log.Printf("Accumulator %d (User %d) LOST on leg %d in race %d.", l.BetID, l.UserID, l.Leg, raceID)
+			continue
+		}
+
+		if _, err := tx.Exec("UPDATE bet_legs SET status = ? WHERE bet_id = ? AND leg = ?", LegStatusWon, l.BetID, l.Leg); err != nil {
+			return fmt.Errorf("failed to settle leg %d of accumulator %d: %w", l.Leg, l.BetID, err)
+		}
+		var remaining int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM bet_legs WHERE bet_id = ? AND status = ?", l.BetID, LegStatusPending).Scan(&remaining); err != nil {
+			return fmt.Errorf("failed to count remaining legs of accumulator %d: %w", l.BetID, err)
+		}
+		if remaining > 0 {
+			log.Printf("Accumulator %d (User %d) won leg %d in race %d; %d legs to go.", l.BetID, l.UserID, l.Leg, raceID, remaining)
+			continue
+		}
+
+		payout := fixedOddsPayout(l.Stake, l.Odds)
+		if _, err := postWalletTransaction(tx, WalletTransaction{
+			UserID: l.UserID, Kind: WalletTxPayout, Amount: payout, BetID: l.BetID, RaceID: raceID, Note: "Accumulator won",
+		}); err != nil {
+			return fmt.Errorf("failed to update balance for user %d on accumulator %d: %w", l.UserID, l.BetID, err)
+		}
+		if _, err := tx.Exec("UPDATE bets SET bet_status_id = ?, settled_at = CURRENT_TIMESTAMP, actual_payout = ? WHERE id = ?", wonStatusID, payout, l.BetID); err != nil {
+			return fmt.Errorf("failed to update status for accumulator %d: %w", l.BetID, err)
+		}
+		if err := recordBonusWagering(tx, l.UserID, l.Stake); err != nil {
+			return err
+		}
+		log.Printf("Accumulator %d (User %d) WON on its last leg in race %d. Bet: %s, Odds: %.2f, Payout: %s",
+			l.BetID, l.UserID, raceID, l.Stake, l.Odds, payout)
+	}
+	return nil
+}
+
+// voidRaceAccumulators voids every pending accumulator with a leg in a race that will not be run,
+// refunding the stake. It returns how many accumulators were voided.
+func voidRaceAccumulators(tx *sql.Tx, raceID int) (int, error) {
+	legs, err := getPendingLegs(tx, raceID)
+	if err != nil {
+		return 0, err
+	}
+	if len(legs) == 0 {
+		return 0, nil
+	}
+	cancelledStatusID, err := getBetStatusID(tx, "Cancelled")
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range legs {
+		if _, err := tx.Exec("UPDATE bet_legs SET status = ? WHERE bet_id = ? AND status = ?", LegStatusVoid, l.BetID, LegStatusPending); err != nil {
+			return 0, fmt.Errorf("failed to void legs of accumulator %d: %w", l.BetID, err)
+		}
+		if _, err := postWalletTransaction(tx, WalletTransaction{
+			UserID: l.UserID, Kind: WalletTxRefund, Amount: l.Stake, BetID: l.BetID, RaceID: raceID, Note: "Accumulator void: race not run",
+		}); err != nil {
+			return 0, fmt.Errorf("failed to refund user %d for accumulator %d: %w", l.UserID, l.BetID, err)
+		}
+		if _, err := tx.Exec("UPDATE bets SET bet_status_id = ?, settled_at = CURRENT_TIMESTAMP, actual_payout = ? WHERE id = ?", cancelledStatusID, l.Stake, l.BetID); err != nil {
+			return 0, fmt.Errorf("failed to update status for accumulator %d: %w", l.BetID, err)
+		}
+		log.Printf("Accumulator %d (User %d) voided: race %d of leg %d will not be run. Refunded %s.", l.BetID, l.UserID, raceID, l.Leg, l.Stake)
+	}
+	return len(legs), nil
+}
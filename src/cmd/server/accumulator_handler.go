@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// accumulatorCalcTemplate renders the legs and return of a prospective accumulator.
+var accumulatorCalcTemplate = template.Must(template.New("accumulatorCalc").Parse(`
+    {{if .Hint}}
+        <p>Potential Win:</p>
+        <span class="winnings-hint">{{.Hint}}</span>
+    {{else}}
+        <ul class="accumulator-legs">
+            {{range .Legs}}<li>{{.RaceName}}: {{.ChickenName}} @ {{printf "%.2f" .Odds}}</li>{{end}}
+        </ul>
+        <p>Combined odds {{printf "%.2f" .Odds}}. Potential Win:</p>
+        <span class="winnings-amount">{{.Amount}} Credits</span>
+    {{end}}
+`))
+
+// accumulatorCalc is the data for accumulatorCalcTemplate.
+type accumulatorCalc struct {
+	Legs   []AccumulatorLeg
+	Odds   float64
+	Amount Money
+	Hint   string // Shown instead of an amount when the accumulator cannot be priced
+}
+
+// accumulatorErrorMessage turns an error from pricing an accumulator into a message for the punter.
+func accumulatorErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errAccumulatorLegs):
+		return fmt.Sprintf("Pick a winner in at least %d different races.", minAccumulatorLegs)
+	case errors.Is(err, errLegRaceClosed):
+		return "One of the races has closed for betting. Refresh the races and try again."
+	case errors.Is(err, errEntrantNotFound):
+		return "A selected chicken is not running in its race."
+	default:
+		return "Error pricing the accumulator."
+	}
+}
+
+// accumulatorLegNames lists the picks of an accumulator for messages.
+func accumulatorLegNames(legs []AccumulatorLeg) string {
+	names := make([]string, len(legs))
+	for i, leg := range legs {
+		names[i] = leg.ChickenName
+	}
+	return strings.Join(names, " + ")
+}
+
+// accumulatorRacesHandler renders the leg pickers of the accumulator panel.
+func accumulatorRacesHandler(w http.ResponseWriter, r *http.Request) {
+	races, err := getAccumulatorRaces(db)
+	if err != nil {
+		log.Printf("accumulatorRacesHandler: Error loading accumulator races: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := raceTemplate.ExecuteTemplate(w, "accumulator-races", races); err != nil {
+		log.Printf("accumulatorRacesHandler: Template execution error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// calculateAccumulatorHandler prices the accumulator being built at the current win prices.
+func calculateAccumulatorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("calculateAccumulatorHandler: Failed to parse form: %v", err)
+		http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+		return
+	}
+
+	var calc accumulatorCalc
+	betAmount, err := ParseMoney(r.Form.Get("accumulatorAmount"))
+	if err != nil || betAmount <= 0 {
+		calc.Hint = "Enter a positive bet amount."
+	} else if picks, err := parseAccumulatorPicks(r.Form["leg"]); err != nil {
+		calc.Hint = accumulatorErrorMessage(err)
+	} else if calc.Legs, calc.Odds, err = priceAccumulator(db, picks); err != nil {
+		calc.Hint = accumulatorErrorMessage(err)
+	} else {
+		calc.Amount = fixedOddsPayout(betAmount, calc.Odds)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := accumulatorCalcTemplate.Execute(w, calc); err != nil {
+		log.Printf("calculateAccumulatorHandler: Template execution error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// placeAccumulatorHandler places an accumulator: one stake on a win pick in each of several upcoming races.
+// Each leg is struck at its chicken's live win price and the combined odds are locked in.
+func placeAccumulatorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if sessionManager == nil {
+		log.Printf("placeAccumulatorHandler: CRITICAL: sessionManager is not initialized.")
+		http.Error(w, "Server configuration error", http.StatusInternalServerError)
+		return
+	}
+
+	currentUserID := authenticatedUserID(r)
+	if currentUserID == 0 {
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Please log in to place a bet.", NewBalance: -1})
+		return
+	}
+	var userCurrentBalanceForErrorDisplay Money = -1
+	_ = db.QueryRow("SELECT balance FROM users WHERE id = ?", currentUserID).Scan(&userCurrentBalanceForErrorDisplay)
+
+	if err := r.ParseForm(); err != nil {
+		log.Printf("placeAccumulatorHandler: Failed to parse form: %v", err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Error processing request.", NewBalance: userCurrentBalanceForErrorDisplay})
+		return
+	}
+	betAmountStr := r.FormValue("accumulatorAmount")
+	betAmount, err := ParseMoney(betAmountStr)
+	if err != nil || betAmount <= 0 {
+		log.Printf("placeAccumulatorHandler: Invalid bet amount. String: '%s', Error: %v", betAmountStr, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Invalid bet amount. Must be a positive number.", NewBalance: userCurrentBalanceForErrorDisplay})
+		return
+	}
+	picks, err := parseAccumulatorPicks(r.Form["leg"])
+	if err != nil {
+		log.Printf("placeAccumulatorHandler: Invalid legs %v: %v", r.Form["leg"], err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: accumulatorErrorMessage(err), NewBalance: userCurrentBalanceForErrorDisplay})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("placeAccumulatorHandler: Failed to begin transaction: %v", err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Database error (begin tx). Please try again later.", NewBalance: userCurrentBalanceForErrorDisplay})
+		return
+	}
+	defer tx.Rollback() // No-op once committed
+
+	legs, odds, err := priceAccumulator(tx, picks)
+	if err != nil {
+		log.Printf("placeAccumulatorHandler: Could not price legs %v for user %d: %v", picks, currentUserID, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: accumulatorErrorMessage(err), NewBalance: userCurrentBalanceForErrorDisplay})
+		return
+	}
+	legNames := accumulatorLegNames(legs)
+
+	// Each leg counts towards its race's liability cap, and no single accumulator may win more than the cap.
+	maxStake, err := checkAccumulatorLiability(tx, legs, betAmount, maxRaceLiability)
+	if err != nil && !errors.Is(err, errLiabilityCap) {
+		log.Printf("placeAccumulatorHandler: Error checking liability of legs %v: %v", picks, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Error confirming the odds.", NewBalance: userCurrentBalanceForErrorDisplay})
+		return
+	}
+	if fixedOddsPayout(betAmount, odds)-betAmount > maxRaceLiability {
+		maxStake = min(maxStake, maxStakeForLiability(maxRaceLiability, odds))
+	}
+	if maxStake < betAmount {
+		log.Printf("placeAccumulatorHandler: Accumulator of %s at %.2f refused for user %d: over the liability cap (max %s).", betAmount, odds, currentUserID, maxStake)
+		_ = betResponseTemplate.Execute(w, BetResponse{
+			Success:    false,
+			Message:    fmt.Sprintf("The most we can accept on this accumulator at %.2f is %s credits.", odds, maxStake),
+			NewBalance: userCurrentBalanceForErrorDisplay,
+		})
+		return
+	}
+
+	var currentUserBalanceInTx Money
+	if err := tx.QueryRow("SELECT balance FROM users WHERE id = ?", currentUserID).Scan(&currentUserBalanceInTx); err != nil {
+		log.Printf("placeAccumulatorHandler: Error scanning user balance for user ID %d: %v", currentUserID, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Error fetching user balance.", NewBalance: -1})
+		return
+	}
+	if currentUserBalanceInTx < betAmount {
+		_ = betResponseTemplate.Execute(w, BetResponse{
+			Success:     false,
+			Message:     fmt.Sprintf("Insufficient funds. Your balance is %s credits.", currentUserBalanceInTx),
+			NewBalance:  currentUserBalanceInTx,
+			BetAmount:   betAmount,
+			ChickenName: legNames,
+		})
+		return
+	}
+
+	if err := checkBetLimits(tx, currentUserID, betAmount, time.Now()); err != nil {
+		msg := gamblingLimitMessage(err)
+		if msg == "" {
+			log.Printf("placeAccumulatorHandler: Error checking limits of user %d: %v", currentUserID, err)
+			msg = "Error checking your betting limits."
+		} else {
+			log.Printf("placeAccumulatorHandler: Accumulator of %s by user %d refused: %v", betAmount, currentUserID, err)
+		}
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: msg, NewBalance: currentUserBalanceInTx})
+		return
+	}
+
+	pendingStatusID, err := getPendingBetStatusID(tx)
+	if err != nil {
+		log.Printf("placeAccumulatorHandler: Error from getPendingBetStatusID: %v", err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "System error: Bet status config.", NewBalance: currentUserBalanceInTx})
+		return
+	}
+
+	// The parent bet names the first leg's race and chicken; every leg, in race order, goes to bet_legs.
+	betResult, err := tx.Exec("INSERT INTO bets (user_id, race_id, chicken_id, bet_type, bet_amount, bet_status_id, odds, potential_payout) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		currentUserID, legs[0].RaceID, legs[0].ChickenID, BetTypeAccumulator, betAmount, pendingStatusID, odds, fixedOddsPayout(betAmount, odds))
+	if err != nil {
+		log.Printf("placeAccumulatorHandler: Error inserting accumulator for user %d: %v", currentUserID, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to record bet.", NewBalance: currentUserBalanceInTx})
+		return
+	}
+	betID, _ := betResult.LastInsertId()
+	if err := insertAccumulatorLegs(tx, betID, legs); err != nil {
+		log.Printf("placeAccumulatorHandler: %v", err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to record bet.", NewBalance: currentUserBalanceInTx})
+		return
+	}
+	newBalance, err := postWalletTransaction(tx, WalletTransaction{
+		UserID: currentUserID, Kind: WalletTxStake, Amount: -betAmount, BetID: int(betID), RaceID: legs[0].RaceID,
+		Note: fmt.Sprintf("%d-leg accumulator", len(legs)),
+	})
+	if err != nil {
+		log.Printf("placeAccumulatorHandler: Error debiting stake of accumulator %d from user %d: %v", betID, currentUserID, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to update balance.", NewBalance: currentUserBalanceInTx})
+		return
+	}
+	// The legs add to each race's liability, which shortens the prices of heavily exposed chickens.
+	for _, leg := range legs {
+		if err := updateCurrentOdds(tx, leg.RaceID); err != nil {
+			log.Printf("placeAccumulatorHandler: Error repricing race %d: %v", leg.RaceID, err)
+			_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to record bet.", NewBalance: currentUserBalanceInTx})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("placeAccumulatorHandler: Error committing transaction: %v", err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to finalize bet. Please try again.", NewBalance: currentUserBalanceInTx})
+		return
+	}
+	log.Printf("placeAccumulatorHandler: Accumulator %d placed by user %d: %s on %d legs at %.2f. New balance: %s",
+		betID, currentUserID, betAmount, len(legs), odds, newBalance)
+
+	w.Header().Set("HX-Trigger", "accumulatorPlaced")
+	_ = betResponseTemplate.Execute(w, BetResponse{
+		Success:     true,
+		Message:     fmt.Sprintf("%d-leg accumulator placed at combined odds %.2f!", len(legs), odds),
+		NewBalance:  newBalance,
+		BetAmount:   betAmount,
+		ChickenName: legNames,
+	})
+}
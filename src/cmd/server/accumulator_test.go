@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// scheduleTestRace opens another race for betting with the seeded chickens and returns its ID.
+func scheduleTestRace(t *testing.T, name string) int {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO races (name, date, status, sim_seed) VALUES (?, '2025-06-01 15:00:00', ?, 1)", name, RaceStatusScheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raceID, _ := result.LastInsertId()
+	if _, err := db.Exec(`
+        INSERT INTO race_entrants (race_id, chicken_id, lane, color, starting_odds, current_odds, place_odds, show_odds)
+        SELECT ?, id, id, color, odds, odds, odds, odds FROM chickens
+    `, raceID); err != nil {
+		t.Fatal(err)
+	}
+	return int(raceID)
+}
+
+// placeTestAccumulator books an accumulator the way placeAccumulatorHandler does and returns its ID.
+func placeTestAccumulator(t *testing.T, userID int, stake Money, legs ...AccumulatorLeg) int {
+	t.Helper()
+	pendingStatusID, err := getPendingBetStatusID(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	odds := 1.0
+	for _, leg := range legs {
+		odds *= leg.Odds
+	}
+	result, err := db.Exec("INSERT INTO bets (user_id, race_id, chicken_id, bet_type, bet_amount, bet_status_id, odds, potential_payout) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, legs[0].RaceID, legs[0].ChickenID, BetTypeAccumulator, stake, pendingStatusID, odds, fixedOddsPayout(stake, odds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	betID, _ := result.LastInsertId()
+	if err := insertAccumulatorLegs(db, betID, legs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := postWalletTransaction(db, WalletTransaction{UserID: userID, Kind: WalletTxStake, Amount: -stake, BetID: int(betID), RaceID: legs[0].RaceID}); err != nil {
+		t.Fatal(err)
+	}
+	return int(betID)
+}
+
+// legStatuses returns the status of each leg of an accumulator, in leg order.
+func legStatuses(t *testing.T, betID int) []string {
+	t.Helper()
+	rows, err := db.Query("SELECT status FROM bet_legs WHERE bet_id = ? ORDER BY leg", betID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var statuses []string
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func TestAccumulatorSettlesLegByLeg(t *testing.T) {
+	setupTestDB(t)
+	const punter = 2
+	first, second := scheduledTestRace, scheduleTestRace(t, "Second Leg Stakes")
+
+	carried := placeTestAccumulator(t, punter, 10*Credit,
+		AccumulatorLeg{RaceID: first, ChickenID: 1, Odds: 2.0}, AccumulatorLeg{RaceID: second, ChickenID: 2, Odds: 3.0})
+	beaten := placeTestAccumulator(t, punter, 10*Credit,
+		AccumulatorLeg{RaceID: first, ChickenID: 2, Odds: 1.8}, AccumulatorLeg{RaceID: second, ChickenID: 1, Odds: 2.5})
+
+	settleTestRace(t, first, 1, 2, 3, 4, 5)
+	if status, _ := betOutcome(t, carried); status != "Pending" {
+		t.Errorf("accumulator that won its first leg is %s, want Pending", status)
+	}
+	if got := legStatuses(t, carried); got[0] != LegStatusWon || got[1] != LegStatusPending {
+		t.Errorf("legs after winning the first = %v, want [Won Pending]", got)
+	}
+	if status, payout := betOutcome(t, beaten); status != "Lost" || payout != 0 {
+		t.Errorf("accumulator that lost its first leg is %s paying %s, want Lost paying 0.00", status, payout)
+	}
+	if got := legStatuses(t, beaten); got[0] != LegStatusLost || got[1] != LegStatusVoid {
+		t.Errorf("legs after losing the first = %v, want [Lost Void]", got)
+	}
+
+	// The winnings of the first leg ride on the second at its own price.
+	book, err := getRaceBook(db, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(book.Bets) != 1 || book.Bets[0].Stake != 20*Credit || book.Bets[0].Payout != 60*Credit {
+		t.Errorf("book of the second race = %+v, want one leg riding 20.00 to return 60.00", book.Bets)
+	}
+	if got := book.Liability(2); got != 40*Credit {
+		t.Errorf("Liability(2) = %s, want 40.00", got)
+	}
+
+	settleTestRace(t, second, 2, 1, 3, 4, 5)
+	if status, payout := betOutcome(t, carried); status != "Won" || payout != 60*Credit {
+		t.Errorf("accumulator after its last leg is %s paying %s, want Won paying 60.00", status, payout)
+	}
+}
+
+func TestVoidedRaceRefundsAccumulators(t *testing.T) {
+	setupTestDB(t)
+	const punter = 2
+	first, second := scheduledTestRace, scheduleTestRace(t, "Second Leg Stakes")
+	betID := placeTestAccumulator(t, punter, 10*Credit,
+		AccumulatorLeg{RaceID: first, ChickenID: 1, Odds: 2.0}, AccumulatorLeg{RaceID: second, ChickenID: 2, Odds: 3.0})
+	settleTestRace(t, first, 1, 2, 3, 4, 5)
+
+	if err := cancelRaceByID(db, second, "Abandoned"); err != nil {
+		t.Fatal(err)
+	}
+	if status, payout := betOutcome(t, betID); status != "Cancelled" || payout != 10*Credit {
+		t.Errorf("accumulator on a race that will not be run is %s paying %s, want Cancelled refunding 10.00", status, payout)
+	}
+	if got := legStatuses(t, betID); got[0] != LegStatusWon || got[1] != LegStatusVoid {
+		t.Errorf("legs after voiding = %v, want [Won Void]", got)
+	}
+	checkBalance(t, punter, 1000*Credit)
+}
+
+func TestAccumulatorLegsCountTowardsLiabilityCap(t *testing.T) {
+	setupTestDB(t)
+	const punter = 2
+	first, second := scheduledTestRace, scheduleTestRace(t, "Second Leg Stakes")
+	legs := []AccumulatorLeg{{RaceID: first, ChickenID: 1, Odds: 2.0}, {RaceID: second, ChickenID: 2, Odds: 3.0}}
+	placeTestAccumulator(t, punter, 10*Credit, legs...)
+
+	// The first accumulator already rides 20.00 on chicken 2 to lose 40.00; a second at the same stake makes 80.00.
+	if _, err := checkAccumulatorLiability(db, legs, 10*Credit, 80*Credit); err != nil {
+		t.Errorf("accumulator up to the cap refused: %v", err)
+	}
+	maxStake, err := checkAccumulatorLiability(db, legs, 20*Credit, 80*Credit)
+	if !errors.Is(err, errLiabilityCap) || maxStake != 10*Credit {
+		t.Errorf("accumulator over the cap: max %s, %v; want max 10.00, errLiabilityCap", maxStake, err)
+	}
+	// A win bet on the same chicken shares the exposure.
+	book, err := getRaceBook(db, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := book.checkLiability(BetTypeWin, []int{2}, 21*Credit, 3.0, 80*Credit); !errors.Is(err, errLiabilityCap) {
+		t.Errorf("win bet over the cap alongside the leg: got %v, want errLiabilityCap", err)
+	}
+}
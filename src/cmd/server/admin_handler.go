@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminFeedback is the outcome of an action taken on the admin dashboard.
+type AdminFeedback struct {
+	Message string
+	Success bool
+}
+
+// adminErrorMessage turns an error from a race action into a message for the operator.
+func adminErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errRaceAlreadyRunning):
+		return "Another race is running. Finish or cancel it first."
+	case errors.Is(err, errRaceNotScheduled):
+		return "That race is no longer scheduled."
+	case errors.Is(err, errRaceNotNext):
+		return "Races run in order. Cancel or reschedule the races due before this one first."
+	case errors.Is(err, errRaceNotRunning):
+		return "That race is not the one running."
+	case errors.Is(err, errRaceNotCancellable):
+		return "That race is not scheduled or running."
+	case errors.Is(err, errBetModeLocked):
+		return "That race already has bets, so its bet mode can no longer change."
+	case errors.Is(err, errUnknownBetMode):
+		return "Unknown bet mode."
+	case errors.Is(err, errInvalidRescheduling):
+		return fmt.Sprintf("Choose a start time in the next %d minutes.", int(maxRescheduleDelay.Minutes()))
+	default:
+		return fmt.Sprintf("The action failed: %v", err)
+	}
+}
+
+// adminDashboardHandler renders the race operations dashboard at /admin/.
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/admin/" {
+		http.NotFound(w, r)
+		return
+	}
+	dash, err := getAdminDashboard(db, time.Now())
+	if err != nil {
+		log.Printf("adminDashboardHandler: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data := PageData{Title: "Admin - Scramble Run", AdminDashboard: dash}
+	data.UserData, data.UserBalance, _, err = authenticatedUser(db, r)
+	if err != nil {
+		log.Printf("adminDashboardHandler: %v", err)
+	}
+	renderTemplateWithStatus(w, r, http.StatusOK, adminTemplate, "base.gohtml", data)
+}
+
+// adminRacesHandler renders the races panel of the dashboard. The dashboard polls it, and reloads it
+// after every action.
+func adminRacesHandler(w http.ResponseWriter, r *http.Request) {
+	dash, err := getAdminDashboard(db, time.Now())
+	if err != nil {
+		log.Printf("adminRacesHandler: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := adminTemplate.ExecuteTemplate(w, "admin-races", dash); err != nil {
+		log.Printf("adminRacesHandler: Template execution error: %v", err)
+	}
+}
+
+// adminRaceActionHandler starts, finishes, cancels or reschedules a race from the dashboard. It answers
+// with the outcome and triggers racesChanged so the races panel reloads.
+func adminRaceActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	adminID := authenticatedUserID(r)
+	action := r.FormValue("action")
+	raceID, err := strconv.Atoi(r.FormValue("raceID"))
+	if err != nil {
+		renderAdminFeedback(w, AdminFeedback{Message: "Invalid race ID."})
+		return
+	}
+	log.Printf("ADMIN: User %d requested %s of race %d.", adminID, action, raceID)
+
+	var feedback AdminFeedback
+	now := time.Now()
+	switch action {
+	case "start":
+		if err = adminStartRace(db, raceID); err == nil {
+			feedback.Message = fmt.Sprintf("Race %d started.", raceID)
+		}
+	case "finish":
+		if err = adminFinishRace(db, raceID); err == nil {
+			feedback.Message = fmt.Sprintf("Race %d finished and its bets settled.", raceID)
+		}
+	case "cancel":
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		if reason == "" {
+			reason = "Cancelled by an administrator"
+		}
+		if err = adminCancelRace(db, raceID, reason); err == nil {
+			feedback.Message = fmt.Sprintf("Race %d cancelled (%s). Pending bets refunded.", raceID, reason)
+		}
+	case "reschedule":
+		seconds, convErr := strconv.Atoi(r.FormValue("startIn"))
+		if convErr != nil {
+			err = errInvalidRescheduling
+			break
+		}
+		startAt := now.Add(time.Duration(seconds) * time.Second)
+		betMode := r.FormValue("betMode")
+		if err = adminRescheduleRace(db, raceID, startAt, betMode, now); err == nil {
+			feedback.Message = fmt.Sprintf("Race %d rescheduled for %s.", raceID, startAt.Format("15:04:05"))
+			if betMode != "" {
+				feedback.Message = fmt.Sprintf("Race %d rescheduled for %s with %s betting.", raceID, startAt.Format("15:04:05"), betMode)
+			}
+		}
+	default:
+		renderAdminFeedback(w, AdminFeedback{Message: "Unknown action."})
+		return
+	}
+
+	if err != nil {
+		log.Printf("ADMIN: %s of race %d by user %d failed: %v", action, raceID, adminID, err)
+		feedback.Message = adminErrorMessage(err)
+	} else {
+		log.Printf("ADMIN: %s", feedback.Message)
+		feedback.Success = true
+	}
+	auditAdminRaceAction(r, AuditAdminRaceAction, raceID, action, r.FormValue("reason"), err, feedback.Message)
+	w.Header().Set("HX-Trigger", "racesChanged")
+	renderAdminFeedback(w, feedback)
+}
+
+// auditAdminRaceAction records an administrator's request to act on a race and its outcome, failed or not.
+// The race's own state changes are recorded separately, by the race logic. raceID is 0 when no race was affected.
+func auditAdminRaceAction(r *http.Request, auditAction string, raceID int, action, reason string, err error, result string) {
+	event := newAuditEvent(requestActor(r), auditAction, "race", raceID)
+	outcome := map[string]interface{}{"action": action, "succeeded": err == nil}
+	if err != nil {
+		outcome["error"] = err.Error()
+	} else {
+		outcome["result"] = result
+	}
+	event.After = auditValue(outcome)
+	event.Reason = strings.TrimSpace(reason)
+	logAuditEvent(event)
+}
+
+// renderAdminFeedback renders the outcome of a dashboard action.
+func renderAdminFeedback(w http.ResponseWriter, feedback AdminFeedback) {
+	w.Header().Set("Content-Type", "text/html")
+	if err := adminTemplate.ExecuteTemplate(w, "admin-feedback", feedback); err != nil {
+		log.Printf("renderAdminFeedback: Template execution error: %v", err)
+	}
+}
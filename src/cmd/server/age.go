@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// minimumBettingAge is the age a user must have reached to place bets.
+const minimumBettingAge = 18
+
+// maximumPlausibleAge rejects dates of birth that are almost certainly typos.
+const maximumPlausibleAge = 120
+
+// dateOfBirthLayout is the format of users.date_of_birth, as sent by <input type="date">.
+const dateOfBirthLayout = "2006-01-02"
+
+var (
+	errInvalidDateOfBirth = errors.New("invalid date of birth")
+	errUnderAge           = errors.New("under the minimum betting age")
+	errDateOfBirthUnknown = errors.New("date of birth not recorded")
+)
+
+// parseDateOfBirth reads a date of birth and rejects dates in the future or implausibly long ago.
+func parseDateOfBirth(s string, now time.Time) (time.Time, error) {
+	dob, err := time.ParseInLocation(dateOfBirthLayout, s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q", errInvalidDateOfBirth, s)
+	}
+	if dob.After(now) || ageOn(dob, now) > maximumPlausibleAge {
+		return time.Time{}, fmt.Errorf("%w: %q", errInvalidDateOfBirth, s)
+	}
+	return dob, nil
+}
+
+// ageOn returns how old someone born on dob is on the given day, in whole years.
+func ageOn(dob, now time.Time) int {
+	age := now.Year() - dob.Year()
+	if now.Month() < dob.Month() || (now.Month() == dob.Month() && now.Day() < dob.Day()) {
+		age--
+	}
+	return age
+}
+
+// getUserAge returns a user's age, or errDateOfBirthUnknown for accounts created before dates of birth
+// were collected.
+func getUserAge(q rowQuerier, userID int, now time.Time) (int, error) {
+	var dob sql.NullString
+	if err := q.QueryRow("SELECT date_of_birth FROM users WHERE id = ?", userID).Scan(&dob); err != nil {
+		return 0, fmt.Errorf("error reading date of birth of user %d: %w", userID, err)
+	}
+	if !dob.Valid {
+		return 0, errDateOfBirthUnknown
+	}
+	born, err := time.ParseInLocation(dateOfBirthLayout, dob.String, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing date of birth of user %d: %w", userID, err)
+	}
+	return ageOn(born, now), nil
+}
+
+// checkBettingAge returns errUnderAge or errDateOfBirthUnknown unless the user is known to be old enough to bet.
+func checkBettingAge(q rowQuerier, userID int, now time.Time) error {
+	age, err := getUserAge(q, userID, now)
+	if err != nil {
+		return err
+	}
+	if age < minimumBettingAge {
+		return fmt.Errorf("%w: user %d is %d", errUnderAge, userID, age)
+	}
+	return nil
+}
+
+// correctDateOfBirth replaces a user's date of birth and records who changed it and why in
+// date_of_birth_corrections. It is the override for typos at signup and for verified documents.
+func correctDateOfBirth(ex queryExecer, userID int, dob time.Time, reason, correctedBy string) (previous sql.NullString, err error) {
+	if err := ex.QueryRow("SELECT date_of_birth FROM users WHERE id = ?", userID).Scan(&previous); err != nil {
+		return previous, fmt.Errorf("error reading date of birth of user %d: %w", userID, err)
+	}
+	value := dob.Format(dateOfBirthLayout)
+	if _, err := ex.Exec("UPDATE users SET date_of_birth = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", value, userID); err != nil {
+		return previous, fmt.Errorf("error updating date of birth of user %d: %w", userID, err)
+	}
+	_, err = ex.Exec("INSERT INTO date_of_birth_corrections (user_id, previous_date_of_birth, date_of_birth, reason, corrected_by) VALUES (?, ?, ?, ?, ?)",
+		userID, previous, value, reason, correctedBy)
+	if err != nil {
+		return previous, fmt.Errorf("error recording date of birth correction of user %d: %w", userID, err)
+	}
+	return previous, nil
+}
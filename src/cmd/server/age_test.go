@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgeOn(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.Local)
+	}
+	leapling := day(2008, time.February, 29)
+	born := day(2008, time.March, 11)
+	for _, tc := range []struct {
+		name string
+		dob  time.Time
+		on   time.Time
+		want int
+	}{
+		{"day before the 18th birthday", born, day(2026, time.March, 10), 17},
+		{"18th birthday", born, day(2026, time.March, 11), 18},
+		{"last minute before the 18th birthday", born, day(2026, time.March, 11).Add(-time.Minute), 17},
+		{"Feb 29 birthday, Feb 28 of a common year", leapling, day(2026, time.February, 28), 17},
+		{"Feb 29 birthday, Mar 1 of a common year", leapling, day(2026, time.March, 1), 18},
+		{"Feb 29 birthday, Feb 28 of a leap year", leapling, day(2028, time.February, 28), 19},
+		{"Feb 29 birthday, Feb 29 of a leap year", leapling, day(2028, time.February, 29), 20},
+	} {
+		if got := ageOn(tc.dob, tc.on); got != tc.want {
+			t.Errorf("%s: ageOn = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseDateOfBirth(t *testing.T) {
+	now := time.Date(2026, time.March, 11, 15, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		in string
+		ok bool
+	}{
+		{"2008-02-29", true},
+		{"2026-03-11", true},
+		{"2026-03-12", false}, // Tomorrow
+		{"1906-03-12", true},  // 119
+		{"1905-03-11", false}, // 121
+		{"2007-02-29", false}, // Not a leap year
+		{"11/03/2008", false},
+		{"", false},
+	} {
+		_, err := parseDateOfBirth(tc.in, now)
+		if tc.ok != (err == nil) || (err != nil && !errors.Is(err, errInvalidDateOfBirth)) {
+			t.Errorf("parseDateOfBirth(%q) = %v, want ok %v", tc.in, err, tc.ok)
+		}
+	}
+}
+
+func TestCheckBettingAge(t *testing.T) {
+	setupTestDB(t)
+	now := time.Date(2026, time.March, 11, 15, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		name string
+		dob  interface{}
+		want error
+	}{
+		{"turns 18 tomorrow", "2008-03-12", errUnderAge},
+		{"turns 18 today", "2008-03-11", nil},
+		{"Feb 29 birthday, 18 next week", "2008-02-29", nil},
+		{"no date of birth", nil, errDateOfBirthUnknown},
+	} {
+		userID := createTestUser(t, tc.name, strings.ReplaceAll(tc.name, " ", ".")+"@example.com", 0)
+		if _, err := db.Exec("UPDATE users SET date_of_birth = ? WHERE id = ?", tc.dob, userID); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkBettingAge(db, userID, now); !errors.Is(err, tc.want) {
+			t.Errorf("%s: checkBettingAge = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestBetLimitsRequireDateOfBirth(t *testing.T) {
+	setupTestDB(t)
+	// Accounts created before dates of birth were collected have none.
+	userID := createTestUser(t, "Old Account", "old@example.com", 100*Credit)
+	if _, err := db.Exec("UPDATE users SET date_of_birth = NULL WHERE id = ?", userID); err != nil {
+		t.Fatal(err)
+	}
+	err := checkBetLimits(db, userID, 10*Credit, time.Now())
+	if !errors.Is(err, errDateOfBirthUnknown) {
+		t.Fatalf("checkBetLimits = %v, want %v", err, errDateOfBirthUnknown)
+	}
+	if msg := gamblingLimitMessage(err); !strings.Contains(msg, "We need your date of birth") {
+		t.Errorf("gamblingLimitMessage = %q, want the date of birth message", msg)
+	}
+}
+
+func TestPlaceBetRequiresDateOfBirth(t *testing.T) {
+	setupHandlerTest(t)
+	prevTemplate := betResponseTemplate
+	betResponseTemplate = template.Must(template.New("betResponse").Parse(`{{.Message}}`))
+	t.Cleanup(func() { betResponseTemplate = prevTemplate })
+
+	// Accounts created before dates of birth were collected have none.
+	userID := createTestUser(t, "Old Account", "old@example.com", 100*Credit)
+	if _, err := db.Exec("UPDATE users SET date_of_birth = NULL WHERE id = ?", userID); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"betAmount": {"10"}, "selectedChicken": {"1"}, "betType": {BetTypeWin}}
+	req := httptest.NewRequest(http.MethodPost, "/place-bet", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(loginCookie(t, userID, "Old Account"))
+	rec := httptest.NewRecorder()
+	sessionManager.LoadAndSave(http.HandlerFunc(placeBetHandler)).ServeHTTP(rec, req)
+	body := rec.Body.String()
+
+	if !strings.Contains(body, "We need your date of birth") {
+		t.Errorf("placeBetHandler answered %q, want the date of birth message", body)
+	}
+	var bets int
+	var balance Money
+	if err := db.QueryRow("SELECT (SELECT COUNT(*) FROM bets WHERE user_id = ?), balance FROM users WHERE id = ?", userID, userID).Scan(&bets, &balance); err != nil {
+		t.Fatal(err)
+	}
+	if bets != 0 || balance != 100*Credit {
+		t.Errorf("after the refused bet: %d bets, balance %s; want none and 100.00", bets, balance)
+	}
+}
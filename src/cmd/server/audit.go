@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit actions, stored in audit_events.action. The prefix before the dot is the area of the app.
+const (
+	AuditLoginSucceeded = "login.succeeded"
+	AuditLoginFailed    = "login.failed"
+
+	AuditRaceScheduled = "race.scheduled"
+	AuditRaceStarted   = "race.started"
+	AuditRaceFinished  = "race.finished"
+	AuditRaceCancelled = "race.cancelled"
+
+	AuditAdminRaceAction   = "admin.race_action" // An administrator asked for a race to start, finish, cancel or move
+	AuditAdminRaceCycle    = "admin.race_cycle"  // An administrator forced the race loop on
+	AuditAdminRoleChanged  = "admin.role_changed"
+	AuditAdminDOBCorrected = "admin.date_of_birth_corrected"
+
+	AuditChickenCreated   = "chicken.created"
+	AuditChickenUpdated   = "chicken.updated"
+	AuditChickenRetired   = "chicken.retired"
+	AuditChickenUnretired = "chicken.unretired"
+	AuditChickenDeleted   = "chicken.deleted"
+	AuditChickenPortrait  = "chicken.portrait_changed"
+
+	AuditUserBalanceAdjusted = "user.balance_adjusted"
+	AuditUserLocked          = "user.locked"
+	AuditUserUnlocked        = "user.unlocked"
+	AuditUserLoggedOut       = "user.logged_out"
+	AuditUserPasswordReset   = "user.password_reset"
+	AuditUserExported        = "user.exported" // An administrator downloaded the user's bets or wallet ledger
+)
+
+// auditAreas are the action prefixes the audit log can be filtered by.
+var auditAreas = []string{"login", "race", "admin", "chicken", "user"}
+
+const (
+	// auditGenesisHash is the prev_hash of the first event.
+	auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+	// auditTimeLayout is RFC 3339 with fixed-width nanoseconds, so stored times sort as text.
+	auditTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+	auditPageSize   = 50
+)
+
+// errAuditChainBroken is returned by verifyAuditChain when an event does not follow from the one before it.
+var errAuditChainBroken = errors.New("audit chain broken")
+
+// AuditActor is whoever an audit event is attributed to.
+type AuditActor struct {
+	ID   int    // User ID; 0 for the system and the command line
+	Name string // Name of the user at the time, "system" or "cli:<os user>"
+	IP   string // Empty outside of requests
+}
+
+// systemActor is the race loop and other background work.
+var systemActor = AuditActor{Name: "system"}
+
+// requestActor attributes an event to the logged-in user making the request, or to a guest.
+func requestActor(r *http.Request) AuditActor {
+	actor := AuditActor{ID: authenticatedUserID(r), IP: getIPAddress(r)}
+	if actor.ID != 0 {
+		actor.Name = sessionManager.GetString(r.Context(), sessionUserNameKey)
+	} else {
+		actor.Name = "guest"
+	}
+	return actor
+}
+
+// commandActor attributes an event to the operator running a command-line command.
+func commandActor() AuditActor {
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "unknown"
+	}
+	return AuditActor{Name: "cli:" + user}
+}
+
+// AuditEvent is an entry in the append-only audit log. Each event's hash covers its fields and the hash
+// of the event before it, so changing, removing or reordering any event breaks every hash after it.
+type AuditEvent struct {
+	ID         int64
+	CreatedAt  time.Time
+	ActorID    int
+	Actor      string
+	IP         string
+	Action     string
+	TargetType string // e.g. "user", "race", "chicken"; empty when the event has no target
+	TargetID   string
+	Before     string // JSON of the values before the change, empty when there were none
+	After      string // JSON of the values after the change
+	Reason     string
+	PrevHash   string
+	Hash       string
+}
+
+// auditValue encodes before and after values for an event. nil encodes as no value.
+func auditValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("auditValue: Error encoding %T: %v", v, err)
+		return fmt.Sprintf("%q", fmt.Sprint(v))
+	}
+	return string(b)
+}
+
+// newAuditEvent starts an event by the actor on a target. targetID may be 0 for none.
+func newAuditEvent(actor AuditActor, action, targetType string, targetID int) AuditEvent {
+	e := AuditEvent{ActorID: actor.ID, Actor: actor.Name, IP: actor.IP, Action: action, TargetType: targetType}
+	if targetID != 0 {
+		e.TargetID = strconv.Itoa(targetID)
+	}
+	return e
+}
+
+// computeHash returns the SHA-256 of the event's fields and the previous hash. Fields are length-prefixed
+// so that text cannot move from one field into the next without changing the hash.
+func (e AuditEvent) computeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		strconv.FormatInt(e.ID, 10), e.CreatedAt.UTC().Format(auditTimeLayout), strconv.Itoa(e.ActorID), e.Actor, e.IP,
+		e.Action, e.TargetType, e.TargetID, e.Before, e.After, e.Reason, e.PrevHash,
+	} {
+		fmt.Fprintf(h, "%d:%s;", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordAuditEvent appends an event to the audit log within the action's transaction, making the action
+// and its event atomic. Call it after the transaction's other writes: the transaction then already holds
+// SQLite's write lock, so no other append can slip in between reading the last hash and inserting. Should
+// two appends still read the same last event, the explicit id makes the second fail on the primary key
+// rather than fork the chain. Events that are not part of a transaction go through appendAuditEvent.
+func recordAuditEvent(ex queryExecer, e AuditEvent) (AuditEvent, error) {
+	var lastID int64
+	e.PrevHash = auditGenesisHash
+	err := ex.QueryRow("SELECT id, hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&lastID, &e.PrevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return e, fmt.Errorf("error reading the last audit event: %w", err)
+	}
+	e.ID = lastID + 1
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	e.CreatedAt = e.CreatedAt.UTC()
+	e.Hash = e.computeHash()
+
+	_, err = ex.Exec(`
+        INSERT INTO audit_events (id, created_at, actor_id, actor, ip, action, target_type, target_id, before_value, after_value, reason, prev_hash, hash)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.CreatedAt.Format(auditTimeLayout), e.ActorID, e.Actor, e.IP, e.Action, e.TargetType, e.TargetID, e.Before, e.After, e.Reason, e.PrevHash, e.Hash)
+	if err != nil {
+		return e, fmt.Errorf("error recording %s audit event: %w", e.Action, err)
+	}
+	return e, nil
+}
+
+// auditAppendAttempts is how many times appendAuditEvent tries an append that lost to another writer.
+const auditAppendAttempts = 5
+
+// connQueryExecer runs statements on one connection, so that they share the transaction begun on it.
+type connQueryExecer struct {
+	ctx  context.Context
+	conn *sql.Conn
+}
+
+func (c connQueryExecer) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c connQueryExecer) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c connQueryExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+// appendAuditEvent records an event that is not part of a transaction in one of its own. The transaction
+// begins IMMEDIATE, taking the write lock before the last hash is read, and is retried should it still lose
+// to another writer.
+func appendAuditEvent(db *sql.DB, e AuditEvent) (AuditEvent, error) {
+	var err error
+	for attempt := 1; attempt <= auditAppendAttempts; attempt++ {
+		var recorded AuditEvent
+		if recorded, err = appendAuditEventOnce(db, e); err == nil {
+			return recorded, nil
+		}
+		if !isWriteConflict(err) {
+			return e, err
+		}
+		log.Printf("appendAuditEvent: attempt %d of %d lost to another writer: %v", attempt, auditAppendAttempts, err)
+		time.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+	}
+	return e, err
+}
+
+// appendAuditEventOnce makes one attempt at appendAuditEvent.
+func appendAuditEventOnce(db *sql.DB, e AuditEvent) (AuditEvent, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return e, fmt.Errorf("error getting a connection for %s audit event: %w", e.Action, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return e, fmt.Errorf("error beginning %s audit event: %w", e.Action, err)
+	}
+	recorded, err := recordAuditEvent(connQueryExecer{ctx, conn}, e)
+	if err == nil {
+		if _, err = conn.ExecContext(ctx, "COMMIT"); err != nil {
+			err = fmt.Errorf("error committing %s audit event: %w", e.Action, err)
+		}
+	}
+	if err != nil {
+		if _, rbErr := conn.ExecContext(ctx, "ROLLBACK"); rbErr != nil {
+			log.Printf("appendAuditEvent: Error rolling back %s audit event: %v", e.Action, rbErr)
+		}
+		return e, err
+	}
+	return recorded, nil
+}
+
+// logAuditEvent records an event that is not part of a transaction, such as a login. A failure is logged
+// rather than failing what was audited.
+func logAuditEvent(e AuditEvent) {
+	if _, err := appendAuditEvent(db, e); err != nil {
+		log.Printf("logAuditEvent: %v", err)
+	}
+}
+
+const auditEventColumns = "id, created_at, actor_id, actor, ip, action, target_type, target_id, before_value, after_value, reason, prev_hash, hash"
+
+// scanAuditEvent scans a row of auditEventColumns.
+func scanAuditEvent(scan func(dest ...interface{}) error) (AuditEvent, error) {
+	var e AuditEvent
+	var createdAt string
+	err := scan(&e.ID, &createdAt, &e.ActorID, &e.Actor, &e.IP, &e.Action, &e.TargetType, &e.TargetID, &e.Before, &e.After, &e.Reason, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return e, err
+	}
+	if e.CreatedAt, err = time.Parse(auditTimeLayout, createdAt); err != nil {
+		return e, fmt.Errorf("error parsing time of audit event %d: %w", e.ID, err)
+	}
+	return e, nil
+}
+
+// AuditFilter narrows the audit log. Zero values match every event.
+type AuditFilter struct {
+	Search string    // Text in the actor, IP, action, target or reason
+	Area   string    // One of auditAreas
+	From   time.Time // First day included, local time
+	To     time.Time // Last day included, local time
+	Page   int       // 1-based
+}
+
+// FromValue returns the start of the date range for the filter form.
+func (f AuditFilter) FromValue() string {
+	if f.From.IsZero() {
+		return ""
+	}
+	return f.From.Format(betHistoryDateLayout)
+}
+
+// ToValue returns the end of the date range for the filter form.
+func (f AuditFilter) ToValue() string {
+	if f.To.IsZero() {
+		return ""
+	}
+	return f.To.Format(betHistoryDateLayout)
+}
+
+// Query encodes the filter as the query string of the audit log page, showing the given page.
+func (f AuditFilter) Query(page int) string {
+	v := url.Values{}
+	if f.Search != "" {
+		v.Set("q", f.Search)
+	}
+	if f.Area != "" {
+		v.Set("area", f.Area)
+	}
+	if from := f.FromValue(); from != "" {
+		v.Set("from", from)
+	}
+	if to := f.ToValue(); to != "" {
+		v.Set("to", to)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	return v.Encode()
+}
+
+// parseAuditFilter reads the filter from the query string. Unknown areas and malformed dates are
+// ignored, as on the My bets page.
+func parseAuditFilter(query url.Values) AuditFilter {
+	f := AuditFilter{Search: strings.TrimSpace(query.Get("q"))}
+	for _, area := range auditAreas {
+		if area == query.Get("area") {
+			f.Area = area
+		}
+	}
+	if from, err := time.ParseInLocation(betHistoryDateLayout, query.Get("from"), time.Local); err == nil {
+		f.From = from
+	}
+	if to, err := time.ParseInLocation(betHistoryDateLayout, query.Get("to"), time.Local); err == nil {
+		f.To = to
+	}
+	f.Page, _ = strconv.Atoi(query.Get("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	return f
+}
+
+// AuditView is the data of the audit log page.
+type AuditView struct {
+	Filter AuditFilter
+	Areas  []string
+	Events []AuditEvent
+	Total  int
+	Pages  int
+}
+
+// HasPrev reports whether there is a page before the current one.
+func (v *AuditView) HasPrev() bool {
+	return v.Filter.Page > 1
+}
+
+// HasNext reports whether there is a page after the current one.
+func (v *AuditView) HasNext() bool {
+	return v.Filter.Page < v.Pages
+}
+
+// PrevURL links to the previous page, keeping the filter.
+func (v *AuditView) PrevURL() string {
+	return "/admin/audit?" + v.Filter.Query(v.Filter.Page-1)
+}
+
+// NextURL links to the next page, keeping the filter.
+func (v *AuditView) NextURL() string {
+	return "/admin/audit?" + v.Filter.Query(v.Filter.Page+1)
+}
+
+// auditWhere builds the WHERE clause for the filter.
+func auditWhere(f AuditFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if s := strings.TrimSpace(f.Search); s != "" {
+		pattern := "%" + likeEscaper.Replace(s) + "%"
+		conds = append(conds, `(actor LIKE ? ESCAPE '\' OR ip LIKE ? ESCAPE '\' OR action LIKE ? ESCAPE '\' OR target_type || ' ' || target_id LIKE ? ESCAPE '\' OR reason LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern, pattern, pattern)
+	}
+	if f.Area != "" {
+		conds = append(conds, "action LIKE ?")
+		args = append(args, f.Area+".%")
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.From.UTC().Format(auditTimeLayout))
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, f.To.AddDate(0, 0, 1).UTC().Format(auditTimeLayout))
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// searchAuditEvents returns the page of events matching the filter, newest first.
+func searchAuditEvents(q querier, f AuditFilter) (*AuditView, error) {
+	f.Page = max(f.Page, 1)
+	view := &AuditView{Filter: f, Areas: auditAreas}
+	where, args := auditWhere(f)
+	if err := q.QueryRow("SELECT COUNT(*) FROM audit_events"+where, args...).Scan(&view.Total); err != nil {
+		return nil, fmt.Errorf("error counting audit events: %w", err)
+	}
+	view.Pages = max((view.Total+auditPageSize-1)/auditPageSize, 1)
+
+	var err error
+	view.Events, err = queryAuditEvents(q, "SELECT "+auditEventColumns+" FROM audit_events"+where+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, auditPageSize, (f.Page-1)*auditPageSize)...)
+	if err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// getTargetAuditEvents returns the events on a target in one area, newest first.
+func getTargetAuditEvents(q querier, area, targetType string, targetID int) ([]AuditEvent, error) {
+	return queryAuditEvents(q, "SELECT "+auditEventColumns+" FROM audit_events WHERE target_type = ? AND target_id = ? AND action LIKE ? ORDER BY id DESC",
+		targetType, strconv.Itoa(targetID), area+".%")
+}
+
+// queryAuditEvents runs a query selecting auditEventColumns.
+func queryAuditEvents(q querier, query string, args ...interface{}) ([]AuditEvent, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		e, err := scanAuditEvent(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning audit event: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit events: %w", err)
+	}
+	return events, nil
+}
+
+// AuditVerification is the outcome of checking the audit chain.
+type AuditVerification struct {
+	Events   int
+	HeadHash string // Hash of the last event; record it elsewhere to detect the newest events being removed
+	BrokenAt int64  // First event that does not follow from the one before it; 0 when the chain is intact
+	Problem  string
+}
+
+// verifyAuditChain walks the audit log from the first event, recomputing every hash. It returns
+// errAuditChainBroken, along with where and why, at the first event that was changed, removed or reordered.
+func verifyAuditChain(q querier) (AuditVerification, error) {
+	var v AuditVerification
+	rows, err := q.Query("SELECT " + auditEventColumns + " FROM audit_events ORDER BY id")
+	if err != nil {
+		return v, fmt.Errorf("error querying audit events: %w", err)
+	}
+	defer rows.Close()
+
+	prevID, prevHash := int64(0), auditGenesisHash
+	for rows.Next() {
+		e, err := scanAuditEvent(rows.Scan)
+		if err != nil {
+			return v, fmt.Errorf("error scanning audit event: %w", err)
+		}
+		switch {
+		case e.ID != prevID+1:
+			v.Problem = fmt.Sprintf("event %d follows event %d; events are missing", e.ID, prevID)
+		case e.PrevHash != prevHash:
+			v.Problem = fmt.Sprintf("event %d does not link to the hash of event %d", e.ID, prevID)
+		case e.Hash != e.computeHash():
+			v.Problem = fmt.Sprintf("event %d was changed after it was recorded", e.ID)
+		}
+		if v.Problem != "" {
+			v.BrokenAt = e.ID
+			return v, errAuditChainBroken
+		}
+		v.Events++
+		prevID, prevHash = e.ID, e.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return v, fmt.Errorf("error iterating audit events: %w", err)
+	}
+	if v.Events > 0 {
+		v.HeadHash = prevHash
+	}
+	return v, nil
+}
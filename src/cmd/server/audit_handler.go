@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+// adminAuditHandler renders the audit log at /admin/audit, filtered by the query string.
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	view, err := searchAuditEvents(db, parseAuditFilter(r.URL.Query()))
+	if err != nil {
+		log.Printf("adminAuditHandler: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data := PageData{Title: "Audit log - Scramble Run", Audit: view}
+	data.UserData, data.UserBalance, _, err = authenticatedUser(db, r)
+	if err != nil {
+		log.Printf("adminAuditHandler: %v", err)
+	}
+	renderTemplateWithStatus(w, r, http.StatusOK, adminAuditTemplate, "base.gohtml", data)
+}
+
+// adminAuditVerifyHandler checks the audit chain and renders the outcome. The audit log page loads it
+// after the page itself, as the check reads every event.
+func adminAuditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	v, err := verifyAuditChain(db)
+	if err != nil && !errors.Is(err, errAuditChainBroken) {
+		log.Printf("adminAuditVerifyHandler: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		log.Printf("ADMIN: Audit chain verification failed: %s", v.Problem)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := adminAuditTemplate.ExecuteTemplate(w, "admin-audit-chain", v); err != nil {
+		log.Printf("adminAuditVerifyHandler: Template execution error: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuditChain(t *testing.T) {
+	setupHandlerTest(t)
+	if v, err := verifyAuditChain(db); err != nil || v.Events != 0 {
+		t.Fatalf("empty log: verifyAuditChain = %+v, %v", v, err)
+	}
+
+	var events []AuditEvent
+	for _, action := range []string{AuditLoginSucceeded, AuditUserLocked, AuditRaceCancelled} {
+		e := newAuditEvent(testAdmin, action, "user", 7)
+		e.Reason = "Testing"
+		recorded, err := recordAuditEvent(db, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, recorded)
+	}
+	if events[0].PrevHash != auditGenesisHash || events[1].PrevHash != events[0].Hash || events[2].ID != 3 {
+		t.Errorf("events are not chained: %+v", events)
+	}
+	v, err := verifyAuditChain(db)
+	if err != nil || v.Events != 3 || v.HeadHash != events[2].Hash {
+		t.Fatalf("verifyAuditChain = %+v, %v; want 3 intact events", v, err)
+	}
+
+	if _, err := db.Exec("UPDATE audit_events SET reason = 'Nothing to see' WHERE id = 2"); err == nil {
+		t.Error("audit_events accepted an update")
+	}
+	if _, err := db.Exec("DELETE FROM audit_events WHERE id = 2"); err == nil {
+		t.Error("audit_events accepted a delete")
+	}
+
+	// Someone with write access to the database file can drop the triggers, but not hide the change.
+	if _, err := db.Exec("DROP TRIGGER audit_events_no_update"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE audit_events SET reason = 'Nothing to see' WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	v, err = verifyAuditChain(db)
+	if !errors.Is(err, errAuditChainBroken) || v.BrokenAt != 2 || v.Events != 1 {
+		t.Errorf("after changing event 2: verifyAuditChain = %+v, %v", v, err)
+	}
+}
+
+func TestAuditChainDetectsRemovedEvents(t *testing.T) {
+	setupHandlerTest(t)
+	for range 3 {
+		if _, err := recordAuditEvent(db, newAuditEvent(systemActor, AuditRaceStarted, "race", 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("DROP TRIGGER audit_events_no_delete"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM audit_events WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := verifyAuditChain(db); !errors.Is(err, errAuditChainBroken) || v.BrokenAt != 3 {
+		t.Errorf("after removing event 2: verifyAuditChain = %+v, %v", v, err)
+	}
+}
+
+func TestSearchAuditEvents(t *testing.T) {
+	setupHandlerTest(t)
+	yesterday := time.Now().AddDate(0, 0, -1)
+	for _, e := range []AuditEvent{
+		{Actor: "Admin", IP: "203.0.113.9", Action: AuditUserLocked, TargetType: "user", TargetID: "4", Reason: "Chargeback_fraud"},
+		{Actor: "system", Action: AuditRaceFinished, TargetType: "race", TargetID: "12", CreatedAt: yesterday},
+		{Actor: "guest", IP: "198.51.100.2", Action: AuditLoginFailed, After: `{"email":"henny@example.com"}`},
+	} {
+		if _, err := recordAuditEvent(db, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		name   string
+		filter AuditFilter
+		want   int
+	}{
+		{"all", AuditFilter{}, 3},
+		{"IP", AuditFilter{Search: "203.0.113"}, 1},
+		{"target", AuditFilter{Search: "race 12"}, 1},
+		{"literal underscore", AuditFilter{Search: "k_f"}, 1},
+		{"area", AuditFilter{Area: "login"}, 1},
+		{"to yesterday", AuditFilter{To: today.AddDate(0, 0, -1)}, 1},
+		{"from today", AuditFilter{From: today}, 2},
+		{"second page", AuditFilter{Page: 2}, 0},
+	} {
+		view, err := searchAuditEvents(db, tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(view.Events) != tc.want {
+			t.Errorf("%s: found %d events, want %d", tc.name, len(view.Events), tc.want)
+		}
+	}
+}
+
+func TestConcurrentAuditAppends(t *testing.T) {
+	// Appends race each other only with several connections, which an in-memory database cannot share.
+	schema, err := os.ReadFile("../../internal/database/init_database.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fileDB.Close() })
+	if _, err := fileDB.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+
+	const appends = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, appends)
+	for i := range appends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := appendAuditEvent(fileDB, newAuditEvent(systemActor, AuditLoginSucceeded, "user", i+1)); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("appendAuditEvent: %v", err)
+	}
+	if v, err := verifyAuditChain(fileDB); err != nil || v.Events != appends {
+		t.Errorf("verifyAuditChain = %+v, %v; want %d intact events", v, err, appends)
+	}
+}
+
+func TestStartRaceRecordsEvent(t *testing.T) {
+	setupHandlerTest(t)
+	prevDetails, prevAnimation := currentRaceDetails, currentRaceAnimation
+	t.Cleanup(func() {
+		if raceEndTimer != nil {
+			raceEndTimer.Stop()
+		}
+		currentRaceDetails, currentRaceAnimation = prevDetails, prevAnimation
+	})
+
+	if err := startRace(db, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	if err := startRace(db, scheduledTestRace); err == nil {
+		t.Error("a running race started again")
+	}
+	events, err := getTargetAuditEvents(db, "race", "race", scheduledTestRace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != AuditRaceStarted {
+		t.Errorf("race events %+v, want one %s", events, AuditRaceStarted)
+	}
+}
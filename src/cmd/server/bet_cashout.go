@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// BetStatusCashedOut is the status of a bet settled early at a cash-out price.
+const BetStatusCashedOut = "Cashed Out"
+
+// cashOutMargin is the share of a bet's fair cash-out value kept by the house.
+var cashOutMargin = getEnvFloatOrDefault("CASH_OUT_MARGIN", 0.05)
+
+// cancelWindow is how long after placing a bet the punter can cancel it for a full refund, to put right a
+// mistake. Afterwards the way out before the off is to cash out, at the market price.
+var cancelWindow = time.Duration(getEnvIntOrDefault("CANCEL_WINDOW_SECONDS", 60)) * time.Second
+
+var (
+	// errBetNotOpen is returned for a bet that is not the user's, or is no longer pending.
+	errBetNotOpen = errors.New("bet is not pending")
+	// errBetRaceStarted is returned once the race a bet is on has left 'Scheduled'.
+	errBetRaceStarted = errors.New("the race has already started")
+	// errCancelWindowClosed is returned for a cancellation more than cancelWindow after the bet was placed.
+	errCancelWindowClosed = errors.New("the bet can no longer be cancelled")
+	// errCashOutNotOffered is returned for bets without a fixed price to cash out against, such as tote bets.
+	errCashOutNotOffered = errors.New("cash out is not offered on this bet")
+	// errCashOutChanged is returned when the cash-out value dropped below the quote the user accepted.
+	errCashOutChanged = errors.New("cash-out offer has changed")
+)
+
+// OpenBet is a pending bet with the state of its race, as needed to cancel or cash it out.
+// Accumulators carry the race of their first leg.
+type OpenBet struct {
+	ID         int
+	UserID     int
+	RaceID     int
+	RaceName   string
+	RaceStatus string
+	BetMode    string
+	ChickenID  int
+	BetType    string
+	Amount     Money
+	Odds       sql.NullFloat64 // Struck price; NULL for tote bets
+	PlacedAt   time.Time
+	Selection  string // Chickens of the bet, for display
+	CashOut    Money  // Current cash-out offer, 0 when none is offered
+}
+
+// RaceOpen reports whether the bet's race has not started yet, so the bet can still be cashed out.
+func (b *OpenBet) RaceOpen() bool {
+	return b.RaceStatus == RaceStatusScheduled
+}
+
+// CanCancel reports whether the bet can still be cancelled for a full refund.
+func (b *OpenBet) CanCancel() bool {
+	return b.cancellableAt(time.Now())
+}
+
+// cancellableAt reports whether the bet can be cancelled at now: its race has not started and it was
+// placed less than cancelWindow ago.
+func (b *OpenBet) cancellableAt(now time.Time) bool {
+	return b.RaceOpen() && now.Sub(b.PlacedAt) < cancelWindow
+}
+
+// openBetQuery selects the columns scanned by scanOpenBet.
+const openBetQuery = `
+        SELECT b.id, b.user_id, b.race_id, r.name, r.status, r.bet_mode, b.chicken_id, b.bet_type, b.bet_amount, b.odds, b.created_at
+        FROM bets b
+        JOIN races r ON b.race_id = r.id
+        JOIN bet_statuses s ON b.bet_status_id = s.id
+    `
+
+// scanOpenBet scans a row selected by openBetQuery.
+func scanOpenBet(row interface{ Scan(...interface{}) error }) (*OpenBet, error) {
+	var b OpenBet
+	err := row.Scan(&b.ID, &b.UserID, &b.RaceID, &b.RaceName, &b.RaceStatus, &b.BetMode, &b.ChickenID, &b.BetType, &b.Amount, &b.Odds, &b.PlacedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// getOpenBet loads one of a user's pending bets.
+func getOpenBet(q rowQuerier, userID int, betID int) (*OpenBet, error) {
+	b, err := scanOpenBet(q.QueryRow(openBetQuery+"WHERE b.id = ? AND b.user_id = ? AND s.status_name = 'Pending'", betID, userID))
+	if err == sql.ErrNoRows {
+		return nil, errBetNotOpen
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching bet %d: %w", betID, err)
+	}
+	return b, nil
+}
+
+// getUserOpenBets returns a user's pending bets, soonest race first, with their cash-out offers.
+func getUserOpenBets(q querier, userID int) ([]*OpenBet, error) {
+	rows, err := q.Query(openBetQuery+"WHERE b.user_id = ? AND s.status_name = 'Pending' ORDER BY r.date ASC, b.id ASC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying open bets of user %d: %w", userID, err)
+	}
+	var bets []*OpenBet
+	for rows.Next() {
+		b, err := scanOpenBet(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning open bet of user %d: %w", userID, err)
+		}
+		bets = append(bets, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating open bets of user %d: %w", userID, err)
+	}
+
+	for _, b := range bets {
+		if b.Selection, err = describeBet(q, b.ID, b.RaceID, b.ChickenID, b.BetType); err != nil {
+			return nil, err
+		}
+		if b.RaceOpen() {
+			b.CashOut, err = quoteCashOut(q, b)
+			if err != nil && !errors.Is(err, errCashOutNotOffered) {
+				log.Printf("getUserOpenBets: Could not quote cash out for bet %d: %v", b.ID, err)
+			}
+		}
+	}
+	return bets, nil
+}
+
+// currentOdds is the price the bet would be struck at now: the live price of its selection, or for
+// accumulators the product of the live win prices of its legs. Win prices move with the book, so they
+// are taken without the bettor's own money (see winPriceWithout).
+func currentOdds(q querier, b *OpenBet) (float64, error) {
+	switch b.BetType {
+	case BetTypeAccumulator:
+		legs, err := getAccumulatorLegs(q, b.ID)
+		if err != nil {
+			return 0, err
+		}
+		odds := 1.0
+		for _, leg := range legs {
+			price, err := winPriceWithout(q, leg.RaceID, leg.ChickenID, b.UserID)
+			if err != nil {
+				return 0, err
+			}
+			odds *= price
+		}
+		return odds, nil
+	case BetTypeWin:
+		return winPriceWithout(q, b.RaceID, b.ChickenID, b.UserID)
+	}
+	market, err := getRaceMarket(q, b.RaceID)
+	if err != nil {
+		return 0, err
+	}
+	selection, err := getBetSelection(q, b.ID, b.ChickenID)
+	if err != nil {
+		return 0, err
+	}
+	return market.OddsFor(b.BetType, selection)
+}
+
+// cashOutValue is what a bet struck at struckOdds is worth at currentOdds: the stake that would return
+// the same payout at today's price, less the cash-out margin, rounded down to the cent. A bet whose
+// price has shortened since it was struck is worth more than its stake.
+func cashOutValue(stake Money, struckOdds float64, currentOdds float64, margin float64) Money {
+	if currentOdds <= 0 {
+		return 0
+	}
+	return stake.MulRate(struckOdds / currentOdds * (1 - margin))
+}
+
+// quoteCashOut prices a cash out of a fixed-odds bet at the current odds.
+func quoteCashOut(q querier, b *OpenBet) (Money, error) {
+	if b.BetMode == BetModeTote || !b.Odds.Valid {
+		return 0, errCashOutNotOffered
+	}
+	odds, err := currentOdds(q, b)
+	if err != nil {
+		return 0, err
+	}
+	return cashOutValue(b.Amount, b.Odds.Float64, odds, cashOutMargin), nil
+}
+
+// closeOpenBet settles a bet early, paying amount back to the user, and gives its stake back to the
+// race's book or pool. It must run in the transaction that checked the bet is still open.
+func closeOpenBet(tx *sql.Tx, b *OpenBet, statusName string, amount Money) error {
+	statusID, err := getBetStatusID(tx, statusName)
+	if err != nil {
+		return err
+	}
+	kind, note := WalletTxRefund, "Bet cancelled"
+	if statusName == BetStatusCashedOut {
+		kind, note = WalletTxCashOut, "Bet cashed out"
+	}
+	if _, err := postWalletTransaction(tx, WalletTransaction{
+		UserID: b.UserID, Kind: kind, Amount: amount, BetID: b.ID, RaceID: b.RaceID, Note: note,
+	}); err != nil {
+		return fmt.Errorf("failed to pay user %d for bet %d: %w", b.UserID, b.ID, err)
+	}
+	if _, err := tx.Exec("UPDATE bets SET bet_status_id = ?, settled_at = CURRENT_TIMESTAMP, actual_payout = ? WHERE id = ?", statusID, amount, b.ID); err != nil {
+		return fmt.Errorf("failed to update status for bet %d: %w", b.ID, err)
+	}
+	if b.BetType == BetTypeAccumulator {
+		if _, err := tx.Exec("UPDATE bet_legs SET status = ? WHERE bet_id = ? AND status = ?", LegStatusVoid, b.ID, LegStatusPending); err != nil {
+			return fmt.Errorf("failed to void legs of accumulator %d: %w", b.ID, err)
+		}
+	}
+	if b.BetMode == BetModeTote {
+		return nil
+	}
+	// Every fixed-odds bet and accumulator leg is in its race's book, so the prices move back.
+	raceIDs := []int{b.RaceID}
+	if b.BetType == BetTypeAccumulator {
+		legs, err := getAccumulatorLegs(tx, b.ID)
+		if err != nil {
+			return err
+		}
+		raceIDs = raceIDs[:0]
+		for _, leg := range legs {
+			raceIDs = append(raceIDs, leg.RaceID)
+		}
+	}
+	for _, raceID := range raceIDs {
+		if err := updateCurrentOdds(tx, raceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// renderOpenBets renders a user's open bets, with the outcome of the action that was just taken.
+func renderOpenBets(w http.ResponseWriter, userID int, message string, success bool) {
+	view := OpenBetsView{LoggedIn: userID != 0, Message: message, Success: success, NewBalance: -1}
+	if userID != 0 {
+		bets, err := getUserOpenBets(db, userID)
+		if err != nil {
+			log.Printf("renderOpenBets: Error loading open bets of user %d: %v", userID, err)
+			if view.Message == "" {
+				view.Message = "Could not load your open bets."
+			}
+		}
+		view.Bets = bets
+		if message != "" {
+			_ = db.QueryRow("SELECT balance FROM users WHERE id = ?", userID).Scan(&view.NewBalance)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := raceTemplate.ExecuteTemplate(w, "open-bets", view); err != nil {
+		log.Printf("renderOpenBets: Template execution error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// openBetErrorMessage turns an error from cancelling or cashing out a bet into a message for the punter.
+func openBetErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errBetNotOpen):
+		return "That bet is no longer open."
+	case errors.Is(err, errBetRaceStarted):
+		return "The race has already started, so the bet can no longer be changed."
+	case errors.Is(err, errCancelWindowClosed):
+		return fmt.Sprintf("Bets can only be cancelled within %d seconds of being placed. You can still cash out.", int(cancelWindow.Seconds()))
+	case errors.Is(err, errCashOutNotOffered):
+		return "Cash out is not offered on tote bets."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// openBetFromRequest reads the bet ID of a cancel or cash-out request and loads the bet inside tx,
+// re-checking that its race has not started, just like placeBetHandler does for new bets.
+func openBetFromRequest(r *http.Request, q rowQuerier, userID int) (*OpenBet, error) {
+	betID, err := strconv.Atoi(r.FormValue("betID"))
+	if err != nil {
+		return nil, errBetNotOpen
+	}
+	b, err := getOpenBet(q, userID, betID)
+	if err != nil {
+		return nil, err
+	}
+	if !b.RaceOpen() {
+		return nil, errBetRaceStarted
+	}
+	return b, nil
+}
+
+// openBetsHandler renders the current user's open bets. The races page polls it so cash-out offers stay current.
+func openBetsHandler(w http.ResponseWriter, r *http.Request) {
+	renderOpenBets(w, authenticatedUserID(r), "", false)
+}
+
+// cancelOpenBet cancels the bet named in the request for a full refund, in its own transaction.
+// It returns after the transaction has been committed or rolled back, so the caller can render from db.
+func cancelOpenBet(r *http.Request, userID int) (*OpenBet, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	b, err := openBetFromRequest(r, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !b.CanCancel() {
+		return nil, errCancelWindowClosed
+	}
+	if err := closeOpenBet(tx, b, "Cancelled", b.Amount); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing cancellation of bet %d: %w", b.ID, err)
+	}
+	return b, nil
+}
+
+// cashOutOpenBet cashes out the bet named in the request at its current value, in its own transaction.
+// If the value has dropped below the quote the user accepted, nothing is paid and errCashOutChanged is
+// returned with the new value. Like cancelOpenBet, it returns after the transaction has ended.
+func cashOutOpenBet(r *http.Request, userID int, quote Money) (*OpenBet, Money, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	b, err := openBetFromRequest(r, tx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	value, err := quoteCashOut(tx, b)
+	if err != nil {
+		return b, 0, err
+	}
+	if value < quote {
+		return b, value, errCashOutChanged
+	}
+	if err := closeOpenBet(tx, b, BetStatusCashedOut, value); err != nil {
+		return b, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return b, 0, fmt.Errorf("error committing cash out of bet %d: %w", b.ID, err)
+	}
+	return b, value, nil
+}
+
+// cancelBetHandler cancels a pending bet within the cancellation window, refunding the full stake.
+func cancelBetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	currentUserID := authenticatedUserID(r)
+	if currentUserID == 0 {
+		renderOpenBets(w, 0, "Please log in to manage your bets.", false)
+		return
+	}
+
+	b, err := cancelOpenBet(r, currentUserID)
+	if err != nil {
+		log.Printf("cancelBetHandler: User %d cannot cancel bet '%s': %v", currentUserID, r.FormValue("betID"), err)
+		renderOpenBets(w, currentUserID, openBetErrorMessage(err), false)
+		return
+	}
+	log.Printf("cancelBetHandler: User %d cancelled bet %d (%s on race %d). Refunded %s.", currentUserID, b.ID, b.BetType, b.RaceID, b.Amount)
+
+	w.Header().Set("HX-Trigger", "marketChanged")
+	renderOpenBets(w, currentUserID, fmt.Sprintf("Bet cancelled. %s credits refunded.", b.Amount), true)
+}
+
+// cashOutHandler settles a pending fixed-odds bet early at its current cash-out value.
+// The request carries the quote the user accepted; if the value has since dropped, nothing is paid
+// and the new offer is shown instead.
+func cashOutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	currentUserID := authenticatedUserID(r)
+	if currentUserID == 0 {
+		renderOpenBets(w, 0, "Please log in to manage your bets.", false)
+		return
+	}
+	quote, err := ParseMoney(r.FormValue("quote"))
+	if err != nil || quote <= 0 {
+		renderOpenBets(w, currentUserID, "Invalid cash-out offer.", false)
+		return
+	}
+
+	b, value, err := cashOutOpenBet(r, currentUserID, quote)
+	if errors.Is(err, errCashOutChanged) {
+		log.Printf("cashOutHandler: Bet %d cash out refused: %v (quoted %s, now %s).", b.ID, err, quote, value)
+		renderOpenBets(w, currentUserID, fmt.Sprintf("The cash-out offer has changed to %s credits. Please confirm again.", value), false)
+		return
+	}
+	if err != nil {
+		log.Printf("cashOutHandler: User %d cannot cash out bet '%s': %v", currentUserID, r.FormValue("betID"), err)
+		renderOpenBets(w, currentUserID, openBetErrorMessage(err), false)
+		return
+	}
+	log.Printf("cashOutHandler: User %d cashed out bet %d (%s on race %d, stake %s) for %s.", currentUserID, b.ID, b.BetType, b.RaceID, b.Amount, value)
+
+	w.Header().Set("HX-Trigger", "marketChanged")
+	renderOpenBets(w, currentUserID, fmt.Sprintf("Cashed out for %s credits.", value), true)
+}
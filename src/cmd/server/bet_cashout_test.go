@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCancelWindow(t *testing.T) {
+	placed := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name   string
+		status string
+		now    time.Time
+		want   bool
+	}{
+		{"just placed", RaceStatusScheduled, placed.Add(time.Second), true},
+		{"window closed", RaceStatusScheduled, placed.Add(cancelWindow), false},
+		{"race started", RaceStatusRunning, placed.Add(time.Second), false},
+	}
+	for _, tc := range cases {
+		b := &OpenBet{RaceStatus: tc.status, PlacedAt: placed}
+		if got := b.cancellableAt(tc.now); got != tc.want {
+			t.Errorf("%s: cancellable %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// openBetRequest builds a cancel or cash-out request for a bet, as the open-bets panel posts it.
+func openBetRequest(betID int, quote Money) *http.Request {
+	form := url.Values{"betID": {strconv.Itoa(betID)}, "quote": {quote.String()}}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// backTestChicken places a win bet at the live price on the sample Scheduled race and reprices it.
+func backTestChicken(t *testing.T, userID, chickenID int, stake Money) (int, float64) {
+	t.Helper()
+	ch, err := findRaceEntrant(db, scheduledTestRace, chickenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	betID := placeTestBet(t, userID, scheduledTestRace, BetTypeWin, []int{chickenID}, stake, ch.CurrentOdds)
+	if err := updateCurrentOdds(db, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	return betID, ch.CurrentOdds
+}
+
+func TestCancelOpenBet(t *testing.T) {
+	setupTestDB(t)
+	if err := updateCurrentOdds(db, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	before, err := findRaceEntrant(db, scheduledTestRace, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	betID, _ := backTestChicken(t, 2, 1, 300*Credit)
+
+	if _, err := cancelOpenBet(openBetRequest(betID, 0), 2); err != nil {
+		t.Fatalf("cancelling a fresh bet: %v", err)
+	}
+	if status, paid := betOutcome(t, betID); status != "Cancelled" || paid != 300*Credit {
+		t.Errorf("cancelled bet is %s paying %s, want Cancelled paying 300.00", status, paid)
+	}
+	checkBalance(t, 2, 1000*Credit)
+	after, err := findRaceEntrant(db, scheduledTestRace, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(after.CurrentOdds-before.CurrentOdds) > 1e-9 {
+		t.Errorf("chicken 1 priced %.2f after the refund, want %.2f as before the bet", after.CurrentOdds, before.CurrentOdds)
+	}
+	if _, err := cancelOpenBet(openBetRequest(betID, 0), 2); !errors.Is(err, errBetNotOpen) {
+		t.Errorf("cancelling twice: got %v, want errBetNotOpen", err)
+	}
+
+	late, _ := backTestChicken(t, 2, 1, 100*Credit)
+	if _, err := db.Exec("UPDATE bets SET created_at = datetime('now', '-1 hour') WHERE id = ?", late); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cancelOpenBet(openBetRequest(late, 0), 2); !errors.Is(err, errCancelWindowClosed) {
+		t.Errorf("cancelling an old bet: got %v, want errCancelWindowClosed", err)
+	}
+	if status, _ := betOutcome(t, late); status != "Pending" {
+		t.Errorf("refused cancellation left the bet %s, want Pending", status)
+	}
+	checkBalance(t, 2, 900*Credit)
+	checkLedgerBalanced(t)
+}
+
+func TestCashOutIgnoresOwnMoney(t *testing.T) {
+	setupTestDB(t)
+	fair, err := winPriceWithout(db, scheduledTestRace, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	betID, struck := backTestChicken(t, 2, 1, 300*Credit)
+	b, err := getOpenBet(db, 2, betID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bet shortened chicken 1, but the offer is priced as if it had not been placed.
+	quote, err := quoteCashOut(db, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := cashOutValue(300*Credit, struck, fair, cashOutMargin); quote != want {
+		t.Errorf("quote %s after own bet, want %s", quote, want)
+	}
+
+	// A rival's money on the same chicken does shorten it, and the offer goes up.
+	backTestChicken(t, 1, 1, 300*Credit)
+	raised, err := quoteCashOut(db, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raised <= quote {
+		t.Errorf("quote %s after a rival backed the chicken, want more than %s", raised, quote)
+	}
+
+	if _, _, err := cashOutOpenBet(openBetRequest(betID, raised+Credit), 2, raised+Credit); !errors.Is(err, errCashOutChanged) {
+		t.Errorf("cashing out above the offer: got %v, want errCashOutChanged", err)
+	}
+	if status, _ := betOutcome(t, betID); status != "Pending" {
+		t.Errorf("refused cash out left the bet %s, want Pending", status)
+	}
+	if _, paid, err := cashOutOpenBet(openBetRequest(betID, raised), 2, raised); err != nil || paid != raised {
+		t.Fatalf("cashing out at the offer: paid %s, %v; want %s", paid, err, raised)
+	}
+	if status, paid := betOutcome(t, betID); status != BetStatusCashedOut || paid != raised {
+		t.Errorf("cashed-out bet is %s paying %s, want %s paying %s", status, paid, BetStatusCashedOut, raised)
+	}
+	checkBalance(t, 2, (1000-300)*Credit+raised)
+	checkLedgerBalanced(t)
+}
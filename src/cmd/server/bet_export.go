@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats and datasets, chosen with the format and data query parameters of /my-bets/export
+// and /admin/users/export.
+const (
+	exportFormatCSV  = "csv"
+	exportFormatJSON = "json"
+
+	exportDataBets   = "bets"
+	exportDataWallet = "wallet"
+)
+
+// exportFlushEvery is how many records are written between flushes, so a long export reaches the
+// client as it is produced instead of piling up in the response buffer.
+const exportFlushEvery = 500
+
+// exportReadBatch is how many rows an export reads from the database at a time. Each batch is read in
+// full before any of it is written, so a slow client never keeps a read open.
+var exportReadBatch = 500
+
+var errUnknownExportFormat = errors.New("unknown export format")
+
+// exportRecord is a row of an export: a JSON object, or a CSV line under the dataset's header.
+type exportRecord interface {
+	csvRecord() []string
+}
+
+// exportWriter streams records as CSV, or as a JSON array written one element at a time.
+type exportWriter struct {
+	w       io.Writer
+	flusher http.Flusher // nil when the writer cannot flush
+	format  string
+	csv     *csv.Writer
+	n       int
+}
+
+// newExportWriter starts an export in the given format. header names the CSV columns.
+func newExportWriter(w io.Writer, format string, header []string) (*exportWriter, error) {
+	e := &exportWriter{w: w, format: format}
+	e.flusher, _ = w.(http.Flusher)
+	switch format {
+	case exportFormatCSV:
+		e.csv = csv.NewWriter(w)
+		if err := e.csv.Write(header); err != nil {
+			return nil, err
+		}
+	case exportFormatJSON:
+		if _, err := io.WriteString(w, "["); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnknownExportFormat, format)
+	}
+	return e, nil
+}
+
+// Write appends a record to the export.
+func (e *exportWriter) Write(rec exportRecord) error {
+	if e.csv != nil {
+		if err := e.csv.Write(rec.csvRecord()); err != nil {
+			return err
+		}
+	} else {
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		sep := ",\n"
+		if e.n == 0 {
+			sep = "\n"
+		}
+		if _, err := io.WriteString(e.w, sep); err != nil {
+			return err
+		}
+		if _, err := e.w.Write(b); err != nil {
+			return err
+		}
+	}
+	e.n++
+	if e.n%exportFlushEvery == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+// Close finishes the export.
+func (e *exportWriter) Close() error {
+	if e.csv == nil {
+		if _, err := io.WriteString(e.w, "\n]\n"); err != nil {
+			return err
+		}
+	}
+	return e.flush()
+}
+
+func (e *exportWriter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+	return nil
+}
+
+// exportTime formats a timestamp for exports: RFC 3339 in UTC, or empty when unset.
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// betExportHeader names the columns of the bets CSV, in betExportRecord.csvRecord order.
+var betExportHeader = []string{
+	"bet_id", "placed_at", "settled_at", "race_id", "race_name", "bet_type", "selection", "finishing_positions",
+	"stake", "odds", "status", "payout", "balance_after",
+}
+
+// betExportRecord is a bet in an export.
+type betExportRecord struct {
+	BetID              int        `json:"bet_id"`
+	PlacedAt           time.Time  `json:"placed_at"`
+	SettledAt          *time.Time `json:"settled_at"`
+	RaceID             int        `json:"race_id"`
+	RaceName           string     `json:"race_name"`
+	BetType            string     `json:"bet_type"`
+	Selection          string     `json:"selection"`
+	FinishingPositions string     `json:"finishing_positions"`
+	Stake              Money      `json:"stake"`
+	Odds               *float64   `json:"odds"` // nil for tote bets
+	Status             string     `json:"status"`
+	Payout             Money      `json:"payout"`
+	BalanceAfter       *Money     `json:"balance_after"` // nil for bets placed before the wallet ledger existed
+}
+
+func newBetExportRecord(b BetHistoryRow) betExportRecord {
+	rec := betExportRecord{
+		BetID: b.ID, PlacedAt: b.PlacedAt.UTC(), RaceID: b.RaceID, RaceName: b.RaceName, BetType: b.BetType,
+		Selection: b.Picks, FinishingPositions: b.Positions, Stake: b.Stake, Status: b.Status, Payout: b.Payout,
+	}
+	if b.SettledAt.Valid {
+		settled := b.SettledAt.Time.UTC()
+		rec.SettledAt = &settled
+	}
+	if b.Odds.Valid {
+		rec.Odds = &b.Odds.Float64
+	}
+	if b.HasBalance {
+		rec.BalanceAfter = &b.Balance
+	}
+	return rec
+}
+
+func (r betExportRecord) csvRecord() []string {
+	var settled, odds, balance string
+	if r.SettledAt != nil {
+		settled = exportTime(*r.SettledAt)
+	}
+	if r.Odds != nil {
+		odds = strconv.FormatFloat(*r.Odds, 'f', 2, 64)
+	}
+	if r.BalanceAfter != nil {
+		balance = r.BalanceAfter.String()
+	}
+	return []string{
+		strconv.Itoa(r.BetID), exportTime(r.PlacedAt), settled, strconv.Itoa(r.RaceID), r.RaceName, r.BetType, r.Selection,
+		r.FinishingPositions, r.Stake.String(), odds, r.Status, r.Payout.String(), balance,
+	}
+}
+
+// exportBets streams every bet of the user that matches the filter, newest first, reading them in
+// batches by ID. The filter's page is ignored.
+func exportBets(q querier, ew *exportWriter, userID int, f BetHistoryFilter) error {
+	beforeID := 0
+	for {
+		bets, err := getBetHistoryBatch(q, userID, f, beforeID, exportReadBatch)
+		if err != nil {
+			return err
+		}
+		for _, b := range bets {
+			if err := ew.Write(newBetExportRecord(b)); err != nil {
+				return err
+			}
+		}
+		if len(bets) < exportReadBatch {
+			return nil
+		}
+		beforeID = bets[len(bets)-1].ID
+	}
+}
+
+// walletExportHeader names the columns of the wallet CSV, in walletExportRecord.csvRecord order.
+var walletExportHeader = []string{
+	"entry_id", "created_at", "kind", "amount", "balance_after", "bet_id", "race_id", "race_name", "note",
+}
+
+// walletExportRecord is a wallet ledger entry in an export.
+type walletExportRecord struct {
+	EntryID      int64     `json:"entry_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Kind         string    `json:"kind"`
+	Amount       Money     `json:"amount"`
+	BalanceAfter Money     `json:"balance_after"`
+	BetID        *int64    `json:"bet_id"`
+	RaceID       *int64    `json:"race_id"`
+	RaceName     string    `json:"race_name,omitempty"`
+	Note         string    `json:"note"`
+}
+
+func (r walletExportRecord) csvRecord() []string {
+	var betID, raceID string
+	if r.BetID != nil {
+		betID = strconv.FormatInt(*r.BetID, 10)
+	}
+	if r.RaceID != nil {
+		raceID = strconv.FormatInt(*r.RaceID, 10)
+	}
+	return []string{
+		strconv.FormatInt(r.EntryID, 10), exportTime(r.CreatedAt), r.Kind, r.Amount.String(), r.BalanceAfter.String(),
+		betID, raceID, r.RaceName, r.Note,
+	}
+}
+
+// exportWalletTransactions streams the user's wallet ledger, oldest first so the running balance reads
+// down the file, reading it in batches by ID. Only the filter's date range applies.
+func exportWalletTransactions(q querier, ew *exportWriter, userID int, f BetHistoryFilter) error {
+	var afterID int64
+	for {
+		entries, err := getWalletExportBatch(q, userID, f, afterID, exportReadBatch)
+		if err != nil {
+			return err
+		}
+		for _, rec := range entries {
+			if err := ew.Write(rec); err != nil {
+				return err
+			}
+		}
+		if len(entries) < exportReadBatch {
+			return nil
+		}
+		afterID = entries[len(entries)-1].EntryID
+	}
+}
+
+// getWalletExportBatch reads up to limit of the user's wallet entries in the filter's date range with an
+// ID above afterID, oldest first.
+func getWalletExportBatch(q querier, userID int, f BetHistoryFilter, afterID int64, limit int) ([]walletExportRecord, error) {
+	conds := []string{"w.user_id = ?", "w.id > ?"}
+	args := []interface{}{userID, afterID}
+	if !f.From.IsZero() {
+		conds = append(conds, "date(w.created_at, 'localtime') >= ?")
+		args = append(args, f.FromValue())
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "date(w.created_at, 'localtime') <= ?")
+		args = append(args, f.ToValue())
+	}
+	rows, err := q.Query(`
+        SELECT w.id, w.created_at, w.kind, w.amount, w.balance_after, w.bet_id, w.race_id, COALESCE(r.name, ''), COALESCE(w.note, '')
+        FROM wallet_transactions w
+        LEFT JOIN races r ON w.race_id = r.id
+        WHERE `+strings.Join(conds, " AND ")+`
+        ORDER BY w.id
+        LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying wallet ledger of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var entries []walletExportRecord
+	for rows.Next() {
+		var rec walletExportRecord
+		var betID, raceID sql.NullInt64
+		err := rows.Scan(&rec.EntryID, &rec.CreatedAt, &rec.Kind, &rec.Amount, &rec.BalanceAfter, &betID, &raceID, &rec.RaceName, &rec.Note)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning wallet ledger of user %d: %w", userID, err)
+		}
+		rec.CreatedAt = rec.CreatedAt.UTC()
+		if betID.Valid {
+			rec.BetID = &betID.Int64
+		}
+		if raceID.Valid {
+			rec.RaceID = &raceID.Int64
+		}
+		entries = append(entries, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating wallet ledger of user %d: %w", userID, err)
+	}
+	return entries, nil
+}
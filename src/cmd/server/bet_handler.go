@@ -1,12 +1,15 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // selectChickenHandler handles requests to select a chicken and show potential winnings.
@@ -24,45 +27,43 @@ func selectChickenHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var selectedChicken Chicken
-	found := false
-	for _, chicken := range availableChickens {
-		if chicken.ID == chickenID {
-			selectedChicken = chicken
-			found = true
-			break
-		}
+	market, err := getBettingMarket(db)
+	if err != nil {
+		log.Printf("selectChickenHandler: No betting market available: %v", err)
+		http.Error(w, "No race is open for betting", http.StatusNotFound)
+		return
 	}
-
-	if !found {
+	if _, ok := market.Entrant(chickenID); !ok {
 		http.Error(w, "Chicken not found", http.StatusNotFound)
 		return
 	}
+	selection := []int{chickenID}
 
-	betAmount := 10.0 // Default bet amount
+	betAmount := 10 * Credit // Default bet amount
 	betAmountStr := r.URL.Query().Get("betAmount")
 	if betAmountStr != "" {
-		parsedAmount, parseErr := strconv.ParseFloat(betAmountStr, 64)
+		parsedAmount, parseErr := ParseMoney(betAmountStr)
 		if parseErr == nil && parsedAmount > 0 {
 			betAmount = parsedAmount
 		}
 	}
 
-	potentialWinnings := betAmount * selectedChicken.Odds
+	betType, err := parseBetType(r.URL.Query().Get("betType"))
+	if err != nil {
+		http.Error(w, "Invalid bet type", http.StatusBadRequest)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/html")
 	tmpl := template.Must(template.New("winningsCalc").Parse(`
         <div class="winnings-display" id="winnings-calc">
-            <p>Potential Win:</p>
-            <span class="winnings-amount">{{printf "%.2f" .Amount}} Credits</span>
-            <input type="hidden" name="selectedChicken" value="{{.ChickenID}}" />
+            <p>{{if .IsTote}}Approx. Tote Return:{{else}}Potential Win:{{end}}</p>
+            {{if .Hint}}<span class="winnings-hint">{{.Hint}}</span>{{else}}<span class="winnings-amount">{{.Amount}} Credits</span>{{end}}
+            <input type="hidden" name="selectedChicken" value="{{.Selection}}" />
         </div>
     `))
 
-	winningsData := WinningsCalc{
-		Amount:    potentialWinnings,
-		ChickenID: chickenID,
-	}
+	winningsData := newWinningsCalc(market, betType, selection, betAmount)
 
 	err = tmpl.Execute(w, winningsData)
 	if err != nil {
@@ -71,6 +72,40 @@ func selectChickenHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// newWinningsCalc prices a prospective bet for the winnings display. Selections that cannot be
+// priced yet (too few chickens picked, or a bet type the race does not take) get a hint instead.
+func newWinningsCalc(market *RaceMarket, betType string, selection []int, stake Money) WinningsCalc {
+	calc := WinningsCalc{Selection: joinSelection(selection), IsTote: market.IsTote()}
+	amount, err := market.ApproxReturn(betType, selection, stake)
+	switch {
+	case errors.Is(err, errBetTypeNotOffered):
+		calc.Hint = fmt.Sprintf("%s bets are not offered on this race.", betType)
+	case errors.Is(err, errInvalidSelection):
+		calc.Hint = fmt.Sprintf("%s bets need %d different chickens.", betType, selectionSize(betType))
+	case err != nil:
+		calc.Hint = "Pick a chicken from this race."
+	default:
+		calc.Amount = amount
+	}
+	return calc
+}
+
+// raceMarketHandler renders the chicken selector of the race open for betting.
+// The races page polls it so prices and tote dividends stay live while bets come in.
+func raceMarketHandler(w http.ResponseWriter, r *http.Request) {
+	market, err := getBettingMarket(db)
+	if err != nil {
+		log.Printf("raceMarketHandler: No betting market available: %v", err)
+		market = nil
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := raceTemplate.ExecuteTemplate(w, "chicken-options", market); err != nil {
+		log.Printf("raceMarketHandler: Template execution error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
 // calculateWinningsHandler re-calculates potential winnings based on user input.
 func calculateWinningsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -86,45 +121,38 @@ func calculateWinningsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	betAmountStr := r.Form.Get("betAmount")
-	betAmount, err := strconv.ParseFloat(betAmountStr, 64)
+	betAmount, err := ParseMoney(betAmountStr)
 	if err != nil || betAmount <= 0 {
 		http.Error(w, "Invalid bet amount", http.StatusBadRequest)
 		return
 	}
 
-	chickenIDStr := r.Form.Get("selectedChicken")
-	chickenID, err := strconv.Atoi(chickenIDStr)
+	selection, err := parseSelection(r.Form.Get("selectedChicken"))
 	if err != nil {
-		http.Error(w, "Invalid chicken ID", http.StatusBadRequest)
+		http.Error(w, "Invalid chicken selection", http.StatusBadRequest)
 		return
 	}
-
-	var selectedChickenOdds float64
-	found := false
-	for _, chicken := range availableChickens {
-		if chicken.ID == chickenID {
-			selectedChickenOdds = chicken.Odds
-			found = true
-			break
-		}
+	betType, err := parseBetType(r.Form.Get("betType"))
+	if err != nil {
+		http.Error(w, "Invalid bet type", http.StatusBadRequest)
+		return
 	}
 
-	if !found {
-		http.Error(w, "Chicken not found", http.StatusNotFound)
+	market, err := getBettingMarket(db)
+	if err != nil {
+		log.Printf("calculateWinningsHandler: No betting market available: %v", err)
+		http.Error(w, "No race is open for betting", http.StatusNotFound)
 		return
 	}
 
-	winningsData := WinningsCalc{
-		Amount:    betAmount * selectedChickenOdds,
-		ChickenID: chickenID,
-	}
+	winningsData := newWinningsCalc(market, betType, selection, betAmount)
 
 	w.Header().Set("Content-Type", "text/html")
 	tmpl := template.Must(template.New("winningsCalcResponse").Parse(`
         <div class="winnings-display" id="winnings-calc">
-            <p>Potential Win:</p>
-            <span class="winnings-amount">{{printf "%.2f" .Amount}} Credits</span>
-            <input type="hidden" name="selectedChicken" value="{{.ChickenID}}" />
+            <p>{{if .IsTote}}Approx. Tote Return:{{else}}Potential Win:{{end}}</p>
+            {{if .Hint}}<span class="winnings-hint">{{.Hint}}</span>{{else}}<span class="winnings-amount">{{.Amount}} Credits</span>{{end}}
+            <input type="hidden" name="selectedChicken" value="{{.Selection}}" />
         </div>
     `))
 
@@ -151,8 +179,12 @@ func placeBetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentUserID := sessionManager.GetInt(r.Context(), sessionUserIDKey)
-	var userCurrentBalanceForErrorDisplay float64 = -1
+	currentUserID := authenticatedUserID(r)
+	if currentUserID == 0 { // requireAuthentication should have turned guests away already
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Please log in to place a bet.", NewBalance: -1})
+		return
+	}
+	var userCurrentBalanceForErrorDisplay Money = -1
 	// Fetch initial balance for error display if needed, outside transaction for non-critical info
 	// This is a bit redundant as we fetch it again in TX, but okay for display purposes.
 	if currentUserID != 0 {
@@ -168,41 +200,39 @@ func placeBetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	betAmountStr := r.FormValue("betAmount")
-	betAmount, err := strconv.ParseFloat(betAmountStr, 64)
+	betAmount, err := ParseMoney(betAmountStr)
 	if err != nil || betAmount <= 0 {
-		log.Printf("placeBetHandler: Invalid bet amount. String: '%s', Parsed: %f, Error: %v", betAmountStr, betAmount, err)
+		log.Printf("placeBetHandler: Invalid bet amount. String: '%s', Error: %v", betAmountStr, err)
 		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Invalid bet amount. Must be a positive number.", NewBalance: userCurrentBalanceForErrorDisplay})
 		return
 	}
 
-	chickenIDStr := r.FormValue("selectedChicken")
-	if chickenIDStr == "" {
+	// selectedChicken holds one chicken ID, or several comma-separated IDs in pick order for combination bets.
+	selectionStr := r.FormValue("selectedChicken")
+	if strings.TrimSpace(selectionStr) == "" {
 		log.Println("placeBetHandler: 'selectedChicken' form value is empty.")
 		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "No chicken selected. Please select a chicken first.", NewBalance: userCurrentBalanceForErrorDisplay})
 		return
 	}
-	chickenID, err := strconv.Atoi(chickenIDStr)
+	selection, err := parseSelection(selectionStr)
 	if err != nil {
-		log.Printf("placeBetHandler: Failed to convert 'selectedChicken' value '%s' to an integer: %v", chickenIDStr, err)
-		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Invalid chicken selection data. Expected a numeric ID.", NewBalance: userCurrentBalanceForErrorDisplay})
+		log.Printf("placeBetHandler: Failed to parse 'selectedChicken' value '%s': %v", selectionStr, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Invalid chicken selection data. Expected numeric IDs.", NewBalance: userCurrentBalanceForErrorDisplay})
 		return
 	}
-
-	var selectedChicken Chicken
-	foundChicken := false
-	for _, ch := range availableChickens { // Ensure availableChickens is loaded
-		if ch.ID == chickenID {
-			selectedChicken = ch
-			foundChicken = true
-			break
-		}
+	betType, err := parseBetType(r.FormValue("betType"))
+	if err != nil {
+		log.Printf("placeBetHandler: %v", err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Invalid bet type.", NewBalance: userCurrentBalanceForErrorDisplay})
+		return
 	}
-	if !foundChicken {
-		log.Printf("placeBetHandler: Chicken with ID %d not found in availableChickens list.", chickenID)
-		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: fmt.Sprintf("The selected chicken (ID: %d) is not available for betting. (Is availableChickens cache up to date?)", chickenID), NewBalance: userCurrentBalanceForErrorDisplay})
+	if err := checkSelection(betType, selection); err != nil {
+		log.Printf("placeBetHandler: Selection %v does not fit a %s bet: %v", selection, betType, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: fmt.Sprintf("%s bets need %d different chickens.", betType, selectionSize(betType)), NewBalance: userCurrentBalanceForErrorDisplay})
 		return
 	}
-	log.Printf("placeBetHandler: User %d attempting to bet %.2f on chicken ID %d (%s, Odds: %.2f)", currentUserID, betAmount, selectedChicken.ID, selectedChicken.Name, selectedChicken.Odds)
+	chickenID := selection[0]
+	log.Printf("placeBetHandler: User %d attempting to bet %s (%s) on chickens %v", currentUserID, betAmount, betType, selection)
 
 	// Transaction starts here
 	tx, err := db.Begin()
@@ -235,8 +265,8 @@ func placeBetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("placeBetHandler: Active race for betting determined as ID %d.", activeRaceID)
 
-	var raceStatus string
-	err = tx.QueryRow("SELECT status FROM races WHERE id = ?", activeRaceID).Scan(&raceStatus)
+	var raceStatus, betMode string
+	err = tx.QueryRow("SELECT status, bet_mode FROM races WHERE id = ?", activeRaceID).Scan(&raceStatus, &betMode)
 	if err != nil {
 		log.Printf("placeBetHandler: Error scanning race status for race ID %d: %v. Rolling back.", activeRaceID, err)
 		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Error confirming race status.", NewBalance: userCurrentBalanceForErrorDisplay})
@@ -249,7 +279,31 @@ func placeBetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("placeBetHandler: Race ID %d status is '%s', OK for betting.", activeRaceID, raceStatus)
 
-	var currentUserBalanceInTx float64
+	market, err := getRaceMarket(tx, activeRaceID)
+	if err != nil {
+		log.Printf("placeBetHandler: Error loading market for race %d: %v. Rolling back.", activeRaceID, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Error confirming the selected chicken.", NewBalance: userCurrentBalanceForErrorDisplay})
+		return
+	}
+	odds, err := market.OddsFor(betType, selection)
+	if err != nil {
+		log.Printf("placeBetHandler: %s selection %v not available in race %d: %v. Rolling back.", betType, selection, activeRaceID, err)
+		msg := "Error confirming the selected chicken."
+		switch {
+		case errors.Is(err, errBetTypeNotOffered) && market.IsTote():
+			msg = fmt.Sprintf("This race runs a tote win pool only. %s bets are not available.", betType)
+		case errors.Is(err, errBetTypeNotOffered):
+			msg = fmt.Sprintf("Only %d chickens are running, so %s bets are not offered on this race.", len(market.Entrants), betType)
+		case errors.Is(err, errEntrantNotFound):
+			msg = "A selected chicken is not running in this race."
+		}
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: msg, NewBalance: userCurrentBalanceForErrorDisplay})
+		return
+	}
+	selectionName := describeSelection(betType, market.SelectionNames(selection))
+	log.Printf("placeBetHandler: %s on %s in race %d is priced at %.2f.", betType, selectionName, activeRaceID, odds)
+
+	var currentUserBalanceInTx Money
 	err = tx.QueryRow("SELECT balance FROM users WHERE id = ?", currentUserID).Scan(&currentUserBalanceInTx)
 	if err != nil {
 		log.Printf("placeBetHandler: Error scanning user balance for user ID %d: %v. Rolling back.", currentUserID, err)
@@ -257,17 +311,50 @@ func placeBetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if currentUserBalanceInTx < betAmount {
-		log.Printf("placeBetHandler: User %d has insufficient funds (%.2f) for bet amount %.2f. Rolling back.", currentUserID, currentUserBalanceInTx, betAmount)
+		log.Printf("placeBetHandler: User %d has insufficient funds (%s) for bet amount %s. Rolling back.", currentUserID, currentUserBalanceInTx, betAmount)
 		_ = betResponseTemplate.Execute(w, BetResponse{
 			Success:     false,
-			Message:     fmt.Sprintf("Insufficient funds. Your balance is %.2f credits.", currentUserBalanceInTx),
+			Message:     fmt.Sprintf("Insufficient funds. Your balance is %s credits.", currentUserBalanceInTx),
 			NewBalance:  currentUserBalanceInTx,
 			BetAmount:   betAmount,
-			ChickenName: selectedChicken.Name,
+			ChickenName: selectionName,
 		})
 		return
 	}
-	log.Printf("placeBetHandler: User %d balance %.2f is sufficient for bet amount %.2f.", currentUserID, currentUserBalanceInTx, betAmount)
+	log.Printf("placeBetHandler: User %d balance %s is sufficient for bet amount %s.", currentUserID, currentUserBalanceInTx, betAmount)
+
+	// Responsible gambling: self-exclusion and the user's own limits, checked against the stakes already in this transaction.
+	if err := checkBetLimits(tx, currentUserID, betAmount, time.Now()); err != nil {
+		msg := gamblingLimitMessage(err)
+		if msg == "" {
+			log.Printf("placeBetHandler: Error checking limits of user %d: %v. Rolling back.", currentUserID, err)
+			msg = "Error checking your betting limits."
+		} else {
+			log.Printf("placeBetHandler: Bet of %s by user %d refused: %v. Rolling back.", betAmount, currentUserID, err)
+		}
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: msg, NewBalance: currentUserBalanceInTx})
+		return
+	}
+
+	// Fixed-odds bets are struck at the live price, as long as the house can cover them on every finish they win on.
+	if betMode != BetModeTote {
+		book, err := getRaceBook(tx, activeRaceID)
+		if err != nil {
+			log.Printf("placeBetHandler: Error loading book for race %d: %v. Rolling back.", activeRaceID, err)
+			_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Error confirming the odds.", NewBalance: currentUserBalanceInTx})
+			return
+		}
+		if err := book.checkLiability(betType, selection, betAmount, odds, maxRaceLiability); err != nil {
+			maxStake := book.maxAcceptedStake(betType, selection, odds, maxRaceLiability)
+			log.Printf("placeBetHandler: %s bet of %s on %v in race %d refused: %v (max %s). Rolling back.", betType, betAmount, selection, activeRaceID, err, maxStake)
+			_ = betResponseTemplate.Execute(w, BetResponse{
+				Success:    false,
+				Message:    fmt.Sprintf("%s is at its betting limit. The most we can accept at %.2f is %s credits.", selectionName, odds, maxStake),
+				NewBalance: currentUserBalanceInTx,
+			})
+			return
+		}
+	}
 
 	pendingStatusID, err := getPendingBetStatusID(tx)
 	if err != nil {
@@ -277,24 +364,44 @@ func placeBetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("placeBetHandler: Pending bet status ID: %d.", pendingStatusID)
 
-	newBalance := currentUserBalanceInTx - betAmount
-	_, err = tx.Exec("UPDATE users SET balance = ? WHERE id = ?", newBalance, currentUserID)
+	// Fixed-odds bets lock in the live price; tote bets add their stake to the race's win pool
+	// and their payout is only known once the pool closes.
+	struckOdds := sql.NullFloat64{Float64: odds, Valid: betMode != BetModeTote}
+	potentialPayout := sql.NullInt64{Int64: int64(fixedOddsPayout(betAmount, odds)), Valid: betMode != BetModeTote}
+	// bets.chicken_id is the first pick; the full selection, in order, goes to bet_selections.
+	betResult, err := tx.Exec("INSERT INTO bets (user_id, race_id, chicken_id, bet_type, bet_amount, bet_status_id, odds, potential_payout) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		currentUserID, activeRaceID, chickenID, betType, betAmount, pendingStatusID, struckOdds, potentialPayout)
 	if err != nil {
-		log.Printf("placeBetHandler: Error executing UPDATE users for user ID %d to balance %.2f: %v. Rolling back.", currentUserID, newBalance, err)
-		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to update balance.", NewBalance: currentUserBalanceInTx}) // Show old balance
+		log.Printf("placeBetHandler: Error executing INSERT INTO bets for user %d, race ID %d, chicken ID %d, amount %s: %v. Rolling back.", currentUserID, activeRaceID, chickenID, betAmount, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to record bet.", NewBalance: currentUserBalanceInTx}) // Show old balance as TX will rollback user update too
 		return
 	}
-	log.Printf("placeBetHandler: Successfully updated user %d balance to %.2f.", currentUserID, newBalance)
+	betID, _ := betResult.LastInsertId()
+	if err := insertBetSelections(tx, betID, selection); err != nil {
+		log.Printf("placeBetHandler: %v. Rolling back.", err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to record bet.", NewBalance: currentUserBalanceInTx})
+		return
+	}
+	log.Printf("placeBetHandler: Successfully inserted bet %d for user %d, race %d, selection %v.", betID, currentUserID, activeRaceID, selection)
 
-	potentialPayout := betAmount * selectedChicken.Odds
-	_, err = tx.Exec("INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id, potential_payout) VALUES (?, ?, ?, ?, ?, ?)",
-		currentUserID, activeRaceID, chickenID, betAmount, pendingStatusID, potentialPayout)
+	newBalance, err := postWalletTransaction(tx, WalletTransaction{
+		UserID: currentUserID, Kind: WalletTxStake, Amount: -betAmount, BetID: int(betID), RaceID: activeRaceID,
+		Note: fmt.Sprintf("%s bet on %s", betType, selectionName),
+	})
 	if err != nil {
-		log.Printf("placeBetHandler: Error executing INSERT INTO bets for user %d, race ID %d, chicken ID %d, amount %.2f: %v. Rolling back.", currentUserID, activeRaceID, chickenID, betAmount, err)
-		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to record bet.", NewBalance: currentUserBalanceInTx}) // Show old balance as TX will rollback user update too
+		log.Printf("placeBetHandler: Error debiting stake of bet %d from user %d: %v. Rolling back.", betID, currentUserID, err)
+		_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to update balance.", NewBalance: currentUserBalanceInTx}) // Show old balance as TX will roll back the bet too
 		return
 	}
-	log.Printf("placeBetHandler: Successfully inserted bet for user %d, race %d, chicken %d.", currentUserID, activeRaceID, chickenID)
+	log.Printf("placeBetHandler: Successfully updated user %d balance to %s.", currentUserID, newBalance)
+
+	if betMode != BetModeTote {
+		if err := updateCurrentOdds(tx, activeRaceID); err != nil {
+			log.Printf("placeBetHandler: Error repricing race %d: %v. Rolling back.", activeRaceID, err)
+			_ = betResponseTemplate.Execute(w, BetResponse{Success: false, Message: "Failed to record bet.", NewBalance: currentUserBalanceInTx})
+			return
+		}
+	}
 
 	err = tx.Commit()
 	if err != nil {
@@ -303,14 +410,20 @@ func placeBetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	committed = true // Set committed to true ONLY after successful commit
-	log.Printf("placeBetHandler: Bet successfully placed and transaction committed for user %d on chicken %d (Race %d) for amount %.2f. New balance: %.2f", currentUserID, chickenID, activeRaceID, betAmount, newBalance)
+	log.Printf("placeBetHandler: Bet successfully placed and transaction committed for user %d on chicken %d (Race %d, %s) for amount %s. New balance: %s", currentUserID, chickenID, activeRaceID, betMode, betAmount, newBalance)
 
+	message := fmt.Sprintf("%s bet placed successfully at odds %.2f!", betType, odds)
+	if betMode == BetModeTote {
+		message = "Bet added to the win pool! Dividends are final when the race starts."
+	}
+	// The bet moved the market: refresh the chicken selector straight away instead of waiting for the next poll.
+	w.Header().Set("HX-Trigger", "marketChanged")
 	response := BetResponse{
 		Success:     true,
-		Message:     "Bet placed successfully!",
+		Message:     message,
 		NewBalance:  newBalance,
 		BetAmount:   betAmount,
-		ChickenName: selectedChicken.Name,
+		ChickenName: selectionName,
 	}
 
 	errTmpl := betResponseTemplate.Execute(w, response)
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// betHistoryPageSize is how many bets the My bets page shows at a time.
+const betHistoryPageSize = 20
+
+// betHistoryDateLayout is the format of the date range filter, as sent by <input type="date">.
+const betHistoryDateLayout = "2006-01-02"
+
+// BetHistoryFilter narrows a user's bet history. Zero values match every bet.
+type BetHistoryFilter struct {
+	Status string    // Bet status name, e.g. "Won"
+	From   time.Time // First day included, local time
+	To     time.Time // Last day included, local time
+	Page   int       // 1-based
+}
+
+// FromValue returns the start of the date range for the filter form.
+func (f BetHistoryFilter) FromValue() string {
+	if f.From.IsZero() {
+		return ""
+	}
+	return f.From.Format(betHistoryDateLayout)
+}
+
+// ToValue returns the end of the date range for the filter form.
+func (f BetHistoryFilter) ToValue() string {
+	if f.To.IsZero() {
+		return ""
+	}
+	return f.To.Format(betHistoryDateLayout)
+}
+
+// Query encodes the filter as the query string of the My bets page, showing the given page.
+func (f BetHistoryFilter) Query(page int) string {
+	v := url.Values{}
+	if f.Status != "" {
+		v.Set("status", f.Status)
+	}
+	if from := f.FromValue(); from != "" {
+		v.Set("from", from)
+	}
+	if to := f.ToValue(); to != "" {
+		v.Set("to", to)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	return v.Encode()
+}
+
+// parseBetHistoryFilter reads the filter from the query string. Unknown statuses and malformed
+// dates are ignored rather than rejected, so a stale link still shows something.
+func parseBetHistoryFilter(query url.Values, statuses []string) BetHistoryFilter {
+	var f BetHistoryFilter
+	for _, s := range statuses {
+		if s == query.Get("status") {
+			f.Status = s
+		}
+	}
+	if from, err := time.ParseInLocation(betHistoryDateLayout, query.Get("from"), time.Local); err == nil {
+		f.From = from
+	}
+	if to, err := time.ParseInLocation(betHistoryDateLayout, query.Get("to"), time.Local); err == nil {
+		f.To = to
+	}
+	f.Page, _ = strconv.Atoi(query.Get("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	return f
+}
+
+// BetHistoryRow is one bet in a user's history.
+type BetHistoryRow struct {
+	ID         int
+	PlacedAt   time.Time
+	SettledAt  sql.NullTime // NULL while the bet is pending
+	RaceID     int
+	RaceName   string
+	BetType    string
+	Selection  string // Filled in by getBetHistory only
+	Picks      string // Chickens picked, in pick or leg order, e.g. "Cluck Norris / Foghorn"
+	Positions  string // Where each pick finished, in the same order, e.g. "1 / 4"; "-" until its race is run
+	Stake      Money
+	Odds       sql.NullFloat64 // Struck price; NULL for tote bets
+	Status     string
+	Payout     Money
+	Balance    Money // Wallet balance after the bet's latest ledger entry
+	HasBalance bool  // False for bets placed before the wallet ledger existed
+
+	chickenID int // First pick, for describing the selection
+}
+
+// BetHistoryView is the data of the My bets page.
+type BetHistoryView struct {
+	Filter   BetHistoryFilter
+	Statuses []string
+	Bets     []BetHistoryRow
+	Total    int
+	Pages    int
+}
+
+// HasPrev reports whether there is a page before the current one.
+func (v *BetHistoryView) HasPrev() bool {
+	return v.Filter.Page > 1
+}
+
+// HasNext reports whether there is a page after the current one.
+func (v *BetHistoryView) HasNext() bool {
+	return v.Filter.Page < v.Pages
+}
+
+// PrevURL links to the previous page, keeping the filter.
+func (v *BetHistoryView) PrevURL() string {
+	return "/my-bets?" + v.Filter.Query(v.Filter.Page-1)
+}
+
+// NextURL links to the next page, keeping the filter.
+func (v *BetHistoryView) NextURL() string {
+	return "/my-bets?" + v.Filter.Query(v.Filter.Page+1)
+}
+
+// ExportURL links to a download of the bets or wallet ledger matching the filter, across every page.
+func (v *BetHistoryView) ExportURL(data, format string) string {
+	query := v.Filter.Query(1)
+	if query != "" {
+		query += "&"
+	}
+	return "/my-bets/export?" + query + url.Values{"data": {data}, "format": {format}}.Encode()
+}
+
+// getBetStatusNames returns every bet status, in the order they were seeded.
+func getBetStatusNames(q querier) ([]string, error) {
+	rows, err := q.Query("SELECT status_name FROM bet_statuses ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying bet statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning bet status: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// betHistoryWhere builds the WHERE clause and arguments selecting a user's bets through the filter.
+// Dates are compared in local time, the same zone the page shows them in.
+func betHistoryWhere(userID int, f BetHistoryFilter) (string, []interface{}) {
+	conds := []string{"b.user_id = ?"}
+	args := []interface{}{userID}
+	if f.Status != "" {
+		conds = append(conds, "s.status_name = ?")
+		args = append(args, f.Status)
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "date(b.created_at, 'localtime') >= ?")
+		args = append(args, f.FromValue())
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "date(b.created_at, 'localtime') <= ?")
+		args = append(args, f.ToValue())
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// betHistorySelect is the bet history query, shared by the My bets page and the exports.
+// Accumulators list their legs, each pick finishing in its own race; other bets list their picks in
+// pick order, falling back to bets.chicken_id for bets placed before bet_selections existed.
+const betHistorySelect = `
+        SELECT b.id, b.created_at, b.settled_at, b.race_id, r.name, b.bet_type, b.chicken_id, b.bet_amount, b.odds, s.status_name,
+               COALESCE(b.actual_payout, 0),
+               (SELECT w.balance_after FROM wallet_transactions w WHERE w.bet_id = b.id AND w.user_id = b.user_id ORDER BY w.id DESC LIMIT 1),
+               COALESCE(
+                   (SELECT group_concat(c.name, ' / ' ORDER BY l.leg) FROM bet_legs l JOIN chickens c ON c.id = l.chicken_id WHERE l.bet_id = b.id),
+                   (SELECT group_concat(c.name, ' / ' ORDER BY bs.pick) FROM bet_selections bs JOIN chickens c ON c.id = bs.chicken_id WHERE bs.bet_id = b.id),
+                   (SELECT c.name FROM chickens c WHERE c.id = b.chicken_id),
+                   ''),
+               COALESCE(
+                   (SELECT group_concat(COALESCE(rr.position, '-'), ' / ' ORDER BY l.leg) FROM bet_legs l
+                        LEFT JOIN race_results rr ON rr.race_id = l.race_id AND rr.chicken_id = l.chicken_id WHERE l.bet_id = b.id),
+                   (SELECT group_concat(COALESCE(rr.position, '-'), ' / ' ORDER BY bs.pick) FROM bet_selections bs
+                        LEFT JOIN race_results rr ON rr.race_id = b.race_id AND rr.chicken_id = bs.chicken_id WHERE bs.bet_id = b.id),
+                   (SELECT CAST(rr.position AS TEXT) FROM race_results rr WHERE rr.race_id = b.race_id AND rr.chicken_id = b.chicken_id),
+                   '-')
+        FROM bets b
+        JOIN races r ON b.race_id = r.id
+        JOIN bet_statuses s ON b.bet_status_id = s.id
+        `
+
+// queryBetHistory reads one page of a user's bets through the filter, newest first, calling fn for each.
+func queryBetHistory(q querier, userID int, f BetHistoryFilter, limit, offset int, fn func(BetHistoryRow) error) error {
+	where, args := betHistoryWhere(userID, f)
+	rows, err := q.Query(betHistorySelect+where+`
+        ORDER BY b.created_at DESC, b.id DESC
+        LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return fmt.Errorf("error querying bets of user %d: %w", userID, err)
+	}
+	return scanBetHistory(rows, userID, fn)
+}
+
+// getBetHistoryBatch reads up to limit of a user's bets through the filter with an ID below beforeID,
+// highest ID first. A beforeID of 0 starts from the newest bet. Exports page through the bets this way,
+// so each read is short however many bets there are, and rows added meanwhile do not shift the pages.
+func getBetHistoryBatch(q querier, userID int, f BetHistoryFilter, beforeID, limit int) ([]BetHistoryRow, error) {
+	where, args := betHistoryWhere(userID, f)
+	if beforeID > 0 {
+		where += " AND b.id < ?"
+		args = append(args, beforeID)
+	}
+	rows, err := q.Query(betHistorySelect+where+`
+        ORDER BY b.id DESC
+        LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying bets of user %d: %w", userID, err)
+	}
+	var bets []BetHistoryRow
+	err = scanBetHistory(rows, userID, func(b BetHistoryRow) error {
+		bets = append(bets, b)
+		return nil
+	})
+	return bets, err
+}
+
+// scanBetHistory reads the rows of a bet history query, calling fn for each bet, and closes them.
+func scanBetHistory(rows *sql.Rows, userID int, fn func(BetHistoryRow) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		var b BetHistoryRow
+		var balance sql.NullInt64
+		err := rows.Scan(&b.ID, &b.PlacedAt, &b.SettledAt, &b.RaceID, &b.RaceName, &b.BetType, &b.chickenID, &b.Stake, &b.Odds, &b.Status,
+			&b.Payout, &balance, &b.Picks, &b.Positions)
+		if err != nil {
+			return fmt.Errorf("error scanning bet of user %d: %w", userID, err)
+		}
+		b.Balance, b.HasBalance = Money(balance.Int64), balance.Valid
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating bets of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// getBetHistory loads one page of a user's bets, newest first.
+func getBetHistory(q querier, userID int, f BetHistoryFilter) (*BetHistoryView, error) {
+	statuses, err := getBetStatusNames(q)
+	if err != nil {
+		return nil, err
+	}
+	view := &BetHistoryView{Filter: f, Statuses: statuses}
+
+	where, args := betHistoryWhere(userID, f)
+	err = q.QueryRow(`
+        SELECT COUNT(*)
+        FROM bets b
+        JOIN bet_statuses s ON b.bet_status_id = s.id
+        `+where, args...).Scan(&view.Total)
+	if err != nil {
+		return nil, fmt.Errorf("error counting bets of user %d: %w", userID, err)
+	}
+	view.Pages = (view.Total + betHistoryPageSize - 1) / betHistoryPageSize
+
+	err = queryBetHistory(q, userID, f, betHistoryPageSize, (f.Page-1)*betHistoryPageSize, func(b BetHistoryRow) error {
+		view.Bets = append(view.Bets, b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range view.Bets {
+		b := &view.Bets[i]
+		if b.Selection, err = describeBet(q, b.ID, b.RaceID, b.chickenID, b.BetType); err != nil {
+			return nil, err
+		}
+	}
+	return view, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// myBetsHandler renders the My bets page: the logged-in user's bets with their outcome and the
+// wallet balance after each, filtered by status and date and split into pages.
+// It is registered behind requireAuthentication.
+func myBetsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	currentUserID := authenticatedUserID(r)
+	data := PageData{Title: "My Bets - Scramble Run"}
+
+	if err := db.QueryRow("SELECT balance FROM users WHERE id = ?", currentUserID).Scan(&data.UserBalance); err != nil {
+		log.Printf("myBetsHandler: Error fetching balance of user %d: %v", currentUserID, err)
+	}
+
+	statuses, err := getBetStatusNames(db)
+	if err != nil {
+		log.Printf("myBetsHandler: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	filter := parseBetHistoryFilter(r.URL.Query(), statuses)
+	history, err := getBetHistory(db, currentUserID, filter)
+	if err != nil {
+		log.Printf("myBetsHandler: Error loading bets of user %d: %v", currentUserID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data.BetHistory = history
+	renderTemplateWithStatus(w, r, http.StatusOK, myBetsTemplate, "base.gohtml", data)
+}
+
+// myBetsExportHandler streams the logged-in user's bets, or with data=wallet their wallet ledger, as CSV or
+// JSON. It takes the same filter as the My bets page and ignores paging.
+func myBetsExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if data, format, ok := parseExportRequest(w, r); ok {
+		writeExport(w, r, authenticatedUserID(r), data, format)
+	}
+}
+
+// parseExportRequest reads the data and format query parameters of an export, answering with an error
+// itself when either is unknown.
+func parseExportRequest(w http.ResponseWriter, r *http.Request) (data, format string, ok bool) {
+	query := r.URL.Query()
+	format = query.Get("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+	data = query.Get("data")
+	if data == "" {
+		data = exportDataBets
+	}
+	if data != exportDataBets && data != exportDataWallet {
+		http.Error(w, "Unknown export data: use bets or wallet", http.StatusBadRequest)
+		return "", "", false
+	}
+	if format != exportFormatCSV && format != exportFormatJSON {
+		http.Error(w, "Unknown export format: use csv or json", http.StatusBadRequest)
+		return "", "", false
+	}
+	return data, format, true
+}
+
+// writeExport streams the user's bets or wallet ledger through the filter in the query string.
+// Rows are written as they are read, so an error part way through can only be logged: the status line
+// has already gone out.
+func writeExport(w http.ResponseWriter, r *http.Request, userID int, data, format string) {
+	export, header := exportBets, betExportHeader
+	if data == exportDataWallet {
+		export, header = exportWalletTransactions, walletExportHeader
+	}
+	contentType := "text/csv; charset=utf-8"
+	if format == exportFormatJSON {
+		contentType = "application/json"
+	}
+
+	statuses, err := getBetStatusNames(db)
+	if err != nil {
+		log.Printf("writeExport: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	filter := parseBetHistoryFilter(r.URL.Query(), statuses)
+
+	filename := fmt.Sprintf("scramble-run-%s-%d-%s.%s", data, userID, time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	ew, err := newExportWriter(w, format, header)
+	if err != nil {
+		log.Printf("writeExport: Error starting %s export for user %d: %v", format, userID, err)
+		return
+	}
+	if err := export(db, ew, userID, filter); err != nil {
+		log.Printf("writeExport: Export of %s for user %d stopped after %d rows: %v", data, userID, ew.n, err)
+		return
+	}
+	if err := ew.Close(); err != nil {
+		log.Printf("writeExport: Error finishing export of %s for user %d: %v", data, userID, err)
+		return
+	}
+	log.Printf("writeExport: Exported %d %s rows as %s for user %d", ew.n, data, format, userID)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// setBetPlacedAt moves a bet's placing time, stored in UTC as CURRENT_TIMESTAMP does.
+func setBetPlacedAt(t *testing.T, betID int, at time.Time) {
+	t.Helper()
+	if _, err := db.Exec("UPDATE bets SET created_at = ? WHERE id = ?", at.UTC().Format("2006-01-02 15:04:05"), betID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBetHistoryDateRange(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Punter", "punter@example.com", 100*Credit)
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+	placed := map[string]time.Time{
+		"day before, last minute": day.Add(-time.Minute),
+		"first minute":            day,
+		"last minute":             day.Add(24*time.Hour - time.Minute),
+		"day after, first minute": day.AddDate(0, 0, 1),
+	}
+	bets := make(map[int]string)
+	for name, at := range placed {
+		betID := placeTestBet(t, userID, scheduledTestRace, BetTypeWin, []int{1}, Credit, 2.5)
+		setBetPlacedAt(t, betID, at)
+		bets[betID] = name
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter BetHistoryFilter
+		want   []string
+	}{
+		{"one day", BetHistoryFilter{From: day, To: day}, []string{"first minute", "last minute"}},
+		{"from the day", BetHistoryFilter{From: day}, []string{"first minute", "last minute", "day after, first minute"}},
+		{"to the day", BetHistoryFilter{To: day}, []string{"day before, last minute", "first minute", "last minute"}},
+		{"no range", BetHistoryFilter{}, []string{"day before, last minute", "first minute", "last minute", "day after, first minute"}},
+	} {
+		tc.filter.Page = 1
+		view, err := getBetHistory(db, userID, tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for i := len(view.Bets) - 1; i >= 0; i-- { // Oldest first, to compare with want
+			got = append(got, bets[view.Bets[i].ID])
+		}
+		if len(got) != len(tc.want) || view.Total != len(tc.want) {
+			t.Errorf("%s: got %v (total %d), want %v", tc.name, got, view.Total, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBetHistoryPaging(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Punter", "punter@example.com", 100*Credit)
+	otherID := createTestUser(t, "Other", "other@example.com", 100*Credit)
+	placeTestBet(t, otherID, scheduledTestRace, BetTypeWin, []int{1}, Credit, 2.5)
+	var betIDs []int
+	for range 2*betHistoryPageSize + 5 {
+		betIDs = append(betIDs, placeTestBet(t, userID, scheduledTestRace, BetTypeWin, []int{2}, Credit, 1.8))
+	}
+
+	seen := make(map[int]bool)
+	for _, tc := range []struct {
+		page, bets       int
+		hasPrev, hasNext bool
+	}{
+		{1, betHistoryPageSize, false, true},
+		{2, betHistoryPageSize, true, true},
+		{3, 5, true, false},
+		{4, 0, true, false},
+	} {
+		view, err := getBetHistory(db, userID, BetHistoryFilter{Page: tc.page})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if view.Total != len(betIDs) || view.Pages != 3 {
+			t.Errorf("page %d: total %d in %d pages, want %d in 3", tc.page, view.Total, view.Pages, len(betIDs))
+		}
+		if len(view.Bets) != tc.bets || view.HasPrev() != tc.hasPrev || view.HasNext() != tc.hasNext {
+			t.Errorf("page %d: %d bets, prev %v, next %v; want %d, %v, %v", tc.page, len(view.Bets), view.HasPrev(), view.HasNext(), tc.bets, tc.hasPrev, tc.hasNext)
+		}
+		for i, b := range view.Bets {
+			if seen[b.ID] {
+				t.Errorf("page %d: bet %d already shown on an earlier page", tc.page, b.ID)
+			}
+			seen[b.ID] = true
+			if i > 0 && b.ID > view.Bets[i-1].ID {
+				t.Errorf("page %d: bet %d listed after older bet %d", tc.page, b.ID, view.Bets[i-1].ID)
+			}
+		}
+	}
+	if len(seen) != len(betIDs) {
+		t.Errorf("pages showed %d different bets, want %d", len(seen), len(betIDs))
+	}
+	if got := (&BetHistoryView{Filter: BetHistoryFilter{Status: "Won", Page: 2}}).NextURL(); got != "/my-bets?page=3&status=Won" {
+		t.Errorf("NextURL = %q", got)
+	}
+}
+
+func TestBetHistoryBalanceAfter(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Punter", "punter@example.com", 100*Credit)
+	winner := placeTestBet(t, userID, scheduledTestRace, BetTypeWin, []int{1}, 10*Credit, 2.5)
+	loser := placeTestBet(t, userID, scheduledTestRace, BetTypeWin, []int{2}, 20*Credit, 1.8)
+
+	// A bet from before the wallet ledger existed has no ledger entry to take a balance from.
+	pendingStatusID, err := getPendingBetStatusID(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.Exec("INSERT INTO bets (user_id, race_id, chicken_id, bet_type, bet_amount, bet_status_id, odds, potential_payout) VALUES (?, ?, 3, 'Win', ?, ?, 3.0, ?)",
+		userID, scheduledTestRace, 5*Credit, pendingStatusID, 15*Credit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := result.LastInsertId()
+
+	settleTestRace(t, scheduledTestRace, 1, 2, 3, 4, 5)
+
+	view, err := getBetHistory(db, userID, BetHistoryFilter{Page: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]struct {
+		balance    Money
+		hasBalance bool
+	}{
+		winner:      {95 * Credit, true}, // 100 - 10 - 20, then the 25 payout
+		loser:       {70 * Credit, true}, // A losing bet's latest entry is its stake
+		int(legacy): {0, false},
+	}
+	for _, b := range view.Bets {
+		w, ok := want[b.ID]
+		if !ok {
+			t.Errorf("unexpected bet %d", b.ID)
+			continue
+		}
+		if b.Balance != w.balance || b.HasBalance != w.hasBalance {
+			t.Errorf("bet %d: balance after %s (%v), want %s (%v)", b.ID, b.Balance, b.HasBalance, w.balance, w.hasBalance)
+		}
+	}
+	if len(view.Bets) != len(want) {
+		t.Errorf("got %d bets, want %d", len(view.Bets), len(want))
+	}
+}
+
+func TestExportsReadInBatches(t *testing.T) {
+	setupTestDB(t)
+	prevBatch := exportReadBatch
+	exportReadBatch = 2
+	t.Cleanup(func() { exportReadBatch = prevBatch })
+
+	playerID := createTestUser(t, "Player", "player@example.com", 100*Credit)
+	otherID := createTestUser(t, "Other", "other@example.com", 100*Credit)
+	placeTestBet(t, otherID, scheduledTestRace, BetTypeWin, []int{1}, Credit, 2.5)
+	for range 5 {
+		placeTestBet(t, playerID, scheduledTestRace, BetTypeWin, []int{2}, Credit, 1.8)
+	}
+
+	var out bytes.Buffer
+	ew, err := newExportWriter(&out, exportFormatJSON, betExportHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exportBets(db, ew, playerID, BetHistoryFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var bets []struct {
+		BetID int `json:"bet_id"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &bets); err != nil {
+		t.Fatalf("%v in %s", err, out.String())
+	}
+	if len(bets) != 5 {
+		t.Errorf("exported %d bets, want the player's 5", len(bets))
+	}
+	for i := 1; i < len(bets); i++ {
+		if bets[i].BetID >= bets[i-1].BetID {
+			t.Errorf("bet %d listed after bet %d", bets[i].BetID, bets[i-1].BetID)
+		}
+	}
+
+	out.Reset()
+	ew, err = newExportWriter(&out, exportFormatCSV, walletExportHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exportWalletTransactions(db, ew, playerID, BetHistoryFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 7 { // The header, the opening balance and 5 stakes
+		t.Errorf("wallet export has %d lines, want 7", len(records))
+	}
+	prevEntry := 0
+	for _, record := range records[1:] {
+		entry, _ := strconv.Atoi(record[0])
+		if entry <= prevEntry {
+			t.Errorf("wallet entry %d listed after %d", entry, prevEntry)
+		}
+		prevEntry = entry
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bet types, stored in bets.bet_type.
+const (
+	BetTypeWin   = "Win"   // The chicken must win
+	BetTypePlace = "Place" // The chicken must finish in the top 2
+	BetTypeShow  = "Show"  // The chicken must finish in the top 3
+
+	BetTypeExacta   = "Exacta"   // Two chickens must finish first and second, in order
+	BetTypeQuinella = "Quinella" // Two chickens must finish first and second, in either order
+	BetTypeTrifecta = "Trifecta" // Three chickens must finish first, second and third, in order
+)
+
+// betTypes lists the bet types in the order they are offered.
+var betTypes = []string{BetTypeWin, BetTypePlace, BetTypeShow, BetTypeExacta, BetTypeQuinella, BetTypeTrifecta}
+
+var (
+	// errInvalidSelection is returned when a bet does not name the right number of different chickens.
+	errInvalidSelection = errors.New("selection does not match the bet type")
+	// errBetTypeNotOffered is returned for a bet type the race does not take.
+	errBetTypeNotOffered = errors.New("bet type not offered on this race")
+)
+
+// parseBetType validates a bet type from a form. An empty value is a win bet.
+func parseBetType(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return BetTypeWin, nil
+	}
+	for _, t := range betTypes {
+		if strings.EqualFold(s, t) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown bet type %q", s)
+}
+
+// placesPaid is how many finishing places a bet type pays out on.
+func placesPaid(betType string) int {
+	switch betType {
+	case BetTypePlace:
+		return 2
+	case BetTypeShow:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// betTypeWins reports whether a bet of the given type wins for a chicken finishing at position (1-based).
+func betTypeWins(betType string, position int) bool {
+	return position >= 1 && position <= placesPaid(betType)
+}
+
+// selectionSize is how many chickens a bet of the given type names.
+func selectionSize(betType string) int {
+	switch betType {
+	case BetTypeExacta, BetTypeQuinella:
+		return 2
+	case BetTypeTrifecta:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// isCombinationBet reports whether a bet type names several chickens.
+func isCombinationBet(betType string) bool {
+	return selectionSize(betType) > 1
+}
+
+// parseSelection parses a comma-separated list of chicken IDs, in the order they were picked.
+func parseSelection(s string) ([]int, error) {
+	var selection []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chicken ID %q: %w", part, err)
+		}
+		selection = append(selection, id)
+	}
+	if len(selection) == 0 {
+		return nil, errInvalidSelection
+	}
+	return selection, nil
+}
+
+// joinSelection formats a selection the way parseSelection reads it.
+func joinSelection(selection []int) string {
+	parts := make([]string, len(selection))
+	for i, chickenID := range selection {
+		parts[i] = strconv.Itoa(chickenID)
+	}
+	return strings.Join(parts, ",")
+}
+
+// checkSelection verifies a selection names as many different chickens as the bet type needs.
+func checkSelection(betType string, selection []int) error {
+	if len(selection) != selectionSize(betType) {
+		return errInvalidSelection
+	}
+	for i := range selection {
+		for j := i + 1; j < len(selection); j++ {
+			if selection[i] == selection[j] {
+				return errInvalidSelection
+			}
+		}
+	}
+	return nil
+}
+
+// selectionWins reports whether a bet's selection comes in, given each chicken's finishing position.
+func selectionWins(betType string, selection []int, positions map[int]int) bool {
+	if checkSelection(betType, selection) != nil {
+		return false
+	}
+	switch betType {
+	case BetTypeExacta, BetTypeTrifecta:
+		for i, chickenID := range selection {
+			if positions[chickenID] != i+1 {
+				return false
+			}
+		}
+		return true
+	case BetTypeQuinella:
+		for _, chickenID := range selection {
+			if p := positions[chickenID]; p < 1 || p > len(selection) {
+				return false
+			}
+		}
+		return true
+	default:
+		return betTypeWins(betType, positions[selection[0]])
+	}
+}
+
+// describeSelection names the chickens of a bet for messages, e.g. "A then B" or "A and B in any order".
+func describeSelection(betType string, names []string) string {
+	switch betType {
+	case BetTypeExacta, BetTypeTrifecta:
+		return strings.Join(names, " then ")
+	case BetTypeQuinella:
+		return strings.Join(names, " and ") + " in any order"
+	default:
+		return strings.Join(names, ", ")
+	}
+}
+
+// describeBet names the chickens of a placed bet, e.g. "A then B", or one pick per leg for accumulators.
+func describeBet(q querier, betID int, raceID int, chickenID int, betType string) (string, error) {
+	if betType == BetTypeAccumulator {
+		legs, err := getAccumulatorLegs(q, betID)
+		if err != nil {
+			return "", err
+		}
+		return accumulatorLegNames(legs), nil
+	}
+	market, err := getRaceMarket(q, raceID)
+	if err != nil {
+		return "", err
+	}
+	selection, err := getBetSelection(q, betID, chickenID)
+	if err != nil {
+		return "", err
+	}
+	return describeSelection(betType, market.SelectionNames(selection)), nil
+}
+
+// OddsFor returns the price currently offered on the chicken for a bet type.
+// Win prices move with the book; place and show prices are fixed when the race is scheduled.
+func (c Chicken) OddsFor(betType string) float64 {
+	switch betType {
+	case BetTypePlace:
+		return c.PlaceOdds
+	case BetTypeShow:
+		return c.ShowOdds
+	default:
+		return c.CurrentOdds
+	}
+}
+
+// insertBetSelections stores the chickens a bet names, in pick order.
+func insertBetSelections(ex execer, betID int64, selection []int) error {
+	for i, chickenID := range selection {
+		_, err := ex.Exec("INSERT INTO bet_selections (bet_id, pick, chicken_id) VALUES (?, ?, ?)", betID, i+1, chickenID)
+		if err != nil {
+			return fmt.Errorf("error storing pick %d of bet %d: %w", i+1, betID, err)
+		}
+	}
+	return nil
+}
+
+// getBetSelection returns the chickens a bet names, in pick order.
+// Bets placed before selections were recorded name just their chicken_id.
+func getBetSelection(q querier, betID int, chickenID int) ([]int, error) {
+	rows, err := q.Query("SELECT chicken_id FROM bet_selections WHERE bet_id = ? ORDER BY pick", betID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying selection of bet %d: %w", betID, err)
+	}
+	defer rows.Close()
+
+	var selection []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning selection of bet %d: %w", betID, err)
+		}
+		selection = append(selection, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating selection of bet %d: %w", betID, err)
+	}
+	if len(selection) == 0 {
+		selection = []int{chickenID}
+	}
+	return selection, nil
+}
+
+// getRaceBetSelections returns the selections of every bet on a race, keyed by bet ID.
+func getRaceBetSelections(q querier, raceID int) (map[int][]int, error) {
+	rows, err := q.Query(`
+        SELECT s.bet_id, s.chicken_id
+        FROM bet_selections s
+        JOIN bets b ON s.bet_id = b.id
+        WHERE b.race_id = ?
+        ORDER BY s.bet_id, s.pick
+    `, raceID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying bet selections for race %d: %w", raceID, err)
+	}
+	defer rows.Close()
+
+	selections := make(map[int][]int)
+	for rows.Next() {
+		var betID, chickenID int
+		if err := rows.Scan(&betID, &chickenID); err != nil {
+			return nil, fmt.Errorf("error scanning bet selection for race %d: %w", raceID, err)
+		}
+		selections[betID] = append(selections[betID], chickenID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating bet selections for race %d: %w", raceID, err)
+	}
+	return selections, nil
+}
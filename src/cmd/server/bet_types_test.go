@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestSelectionWins(t *testing.T) {
+	// Chicken 3 won, 1 was second and 4 third.
+	positions := map[int]int{3: 1, 1: 2, 4: 3, 2: 4, 5: 5}
+	for _, tc := range []struct {
+		betType   string
+		selection []int
+		want      bool
+	}{
+		{BetTypeWin, []int{3}, true},
+		{BetTypeWin, []int{1}, false},
+		{BetTypePlace, []int{1}, true},
+		{BetTypePlace, []int{4}, false},
+		{BetTypeShow, []int{4}, true},
+		{BetTypeShow, []int{2}, false},
+
+		// Exactas and trifectas must name the places in order.
+		{BetTypeExacta, []int{3, 1}, true},
+		{BetTypeExacta, []int{1, 3}, false},
+		{BetTypeExacta, []int{3, 4}, false},
+		{BetTypeTrifecta, []int{3, 1, 4}, true},
+		{BetTypeTrifecta, []int{3, 4, 1}, false},
+		{BetTypeTrifecta, []int{1, 3, 4}, false},
+
+		// Quinellas take the first two in either order, but only the first two.
+		{BetTypeQuinella, []int{3, 1}, true},
+		{BetTypeQuinella, []int{1, 3}, true},
+		{BetTypeQuinella, []int{3, 4}, false},
+		{BetTypeQuinella, []int{1, 4}, false},
+
+		// Malformed selections never win.
+		{BetTypeExacta, []int{3, 3}, false},
+		{BetTypeExacta, []int{3}, false},
+		{BetTypeTrifecta, []int{3, 1}, false},
+		{BetTypeWin, []int{6}, false},
+	} {
+		if got := selectionWins(tc.betType, tc.selection, positions); got != tc.want {
+			t.Errorf("selectionWins(%s, %v) = %v, want %v", tc.betType, tc.selection, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Promotion kinds, stored in promotions.kind.
+const (
+	PromotionSignup    = "Signup"    // Granted once when an account is created
+	PromotionDaily     = "Daily"     // Granted once per calendar day, on login or from the races page
+	PromotionPromoCode = "PromoCode" // Granted when the user redeems the promotion's code
+)
+
+// bonusClaimDayLayout is the format of bonus_claims.claim_day, the local date of a daily top-up.
+const bonusClaimDayLayout = "2006-01-02"
+
+var (
+	errPromotionNotFound   = errors.New("promotion not found")
+	errPromotionNotLive    = errors.New("promotion is not running")
+	errBonusAlreadyClaimed = errors.New("bonus already claimed")
+	errPromotionExhausted  = errors.New("promotion has no claims left")
+	errWageringOutstanding = errors.New("wagering requirement outstanding")
+)
+
+// promotionLiveSQL is true for promotions that are switched on and within their start and end times.
+const promotionLiveSQL = `p.active = 1
+        AND (p.starts_at IS NULL OR p.starts_at <= CURRENT_TIMESTAMP)
+        AND (p.ends_at IS NULL OR p.ends_at > CURRENT_TIMESTAMP)`
+
+// Promotion is a bonus users can claim, configured in the promotions table.
+type Promotion struct {
+	ID                 int
+	Kind               string
+	Code               string // Empty unless Kind is PromotionPromoCode
+	Name               string
+	Amount             Money
+	WageringMultiplier float64       // Stake to settle before the next promo code, as a multiple of Amount
+	MaxClaimsPerUser   sql.NullInt64 // NULL for no limit; daily top-ups are still once a day
+	MaxClaimsTotal     sql.NullInt64 // NULL for no limit
+}
+
+// BonusClaim is a bonus a user has received, with its wagering progress.
+type BonusClaim struct {
+	ID               int
+	Name             string
+	Amount           Money
+	WageringRequired Money
+	Wagered          Money
+	CreatedAt        time.Time
+}
+
+// Remaining returns how much the user still has to wager to clear the claim.
+func (c BonusClaim) Remaining() Money {
+	if c.Wagered >= c.WageringRequired {
+		return 0
+	}
+	return c.WageringRequired - c.Wagered
+}
+
+// normalizePromoCode makes promo codes case-insensitive.
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// scanPromotions reads promotions selected as p.id, p.kind, p.code, p.name, p.amount, p.wagering_multiplier,
+// p.max_claims_per_user, p.max_claims_total.
+func scanPromotions(rows *sql.Rows) ([]Promotion, error) {
+	defer rows.Close()
+	var promotions []Promotion
+	for rows.Next() {
+		var p Promotion
+		var code sql.NullString
+		if err := rows.Scan(&p.ID, &p.Kind, &code, &p.Name, &p.Amount, &p.WageringMultiplier, &p.MaxClaimsPerUser, &p.MaxClaimsTotal); err != nil {
+			return nil, fmt.Errorf("error scanning promotion: %w", err)
+		}
+		p.Code = code.String
+		promotions = append(promotions, p)
+	}
+	return promotions, rows.Err()
+}
+
+// getLivePromotions returns the promotions of a kind that can be claimed right now.
+func getLivePromotions(q querier, kind string) ([]Promotion, error) {
+	rows, err := q.Query(`
+        SELECT p.id, p.kind, p.code, p.name, p.amount, p.wagering_multiplier, p.max_claims_per_user, p.max_claims_total
+        FROM promotions p
+        WHERE p.kind = ? AND `+promotionLiveSQL+`
+        ORDER BY p.id`, kind)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s promotions: %w", kind, err)
+	}
+	return scanPromotions(rows)
+}
+
+// claimPromotion grants a promotion's bonus to a user: it checks the claim limits, credits the wallet
+// through the ledger and records the claim with its wagering requirement. It is the only place bonus
+// credits are granted, and must run in a transaction. It returns the user's new balance.
+func claimPromotion(ex queryExecer, userID int, p Promotion, now time.Time) (Money, error) {
+	if err := checkSelfExclusion(ex, userID, now); err != nil {
+		return 0, err
+	}
+	// Daily claims are kept apart by their day, others by their number, so that the unique indexes on
+	// bonus_claims refuse a claim made twice at once.
+	var claimDay sql.NullString
+	var claimNumber sql.NullInt64
+	if p.Kind == PromotionDaily {
+		claimDay = sql.NullString{String: now.Format(bonusClaimDayLayout), Valid: true}
+		var claimedToday int
+		err := ex.QueryRow("SELECT COUNT(*) FROM bonus_claims WHERE promotion_id = ? AND user_id = ? AND claim_day = ?",
+			p.ID, userID, claimDay).Scan(&claimedToday)
+		if err != nil {
+			return 0, fmt.Errorf("error checking today's claims of promotion %d by user %d: %w", p.ID, userID, err)
+		}
+		if claimedToday > 0 {
+			return 0, errBonusAlreadyClaimed
+		}
+	}
+	var userClaims int64
+	if err := ex.QueryRow("SELECT COUNT(*) FROM bonus_claims WHERE promotion_id = ? AND user_id = ?", p.ID, userID).Scan(&userClaims); err != nil {
+		return 0, fmt.Errorf("error counting claims of promotion %d by user %d: %w", p.ID, userID, err)
+	}
+	if p.MaxClaimsPerUser.Valid && userClaims >= p.MaxClaimsPerUser.Int64 {
+		return 0, errBonusAlreadyClaimed
+	}
+	if p.Kind != PromotionDaily {
+		claimNumber = sql.NullInt64{Int64: userClaims + 1, Valid: true}
+	}
+	if p.MaxClaimsTotal.Valid {
+		var claims int64
+		if err := ex.QueryRow("SELECT COUNT(*) FROM bonus_claims WHERE promotion_id = ?", p.ID).Scan(&claims); err != nil {
+			return 0, fmt.Errorf("error counting claims of promotion %d: %w", p.ID, err)
+		}
+		if claims >= p.MaxClaimsTotal.Int64 {
+			return 0, errPromotionExhausted
+		}
+	}
+
+	// The claim goes in before the credit, so a claim refused here has credited nothing: callers skip
+	// errBonusAlreadyClaimed and carry on in the same transaction.
+	_, err := ex.Exec("INSERT INTO bonus_claims (promotion_id, user_id, amount, wagering_required, claim_day, claim_number) VALUES (?, ?, ?, ?, ?, ?)",
+		p.ID, userID, p.Amount, p.Amount.MulRate(p.WageringMultiplier), claimDay, claimNumber)
+	if isUniqueViolation(err) {
+		return 0, errBonusAlreadyClaimed
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error recording claim of promotion %d by user %d: %w", p.ID, userID, err)
+	}
+	return postWalletTransaction(ex, WalletTransaction{UserID: userID, Kind: WalletTxBonus, Amount: p.Amount, Note: p.Name})
+}
+
+// grantSignupBonuses grants every live signup promotion to a new account, skipping any that have run out.
+// It returns the total granted.
+func grantSignupBonuses(ex queryExecer, userID int) (Money, error) {
+	promotions, err := getLivePromotions(ex, PromotionSignup)
+	if err != nil {
+		return 0, err
+	}
+	var granted Money
+	for _, p := range promotions {
+		if _, err := claimPromotion(ex, userID, p, time.Now()); err != nil {
+			if errors.Is(err, errPromotionExhausted) || errors.Is(err, errBonusAlreadyClaimed) {
+				continue
+			}
+			return 0, err
+		}
+		granted += p.Amount
+	}
+	return granted, nil
+}
+
+// claimDailyTopUp grants the live daily promotions the user has not claimed today. It returns the total
+// granted, or errBonusAlreadyClaimed when there was nothing left to claim.
+func claimDailyTopUp(ex queryExecer, userID int, now time.Time) (Money, error) {
+	promotions, err := getLivePromotions(ex, PromotionDaily)
+	if err != nil {
+		return 0, err
+	}
+	var granted Money
+	for _, p := range promotions {
+		if _, err := claimPromotion(ex, userID, p, now); err != nil {
+			if errors.Is(err, errPromotionExhausted) || errors.Is(err, errBonusAlreadyClaimed) {
+				continue
+			}
+			return 0, err
+		}
+		granted += p.Amount
+	}
+	if granted == 0 {
+		return 0, errBonusAlreadyClaimed
+	}
+	return granted, nil
+}
+
+// redeemPromoCode grants the promotion with the given code. A user must finish wagering their earlier
+// bonuses before redeeming another code.
+func redeemPromoCode(ex queryExecer, userID int, code string) (Promotion, error) {
+	var p Promotion
+	var codeValue sql.NullString
+	var live bool
+	err := ex.QueryRow(`
+        SELECT p.id, p.kind, p.code, p.name, p.amount, p.wagering_multiplier, p.max_claims_per_user, p.max_claims_total,
+               `+promotionLiveSQL+`
+        FROM promotions p
+        WHERE p.kind = ? AND p.code = ?`, PromotionPromoCode, normalizePromoCode(code)).
+		Scan(&p.ID, &p.Kind, &codeValue, &p.Name, &p.Amount, &p.WageringMultiplier, &p.MaxClaimsPerUser, &p.MaxClaimsTotal, &live)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, errPromotionNotFound
+	}
+	if err != nil {
+		return p, fmt.Errorf("error looking up promo code %q: %w", code, err)
+	}
+	p.Code = codeValue.String
+	if !live {
+		return p, errPromotionNotLive
+	}
+
+	outstanding, err := getOutstandingWagering(ex, userID)
+	if err != nil {
+		return p, err
+	}
+	if outstanding > 0 {
+		return p, fmt.Errorf("%w: %s credits to go", errWageringOutstanding, outstanding)
+	}
+	_, err = claimPromotion(ex, userID, p, time.Now())
+	return p, err
+}
+
+// getOutstandingWagering returns how much the user still has to wager across all their bonuses.
+func getOutstandingWagering(q rowQuerier, userID int) (Money, error) {
+	var outstanding Money
+	err := q.QueryRow("SELECT COALESCE(SUM(wagering_required - wagered), 0) FROM bonus_claims WHERE user_id = ? AND wagered < wagering_required",
+		userID).Scan(&outstanding)
+	if err != nil {
+		return 0, fmt.Errorf("error summing outstanding wagering of user %d: %w", userID, err)
+	}
+	return outstanding, nil
+}
+
+// recordBonusWagering counts a settled stake towards the user's open wagering requirements, oldest bonus first.
+// Only bets that are won or lost count: stakes that are refunded or cashed out do not.
+func recordBonusWagering(ex queryExecer, userID int, stake Money) error {
+	type openClaim struct {
+		id        int
+		remaining Money
+	}
+	rows, err := ex.Query("SELECT id, wagering_required - wagered FROM bonus_claims WHERE user_id = ? AND wagered < wagering_required ORDER BY id", userID)
+	if err != nil {
+		return fmt.Errorf("error querying open bonus claims of user %d: %w", userID, err)
+	}
+	var claims []openClaim
+	for rows.Next() {
+		var c openClaim
+		if err := rows.Scan(&c.id, &c.remaining); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning bonus claim of user %d: %w", userID, err)
+		}
+		claims = append(claims, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating bonus claims of user %d: %w", userID, err)
+	}
+
+	for _, c := range claims {
+		if stake <= 0 {
+			break
+		}
+		counted := stake.Min(c.remaining)
+		if _, err := ex.Exec("UPDATE bonus_claims SET wagered = wagered + ? WHERE id = ?", counted, c.id); err != nil {
+			return fmt.Errorf("error recording wagering on bonus claim %d: %w", c.id, err)
+		}
+		stake -= counted
+	}
+	return nil
+}
+
+// BonusView is the bonus panel of the races page.
+type BonusView struct {
+	LoggedIn    bool
+	DailyAmount Money        // Daily top-up still to claim today; 0 once claimed
+	Claims      []BonusClaim // Bonuses with wagering still to do
+	Message     string
+	Success     bool
+	NewBalance  Money // -1 when the balance is not shown
+}
+
+// getBonusView loads a user's daily top-up and the bonuses they are still wagering.
+func getBonusView(q querier, userID int, now time.Time) (BonusView, error) {
+	view := BonusView{LoggedIn: true, NewBalance: -1}
+	err := q.QueryRow(`
+        SELECT COALESCE(SUM(p.amount), 0)
+        FROM promotions p
+        WHERE p.kind = ? AND `+promotionLiveSQL+`
+          AND NOT EXISTS (SELECT 1 FROM bonus_claims c WHERE c.promotion_id = p.id AND c.user_id = ? AND c.claim_day = ?)`,
+		PromotionDaily, userID, now.Format(bonusClaimDayLayout)).Scan(&view.DailyAmount)
+	if err != nil {
+		return view, fmt.Errorf("error checking daily top-up of user %d: %w", userID, err)
+	}
+
+	rows, err := q.Query(`
+        SELECT c.id, p.name, c.amount, c.wagering_required, c.wagered, c.created_at
+        FROM bonus_claims c
+        JOIN promotions p ON c.promotion_id = p.id
+        WHERE c.user_id = ? AND c.wagered < c.wagering_required
+        ORDER BY c.id`, userID)
+	if err != nil {
+		return view, fmt.Errorf("error querying bonus claims of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c BonusClaim
+		if err := rows.Scan(&c.ID, &c.Name, &c.Amount, &c.WageringRequired, &c.Wagered, &c.CreatedAt); err != nil {
+			return view, fmt.Errorf("error scanning bonus claim of user %d: %w", userID, err)
+		}
+		view.Claims = append(view.Claims, c)
+	}
+	return view, rows.Err()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// bonusErrorMessage turns an error from claiming a bonus into a message for the user.
+func bonusErrorMessage(err error) string {
+	if msg := gamblingLimitMessage(err); msg != "" {
+		return msg
+	}
+	switch {
+	case errors.Is(err, errPromotionNotFound):
+		return "That promo code does not exist."
+	case errors.Is(err, errPromotionNotLive):
+		return "That promo code is not running at the moment."
+	case errors.Is(err, errBonusAlreadyClaimed):
+		return "You have already claimed this bonus."
+	case errors.Is(err, errPromotionExhausted):
+		return "Sorry, this promotion has run out."
+	case errors.Is(err, errWageringOutstanding):
+		return "Finish wagering your current bonus before redeeming another code."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// renderBonuses renders the bonus panel, with the outcome of the claim that was just made.
+func renderBonuses(w http.ResponseWriter, userID int, message string, success bool) {
+	view := BonusView{Message: message, Success: success, NewBalance: -1}
+	if userID != 0 {
+		loaded, err := getBonusView(db, userID, time.Now())
+		if err != nil {
+			log.Printf("renderBonuses: Error loading bonuses of user %d: %v", userID, err)
+			if view.Message == "" {
+				view.Message = "Could not load your bonuses."
+			}
+		}
+		loaded.Message, loaded.Success = view.Message, view.Success
+		view = loaded
+		if message != "" {
+			_ = db.QueryRow("SELECT balance FROM users WHERE id = ?", userID).Scan(&view.NewBalance)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := raceTemplate.ExecuteTemplate(w, "bonuses", view); err != nil {
+		log.Printf("renderBonuses: Template execution error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// grantLoginTopUp claims the day's top-up for a user who has just logged in. Failing to grant it
+// does not stop the login; the user can still claim it from the races page.
+func grantLoginTopUp(ctx context.Context, userID int) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("grantLoginTopUp: Failed to begin transaction: %v", err)
+		return
+	}
+	defer tx.Rollback() // No-op once committed
+
+	granted, err := claimDailyTopUp(tx, userID, time.Now())
+	if errors.Is(err, errBonusAlreadyClaimed) || gamblingLimitMessage(err) != "" {
+		return // Already had today's, or self-excluded
+	}
+	if err != nil {
+		log.Printf("grantLoginTopUp: Error granting daily top-up to user %d: %v", userID, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("grantLoginTopUp: Error committing daily top-up of user %d: %v", userID, err)
+		return
+	}
+	log.Printf("grantLoginTopUp: User %d received a daily top-up of %s.", userID, granted)
+}
+
+// bonusesHandler renders the current user's bonus panel on the races page.
+func bonusesHandler(w http.ResponseWriter, r *http.Request) {
+	renderBonuses(w, authenticatedUserID(r), "", false)
+}
+
+// claimDailyBonusHandler claims today's top-up for a user who has not had it yet.
+func claimDailyBonusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	currentUserID := authenticatedUserID(r)
+	if currentUserID == 0 {
+		renderBonuses(w, 0, "Please log in to claim bonuses.", false)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("claimDailyBonusHandler: Failed to begin transaction: %v", err)
+		renderBonuses(w, currentUserID, bonusErrorMessage(err), false)
+		return
+	}
+	defer tx.Rollback() // No-op once committed
+
+	granted, err := claimDailyTopUp(tx, currentUserID, time.Now())
+	if err != nil {
+		log.Printf("claimDailyBonusHandler: User %d cannot claim a daily top-up: %v", currentUserID, err)
+		message := bonusErrorMessage(err)
+		if errors.Is(err, errBonusAlreadyClaimed) {
+			message = "You have already had today's top-up. Come back tomorrow!"
+		}
+		renderBonuses(w, currentUserID, message, false)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("claimDailyBonusHandler: Error committing daily top-up of user %d: %v", currentUserID, err)
+		renderBonuses(w, currentUserID, bonusErrorMessage(err), false)
+		return
+	}
+	log.Printf("claimDailyBonusHandler: User %d claimed a daily top-up of %s.", currentUserID, granted)
+	renderBonuses(w, currentUserID, fmt.Sprintf("%s credits added to your balance.", granted), true)
+}
+
+// redeemPromoCodeHandler grants the promotion behind a promo code.
+func redeemPromoCodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	currentUserID := authenticatedUserID(r)
+	if currentUserID == 0 {
+		renderBonuses(w, 0, "Please log in to redeem a promo code.", false)
+		return
+	}
+	code := strings.TrimSpace(r.FormValue("promoCode"))
+	if code == "" || len(code) > 64 {
+		renderBonuses(w, currentUserID, "Enter a promo code.", false)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("redeemPromoCodeHandler: Failed to begin transaction: %v", err)
+		renderBonuses(w, currentUserID, bonusErrorMessage(err), false)
+		return
+	}
+	defer tx.Rollback() // No-op once committed
+
+	p, err := redeemPromoCode(tx, currentUserID, code)
+	if err != nil {
+		log.Printf("redeemPromoCodeHandler: User %d cannot redeem promo code %q: %v", currentUserID, code, err)
+		renderBonuses(w, currentUserID, bonusErrorMessage(err), false)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("redeemPromoCodeHandler: Error committing promo code %q for user %d: %v", code, currentUserID, err)
+		renderBonuses(w, currentUserID, bonusErrorMessage(err), false)
+		return
+	}
+	log.Printf("redeemPromoCodeHandler: User %d redeemed promo code %s for %s.", currentUserID, p.Code, p.Amount)
+	renderBonuses(w, currentUserID, fmt.Sprintf("%s: %s credits added to your balance.", p.Name, p.Amount), true)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// getTestPromotion loads a promotion by ID, live or not.
+func getTestPromotion(t *testing.T, id int) Promotion {
+	t.Helper()
+	rows, err := db.Query("SELECT p.id, p.kind, p.code, p.name, p.amount, p.wagering_multiplier, p.max_claims_per_user, p.max_claims_total FROM promotions p WHERE p.id = ?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	promotions, err := scanPromotions(rows)
+	if err != nil || len(promotions) != 1 {
+		t.Fatalf("loading promotion %d: %v, %v", id, promotions, err)
+	}
+	return promotions[0]
+}
+
+func TestDailyTopUpOncePerDay(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Punter", "punter@example.com", 0)
+	morning := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+
+	for _, tc := range []struct {
+		name string
+		now  time.Time
+		want error
+	}{
+		{"first claim", morning, nil},
+		{"same day", morning.Add(12 * time.Hour), errBonusAlreadyClaimed},
+		{"last minute of the day", morning.Add(24*time.Hour - time.Minute), errBonusAlreadyClaimed},
+		{"next day", morning.AddDate(0, 0, 1), nil},
+	} {
+		granted, err := claimDailyTopUp(db, userID, tc.now)
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("%s: claimDailyTopUp = %s, %v; want %v", tc.name, granted, err, tc.want)
+		}
+		if err == nil && granted != 100*Credit {
+			t.Errorf("%s: granted %s, want 100.00", tc.name, granted)
+		}
+	}
+	var balance Money
+	if err := db.QueryRow("SELECT balance FROM users WHERE id = ?", userID).Scan(&balance); err != nil || balance != 200*Credit {
+		t.Errorf("balance = %s, %v; want two top-ups", balance, err)
+	}
+}
+
+func TestPromotionClaimLimits(t *testing.T) {
+	setupTestDB(t)
+	result, err := db.Exec("INSERT INTO promotions (kind, code, name, amount, max_claims_per_user, max_claims_total) VALUES ('PromoCode', 'TWICE', 'Twice', 1000, 2, 3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	promotionID, _ := result.LastInsertId()
+	p := getTestPromotion(t, int(promotionID))
+	first := createTestUser(t, "First", "first@example.com", 0)
+	second := createTestUser(t, "Second", "second@example.com", 0)
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name   string
+		userID int
+		want   error
+	}{
+		{"first claim", first, nil},
+		{"second claim", first, nil},
+		{"over the per-user limit", first, errBonusAlreadyClaimed},
+		{"another user", second, nil},
+		{"over the total limit", second, errPromotionExhausted},
+	} {
+		if _, err := claimPromotion(db, tc.userID, p, now); !errors.Is(err, tc.want) {
+			t.Errorf("%s: claimPromotion = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	var claims, credited int
+	err = db.QueryRow("SELECT (SELECT COUNT(*) FROM bonus_claims WHERE promotion_id = ?), (SELECT COUNT(*) FROM wallet_transactions WHERE kind = ?)",
+		promotionID, WalletTxBonus).Scan(&claims, &credited)
+	if err != nil || claims != 3 || credited != 3 {
+		t.Errorf("%d claims and %d credits recorded, %v; want 3 of each", claims, credited, err)
+	}
+}
+
+func TestBonusClaimUniqueIndex(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Punter", "punter@example.com", 0)
+	signup := getTestPromotion(t, 1)
+
+	// claim_day is NULL for Signup and PromoCode claims, so only claim_number keeps them apart.
+	if _, err := db.Exec("INSERT INTO bonus_claims (promotion_id, user_id, amount, claim_number) VALUES (1, ?, 100, 1)", userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO bonus_claims (promotion_id, user_id, amount, claim_number) VALUES (1, ?, 100, 1)", userID); !isUniqueViolation(err) {
+		t.Errorf("a second first claim of a signup bonus: got %v, want a unique constraint error", err)
+	}
+	if _, err := db.Exec("INSERT INTO bonus_claims (promotion_id, user_id, amount, claim_day) VALUES (2, ?, 100, '2026-03-10'), (2, ?, 100, '2026-03-11')", userID, userID); err != nil {
+		t.Errorf("daily claims on different days: %v", err)
+	}
+
+	// A claim made at the same time as another passes the count, then loses on the index.
+	if _, err := db.Exec("INSERT INTO bonus_claims (promotion_id, user_id, amount, claim_number) VALUES (1, ?, 100, 2)", userID); err != nil {
+		t.Fatal(err)
+	}
+	signup.MaxClaimsPerUser.Valid = false
+	if _, err := db.Exec("DELETE FROM bonus_claims WHERE promotion_id = 1 AND claim_number = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := claimPromotion(db, userID, signup, time.Now()); !errors.Is(err, errBonusAlreadyClaimed) {
+		t.Errorf("claim numbered like an existing one: got %v, want errBonusAlreadyClaimed", err)
+	}
+	var balance Money
+	if err := db.QueryRow("SELECT balance FROM users WHERE id = ?", userID).Scan(&balance); err != nil || balance != 0 {
+		t.Errorf("balance after the refused claim = %s, %v; want nothing credited", balance, err)
+	}
+}
+
+func TestAddBonusClaimNumberMigration(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Punter", "punter@example.com", 0)
+	// A database from before claims were numbered.
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DROP INDEX idx_bonus_claims_number", nil},
+		{"ALTER TABLE bonus_claims DROP COLUMN claim_number", nil},
+		{"INSERT INTO bonus_claims (promotion_id, user_id, amount) VALUES (3, 1, 100), (3, ?, 100), (3, ?, 100)", []interface{}{userID, userID}},
+		{"INSERT INTO bonus_claims (promotion_id, user_id, amount, claim_day) VALUES (2, ?, 100, '2026-03-10')", []interface{}{userID}},
+	} {
+		if _, err := db.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 2 {
+		if err := migrateDatabase(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query("SELECT claim_number FROM bonus_claims WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []int64
+	for rows.Next() {
+		var n *int64
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n == nil {
+			got = append(got, 0)
+		} else {
+			got = append(got, *n)
+		}
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 0 {
+		t.Errorf("claim numbers = %v, want [1 2 0]", got)
+	}
+	if _, err := db.Exec("INSERT INTO bonus_claims (promotion_id, user_id, amount, claim_number) VALUES (3, ?, 100, 2)", userID); !isUniqueViolation(err) {
+		t.Errorf("after the migration a repeated claim number: got %v, want a unique constraint error", err)
+	}
+}
+
+func TestRecordBonusWagering(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Punter", "punter@example.com", 0)
+	for _, required := range []Money{50 * Credit, 30 * Credit, 0} {
+		if _, err := db.Exec("INSERT INTO bonus_claims (promotion_id, user_id, amount, wagering_required, claim_number) VALUES (3, ?, 100, ?, (SELECT COUNT(*) + 1 FROM bonus_claims))",
+			userID, required); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wagered := func() []Money {
+		rows, err := db.Query("SELECT wagered FROM bonus_claims WHERE user_id = ? ORDER BY id", userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var w []Money
+		for rows.Next() {
+			var m Money
+			if err := rows.Scan(&m); err != nil {
+				t.Fatal(err)
+			}
+			w = append(w, m)
+		}
+		return w
+	}
+	for _, tc := range []struct {
+		name        string
+		stake       Money
+		want        []Money
+		outstanding Money
+	}{
+		{"oldest bonus first", 20 * Credit, []Money{20 * Credit, 0, 0}, 60 * Credit},
+		{"spills into the next bonus", 40 * Credit, []Money{50 * Credit, 10 * Credit, 0}, 20 * Credit},
+		{"stops at the requirement", 100 * Credit, []Money{50 * Credit, 30 * Credit, 0}, 0},
+	} {
+		if err := recordBonusWagering(db, userID, tc.stake); err != nil {
+			t.Fatal(err)
+		}
+		got := wagered()
+		for i := range tc.want {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: wagered %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+		if outstanding, err := getOutstandingWagering(db, userID); err != nil || outstanding != tc.outstanding {
+			t.Errorf("%s: outstanding %s, %v; want %s", tc.name, outstanding, err, tc.outstanding)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxChickenNameLength = 40
+	minRacingChickens    = 3 // Show and trifecta bets need a field of at least three
+	maxPortraitBytes     = 2 * 1024 * 1024
+
+	// Bounds for a chicken's attributes. The seeded stable runs at speeds around 6 with acceleration around 2.
+	minChickenOdds         = 1.01
+	maxChickenOdds         = 100.0
+	maxChickenSpeed        = 15.0
+	maxChickenAcceleration = 10.0
+
+	chickenPortraitURLPrefix = "/static/images/chickens/"
+)
+
+// chickenPortraitDir is where uploaded portraits are stored; main.go serves it under chickenPortraitURLPrefix.
+var chickenPortraitDir = "src/web/static/images/chickens"
+
+// portraitExtensions maps the image types accepted as portraits to the extension they are stored with.
+var portraitExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var chickenColorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+var (
+	errChickenNotFound     = errors.New("chicken not found")
+	errInvalidChickenName  = errors.New("invalid chicken name")
+	errChickenNameTaken    = errors.New("chicken name already taken")
+	errInvalidChickenColor = errors.New("invalid chicken colour")
+	errInvalidChickenOdds  = errors.New("invalid chicken odds")
+	errInvalidChickenStats = errors.New("invalid chicken racing attributes")
+	errChickenInActiveRace = errors.New("chicken is entered in a scheduled or running race")
+	errChickenHasRaced     = errors.New("chicken has race history")
+	errStableTooSmall      = errors.New("too few chickens left to race")
+	errInvalidPortrait     = errors.New("portrait is not a PNG, JPEG, GIF or WebP image")
+	errPortraitTooLarge    = errors.New("portrait is too large")
+)
+
+// ChickenInput is the part of a chicken an administrator edits.
+type ChickenInput struct {
+	Name         string
+	Color        string
+	Odds         float64
+	Speed        float64
+	Acceleration float64
+	Stamina      float64
+}
+
+// defaultChickenInput matches the column defaults of the chickens table.
+var defaultChickenInput = ChickenInput{Color: "#f59e0b", Odds: 2.0, Speed: 6.0, Acceleration: 2.0, Stamina: 0.7}
+
+// normalize trims the name and lowercases the colour, then checks every attribute is in range.
+func (in *ChickenInput) normalize() error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Color = strings.ToLower(strings.TrimSpace(in.Color))
+	switch {
+	case in.Name == "" || utf8.RuneCountInString(in.Name) > maxChickenNameLength:
+		return errInvalidChickenName
+	case !chickenColorPattern.MatchString(in.Color):
+		return errInvalidChickenColor
+	// Written so that NaN fails the checks too.
+	case !(in.Odds >= minChickenOdds && in.Odds <= maxChickenOdds):
+		return errInvalidChickenOdds
+	case !(in.Speed > 0 && in.Speed <= maxChickenSpeed),
+		!(in.Acceleration > 0 && in.Acceleration <= maxChickenAcceleration),
+		!(in.Stamina >= 0 && in.Stamina <= 1):
+		return errInvalidChickenStats
+	}
+	return nil
+}
+
+// StableChicken is a chicken on the admin stable page.
+type StableChicken struct {
+	Chicken
+	ImagePath   string // Empty when the chicken has no portrait
+	Retired     bool
+	ActiveRaces int // Scheduled or running races the chicken is entered in
+	Starts      int // Races the chicken has ever been entered in, whatever their outcome
+}
+
+// CanDelete reports whether the chicken can be deleted. Chickens with race history are retired instead,
+// so past races, bets and results keep their chicken.
+func (c StableChicken) CanDelete() bool {
+	return c.Starts == 0
+}
+
+// Input returns the chicken's editable attributes.
+func (c StableChicken) Input() ChickenInput {
+	return ChickenInput{Name: c.Name, Color: c.Color, Odds: c.Odds, Speed: c.Speed, Acceleration: c.Acceleration, Stamina: c.Stamina}
+}
+
+const stableChickenQuery = `
+    SELECT c.id, c.name, c.color, c.odds, c.speed, c.acceleration, c.stamina, COALESCE(c.image_path, ''), c.retired_at IS NOT NULL,
+           (SELECT COUNT(*) FROM race_entrants e JOIN races r ON e.race_id = r.id WHERE e.chicken_id = c.id AND r.status IN (?, ?)),
+           (SELECT COUNT(*) FROM race_entrants e WHERE e.chicken_id = c.id)
+    FROM chickens c
+`
+
+// scanStableChicken scans a row of stableChickenQuery.
+func scanStableChicken(scan func(dest ...interface{}) error) (StableChicken, error) {
+	var c StableChicken
+	err := scan(&c.ID, &c.Name, &c.Color, &c.Odds, &c.Speed, &c.Acceleration, &c.Stamina, &c.ImagePath, &c.Retired, &c.ActiveRaces, &c.Starts)
+	return c, err
+}
+
+// listStableChickens returns every chicken in the stable, racing chickens first.
+func listStableChickens(q querier) ([]StableChicken, error) {
+	rows, err := q.Query(stableChickenQuery+" ORDER BY c.retired_at IS NOT NULL, c.name COLLATE NOCASE", RaceStatusScheduled, RaceStatusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("error querying the stable: %w", err)
+	}
+	defer rows.Close()
+
+	var chickens []StableChicken
+	for rows.Next() {
+		c, err := scanStableChicken(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning chicken: %w", err)
+		}
+		chickens = append(chickens, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating the stable: %w", err)
+	}
+	return chickens, nil
+}
+
+// getStableChicken returns a single chicken, or errChickenNotFound.
+func getStableChicken(q rowQuerier, chickenID int) (StableChicken, error) {
+	c, err := scanStableChicken(q.QueryRow(stableChickenQuery+" WHERE c.id = ?", RaceStatusScheduled, RaceStatusRunning, chickenID).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, errChickenNotFound
+	}
+	if err != nil {
+		return c, fmt.Errorf("error loading chicken %d: %w", chickenID, err)
+	}
+	return c, nil
+}
+
+// checkChickenName returns errChickenNameTaken if another chicken already has the name, ignoring case.
+func checkChickenName(q rowQuerier, name string, exceptID int) error {
+	var count int
+	if err := q.QueryRow("SELECT COUNT(*) FROM chickens WHERE name = ? COLLATE NOCASE AND id != ?", name, exceptID).Scan(&count); err != nil {
+		return fmt.Errorf("error checking chicken name: %w", err)
+	}
+	if count > 0 {
+		return errChickenNameTaken
+	}
+	return nil
+}
+
+// countRacingChickens returns how many chickens are not retired.
+func countRacingChickens(q rowQuerier) (int, error) {
+	var count int
+	if err := q.QueryRow("SELECT COUNT(*) FROM chickens WHERE retired_at IS NULL").Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting racing chickens: %w", err)
+	}
+	return count, nil
+}
+
+// createChicken adds a chicken to the stable. It is entered in races from the next one scheduled.
+func createChicken(db *sql.DB, actor AuditActor, in ChickenInput) (int, error) {
+	if err := in.normalize(); err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkChickenName(tx, in.Name, 0); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("INSERT INTO chickens (name, color, odds, speed, acceleration, stamina) VALUES (?, ?, ?, ?, ?, ?)",
+		in.Name, in.Color, in.Odds, in.Speed, in.Acceleration, in.Stamina)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting chicken: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error reading new chicken ID: %w", err)
+	}
+	event := newAuditEvent(actor, AuditChickenCreated, "chicken", int(id))
+	event.After = auditValue(in)
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing new chicken: %w", err)
+	}
+	return int(id), nil
+}
+
+// updateChicken changes a chicken's attributes. Races copy a chicken's colour and odds when they are drawn,
+// but the simulation reads the racing attributes from the stable, so those are locked while the chicken is
+// entered in a scheduled or running race.
+func updateChicken(db *sql.DB, actor AuditActor, chickenID int, in ChickenInput) error {
+	if err := in.normalize(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := getStableChicken(tx, chickenID)
+	if err != nil {
+		return err
+	}
+	if current.ActiveRaces > 0 && (in.Speed != current.Speed || in.Acceleration != current.Acceleration || in.Stamina != current.Stamina) {
+		return errChickenInActiveRace
+	}
+	if err := checkChickenName(tx, in.Name, chickenID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE chickens SET name = ?, color = ?, odds = ?, speed = ?, acceleration = ?, stamina = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		in.Name, in.Color, in.Odds, in.Speed, in.Acceleration, in.Stamina, chickenID)
+	if err != nil {
+		return fmt.Errorf("error updating chicken %d: %w", chickenID, err)
+	}
+	if before := current.Input(); before != in {
+		event := newAuditEvent(actor, AuditChickenUpdated, "chicken", chickenID)
+		event.Before = auditValue(before)
+		event.After = auditValue(in)
+		if _, err := recordAuditEvent(tx, event); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing chicken %d: %w", chickenID, err)
+	}
+	return nil
+}
+
+// setChickenRetired retires a chicken or brings it back. A retired chicken still runs the races it is
+// already entered in, but is not drawn for new ones. The stable keeps at least minRacingChickens racing.
+func setChickenRetired(db *sql.DB, actor AuditActor, chickenID int, retired bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := getStableChicken(tx, chickenID)
+	if err != nil {
+		return err
+	}
+	if current.Retired == retired {
+		return nil
+	}
+	if retired {
+		racing, err := countRacingChickens(tx)
+		if err != nil {
+			return err
+		}
+		if racing <= minRacingChickens {
+			return errStableTooSmall
+		}
+		_, err = tx.Exec("UPDATE chickens SET retired_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = ?", chickenID)
+		if err != nil {
+			return fmt.Errorf("error retiring chicken %d: %w", chickenID, err)
+		}
+	} else {
+		_, err = tx.Exec("UPDATE chickens SET retired_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?", chickenID)
+		if err != nil {
+			return fmt.Errorf("error un-retiring chicken %d: %w", chickenID, err)
+		}
+	}
+	action := AuditChickenUnretired
+	if retired {
+		action = AuditChickenRetired
+	}
+	event := newAuditEvent(actor, action, "chicken", chickenID)
+	event.Before = auditValue(map[string]bool{"retired": current.Retired})
+	event.After = auditValue(map[string]bool{"retired": retired})
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing chicken %d: %w", chickenID, err)
+	}
+	return nil
+}
+
+// deleteChicken removes a chicken that has never been entered in a race, along with its portrait.
+// A chicken entered in a scheduled or running race cannot be deleted; one with race history is retired instead.
+func deleteChicken(db *sql.DB, actor AuditActor, chickenID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := getStableChicken(tx, chickenID)
+	if err != nil {
+		return err
+	}
+	if current.ActiveRaces > 0 {
+		return errChickenInActiveRace
+	}
+	if !current.CanDelete() {
+		return errChickenHasRaced
+	}
+	if !current.Retired {
+		racing, err := countRacingChickens(tx)
+		if err != nil {
+			return err
+		}
+		if racing <= minRacingChickens {
+			return errStableTooSmall
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM chickens WHERE id = ?", chickenID); err != nil {
+		return fmt.Errorf("error deleting chicken %d: %w", chickenID, err)
+	}
+	event := newAuditEvent(actor, AuditChickenDeleted, "chicken", chickenID)
+	event.Before = auditValue(current.Input())
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing deletion of chicken %d: %w", chickenID, err)
+	}
+	removeChickenPortrait(current.ImagePath)
+	return nil
+}
+
+// readChickenPortrait reads an uploaded portrait, checking its size and sniffing its type from the content
+// rather than trusting the uploaded file name. It returns the image and the extension to store it with.
+func readChickenPortrait(r io.Reader) ([]byte, string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxPortraitBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading portrait: %w", err)
+	}
+	if len(data) > maxPortraitBytes {
+		return nil, "", errPortraitTooLarge
+	}
+	ext, ok := portraitExtensions[http.DetectContentType(data)]
+	if !ok {
+		return nil, "", errInvalidPortrait
+	}
+	return data, ext, nil
+}
+
+// setChickenPortrait stores a portrait read by readChickenPortrait and replaces the chicken's previous one.
+// Each upload gets a new file name, so browsers do not show a cached old portrait.
+func setChickenPortrait(db *sql.DB, actor AuditActor, chickenID int, data []byte, ext string) error {
+	current, err := getStableChicken(db, chickenID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(chickenPortraitDir, 0o755); err != nil {
+		return fmt.Errorf("error creating portrait directory: %w", err)
+	}
+	name := fmt.Sprintf("chicken-%d-%d%s", chickenID, time.Now().UnixNano(), ext)
+	file := filepath.Join(chickenPortraitDir, name)
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		return fmt.Errorf("error writing portrait of chicken %d: %w", chickenID, err)
+	}
+	if err := saveChickenPortraitPath(db, actor, current, chickenPortraitURLPrefix+name); err != nil {
+		os.Remove(file)
+		return err
+	}
+	removeChickenPortrait(current.ImagePath)
+	return nil
+}
+
+// saveChickenPortraitPath points a chicken at its new portrait file.
+func saveChickenPortraitPath(db *sql.DB, actor AuditActor, current StableChicken, imagePath string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE chickens SET image_path = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", imagePath, current.ID); err != nil {
+		return fmt.Errorf("error saving portrait of chicken %d: %w", current.ID, err)
+	}
+	event := newAuditEvent(actor, AuditChickenPortrait, "chicken", current.ID)
+	event.Before = auditValue(map[string]string{"image_path": current.ImagePath})
+	event.After = auditValue(map[string]string{"image_path": imagePath})
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing portrait of chicken %d: %w", current.ID, err)
+	}
+	return nil
+}
+
+// removeChickenPortrait deletes an uploaded portrait file. Paths outside the portrait directory are left alone.
+func removeChickenPortrait(imagePath string) {
+	if !strings.HasPrefix(imagePath, chickenPortraitURLPrefix) {
+		return
+	}
+	file := filepath.Join(chickenPortraitDir, filepath.Base(imagePath))
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("removeChickenPortrait: Error removing %s: %v", file, err)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const sessionAdminFlashKey = "adminFlash"
+
+// ChickenForm is the add or edit form on the stable page.
+type ChickenForm struct {
+	ID int // 0 when adding a chicken
+	ChickenInput
+	ImagePath    string
+	InActiveRace bool // The racing attributes are locked while the chicken is entered in a scheduled or running race
+	Message      string
+}
+
+// StableView is the chicken stable, for the admin stable page.
+type StableView struct {
+	Chickens      []StableChicken
+	Form          ChickenForm
+	Flash         string // Outcome of the last save, shown once
+	MinRacing     int
+	MaxPortraitMB int
+}
+
+// chickenStableErrorMessage turns an error from a stable action into a message for the operator.
+func chickenStableErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errChickenNotFound):
+		return "That chicken no longer exists."
+	case errors.Is(err, errInvalidChickenName):
+		return fmt.Sprintf("Enter a name of at most %d characters.", maxChickenNameLength)
+	case errors.Is(err, errChickenNameTaken):
+		return "Another chicken already has that name."
+	case errors.Is(err, errInvalidChickenColor):
+		return "Choose a colour like #f59e0b."
+	case errors.Is(err, errInvalidChickenOdds):
+		return fmt.Sprintf("Odds must be between %.2f and %.0f.", minChickenOdds, maxChickenOdds)
+	case errors.Is(err, errInvalidChickenStats):
+		return fmt.Sprintf("Speed must be above 0 and at most %.0f, acceleration above 0 and at most %.0f, and stamina between 0 and 1.",
+			maxChickenSpeed, maxChickenAcceleration)
+	case errors.Is(err, errChickenInActiveRace):
+		return "The chicken is entered in a scheduled or running race. Its racing attributes cannot change and it cannot be deleted until that race is over."
+	case errors.Is(err, errChickenHasRaced):
+		return "The chicken has raced before, so it can only be retired."
+	case errors.Is(err, errStableTooSmall):
+		return fmt.Sprintf("At least %d chickens must stay in racing.", minRacingChickens)
+	case errors.Is(err, errInvalidPortrait):
+		return "The portrait must be a PNG, JPEG, GIF or WebP image."
+	case errors.Is(err, errPortraitTooLarge):
+		return fmt.Sprintf("The portrait must be at most %d MB.", maxPortraitBytes/(1024*1024))
+	default:
+		return fmt.Sprintf("The action failed: %v", err)
+	}
+}
+
+// chickenStableErrorStatus picks the HTTP status of a failed save.
+func chickenStableErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errChickenNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errChickenNameTaken), errors.Is(err, errChickenInActiveRace):
+		return http.StatusConflict
+	case errors.Is(err, errInvalidChickenName), errors.Is(err, errInvalidChickenColor), errors.Is(err, errInvalidChickenOdds),
+		errors.Is(err, errInvalidChickenStats), errors.Is(err, errInvalidPortrait), errors.Is(err, errPortraitTooLarge):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseChickenForm reads the chicken attributes from the add/edit form.
+func parseChickenForm(r *http.Request) (ChickenInput, error) {
+	in := ChickenInput{Name: r.FormValue("name"), Color: r.FormValue("color")}
+	var err error
+	if in.Odds, err = strconv.ParseFloat(strings.TrimSpace(r.FormValue("odds")), 64); err != nil {
+		return in, errInvalidChickenOdds
+	}
+	for field, dest := range map[string]*float64{"speed": &in.Speed, "acceleration": &in.Acceleration, "stamina": &in.Stamina} {
+		if *dest, err = strconv.ParseFloat(strings.TrimSpace(r.FormValue(field)), 64); err != nil {
+			return in, errInvalidChickenStats
+		}
+	}
+	return in, nil
+}
+
+// renderStablePage renders the stable page with the given form.
+func renderStablePage(w http.ResponseWriter, r *http.Request, status int, form ChickenForm) {
+	chickens, err := listStableChickens(db)
+	if err != nil {
+		log.Printf("renderStablePage: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data := PageData{Title: "Stable - Scramble Run", Stable: &StableView{
+		Chickens:      chickens,
+		Form:          form,
+		Flash:         sessionManager.PopString(r.Context(), sessionAdminFlashKey),
+		MinRacing:     minRacingChickens,
+		MaxPortraitMB: maxPortraitBytes / (1024 * 1024),
+	}}
+	data.UserData, data.UserBalance, _, err = authenticatedUser(db, r)
+	if err != nil {
+		log.Printf("renderStablePage: %v", err)
+	}
+	renderTemplateWithStatus(w, r, status, adminChickensTemplate, "base.gohtml", data)
+}
+
+// adminChickensHandler renders the stable page at /admin/chickens, with the form for adding a chicken.
+func adminChickensHandler(w http.ResponseWriter, r *http.Request) {
+	renderStablePage(w, r, http.StatusOK, ChickenForm{ChickenInput: defaultChickenInput})
+}
+
+// adminEditChickenHandler renders the stable page with the form for editing the chicken in ?id=.
+func adminEditChickenHandler(w http.ResponseWriter, r *http.Request) {
+	chickenID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	chicken, err := getStableChicken(db, chickenID)
+	if errors.Is(err, errChickenNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("adminEditChickenHandler: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderStablePage(w, r, http.StatusOK, ChickenForm{
+		ID:           chicken.ID,
+		ChickenInput: chicken.Input(),
+		ImagePath:    chicken.ImagePath,
+		InActiveRace: chicken.ActiveRaces > 0,
+	})
+}
+
+// adminChickensListHandler renders the stable table. The stable page reloads it after every action.
+func adminChickensListHandler(w http.ResponseWriter, r *http.Request) {
+	chickens, err := listStableChickens(db)
+	if err != nil {
+		log.Printf("adminChickensListHandler: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := adminChickensTemplate.ExecuteTemplate(w, "admin-chickens", &StableView{Chickens: chickens, MinRacing: minRacingChickens}); err != nil {
+		log.Printf("adminChickensListHandler: Template execution error: %v", err)
+	}
+}
+
+// adminSaveChickenHandler adds a chicken, or edits the one in the id field, from the multipart stable form.
+// A portrait upload is optional. On success it redirects back to the stable page.
+func adminSaveChickenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	adminID := authenticatedUserID(r)
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if err := r.ParseMultipartForm(maxFormMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		log.Printf("adminSaveChickenHandler: Error parsing form: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	var form ChickenForm
+	var err error
+	if id := r.FormValue("id"); id != "" {
+		if form.ID, err = strconv.Atoi(id); err != nil {
+			http.Error(w, "Invalid chicken ID", http.StatusBadRequest)
+			return
+		}
+		current, err := getStableChicken(db, form.ID)
+		if err != nil {
+			log.Printf("adminSaveChickenHandler: %v", err)
+			http.Error(w, chickenStableErrorMessage(err), chickenStableErrorStatus(err))
+			return
+		}
+		form.ImagePath = current.ImagePath
+		form.InActiveRace = current.ActiveRaces > 0
+	}
+	form.ChickenInput, err = parseChickenForm(r)
+
+	var portrait []byte
+	var portraitExt string
+	if err == nil {
+		file, _, fileErr := r.FormFile("portrait")
+		if fileErr == nil {
+			portrait, portraitExt, err = readChickenPortrait(file)
+			file.Close()
+		} else if !errors.Is(fileErr, http.ErrMissingFile) {
+			err = fmt.Errorf("error reading portrait upload: %w", fileErr)
+		}
+	}
+
+	if err == nil {
+		if form.ID == 0 {
+			form.ID, err = createChicken(db, requestActor(r), form.ChickenInput)
+		} else {
+			err = updateChicken(db, requestActor(r), form.ID, form.ChickenInput)
+		}
+	}
+	if err == nil && portrait != nil {
+		err = setChickenPortrait(db, requestActor(r), form.ID, portrait, portraitExt)
+	}
+	if err != nil {
+		log.Printf("ADMIN: Saving chicken %d (%q) by user %d failed: %v", form.ID, form.Name, adminID, err)
+		form.Message = chickenStableErrorMessage(err)
+		renderStablePage(w, r, chickenStableErrorStatus(err), form)
+		return
+	}
+
+	log.Printf("ADMIN: User %d saved chicken %d (%q).", adminID, form.ID, strings.TrimSpace(form.Name))
+	sessionManager.Put(r.Context(), sessionAdminFlashKey, fmt.Sprintf("Saved %s.", strings.TrimSpace(form.Name)))
+	http.Redirect(w, r, "/admin/chickens", http.StatusSeeOther)
+}
+
+// adminRetireChickenHandler retires the chicken in the id field, or brings it back when retired=0.
+func adminRetireChickenHandler(w http.ResponseWriter, r *http.Request) {
+	adminChickenAction(w, r, func(chickenID int) (string, error) {
+		retired := r.FormValue("retired") != "0"
+		if err := setChickenRetired(db, requestActor(r), chickenID, retired); err != nil {
+			return "", err
+		}
+		if retired {
+			return fmt.Sprintf("Chicken %d retired. It runs the races it is already entered in, but no new ones.", chickenID), nil
+		}
+		return fmt.Sprintf("Chicken %d is back in racing.", chickenID), nil
+	})
+}
+
+// adminDeleteChickenHandler deletes the chicken in the id field.
+func adminDeleteChickenHandler(w http.ResponseWriter, r *http.Request) {
+	adminChickenAction(w, r, func(chickenID int) (string, error) {
+		if err := deleteChicken(db, requestActor(r), chickenID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Chicken %d deleted.", chickenID), nil
+	})
+}
+
+// adminChickenAction runs a stable action on the chicken in the id field. It answers with the outcome
+// and triggers chickensChanged so the stable table reloads.
+func adminChickenAction(w http.ResponseWriter, r *http.Request, action func(chickenID int) (string, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	adminID := authenticatedUserID(r)
+	chickenID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		renderAdminFeedback(w, AdminFeedback{Message: "Invalid chicken ID."})
+		return
+	}
+
+	var feedback AdminFeedback
+	if feedback.Message, err = action(chickenID); err != nil {
+		log.Printf("ADMIN: %s of chicken %d by user %d failed: %v", r.URL.Path, chickenID, adminID, err)
+		feedback.Message = chickenStableErrorMessage(err)
+	} else {
+		log.Printf("ADMIN: User %d: %s", adminID, feedback.Message)
+		feedback.Success = true
+	}
+	w.Header().Set("HX-Trigger", "chickensChanged")
+	renderAdminFeedback(w, feedback)
+}
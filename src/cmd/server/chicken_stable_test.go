@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testAdmin is the administrator stable changes are attributed to in tests.
+var testAdmin = AuditActor{Name: "Test admin", IP: "203.0.113.1"}
+
+func TestChickenStable(t *testing.T) {
+	setupHandlerTest(t)
+	valid := ChickenInput{Name: " Eggbert ", Color: "#ABCDEF", Odds: 3, Speed: 6, Acceleration: 2, Stamina: 0.7}
+
+	for _, tc := range []struct {
+		name   string
+		change func(in *ChickenInput)
+		want   error
+	}{
+		{"blank name", func(in *ChickenInput) { in.Name = "  " }, errInvalidChickenName},
+		{"long name", func(in *ChickenInput) { in.Name = strings.Repeat("x", maxChickenNameLength+1) }, errInvalidChickenName},
+		{"colour name", func(in *ChickenInput) { in.Color = "red" }, errInvalidChickenColor},
+		{"evens", func(in *ChickenInput) { in.Odds = 1 }, errInvalidChickenOdds},
+		{"NaN speed", func(in *ChickenInput) { in.Speed = math.NaN() }, errInvalidChickenStats},
+		{"stamina above 1", func(in *ChickenInput) { in.Stamina = 1.5 }, errInvalidChickenStats},
+		{"taken name", func(in *ChickenInput) { in.Name = "henrietta" }, errChickenNameTaken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in := valid
+			tc.change(&in)
+			if _, err := createChicken(db, testAdmin, in); !errors.Is(err, tc.want) {
+				t.Errorf("createChicken = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	newID, err := createChicken(db, testAdmin, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := getStableChicken(db, newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.Name != "Eggbert" || added.Color != "#abcdef" || added.Retired || !added.CanDelete() {
+		t.Errorf("added chicken = %+v", added)
+	}
+
+	// The seeded stable is entered in a scheduled race: its racing attributes are locked and it cannot be deleted.
+	henrietta, err := getStableChicken(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if henrietta.ActiveRaces != 1 {
+		t.Fatalf("Henrietta is in %d active races, want 1", henrietta.ActiveRaces)
+	}
+	if err := deleteChicken(db, testAdmin, 1); !errors.Is(err, errChickenInActiveRace) {
+		t.Errorf("deleting a chicken in a scheduled race = %v, want %v", err, errChickenInActiveRace)
+	}
+	faster := henrietta.Input()
+	faster.Speed++
+	if err := updateChicken(db, testAdmin, 1, faster); !errors.Is(err, errChickenInActiveRace) {
+		t.Errorf("changing the speed of a chicken in a scheduled race = %v, want %v", err, errChickenInActiveRace)
+	}
+	shorter := henrietta.Input()
+	shorter.Odds = 2
+	if err := updateChicken(db, testAdmin, 1, shorter); err != nil {
+		t.Errorf("changing the odds of a chicken in a scheduled race = %v", err)
+	}
+
+	// Retired chickens are not drawn for new races, and the stable keeps enough chickens to race.
+	for _, id := range []int{1, 2, 3} {
+		if err := setChickenRetired(db, testAdmin, id, true); err != nil {
+			t.Fatalf("retiring chicken %d: %v", id, err)
+		}
+	}
+	entrants, err := pickRaceEntrants(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entrants) != minRacingChickens {
+		t.Errorf("drew %d entrants, want the %d racing chickens", len(entrants), minRacingChickens)
+	}
+	for _, ch := range entrants {
+		if ch.ID <= 3 {
+			t.Errorf("retired chicken %d drawn for a race", ch.ID)
+		}
+	}
+	if err := setChickenRetired(db, testAdmin, 4, true); !errors.Is(err, errStableTooSmall) {
+		t.Errorf("retiring below the minimum = %v, want %v", err, errStableTooSmall)
+	}
+	if err := deleteChicken(db, testAdmin, newID); !errors.Is(err, errStableTooSmall) {
+		t.Errorf("deleting below the minimum = %v, want %v", err, errStableTooSmall)
+	}
+
+	if err := setChickenRetired(db, testAdmin, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteChicken(db, testAdmin, newID); err != nil {
+		t.Errorf("deleting a chicken that never raced = %v", err)
+	}
+	if _, err := getStableChicken(db, newID); !errors.Is(err, errChickenNotFound) {
+		t.Errorf("deleted chicken still loads: %v", err)
+	}
+
+	// Once its races are over, a chicken that has raced can only be retired.
+	if _, err := db.Exec("UPDATE races SET status = ?", RaceStatusFinished); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteChicken(db, testAdmin, 2); !errors.Is(err, errChickenHasRaced) {
+		t.Errorf("deleting a chicken with race history = %v, want %v", err, errChickenHasRaced)
+	}
+}
+
+func TestChickenPortrait(t *testing.T) {
+	setupHandlerTest(t)
+	prevDir := chickenPortraitDir
+	chickenPortraitDir = t.TempDir()
+	t.Cleanup(func() { chickenPortraitDir = prevDir })
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"text", []byte("<?php echo 'hi'; ?>"), errInvalidPortrait},
+		{"too large", append(png, make([]byte, maxPortraitBytes)...), errPortraitTooLarge},
+	} {
+		if _, _, err := readChickenPortrait(bytes.NewReader(tc.data)); !errors.Is(err, tc.want) {
+			t.Errorf("%s: readChickenPortrait = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	data, ext, err := readChickenPortrait(bytes.NewReader(png))
+	if err != nil || ext != ".png" {
+		t.Fatalf("readChickenPortrait(png) = %q, %v", ext, err)
+	}
+	var paths []string
+	for range 2 {
+		if err := setChickenPortrait(db, testAdmin, 1, data, ext); err != nil {
+			t.Fatal(err)
+		}
+		chicken, err := getStableChicken(db, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, chicken.ImagePath)
+	}
+	stored, _ := os.ReadDir(chickenPortraitDir)
+	if len(stored) != 1 || chickenPortraitURLPrefix+stored[0].Name() != paths[1] {
+		t.Errorf("portraits on disk = %v, want only the latest %s", stored, paths[1])
+	}
+	if _, err := os.Stat(filepath.Join(chickenPortraitDir, filepath.Base(paths[0]))); !os.IsNotExist(err) {
+		t.Errorf("replaced portrait %s was not removed", paths[0])
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// commands are maintenance tasks run from the server binary instead of starting the web server,
+// e.g. `go run ./src/cmd/server reconcile`. Each returns the process exit code.
+var commands = map[string]func(db *sql.DB, args []string, out io.Writer) int{
+	"promote-admin":     promoteAdminCommand,
+	"reconcile":         reconcileCommand,
+	"set-date-of-birth": setDateOfBirthCommand,
+	"set-role":          setRoleCommand,
+	"verify-audit":      verifyAuditCommand,
+}
+
+// runCommand runs the named command and returns its exit code.
+func runCommand(db *sql.DB, args []string, out io.Writer) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(out, "unknown command %q\n", args[0])
+		fmt.Fprintln(out, "available commands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "  %s\n", name)
+		}
+		return 2
+	}
+	return cmd(db, args[1:], out)
+}
+
+// reconcileCommand checks every user's balance against the wallet ledger, and the ledger against its
+// house side. It exits with 1 when anything has drifted, so it can run from cron or CI.
+func reconcileCommand(db *sql.DB, args []string, out io.Writer) int {
+	drifts, err := reconcileWallets(db)
+	if err != nil {
+		log.Printf("reconcileCommand: %v", err)
+		return 2
+	}
+	imbalances, err := reconcileHouse(db)
+	if err != nil {
+		log.Printf("reconcileCommand: %v", err)
+		return 2
+	}
+	if len(drifts) == 0 && len(imbalances) == 0 {
+		fmt.Fprintln(out, "All balances match the wallet ledger, and every entry is balanced by the house.")
+		return 0
+	}
+	if len(drifts) > 0 {
+		fmt.Fprintf(out, "%d balance(s) do not match the wallet ledger:\n", len(drifts))
+	}
+	for _, d := range drifts {
+		fmt.Fprintf(out, "  user %d (%s): balance %s, ledger %s, drift %s", d.UserID, d.Name, d.Balance, d.LedgerBalance, d.Difference())
+		if d.BrokenEntryID != 0 {
+			fmt.Fprintf(out, ", running balance breaks at entry %d", d.BrokenEntryID)
+		}
+		fmt.Fprintln(out)
+	}
+	if len(imbalances) > 0 {
+		fmt.Fprintf(out, "%d ledger entries are not balanced by the house:\n", len(imbalances))
+	}
+	for _, i := range imbalances {
+		fmt.Fprintf(out, "  %s %d: %s\n", i.Table, i.EntryID, i.Problem)
+	}
+	return 1
+}
+
+// setDateOfBirthCommand is the admin override for a user's date of birth, for typos at signup and
+// accounts created before it was collected. The correction is recorded with the reason given and
+// the operator running the command.
+func setDateOfBirthCommand(db *sql.DB, args []string, out io.Writer) int {
+	if len(args) < 3 {
+		fmt.Fprintln(out, "usage: set-date-of-birth <email> <YYYY-MM-DD> <reason...>")
+		return 2
+	}
+	email, reason := normalizeEmail(args[0]), strings.Join(args[2:], " ")
+	now := time.Now()
+	dob, err := parseDateOfBirth(args[1], now)
+	if err != nil {
+		fmt.Fprintf(out, "%q is not a valid date of birth (YYYY-MM-DD).\n", args[1])
+		return 2
+	}
+	correctedBy := os.Getenv("USER")
+	if correctedBy == "" {
+		correctedBy = "cli"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("setDateOfBirthCommand: Failed to begin transaction: %v", err)
+		return 1
+	}
+	defer tx.Rollback() // No-op once committed
+
+	var userID int
+	if err := tx.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			fmt.Fprintf(out, "No user with email %s.\n", email)
+			return 1
+		}
+		log.Printf("setDateOfBirthCommand: Error looking up user %s: %v", email, err)
+		return 1
+	}
+	previous, err := correctDateOfBirth(tx, userID, dob, reason, correctedBy)
+	if err != nil {
+		log.Printf("setDateOfBirthCommand: %v", err)
+		return 1
+	}
+	event := newAuditEvent(commandActor(), AuditAdminDOBCorrected, "user", userID)
+	event.Before = auditValue(map[string]interface{}{"date_of_birth": previous.String})
+	event.After = auditValue(map[string]string{"date_of_birth": dob.Format(dateOfBirthLayout)})
+	event.Reason = reason
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		log.Printf("setDateOfBirthCommand: %v", err)
+		return 1
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("setDateOfBirthCommand: Error committing date of birth of user %d: %v", userID, err)
+		return 1
+	}
+
+	was := "none"
+	if previous.Valid {
+		was = previous.String
+	}
+	age := ageOn(dob, now)
+	fmt.Fprintf(out, "User %d (%s): date of birth %s -> %s, now %d.\n", userID, email, was, dob.Format(dateOfBirthLayout), age)
+	if age < minimumBettingAge {
+		fmt.Fprintf(out, "They are under %d and cannot place bets.\n", minimumBettingAge)
+	} else {
+		fmt.Fprintln(out, "They can place bets.")
+	}
+	return 0
+}
+
+// promoteAdminCommand makes a user an admin. It is how the first admin is created, and short for
+// set-role <email> admin.
+func promoteAdminCommand(db *sql.DB, args []string, out io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(out, "usage: promote-admin <email>")
+		return 2
+	}
+	return changeRole(db, args[0], RoleAdmin, out)
+}
+
+// setRoleCommand gives a user any role: it assigns moderators and demotes admins, and also restores
+// access when every admin has been demoted.
+func setRoleCommand(db *sql.DB, args []string, out io.Writer) int {
+	if len(args) != 2 || roleRank(args[1]) < 0 {
+		fmt.Fprintf(out, "usage: set-role <email> <%s>\n", strings.Join(roles, "|"))
+		return 2
+	}
+	return changeRole(db, args[0], args[1], out)
+}
+
+// changeRole sets the role of the user with the given email and records the change in the audit log.
+func changeRole(db *sql.DB, email, newRole string, out io.Writer) int {
+	email = normalizeEmail(email)
+
+	var userID int
+	var name, role string
+	if err := db.QueryRow("SELECT id, name, role FROM users WHERE email = ?", email).Scan(&userID, &name, &role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			fmt.Fprintf(out, "No user with email %s.\n", email)
+			return 1
+		}
+		log.Printf("changeRole: Error looking up user %s: %v", email, err)
+		return 1
+	}
+	if role == newRole {
+		fmt.Fprintf(out, "User %d (%s) already has the %s role.\n", userID, name, role)
+		return 0
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("changeRole: Failed to begin transaction: %v", err)
+		return 1
+	}
+	defer tx.Rollback() // No-op once committed
+
+	if err := setUserRole(tx, userID, newRole); err != nil {
+		log.Printf("changeRole: %v", err)
+		return 1
+	}
+	event := newAuditEvent(commandActor(), AuditAdminRoleChanged, "user", userID)
+	event.Before = auditValue(map[string]string{"role": role})
+	event.After = auditValue(map[string]string{"role": newRole})
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		log.Printf("changeRole: %v", err)
+		return 1
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("changeRole: Error committing role of user %d: %v", userID, err)
+		return 1
+	}
+	verb := "promoted"
+	if roleRank(newRole) < roleRank(role) {
+		verb = "demoted"
+	}
+	fmt.Fprintf(out, "User %d (%s) %s from %s to %s.\n", userID, name, verb, role, newRole)
+	return 0
+}
+
+// verifyAuditCommand walks the audit log's hash chain. It exits with 1 when an event was changed,
+// removed or reordered, so it can run from cron or CI. Keep the head hash it prints somewhere outside
+// the database: the chain alone cannot show that the newest events were removed.
+func verifyAuditCommand(db *sql.DB, args []string, out io.Writer) int {
+	v, err := verifyAuditChain(db)
+	if errors.Is(err, errAuditChainBroken) {
+		fmt.Fprintf(out, "The audit log has been tampered with: %s.\n", v.Problem)
+		fmt.Fprintf(out, "%d event(s) before event %d are intact.\n", v.Events, v.BrokenAt)
+		return 1
+	}
+	if err != nil {
+		log.Printf("verifyAuditCommand: %v", err)
+		return 2
+	}
+	if v.Events == 0 {
+		fmt.Fprintln(out, "The audit log is empty.")
+		return 0
+	}
+	fmt.Fprintf(out, "All %d audit event(s) are intact. Head hash: %s\n", v.Events, v.HeadHash)
+	return 0
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/smtp"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -35,17 +36,41 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return value
 }
 
+// getEnvFloatOrDefault returns the environment variable parsed as a float, or a default value if not set or invalid
+func getEnvFloatOrDefault(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("getEnvFloatOrDefault: Invalid value '%s' for %s, using default %v", value, key, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// getEnvIntOrDefault returns the environment variable parsed as an int, or a default value if not set or invalid
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("getEnvIntOrDefault: Invalid value '%s' for %s, using default %v", value, key, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
 func aboutUsHandler(w http.ResponseWriter, r *http.Request) {
 
 	data := PageData{
 		Title: "About us - Scramble Run",
 	}
 
-	err := aboutUsTemplate.ExecuteTemplate(w, "base.gohtml", data)
-	if err != nil {
-		log.Printf("signupHandler: Template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-	}
+	renderTemplateWithStatus(w, r, http.StatusOK, aboutUsTemplate, "base.gohtml", data)
 }
 
 // contactHandler handles contact form submissions.
@@ -55,11 +80,7 @@ func contactHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodGet {
-		err := contactTemplate.ExecuteTemplate(w, "base.gohtml", data)
-		if err != nil {
-			log.Printf("contactHandler: Template execution error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
+		renderTemplateWithStatus(w, r, http.StatusOK, contactTemplate, "base.gohtml", data)
 		return
 	}
 
@@ -70,7 +91,7 @@ func contactHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("contactHandler: Error parsing form: %v", err)
 			data.Message = "Error processing form"
 			data.Success = false
-			_ = contactTemplate.ExecuteTemplate(w, "base.gohtml", data)
+			renderTemplateWithStatus(w, r, http.StatusOK, contactTemplate, "base.gohtml", data)
 			return
 		}
 
@@ -83,14 +104,14 @@ func contactHandler(w http.ResponseWriter, r *http.Request) {
 		if topic == "" || email == "" || message == "" {
 			data.Message = "All fields are required"
 			data.Success = false
-			_ = contactTemplate.ExecuteTemplate(w, "base.gohtml", data)
+			renderTemplateWithStatus(w, r, http.StatusOK, contactTemplate, "base.gohtml", data)
 			return
 		}
 
 		if !strings.Contains(email, "@") {
 			data.Message = "Invalid email format"
 			data.Success = false
-			_ = contactTemplate.ExecuteTemplate(w, "base.gohtml", data)
+			renderTemplateWithStatus(w, r, http.StatusOK, contactTemplate, "base.gohtml", data)
 			return
 		}
 
@@ -119,14 +140,14 @@ func contactHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("contactHandler: Error sending email: %v", err)
 			data.Message = "There was a problem sending your message. Please try again later."
 			data.Success = false
-			_ = contactTemplate.ExecuteTemplate(w, "base.gohtml", data)
+			renderTemplateWithStatus(w, r, http.StatusOK, contactTemplate, "base.gohtml", data)
 			return
 		}
 
 		// Return success to user
 		data.Message = "Thank you! Your message has been sent successfully. We'll be in touch soon."
 		data.Success = true
-		_ = contactTemplate.ExecuteTemplate(w, "base.gohtml", data)
+		renderTemplateWithStatus(w, r, http.StatusOK, contactTemplate, "base.gohtml", data)
 		return
 	}
 
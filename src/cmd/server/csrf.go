@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	sessionCSRFTokenKey = "csrfToken"
+	csrfFormField       = "csrf_token"   // Hidden field carried by plain HTML forms
+	csrfHeader          = "X-CSRF-Token" // Header sent by HTMX, set through hx-headers in base.gohtml
+)
+
+// csrfToken returns the session's CSRF token, creating one for sessions that do not have one yet.
+func csrfToken(ctx context.Context) string {
+	if token := sessionManager.GetString(ctx, sessionCSRFTokenKey); token != "" {
+		return token
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("csrfToken: Error generating token: %v", err)
+		return ""
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	sessionManager.Put(ctx, sessionCSRFTokenKey, token)
+	return token
+}
+
+// csrfProtect rejects state-changing requests that do not carry the session's CSRF token, either in the
+// X-CSRF-Token header or the csrf_token form field. It must run inside sessionManager.LoadAndSave.
+func csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		expected := sessionManager.GetString(r.Context(), sessionCSRFTokenKey)
+		sent := r.Header.Get(csrfHeader)
+		if sent == "" && !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			// Handlers re-wrap the body when they parse it; the form parsed here is kept on the request.
+			r.Body = http.MaxBytesReader(w, r.Body, maxFormMemory)
+			sent = r.PostFormValue(csrfFormField)
+		} else if sent == "" {
+			r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+			if err := r.ParseMultipartForm(maxFormMemory); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+					return
+				}
+			}
+			sent = r.FormValue(csrfFormField)
+		}
+		if expected == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+			log.Printf("csrfProtect: Rejected %s %s without a valid CSRF token.", r.Method, r.URL.Path)
+			http.Error(w, "Forbidden - invalid or missing CSRF token. Please reload the page and try again.", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
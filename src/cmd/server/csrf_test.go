@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	setupHandlerTest(t)
+
+	// A page load gives the session its token.
+	var token string
+	rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = csrfToken(r.Context())
+	}), httptest.NewRequest(http.MethodGet, "/login", nil), nil)
+	cookie := sessionCookie(rec)
+	if token == "" || cookie == nil {
+		t.Fatal("no CSRF token issued")
+	}
+
+	reached := false
+	protected := csrfProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	form := func(values url.Values) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/place-bet", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	withHeader := func(value string) *http.Request {
+		req := form(url.Values{"betAmount": {"10"}})
+		req.Header.Set(csrfHeader, value)
+		return req
+	}
+
+	for _, tc := range []struct {
+		name   string
+		req    *http.Request
+		cookie *http.Cookie
+		allow  bool
+	}{
+		{"GET without token", httptest.NewRequest(http.MethodGet, "/races", nil), nil, true},
+		{"POST without token", form(url.Values{"betAmount": {"10"}}), cookie, false},
+		{"POST with wrong token", form(url.Values{csrfFormField: {"forged"}}), cookie, false},
+		{"POST with form token", form(url.Values{csrfFormField: {token}}), cookie, true},
+		{"HTMX POST with header token", withHeader(token), cookie, true},
+		{"POST with token from another session", form(url.Values{csrfFormField: {token}}), nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reached = false
+			rec := serve(protected, tc.req, tc.cookie)
+			if reached != tc.allow {
+				t.Errorf("request allowed = %v, want %v", reached, tc.allow)
+			}
+			if !tc.allow && rec.Code != http.StatusForbidden {
+				t.Errorf("status %d, want %d", rec.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
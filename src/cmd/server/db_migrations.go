@@ -0,0 +1,720 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// schemaMigration upgrades an existing database in place, keeping its data. Migrations run in order on
+// every start, before init_database checks the schema, and each one checks whether it is still needed.
+// init_database.sql only ever runs against an empty database.
+type schemaMigration struct {
+	name   string
+	needed func(q querier) (bool, error)
+	apply  func(tx *sql.Tx) error
+}
+
+// schemaMigrations lists the in-place upgrades in the order they run. Claims are numbered before
+// addMissingColumns runs, as a bare claim_number column would leave existing claims unnumbered.
+var schemaMigrations = []schemaMigration{
+	{"store money columns as integer cents", moneyColumnsNotCents, convertMoneyColumns},
+	{"number Signup and PromoCode bonus claims", bonusClaimNumberMissing, addBonusClaimNumber},
+	{"add new columns to existing tables", columnsMissing, addMissingColumns},
+	{"add new tables", tablesMissing, addMissingTables},
+	{"add new bet statuses", betStatusesMissing, addMissingBetStatuses},
+	{"add new indexes", indexesMissing, addMissingIndexes},
+}
+
+// migrateDatabase applies the migrations the database needs, each in its own transaction.
+func migrateDatabase(db *sql.DB) error {
+	for _, m := range schemaMigrations {
+		needed, err := m.needed(db)
+		if err != nil {
+			return fmt.Errorf("checking migration %q: %w", m.name, err)
+		}
+		if !needed {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("starting migration %q: %w", m.name, err)
+		}
+		if err := m.apply(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %q: %w", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("committing migration %q: %w", m.name, err)
+		}
+		log.Printf("migrateDatabase: Applied migration %q.", m.name)
+	}
+	return nil
+}
+
+// pendingMigrations returns the names of the migrations the database needs.
+func pendingMigrations(q querier) ([]string, error) {
+	var names []string
+	for _, m := range schemaMigrations {
+		needed, err := m.needed(q)
+		if err != nil {
+			return nil, fmt.Errorf("checking migration %q: %w", m.name, err)
+		}
+		if needed {
+			names = append(names, m.name)
+		}
+	}
+	return names, nil
+}
+
+// tableExists reports whether the database has a table of the given name.
+func tableExists(q rowQuerier, name string) (bool, error) {
+	var n int
+	if err := q.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n); err != nil {
+		return false, fmt.Errorf("error checking for table %s: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+// columnExists reports whether a table has a column of the given name.
+func columnExists(q rowQuerier, table, column string) (bool, error) {
+	var n int
+	if err := q.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n); err != nil {
+		return false, fmt.Errorf("error checking for column %s.%s: %w", table, column, err)
+	}
+	return n > 0, nil
+}
+
+// databaseIsEmpty reports whether the database has no tables at all, as when it is first created.
+func databaseIsEmpty(q rowQuerier) (bool, error) {
+	var n int
+	if err := q.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'").Scan(&n); err != nil {
+		return false, fmt.Errorf("error counting tables: %w", err)
+	}
+	return n == 0, nil
+}
+
+// backupDatabase copies the database to a timestamped file next to path and returns its name.
+func backupDatabase(db *sql.DB, path string) (string, error) {
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+	if _, err := os.Stat(backup); err == nil {
+		return "", fmt.Errorf("backup %s already exists", backup)
+	}
+	if _, err := db.Exec("VACUUM INTO ?", backup); err != nil {
+		return "", fmt.Errorf("error backing up the database to %s: %w", backup, err)
+	}
+	return backup, nil
+}
+
+// columnsMissing reports whether an existing table lacks a column in requiredColumns.
+func columnsMissing(q querier) (bool, error) {
+	for _, col := range requiredColumns {
+		missing, err := columnMissing(q, col.table, col.column)
+		if err != nil || missing {
+			return missing, err
+		}
+	}
+	return false, nil
+}
+
+// columnMissing reports whether a table exists without the given column. Columns of a missing table
+// come with it when addMissingTables creates it.
+func columnMissing(q rowQuerier, table, column string) (bool, error) {
+	exists, err := tableExists(q, table)
+	if err != nil || !exists {
+		return false, err
+	}
+	has, err := columnExists(q, table, column)
+	return !has, err
+}
+
+// addMissingColumns adds each column in requiredColumns that its table lacks, with the definition it
+// has in init_database.sql. Existing rows take the column's default.
+func addMissingColumns(tx *sql.Tx) error {
+	for _, col := range requiredColumns {
+		missing, err := columnMissing(tx, col.table, col.column)
+		if err != nil {
+			return err
+		}
+		if !missing {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.column, col.definition)); err != nil {
+			return fmt.Errorf("error adding column %s.%s: %w", col.table, col.column, err)
+		}
+		log.Printf("addMissingColumns: Added %s.%s.", col.table, col.column)
+	}
+	return nil
+}
+
+// addedTable is a table added since the first schema. create holds its definition, indexes and
+// triggers as in init_database.sql; fill, if set, gives it the rows an existing database needs.
+type addedTable struct {
+	name   string
+	create []string
+	fill   func(tx *sql.Tx) error
+}
+
+// addedTables lists the tables added since the first schema, in the order they can be created and filled.
+var addedTables = []addedTable{
+	{name: "race_entrants", create: []string{`
+        CREATE TABLE race_entrants (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            race_id INTEGER NOT NULL,
+            chicken_id INTEGER NOT NULL,
+            lane INTEGER NOT NULL CHECK (lane >= 1),
+            color TEXT NOT NULL,
+            starting_odds REAL NOT NULL CHECK (starting_odds >= 1.0),
+            current_odds REAL NOT NULL CHECK (current_odds >= 1.0),
+            place_odds REAL NOT NULL CHECK (place_odds >= 1.0),
+            show_odds REAL NOT NULL CHECK (show_odds >= 1.0),
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (race_id, chicken_id),
+            UNIQUE (race_id, lane),
+            FOREIGN KEY (race_id) REFERENCES races (id),
+            FOREIGN KEY (chicken_id) REFERENCES chickens (id)
+        )`,
+		`CREATE INDEX idx_race_entrants_race_id ON race_entrants (race_id)`,
+	}, fill: fillRaceEntrants},
+	{name: "race_results", create: []string{`
+        CREATE TABLE race_results (
+            race_id INTEGER NOT NULL,
+            chicken_id INTEGER NOT NULL,
+            position INTEGER NOT NULL CHECK (position >= 1),
+            PRIMARY KEY (race_id, position),
+            UNIQUE (race_id, chicken_id),
+            FOREIGN KEY (race_id) REFERENCES races (id),
+            FOREIGN KEY (chicken_id) REFERENCES chickens (id)
+        )`,
+	}},
+	{name: "bet_selections", create: []string{`
+        CREATE TABLE bet_selections (
+            bet_id INTEGER NOT NULL,
+            pick INTEGER NOT NULL CHECK (pick >= 1),
+            chicken_id INTEGER NOT NULL,
+            PRIMARY KEY (bet_id, pick),
+            UNIQUE (bet_id, chicken_id),
+            FOREIGN KEY (bet_id) REFERENCES bets (id),
+            FOREIGN KEY (chicken_id) REFERENCES chickens (id)
+        )`,
+	}},
+	{name: "bet_legs", create: []string{`
+        CREATE TABLE bet_legs (
+            bet_id INTEGER NOT NULL,
+            leg INTEGER NOT NULL CHECK (leg >= 1),
+            race_id INTEGER NOT NULL,
+            chicken_id INTEGER NOT NULL,
+            odds REAL NOT NULL CHECK (odds >= 1.0),
+            status TEXT NOT NULL DEFAULT 'Pending' CHECK (status IN ('Pending', 'Won', 'Lost', 'Void')),
+            PRIMARY KEY (bet_id, leg),
+            UNIQUE (bet_id, race_id),
+            FOREIGN KEY (bet_id) REFERENCES bets (id),
+            FOREIGN KEY (race_id) REFERENCES races (id),
+            FOREIGN KEY (chicken_id) REFERENCES chickens (id)
+        )`,
+		`CREATE INDEX idx_bet_legs_race_id ON bet_legs (race_id)`,
+	}},
+	{name: "wallet_transactions", create: []string{`
+        CREATE TABLE wallet_transactions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            kind TEXT NOT NULL CHECK (kind IN ('Opening', 'Stake', 'Payout', 'Refund', 'CashOut', 'Bonus', 'Adjustment')),
+            amount INTEGER NOT NULL,
+            balance_after INTEGER NOT NULL,
+            bet_id INTEGER,
+            race_id INTEGER,
+            note TEXT,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (user_id) REFERENCES users (id),
+            FOREIGN KEY (bet_id) REFERENCES bets (id),
+            FOREIGN KEY (race_id) REFERENCES races (id)
+        )`, `
+        CREATE TRIGGER wallet_transactions_no_update BEFORE UPDATE ON wallet_transactions
+        BEGIN
+            SELECT RAISE(ABORT, 'wallet_transactions is append-only');
+        END`, `
+        CREATE TRIGGER wallet_transactions_no_delete BEFORE DELETE ON wallet_transactions
+        BEGIN
+            SELECT RAISE(ABORT, 'wallet_transactions is append-only');
+        END`,
+		`CREATE INDEX idx_wallet_transactions_user_id ON wallet_transactions (user_id)`,
+	}, fill: fillOpeningBalances},
+	{name: "house_transactions", create: []string{`
+        CREATE TABLE house_transactions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            wallet_transaction_id INTEGER NOT NULL UNIQUE,
+            account TEXT NOT NULL CHECK (account IN ('Betting', 'Promotions', 'Adjustments')),
+            amount INTEGER NOT NULL,
+            balance_after INTEGER NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (wallet_transaction_id) REFERENCES wallet_transactions (id)
+        )`, `
+        CREATE TRIGGER house_transactions_no_update BEFORE UPDATE ON house_transactions
+        BEGIN
+            SELECT RAISE(ABORT, 'house_transactions is append-only');
+        END`, `
+        CREATE TRIGGER house_transactions_no_delete BEFORE DELETE ON house_transactions
+        BEGIN
+            SELECT RAISE(ABORT, 'house_transactions is append-only');
+        END`,
+	}, fill: fillHouseSide},
+	{name: "promotions", create: []string{`
+        CREATE TABLE promotions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            kind TEXT NOT NULL CHECK (kind IN ('Signup', 'Daily', 'PromoCode')),
+            code TEXT UNIQUE,
+            name TEXT NOT NULL,
+            amount INTEGER NOT NULL CHECK (amount > 0),
+            wagering_multiplier REAL NOT NULL DEFAULT 0 CHECK (wagering_multiplier >= 0),
+            max_claims_per_user INTEGER,
+            max_claims_total INTEGER,
+            starts_at TIMESTAMP,
+            ends_at TIMESTAMP,
+            active INTEGER NOT NULL DEFAULT 1,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+	}},
+	{name: "bonus_claims", create: []string{`
+        CREATE TABLE bonus_claims (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            promotion_id INTEGER NOT NULL,
+            user_id INTEGER NOT NULL,
+            amount INTEGER NOT NULL,
+            wagering_required INTEGER NOT NULL DEFAULT 0,
+            wagered INTEGER NOT NULL DEFAULT 0,
+            claim_day TEXT,
+            claim_number INTEGER,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (promotion_id, user_id, claim_day),
+            FOREIGN KEY (promotion_id) REFERENCES promotions (id),
+            FOREIGN KEY (user_id) REFERENCES users (id)
+        )`,
+		`CREATE INDEX idx_bonus_claims_user_id ON bonus_claims (user_id)`,
+		`CREATE UNIQUE INDEX idx_bonus_claims_number ON bonus_claims (promotion_id, user_id, claim_number) WHERE claim_day IS NULL`,
+	}},
+	{name: "gambling_limits", create: []string{`
+        CREATE TABLE gambling_limits (
+            user_id INTEGER NOT NULL,
+            kind TEXT NOT NULL CHECK (kind IN ('DailyLoss', 'WeeklyLoss', 'MonthlyLoss', 'StakePerBet', 'DailyStake')),
+            amount INTEGER,
+            pending_amount INTEGER,
+            pending_from TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (user_id, kind),
+            FOREIGN KEY (user_id) REFERENCES users (id)
+        )`,
+	}},
+	{name: "date_of_birth_corrections", create: []string{`
+        CREATE TABLE date_of_birth_corrections (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            previous_date_of_birth TEXT,
+            date_of_birth TEXT NOT NULL,
+            reason TEXT NOT NULL,
+            corrected_by TEXT NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (user_id) REFERENCES users (id)
+        )`,
+	}},
+	{name: "audit_events", create: []string{`
+        CREATE TABLE audit_events (
+            id INTEGER PRIMARY KEY,
+            created_at TEXT NOT NULL,
+            actor_id INTEGER NOT NULL DEFAULT 0,
+            actor TEXT NOT NULL,
+            ip TEXT NOT NULL DEFAULT '',
+            action TEXT NOT NULL,
+            target_type TEXT NOT NULL DEFAULT '',
+            target_id TEXT NOT NULL DEFAULT '',
+            before_value TEXT NOT NULL DEFAULT '',
+            after_value TEXT NOT NULL DEFAULT '',
+            reason TEXT NOT NULL DEFAULT '',
+            prev_hash TEXT NOT NULL,
+            hash TEXT NOT NULL UNIQUE
+        )`, `
+        CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+        BEGIN
+            SELECT RAISE(ABORT, 'audit_events is append-only');
+        END`, `
+        CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+        BEGIN
+            SELECT RAISE(ABORT, 'audit_events is append-only');
+        END`,
+		`CREATE INDEX idx_audit_events_target ON audit_events (target_type, target_id)`,
+		`CREATE INDEX idx_audit_events_created_at ON audit_events (created_at)`,
+	}, fill: moveAccountActions},
+}
+
+// tablesMissing reports whether the database lacks a table in addedTables.
+func tablesMissing(q querier) (bool, error) {
+	for _, t := range addedTables {
+		exists, err := tableExists(q, t.name)
+		if err != nil || !exists {
+			return !exists, err
+		}
+	}
+	return false, nil
+}
+
+// addMissingTables creates and fills each table in addedTables the database lacks.
+func addMissingTables(tx *sql.Tx) error {
+	for _, t := range addedTables {
+		exists, err := tableExists(tx, t.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		for _, stmt := range t.create {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("error creating %s: %w", t.name, err)
+			}
+		}
+		if t.fill != nil {
+			if err := t.fill(tx); err != nil {
+				return err
+			}
+		}
+		log.Printf("addMissingTables: Added %s.", t.name)
+	}
+	return nil
+}
+
+// fillRaceEntrants gives every existing race a field. Before fields were drawn any chicken could be
+// backed in any race, so each race gets the whole stable, priced at the chickens' own odds as in the
+// sample data of init_database.sql; updateCurrentOdds reprices races still open for betting.
+func fillRaceEntrants(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        INSERT INTO race_entrants (race_id, chicken_id, lane, color, starting_odds, current_odds, place_odds, show_odds)
+        SELECT r.id, c.id, ROW_NUMBER() OVER (PARTITION BY r.id ORDER BY c.id), c.color, c.odds, c.odds,
+               ROUND(1 + (c.odds - 1) / 2, 2), ROUND(1 + (c.odds - 1) / 4, 2)
+        FROM races r CROSS JOIN chickens c
+    `)
+	if err != nil {
+		return fmt.Errorf("error filling race_entrants: %w", err)
+	}
+	return nil
+}
+
+// fillOpeningBalances opens the wallet ledger with each user's balance, so that every balance
+// matches the sum of its entries from the start.
+func fillOpeningBalances(tx *sql.Tx) error {
+	_, err := tx.Exec("INSERT INTO wallet_transactions (user_id, kind, amount, balance_after, note) SELECT id, ?, balance, balance, 'Balance before the ledger' FROM users ORDER BY id", WalletTxOpening)
+	if err != nil {
+		return fmt.Errorf("error recording opening balances: %w", err)
+	}
+	return nil
+}
+
+// fillHouseSide records the house side of every existing wallet entry.
+func fillHouseSide(tx *sql.Tx) error {
+	type entry struct {
+		id     int64
+		kind   string
+		amount Money
+	}
+	rows, err := tx.Query("SELECT id, kind, amount FROM wallet_transactions ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("error querying wallet ledger: %w", err)
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.kind, &e.amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning wallet ledger: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating wallet ledger: %w", err)
+	}
+
+	balances := make(map[string]Money)
+	for _, e := range entries {
+		account := houseAccountFor(e.kind)
+		balances[account] -= e.amount
+		_, err := tx.Exec("INSERT INTO house_transactions (wallet_transaction_id, account, amount, balance_after) VALUES (?, ?, ?, ?)",
+			e.id, account, -e.amount, balances[account])
+		if err != nil {
+			return fmt.Errorf("error recording house side of wallet entry %d: %w", e.id, err)
+		}
+	}
+	return nil
+}
+
+// betStatusesMissing reports whether bet_statuses lacks a status in requiredBetStatuses.
+func betStatusesMissing(q querier) (bool, error) {
+	for _, status := range requiredBetStatuses {
+		var n int
+		if err := q.QueryRow("SELECT COUNT(*) FROM bet_statuses WHERE status_name = ?", status).Scan(&n); err != nil {
+			return false, fmt.Errorf("error checking for bet status %s: %w", status, err)
+		}
+		if n == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addMissingBetStatuses adds each status in requiredBetStatuses that bet_statuses lacks.
+func addMissingBetStatuses(tx *sql.Tx) error {
+	for _, status := range requiredBetStatuses {
+		_, err := tx.Exec("INSERT INTO bet_statuses (status_name) SELECT ? WHERE NOT EXISTS (SELECT 1 FROM bet_statuses WHERE status_name = ?)", status, status)
+		if err != nil {
+			return fmt.Errorf("error adding bet status %s: %w", status, err)
+		}
+	}
+	return nil
+}
+
+// indexesMissing reports whether the database lacks an index in requiredIndexes.
+func indexesMissing(q querier) (bool, error) {
+	for _, idx := range requiredIndexes {
+		var n int
+		if err := q.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?", idx.name).Scan(&n); err != nil {
+			return false, fmt.Errorf("error checking for index %s: %w", idx.name, err)
+		}
+		if n == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addMissingIndexes creates each index in requiredIndexes the database lacks.
+func addMissingIndexes(tx *sql.Tx) error {
+	for _, idx := range requiredIndexes {
+		if _, err := tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s %s", idx.name, idx.definition)); err != nil {
+			return fmt.Errorf("error creating index %s: %w", idx.name, err)
+		}
+	}
+	return nil
+}
+
+// moneyColumnTables returns the tables with a column in requiredColumnTypes whose declared type differs,
+// and those columns, in the order they are listed.
+func moneyColumnTables(q querier) ([]string, map[string][]string, error) {
+	var tables []string
+	columns := make(map[string][]string)
+	for _, col := range requiredColumnTypes {
+		var colType string
+		err := q.QueryRow("SELECT type FROM pragma_table_info(?) WHERE name = ?", col.table, col.column).Scan(&colType)
+		if err == sql.ErrNoRows {
+			continue // Missing columns are left to init_database
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error checking type of %s.%s: %w", col.table, col.column, err)
+		}
+		if strings.EqualFold(colType, col.columnType) {
+			continue
+		}
+		if _, seen := columns[col.table]; !seen {
+			tables = append(tables, col.table)
+		}
+		columns[col.table] = append(columns[col.table], col.column)
+	}
+	return tables, columns, nil
+}
+
+// moneyColumnsNotCents reports whether any money column still has its old REAL type.
+func moneyColumnsNotCents(q querier) (bool, error) {
+	tables, _, err := moneyColumnTables(q)
+	return len(tables) > 0, err
+}
+
+// convertMoneyColumns rebuilds each table whose money columns are still REAL credits with INTEGER
+// cents. SQLite cannot change a column's type, so each table is copied into a new one created from
+// its own definition with the types changed, then swapped in, and its indexes and triggers recreated.
+func convertMoneyColumns(tx *sql.Tx) error {
+	tables, columns, err := moneyColumnTables(tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err := rebuildWithCents(tx, table, columns[table]); err != nil {
+			return err
+		}
+		log.Printf("convertMoneyColumns: Converted %s.%s to integer cents.", table, strings.Join(columns[table], ", "))
+	}
+	return nil
+}
+
+// createTablePattern matches the start of a table definition, up to the table name.
+var createTablePattern = regexp.MustCompile(`(?i)^\s*CREATE\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?"?\w+"?`)
+
+// rebuildWithCents rebuilds a table with the given REAL credit columns as INTEGER cents.
+func rebuildWithCents(tx *sql.Tx, table string, moneyColumns []string) error {
+	var createSQL string
+	if err := tx.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&createSQL); err != nil {
+		return fmt.Errorf("error reading definition of %s: %w", table, err)
+	}
+	newTable := table + "_cents"
+	if !createTablePattern.MatchString(createSQL) {
+		return fmt.Errorf("unexpected definition of %s: %q", table, createSQL)
+	}
+	createSQL = createTablePattern.ReplaceAllString(createSQL, "CREATE TABLE "+newTable)
+	for _, column := range moneyColumns {
+		typePattern := regexp.MustCompile(`(?i)(\b` + regexp.QuoteMeta(column) + `\s+)REAL\b`)
+		if !typePattern.MatchString(createSQL) {
+			return fmt.Errorf("cannot find the REAL type of %s.%s in its definition", table, column)
+		}
+		createSQL = typePattern.ReplaceAllString(createSQL, "${1}INTEGER")
+	}
+
+	// Indexes and triggers go with the old table and are recreated from their own definitions.
+	rows, err := tx.Query("SELECT sql FROM sqlite_master WHERE tbl_name = ? AND type IN ('index', 'trigger') AND sql IS NOT NULL", table)
+	if err != nil {
+		return fmt.Errorf("error reading indexes and triggers of %s: %w", table, err)
+	}
+	var dependents []string
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning indexes and triggers of %s: %w", table, err)
+		}
+		dependents = append(dependents, stmt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating indexes and triggers of %s: %w", table, err)
+	}
+
+	rows, err = tx.Query("SELECT name FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return fmt.Errorf("error reading columns of %s: %w", table, err)
+	}
+	var names, values []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning columns of %s: %w", table, err)
+		}
+		value := `"` + name + `"`
+		for _, column := range moneyColumns {
+			if name == column {
+				value = fmt.Sprintf(`CAST(ROUND("%s" * %d) AS INTEGER)`, name, int64(Credit))
+			}
+		}
+		names = append(names, `"`+name+`"`)
+		values = append(values, value)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating columns of %s: %w", table, err)
+	}
+
+	for _, stmt := range []string{
+		createSQL,
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", newTable, strings.Join(names, ", "), strings.Join(values, ", "), table),
+		"DROP TABLE " + table,
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", newTable, table),
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("error rebuilding %s: %w", table, err)
+		}
+	}
+	for _, stmt := range dependents {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("error recreating %q on %s: %w", stmt, table, err)
+		}
+	}
+	return nil
+}
+
+// bonusClaimNumberMissing reports whether bonus_claims predates claim_number.
+func bonusClaimNumberMissing(q querier) (bool, error) {
+	return columnMissing(q, "bonus_claims", "claim_number")
+}
+
+// addBonusClaimNumber adds claim_number as in init_database.sql, numbering each user's existing claims of
+// every Signup and PromoCode promotion in the order they were made, and adds its unique index.
+func addBonusClaimNumber(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		"ALTER TABLE bonus_claims ADD COLUMN claim_number INTEGER", `
+        UPDATE bonus_claims
+        SET claim_number = (SELECT COUNT(*) FROM bonus_claims e
+                            WHERE e.promotion_id = bonus_claims.promotion_id AND e.user_id = bonus_claims.user_id
+                              AND e.claim_day IS NULL AND e.id <= bonus_claims.id)
+        WHERE claim_day IS NULL`,
+		"CREATE UNIQUE INDEX idx_bonus_claims_number ON bonus_claims (promotion_id, user_id, claim_number) WHERE claim_day IS NULL",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("error adding bonus_claims.claim_number: %w", err)
+		}
+	}
+	return nil
+}
+
+// accountActionAudits maps the actions of the account_actions table, which the audit log replaced, to
+// audit actions.
+var accountActionAudits = map[string]string{
+	"BalanceAdjustment": AuditUserBalanceAdjusted,
+	"Lock":              AuditUserLocked,
+	"Unlock":            AuditUserUnlocked,
+	"ForceLogout":       AuditUserLoggedOut,
+	"PasswordReset":     AuditUserPasswordReset,
+}
+
+// moveAccountActions starts the audit log with the actions administrators took on user accounts before
+// it existed, oldest first, then drops the account_actions table they were kept in. The free-text detail
+// of an action becomes its after value.
+func moveAccountActions(tx *sql.Tx) error {
+	exists, err := tableExists(tx, "account_actions")
+	if err != nil || !exists {
+		return err
+	}
+	rows, err := tx.Query(`
+        SELECT a.user_id, a.action, COALESCE(a.detail, ''), a.reason, a.performed_by, COALESCE(u.name, 'user ' || a.performed_by), a.created_at
+        FROM account_actions a LEFT JOIN users u ON a.performed_by = u.id
+        ORDER BY a.id`)
+	if err != nil {
+		return fmt.Errorf("error querying account actions: %w", err)
+	}
+	var events []AuditEvent
+	for rows.Next() {
+		var userID int
+		var action, detail, reason string
+		var actor AuditActor
+		var createdAt time.Time
+		if err := rows.Scan(&userID, &action, &detail, &reason, &actor.ID, &actor.Name, &createdAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning account action: %w", err)
+		}
+		auditAction, ok := accountActionAudits[action]
+		if !ok {
+			rows.Close()
+			return fmt.Errorf("unknown account action %q", action)
+		}
+		e := newAuditEvent(actor, auditAction, "user", userID)
+		e.Reason, e.CreatedAt = reason, createdAt
+		if detail != "" {
+			e.After = auditValue(map[string]string{"detail": detail})
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("error reading account actions: %w", err)
+	}
+	rows.Close()
+
+	for _, e := range events {
+		if _, err := recordAuditEvent(tx, e); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DROP TABLE account_actions"); err != nil {
+		return fmt.Errorf("error dropping account_actions: %w", err)
+	}
+	log.Printf("moveAccountActions: Moved %d account action(s) into the audit log.", len(events))
+	return nil
+}
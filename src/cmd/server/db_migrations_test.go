@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// baselineSchema is the schema and sample data of the first release of init_database.sql, before any
+// migration existed.
+const baselineSchema = `
+CREATE TABLE chickens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    odds REAL NOT NULL DEFAULT 2.0 CHECK (odds >= 1.0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    balance REAL DEFAULT 1000.0 NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE races (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    date TIMESTAMP NOT NULL,
+    winner_chicken_id INTEGER,
+    winner TEXT,
+    status TEXT NOT NULL DEFAULT 'Scheduled',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (winner_chicken_id) REFERENCES chickens(id)
+);
+CREATE TABLE bet_statuses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    status_name TEXT NOT NULL UNIQUE
+);
+CREATE TABLE bets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    race_id INTEGER NOT NULL,
+    chicken_id INTEGER NOT NULL,
+    bet_amount REAL NOT NULL CHECK (bet_amount > 0),
+    bet_status_id INTEGER NOT NULL,
+    potential_payout REAL,
+    actual_payout REAL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (race_id) REFERENCES races (id),
+    FOREIGN KEY (chicken_id) REFERENCES chickens (id),
+    FOREIGN KEY (bet_status_id) REFERENCES bet_statuses (id)
+);
+CREATE TABLE contact_messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    topic VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    ip_address VARCHAR(45) NOT NULL
+);
+INSERT INTO bet_statuses (status_name) VALUES ('Pending'), ('Won'), ('Lost'), ('Cancelled');
+INSERT INTO users (name, email, password_hash, balance) VALUES
+    ('John Doe', 'john.doe@example.com', 'x', 1075.5),
+    ('Jane Smith', 'jane.smith@example.com', 'x', 975.25);
+INSERT INTO chickens (name, odds) VALUES ('Henrietta', 2.5), ('Cluck Norris', 1.8), ('Foghorn Leghorn Jr.', 3.0);
+INSERT INTO races (name, date, winner_chicken_id, winner, status) VALUES
+    ('The Grand Cluck Off', '2025-01-20 10:00:00', 1, 'Henrietta', 'Finished');
+INSERT INTO races (name, date, status) VALUES ('Upcoming Eggstravaganza', '2025-06-01 14:00:00', 'Scheduled');
+INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id, actual_payout) VALUES (1, 1, 1, 50.0, 2, 125.0);
+INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id) VALUES (2, 2, 3, 24.75, 1);
+CREATE INDEX idx_bets_user_id ON bets (user_id);
+CREATE INDEX idx_bets_race_id ON bets (race_id);
+CREATE INDEX idx_bets_chicken_id ON bets (chicken_id);
+CREATE INDEX idx_users_email ON users (email);
+CREATE INDEX idx_races_status_date ON races (status, date);
+CREATE INDEX idx_chickens_name ON chickens (name);
+`
+
+// openBaselineDB returns an in-memory database with baselineSchema.
+func openBaselineDB(t *testing.T) *sql.DB {
+	t.Helper()
+	old, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.SetMaxOpenConns(1) // Every connection to :memory: is a separate database
+	t.Cleanup(func() { old.Close() })
+	if _, err := old.Exec(baselineSchema); err != nil {
+		t.Fatalf("creating baseline schema: %v", err)
+	}
+	return old
+}
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	old := openBaselineDB(t)
+	if err := checkSchema(old); err == nil {
+		t.Fatal("checkSchema accepted the baseline schema")
+	}
+	if empty, err := databaseIsEmpty(old); err != nil || empty {
+		t.Fatalf("databaseIsEmpty = %v, %v; want false", empty, err)
+	}
+
+	for range 2 {
+		if err := migrateDatabase(old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := checkSchema(old); err != nil {
+		t.Fatalf("checkSchema after migrating: %v", err)
+	}
+	if pending, err := pendingMigrations(old); err != nil || len(pending) != 0 {
+		t.Errorf("pendingMigrations after migrating = %v, %v; want none", pending, err)
+	}
+
+	// Balances and bets are kept, in cents.
+	var balance Money
+	if err := old.QueryRow("SELECT balance FROM users WHERE id = 2").Scan(&balance); err != nil || balance != 97525 {
+		t.Errorf("balance = %s, %v; want 975.25", balance, err)
+	}
+	var stake Money
+	var betType, status string
+	err := old.QueryRow("SELECT b.bet_amount, b.bet_type, s.status_name FROM bets b JOIN bet_statuses s ON b.bet_status_id = s.id WHERE b.id = 2").Scan(&stake, &betType, &status)
+	if err != nil || stake != 2475 || betType != BetTypeWin || status != "Pending" {
+		t.Errorf("pending bet = %s %s %s, %v; want a Pending Win bet of 24.75", stake, betType, status, err)
+	}
+	var mode string
+	if err := old.QueryRow("SELECT bet_mode FROM races WHERE id = 2").Scan(&mode); err != nil || mode != BetModeFixedOdds {
+		t.Errorf("bet mode = %q, %v; want %s", mode, err, BetModeFixedOdds)
+	}
+
+	// Every race gets the whole stable as its field.
+	entrants, err := getRaceEntrants(old, 2)
+	if err != nil || len(entrants) != 3 {
+		t.Fatalf("race 2 has %d entrants, %v; want 3", len(entrants), err)
+	}
+	for i, ch := range entrants {
+		if ch.Lane != i+1 {
+			t.Errorf("entrant %d in lane %d, want %d", ch.ID, ch.Lane, i+1)
+		}
+	}
+
+	// The ledger opens at each balance, with its house side, and reconciles.
+	if drifts, err := reconcileWallets(old); err != nil || len(drifts) != 0 {
+		t.Errorf("reconcileWallets = %+v, %v; want no drift", drifts, err)
+	}
+	if imbalances, err := reconcileHouse(old); err != nil || len(imbalances) != 0 {
+		t.Errorf("reconcileHouse = %+v, %v; want no imbalance", imbalances, err)
+	}
+	var opening Money
+	if err := old.QueryRow("SELECT SUM(amount) FROM wallet_transactions WHERE kind = ?", WalletTxOpening).Scan(&opening); err != nil || opening != 107550+97525 {
+		t.Errorf("opening entries sum to %s, %v; want 2050.75", opening, err)
+	}
+	if _, err := old.Exec("DELETE FROM wallet_transactions"); err == nil {
+		t.Error("the migrated wallet_transactions accepted a delete")
+	}
+
+	var indexes int
+	if err := old.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name IN ('idx_bets_user_id', 'idx_wallet_transactions_user_id', 'idx_wallet_transactions_bet_id')").Scan(&indexes); err != nil || indexes != 3 {
+		t.Errorf("found %d of the 3 indexes, %v", indexes, err)
+	}
+	var cashedOut int
+	if err := old.QueryRow("SELECT COUNT(*) FROM bet_statuses WHERE status_name = ?", BetStatusCashedOut).Scan(&cashedOut); err != nil || cashedOut != 1 {
+		t.Errorf("found %d %s statuses, %v; want 1", cashedOut, BetStatusCashedOut, err)
+	}
+}
+
+func TestMigrateAccountActions(t *testing.T) {
+	old := openBaselineDB(t)
+	_, err := old.Exec(`
+        CREATE TABLE account_actions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            action TEXT NOT NULL,
+            detail TEXT,
+            reason TEXT NOT NULL,
+            performed_by INTEGER NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+        INSERT INTO account_actions (user_id, action, detail, reason, performed_by, created_at) VALUES
+            (2, 'BalanceAdjustment', '+10.00', 'Goodwill', 1, '2025-03-01 09:00:00'),
+            (2, 'Lock', NULL, 'Chargeback', 7, '2025-03-02 10:30:00');
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDatabase(old); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists, err := tableExists(old, "account_actions"); err != nil || exists {
+		t.Errorf("account_actions exists = %v, %v; want it dropped", exists, err)
+	}
+	events, err := getTargetAuditEvents(old, "user", "user", 2)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("got %d audit events on user 2, %v; want 2", len(events), err)
+	}
+	lock, adjustment := events[0], events[1]
+	if adjustment.Action != AuditUserBalanceAdjusted || adjustment.Actor != "John Doe" || adjustment.ActorID != 1 ||
+		adjustment.After != `{"detail":"+10.00"}` || adjustment.Reason != "Goodwill" ||
+		!adjustment.CreatedAt.Equal(time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("balance adjustment = %+v", adjustment)
+	}
+	if lock.Action != AuditUserLocked || lock.Actor != "user 7" || lock.After != "" || lock.Reason != "Chargeback" {
+		t.Errorf("lock = %+v", lock)
+	}
+	if v, err := verifyAuditChain(old); err != nil || v.Events != 2 {
+		t.Errorf("verifyAuditChain = %+v, %v; want 2 chained events", v, err)
+	}
+}
+
+// schemaOf lists the tables, columns with their types, indexes and triggers of a database.
+func schemaOf(t *testing.T, q *sql.DB) map[string]string {
+	t.Helper()
+	rows, err := q.Query(`
+        SELECT m.type || ' ' || m.name, COALESCE(p.name, ''), COALESCE(p.type, '')
+        FROM sqlite_master m LEFT JOIN pragma_table_info(m.name) p ON m.type = 'table'
+        WHERE m.name NOT LIKE 'sqlite_%'
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	schema := make(map[string]string)
+	for rows.Next() {
+		var object, column, colType string
+		if err := rows.Scan(&object, &column, &colType); err != nil {
+			t.Fatal(err)
+		}
+		schema[object+" "+column] = colType
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestMigratedSchemaMatchesInitSchema(t *testing.T) {
+	old := openBaselineDB(t)
+	if err := migrateDatabase(old); err != nil {
+		t.Fatal(err)
+	}
+	migrated := schemaOf(t, old)
+	setupTestDB(t)
+	for object, colType := range schemaOf(t, db) {
+		got, ok := migrated[object]
+		if !ok {
+			t.Errorf("a migrated database lacks %s", object)
+		} else if got != colType {
+			t.Errorf("%s is %s in a migrated database, %s in a new one", object, got, colType)
+		}
+	}
+}
+
+func TestInitSchemaNeedsNoMigration(t *testing.T) {
+	setupTestDB(t)
+	if pending, err := pendingMigrations(db); err != nil || len(pending) != 0 {
+		t.Errorf("pendingMigrations on a fresh database = %v, %v; want none", pending, err)
+	}
+	if err := checkSchema(db); err != nil {
+		t.Errorf("checkSchema on a fresh database: %v", err)
+	}
+}
@@ -2,49 +2,99 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	"github.com/mattn/go-sqlite3" // SQLite driver
 )
 
+// databasePath is the SQLite database the server runs on.
+const databasePath = "src/internal/database/scramble.db"
+
+// requiredTables lists the tables the application expects. Tables added since the first schema are created by migrateDatabase (see addedTables).
+var requiredTables = []string{"users", "races", "chickens", "bets", "bet_statuses", "race_entrants", "race_results", "bet_selections", "bet_legs", "wallet_transactions", "house_transactions", "promotions", "bonus_claims", "gambling_limits", "date_of_birth_corrections", "audit_events"}
+
+// requiredColumns lists columns added after a table was first introduced, with their definitions as in init_database.sql, so migrateDatabase can add them to older databases.
+var requiredColumns = []struct{ table, column, definition string }{
+	{"users", "balance", "INTEGER DEFAULT 0 NOT NULL"},
+	{"races", "status", "TEXT NOT NULL DEFAULT 'Scheduled'"},
+	{"chickens", "color", "TEXT NOT NULL DEFAULT '#f59e0b'"},
+	{"chickens", "speed", "REAL NOT NULL DEFAULT 6.0 CHECK (speed > 0)"},
+	{"chickens", "acceleration", "REAL NOT NULL DEFAULT 2.0 CHECK (acceleration > 0)"},
+	{"chickens", "stamina", "REAL NOT NULL DEFAULT 0.7 CHECK (stamina BETWEEN 0 AND 1)"},
+	{"races", "sim_seed", "INTEGER NOT NULL DEFAULT 0"},
+	{"races", "bet_mode", "TEXT NOT NULL DEFAULT 'FixedOdds' CHECK (bet_mode IN ('FixedOdds', 'Tote'))"},
+	{"races", "takeout", "REAL NOT NULL DEFAULT 0 CHECK (takeout >= 0 AND takeout < 1)"},
+	{"bets", "odds", "REAL"},
+	{"bets", "bet_type", "TEXT NOT NULL DEFAULT 'Win' CHECK (bet_type IN ('Win', 'Place', 'Show', 'Exacta', 'Quinella', 'Trifecta', 'Accumulator'))"},
+	{"races", "cancel_reason", "TEXT"},
+	{"bets", "settled_at", "TIMESTAMP"},
+	{"bonus_claims", "claim_number", "INTEGER"},
+	{"users", "self_excluded_until", "TIMESTAMP"},
+	{"users", "session_reminder_minutes", "INTEGER"},
+	{"users", "date_of_birth", "TEXT"},
+	{"users", "role", "TEXT NOT NULL DEFAULT 'player' CHECK (role IN ('player', 'moderator', 'admin'))"},
+	{"chickens", "image_path", "TEXT"},
+	{"chickens", "retired_at", "TIMESTAMP"},
+	{"users", "locked_at", "TIMESTAMP"},
+}
+
+// requiredIndexes lists indexes added after their table was first introduced, as created in init_database.sql.
+var requiredIndexes = []struct{ name, definition string }{
+	{"idx_wallet_transactions_bet_id", "ON wallet_transactions (bet_id)"},
+}
+
+// requiredColumnTypes lists columns whose declared type changed, e.g. money columns that moved from REAL credits to INTEGER cents.
+var requiredColumnTypes = []struct{ table, column, columnType string }{
+	{"users", "balance", "INTEGER"},
+	{"bets", "bet_amount", "INTEGER"},
+	{"bets", "potential_payout", "INTEGER"},
+	{"bets", "actual_payout", "INTEGER"},
+	{"wallet_transactions", "amount", "INTEGER"},
+	{"wallet_transactions", "balance_after", "INTEGER"},
+}
+
+// requiredBetStatuses lists bet statuses added after bet_statuses was first seeded.
+var requiredBetStatuses = []string{BetStatusCashedOut}
+
+// isUniqueViolation reports whether err is SQLite refusing a row that breaks a UNIQUE constraint or index.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// isWriteConflict reports whether err is SQLite refusing a write because another connection got there
+// first: the database was locked, or the row's primary key was taken in the meantime.
+func isWriteConflict(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
+
 // init_database initializes and returns a database connection.
-// It also checks if the schema needs to be created/updated.
+// An empty database is created from init_database.sql; an existing one is backed up and migrated in
+// place when it needs it, and never rebuilt, so no bet or ledger entry is lost.
 func init_database() *sql.DB {
-	db, err := sql.Open("sqlite3", "src/internal/database/scramble.db")
+	db, err := sql.Open("sqlite3", databasePath)
 	if err != nil {
 		log.Printf("Failed to connect to the database: %v", err)
 		return nil
 	}
 
-	shouldInitialize := false
-	var tableCount int
-	err = db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name IN ('users', 'races', 'chickens', 'bets', 'bet_statuses');").Scan(&tableCount)
+	empty, err := databaseIsEmpty(db)
 	if err != nil {
-		log.Printf("Failed to check for core tables: %v. Assuming initialization is needed.", err)
-		shouldInitialize = true
-	} else if tableCount < 5 {
-		log.Printf("Found %d core tables, expected 5. Database might be incomplete. Attempting initialization.", tableCount)
-		shouldInitialize = true
-	} else {
-		var balanceColExists int
-		err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='balance'").Scan(&balanceColExists)
-		if err != nil || balanceColExists == 0 {
-			log.Println("'balance' column not found in 'users' or error checking. Database might need re-initialization.")
-			shouldInitialize = true
-		}
-		var statusColExistsRaces int
-		err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('races') WHERE name='status'").Scan(&statusColExistsRaces)
-		if err != nil || statusColExistsRaces == 0 {
-			log.Println("'status' column not found in 'races' or error checking. Database might need re-initialization.")
-			shouldInitialize = true
-		}
+		log.Printf("Failed to check the database: %v", err)
+		db.Close()
+		return nil
 	}
-
-	if shouldInitialize {
-		log.Println("Attempting to initialize database from SQL file...")
+	if empty {
+		log.Println("Database is empty. Initializing it from SQL file...")
 		sqlFile, errRead := os.ReadFile("src/internal/database/init_database.sql")
 		if errRead != nil {
 			log.Printf("Failed to read SQL initialization file: %v", errRead)
@@ -57,13 +107,80 @@ func init_database() *sql.DB {
 			db.Close()
 			return nil
 		}
-		fmt.Println("Database initialized/verified successfully from SQL file.")
-	} else {
-		fmt.Println("Database structure appears up-to-date.")
+		fmt.Println("Database initialized successfully from SQL file.")
+		return db
 	}
+
+	pending, err := pendingMigrations(db)
+	if err != nil {
+		log.Printf("Failed to check the database for migrations: %v", err)
+		db.Close()
+		return nil
+	}
+	if len(pending) > 0 {
+		backup, err := backupDatabase(db, databasePath)
+		if err != nil {
+			log.Printf("Failed to back up the database before migrating it: %v", err)
+			db.Close()
+			return nil
+		}
+		log.Printf("Backed up the database to %s before migrating it (%s).", backup, strings.Join(pending, "; "))
+		if err := migrateDatabase(db); err != nil {
+			log.Printf("Failed to migrate the database: %v", err)
+			db.Close()
+			return nil
+		}
+	}
+	if err := checkSchema(db); err != nil {
+		log.Printf("The database schema is out of date and no migration covers it: %v. Move the database aside to start with a fresh one.", err)
+		db.Close()
+		return nil
+	}
+	fmt.Println("Database structure appears up-to-date.")
 	return db
 }
 
+// checkSchema returns an error naming the first table, column, column type or bet status the
+// application expects that the database lacks.
+func checkSchema(q querier) error {
+	for _, name := range requiredTables {
+		exists, err := tableExists(q, name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("table %s is missing", name)
+		}
+	}
+	for _, col := range requiredColumns {
+		exists, err := columnExists(q, col.table, col.column)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("column %s.%s is missing", col.table, col.column)
+		}
+	}
+	for _, col := range requiredColumnTypes {
+		var colType string
+		err := q.QueryRow("SELECT type FROM pragma_table_info(?) WHERE name = ?", col.table, col.column).Scan(&colType)
+		if err != nil {
+			return fmt.Errorf("error checking type of %s.%s: %w", col.table, col.column, err)
+		}
+		if !strings.EqualFold(colType, col.columnType) {
+			return fmt.Errorf("column %s.%s is %s, not %s", col.table, col.column, colType, col.columnType)
+		}
+	}
+	missing, err := betStatusesMissing(q)
+	if err != nil {
+		return err
+	}
+	if missing {
+		return fmt.Errorf("a bet status in %v is missing", requiredBetStatuses)
+	}
+	return nil
+}
+
 // parseRaceDate attempts to parse a date string from the database using various common formats.
 func parseRaceDate(dateStr string) (time.Time, error) {
 	layouts := []string{
@@ -86,19 +203,20 @@ func parseRaceDate(dateStr string) (time.Time, error) {
 }
 
 // getRaceDetails fetches detailed information for a single race.
-func getRaceDetails(querier rowQuerier, raceID int) (*RaceInfo, error) {
+func getRaceDetails(q querier, raceID int) (*RaceInfo, error) {
 	var race RaceInfo
 	var dateStr string
 	var winnerID sql.NullInt64
 	var winnerName sql.NullString
+	var cancelReason sql.NullString
 
 	query := `
-        SELECT r.id, r.name, r.date, r.status, r.winner_chicken_id, c.name AS winner_name
+        SELECT r.id, r.name, r.date, r.status, r.winner_chicken_id, c.name AS winner_name, r.cancel_reason
         FROM races r
         LEFT JOIN chickens c ON r.winner_chicken_id = c.id
         WHERE r.id = ?
     `
-	err := querier.QueryRow(query, raceID).Scan(&race.Id, &race.Name, &dateStr, &race.Status, &winnerID, &winnerName)
+	err := q.QueryRow(query, raceID).Scan(&race.Id, &race.Name, &dateStr, &race.Status, &winnerID, &winnerName, &cancelReason)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("race with ID %d not found", raceID)
@@ -122,10 +240,14 @@ func getRaceDetails(querier rowQuerier, raceID int) (*RaceInfo, error) {
 	} else {
 		race.Winner = "N/A"
 	}
+	race.CancelReason = cancelReason.String
 
-	race.ChickenNames = make([]string, len(availableChickens))
-	for i, ch := range availableChickens {
-		race.ChickenNames[i] = ch.Name
+	entrants, err := getRaceEntrants(q, raceID)
+	if err != nil {
+		log.Printf("getRaceDetails: Failed to load entrants for race ID %d: %v", raceID, err)
+	}
+	for _, ch := range entrants {
+		race.ChickenNames = append(race.ChickenNames, ch.Name)
 	}
 	return &race, nil
 }
@@ -156,10 +278,21 @@ func getPendingBetStatusID(dbq rowQuerier) (int, error) {
 	return statusID, nil
 }
 
+// getBetStatusID retrieves the ID of a bet status by name, e.g. 'Won' or 'Cancelled'.
+func getBetStatusID(dbq rowQuerier, name string) (int, error) {
+	var statusID int
+	err := dbq.QueryRow("SELECT id FROM bet_statuses WHERE status_name = ?", name).Scan(&statusID)
+	if err != nil {
+		return 0, fmt.Errorf("could not find '%s' bet status ID: %w", name, err)
+	}
+	return statusID, nil
+}
+
 // get_races fetches a list of all races, ordered by date descending.
 func get_races(db *sql.DB) []RaceInfo {
+	entrantNames := getEntrantNamesByRace(db)
 	rows, err := db.Query(`
-        SELECT r.id, r.name, r.date, r.status, r.winner_chicken_id, c.name AS winner_name
+        SELECT r.id, r.name, r.date, r.status, r.winner_chicken_id, c.name AS winner_name, r.cancel_reason
         FROM races r
         LEFT JOIN chickens c ON r.winner_chicken_id = c.id
         ORDER BY r.date DESC
@@ -176,8 +309,9 @@ func get_races(db *sql.DB) []RaceInfo {
 		var dateStr string
 		var winnerID sql.NullInt64
 		var winnerName sql.NullString
+		var cancelReason sql.NullString
 
-		err = rows.Scan(&race.Id, &race.Name, &dateStr, &race.Status, &winnerID, &winnerName)
+		err = rows.Scan(&race.Id, &race.Name, &dateStr, &race.Status, &winnerID, &winnerName, &cancelReason)
 		if err != nil {
 			log.Printf("get_races: Failed to scan row: %v", err)
 			continue
@@ -201,6 +335,8 @@ func get_races(db *sql.DB) []RaceInfo {
 		} else {
 			race.Winner = ""
 		}
+		race.CancelReason = cancelReason.String
+		race.ChickenNames = entrantNames[race.Id]
 		races = append(races, race)
 	}
 	if err := rows.Err(); err != nil {
@@ -10,6 +10,7 @@ import (
 	"log"
 	_ "math/rand"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
@@ -24,27 +25,27 @@ const (
 	RaceStatusScheduled string = "Scheduled"
 	RaceStatusRunning   string = "Running"
 	RaceStatusFinished  string = "Finished"
+	RaceStatusCancelled string = "Cancelled"
 	RaceStatusNoRace    string = "NoRace"
 )
 
 // Global Variables
 var (
-	db                  *sql.DB
-	baseTemplate        *template.Template
-	homeTemplate        *template.Template
-	raceTemplate        *template.Template
-	loginTemplate       *template.Template
-	signupTemplate      *template.Template
-	contactTemplate     *template.Template
-	aboutUsTemplate     *template.Template
-	betResponseTemplate *template.Template
-	raceInfoTemplate    *template.Template
-
-	availableChickens = []Chicken{
-		{ID: 1, Name: "Henrietta", Color: "red", Odds: 2.5, Lane: 10, Progress: 0},
-		{ID: 2, Name: "Cluck Norris", Color: "blue", Odds: 3.0, Lane: 50, Progress: 0},
-		{ID: 3, Name: "Foghorn", Color: "green", Odds: 4.0, Lane: 90, Progress: 0},
-	}
+	db                          *sql.DB
+	baseTemplate                *template.Template
+	homeTemplate                *template.Template
+	raceTemplate                *template.Template
+	loginTemplate               *template.Template
+	signupTemplate              *template.Template
+	contactTemplate             *template.Template
+	aboutUsTemplate             *template.Template
+	myBetsTemplate              *template.Template
+	responsibleGamblingTemplate *template.Template
+	adminTemplate               *template.Template
+	adminChickensTemplate       *template.Template
+	adminUsersTemplate          *template.Template
+	adminAuditTemplate          *template.Template
+	betResponseTemplate         *template.Template
 
 	raceMutex          sync.Mutex
 	currentRaceDetails *RaceInfo
@@ -56,8 +57,56 @@ var (
 	sessionManager *scs.SessionManager
 )
 
-// init initializes database connection, templates, and seeds random number generator.
-func init() {
+// raceInfoTemplate renders the race timer, betting status and (for logged-in users) their balance.
+var raceInfoTemplate = template.Must(template.New("raceInfoSnippet").Parse(`
+	{{/* This is the entire new innerHTML for div#race-timer-dynamic-area */}}
+	<svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" class="race-timer-icon">
+		<circle cx="12" cy="12" r="10"></circle>
+		<polyline points="12 6 12 12 16 14"></polyline>
+	</svg>
+	<span class="race-timer-prefix">
+		{{ if .IsRaceRunning }}
+			Race in Progress:
+		{{ else if .CountdownStr }} {{/* Check CountdownStr first if it's more specific */}}
+			Next race in:
+		{{ else if .StatusMsg }} {{/* Fallback to general status message if no countdown */}}
+			{{.StatusMsg}}
+		{{ end }}
+	</span>
+	<span class="race-timer-countdown">
+		{{if .CountdownStr}}
+			{{.CountdownStr}}
+		{{else if .IsRaceRunning}} {{/* Explicitly show "Running!" if race is running and no specific countdown*/}}
+			Running!
+		{{else if .StatusMsg}}
+			{{.StatusMsg}}
+		{{else}}
+			--:--
+		{{end}}
+	</span>
+	{{if .RaceName}}
+		<span class="race-timer-racename">({{ .RaceName }})</span>
+	{{end}}
+	<br>
+	<span class="race-timer-bettingstatus">
+		{{if .IsBettingOpen}}
+			Betting is Open!
+		{{else if .IsRaceRunning}}
+			Betting Closed (Race Running)
+		{{else}}
+			Betting is Closed
+		{{end}}
+	</span>
+	{{if .UserLoggedIn }}
+	<span id="user-balance-display" hx-swap-oob="innerHTML">
+		{{.CurrentUserBalance}}
+	</span>
+	{{end}}
+`))
+
+// initApp initializes database connection, templates, and the session manager.
+// It is called from main rather than init() so tests can load the package without a database on disk.
+func initApp() {
 	gob.Register(time.Time{})
 	db = init_database() // Assuming init_database() is defined in db_utils.go or similar
 	if db == nil {
@@ -104,6 +153,12 @@ func init() {
 	signupTemplate = mustParse(baseTemplate, "signup", "src/web/templates/signup.gohtml")
 	contactTemplate = mustParse(baseTemplate, "contact", "src/web/templates/contact.gohtml")
 	aboutUsTemplate = mustParse(baseTemplate, "about-us", "src/web/templates/about-us.gohtml")
+	myBetsTemplate = mustParse(baseTemplate, "my-bets", "src/web/templates/my-bets.gohtml")
+	responsibleGamblingTemplate = mustParse(baseTemplate, "responsible-gambling", "src/web/templates/responsible-gambling.gohtml")
+	adminTemplate = mustParse(baseTemplate, "admin", "src/web/templates/admin.gohtml")
+	adminChickensTemplate = mustParse(baseTemplate, "admin-chickens", "src/web/templates/admin-chickens.gohtml")
+	adminUsersTemplate = mustParse(baseTemplate, "admin-users", "src/web/templates/admin-users.gohtml")
+	adminAuditTemplate = mustParse(baseTemplate, "admin-audit", "src/web/templates/admin-audit.gohtml")
 
 	betResponseTemplate = template.Must(template.New("betResponse").Parse(`
 		{{/* This is the content for #bet-response-area */}}
@@ -111,66 +166,21 @@ func init() {
 			{{if .Success}}
 				<div class="alert alert-success">
 					<p>{{.Message}}</p>
-					<p>Bet placed: {{printf "%.2f" .BetAmount}} credits on {{.ChickenName}}</p>
-					<p>New balance: {{printf "%.2f" .NewBalance}} credits</p>
+					<p>Bet placed: {{.BetAmount}} credits on {{.ChickenName}}</p>
+					<p>New balance: {{.NewBalance}} credits</p>
 				</div>
 			{{else}}
 				<div class="alert alert-danger">
 					<p>{{.Message}}</p>
-					{{if ge .NewBalance 0.0}} {{/* Only show balance if it's not negative (e.g. insufficient funds) */}}
-					<p>Your balance: {{printf "%.2f" .NewBalance}} credits</p>
+					{{if ge .NewBalance 0}} {{/* Only show balance if it's not negative (e.g. insufficient funds) */}}
+					<p>Your balance: {{.NewBalance}} credits</p>
 					{{end}}
 				</div>
 			{{end}}
 		</div>
-		<span id="user-balance-display" hx-swap-oob="true">{{printf "%.2f" .NewBalance}}</span>
+		{{if ge .NewBalance 0}}<span id="user-balance-display" hx-swap-oob="true">{{.NewBalance}}</span>{{end}}
 	`))
 
-	raceInfoTemplate = template.Must(template.New("raceInfoSnippet").Parse(`
-		{{/* This is the entire new innerHTML for div#race-timer-dynamic-area */}}
-		<svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" class="race-timer-icon">
-			<circle cx="12" cy="12" r="10"></circle>
-			<polyline points="12 6 12 12 16 14"></polyline>
-		</svg>
-		<span class="race-timer-prefix">
-			{{ if .IsRaceRunning }}
-				Race in Progress:
-			{{ else if .CountdownStr }} {{/* Check CountdownStr first if it's more specific */}}
-				Next race in:
-			{{ else if .StatusMsg }} {{/* Fallback to general status message if no countdown */}}
-				{{.StatusMsg}}
-			{{ end }}
-		</span>
-		<span class="race-timer-countdown">
-			{{if .CountdownStr}}
-				{{.CountdownStr}}
-			{{else if .IsRaceRunning}} {{/* Explicitly show "Running!" if race is running and no specific countdown*/}}
-				Running!
-			{{else if .StatusMsg}}
-				{{.StatusMsg}}
-			{{else}}
-				--:--
-			{{end}}
-		</span>
-		{{if .RaceName}}
-			<span class="race-timer-racename">({{ .RaceName }})</span>
-		{{end}}
-		<br>
-		<span class="race-timer-bettingstatus">
-			{{if .IsBettingOpen}}
-				Betting is Open!
-			{{else if .IsRaceRunning}}
-				Betting Closed (Race Running)
-			{{else}}
-				Betting is Closed
-			{{end}}
-		</span>
-		{{if .UserLoggedIn }}
-		<span id="user-balance-display" hx-swap-oob="innerHTML">
-			{{printf "%.2f" .CurrentUserBalance}}
-		</span>
-		{{end}}
-	`))
 	log.Println("Templates loaded successfully")
 
 	// --- Session Manager Initialization ---
@@ -190,10 +200,17 @@ func init() {
 
 // main is the entry point of the application.
 func main() {
+	initApp()
 	if db == nil {
 		log.Fatal("Database not initialized (db is nil in main). Exiting.")
 		return
 	}
+	// `server <command>` runs a maintenance command (see commands.go) instead of the web server.
+	if len(os.Args) > 1 {
+		code := runCommand(db, os.Args[1:], os.Stdout)
+		db.Close()
+		os.Exit(code)
+	}
 	defer func() {
 		if db != nil {
 			log.Println("Closing database connection.")
@@ -232,23 +249,57 @@ func main() {
 	// Betting handlers
 	mux.HandleFunc("/select-chicken/", selectChickenHandler)
 	mux.HandleFunc("/calculate-winnings", calculateWinningsHandler)
-	mux.HandleFunc("/place-bet", placeBetHandler)
+	mux.HandleFunc("/race-market", raceMarketHandler)
+	mux.Handle("/place-bet", requireAuthentication(http.HandlerFunc(placeBetHandler)))
+	mux.HandleFunc("/accumulator-races", accumulatorRacesHandler)
+	mux.HandleFunc("/calculate-accumulator", calculateAccumulatorHandler)
+	mux.Handle("/place-accumulator", requireAuthentication(http.HandlerFunc(placeAccumulatorHandler)))
+	mux.HandleFunc("/open-bets", openBetsHandler)
+	mux.Handle("/cancel-bet", requireAuthentication(http.HandlerFunc(cancelBetHandler)))
+	mux.Handle("/cash-out", requireAuthentication(http.HandlerFunc(cashOutHandler)))
+	mux.HandleFunc("/bonuses", bonusesHandler)
+	mux.Handle("/claim-daily-bonus", requireAuthentication(http.HandlerFunc(claimDailyBonusHandler)))
+	mux.Handle("/redeem-promo", requireAuthentication(http.HandlerFunc(redeemPromoCodeHandler)))
+	mux.Handle("/my-bets", requireAuthentication(http.HandlerFunc(myBetsHandler)))
+	mux.Handle("/my-bets/export", requireAuthentication(http.HandlerFunc(myBetsExportHandler)))
+	mux.Handle("/responsible-gambling", requireAuthentication(http.HandlerFunc(responsibleGamblingHandler)))
+	mux.HandleFunc("/session-reminder", sessionReminderHandler)
 
 	// Race info and admin
 	mux.HandleFunc("/next-race-info", nextRaceInfoHandler)
-	mux.HandleFunc("/admin/trigger-race-cycle", handleTriggerRaceCycle) // Consider protecting this admin route
 	mux.HandleFunc("/race-update", raceUpdateHandler)
 
+	// Admin handlers. Everything under /admin/ is for admins only; anyone else gets 403 (guests log in first).
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/admin/", adminDashboardHandler)
+	adminMux.HandleFunc("/admin/races", adminRacesHandler)
+	adminMux.HandleFunc("/admin/race-action", adminRaceActionHandler)
+	adminMux.HandleFunc("/admin/trigger-race-cycle", handleTriggerRaceCycle)
+	adminMux.HandleFunc("/admin/cancel-race", handleCancelRace)
+	adminMux.HandleFunc("/admin/chickens", adminChickensHandler)
+	adminMux.HandleFunc("/admin/chickens/list", adminChickensListHandler)
+	adminMux.HandleFunc("/admin/chickens/edit", adminEditChickenHandler)
+	adminMux.HandleFunc("/admin/chickens/save", adminSaveChickenHandler)
+	adminMux.HandleFunc("/admin/chickens/retire", adminRetireChickenHandler)
+	adminMux.HandleFunc("/admin/chickens/delete", adminDeleteChickenHandler)
+	adminMux.HandleFunc("/admin/users", adminUsersHandler)
+	adminMux.HandleFunc("/admin/users/search", adminUserSearchHandler)
+	adminMux.HandleFunc("/admin/users/view", adminUserHandler)
+	adminMux.HandleFunc("/admin/users/panel", adminUserPanelHandler)
+	adminMux.HandleFunc("/admin/users/action", adminUserActionHandler)
+	adminMux.HandleFunc("/admin/users/export", adminUserExportHandler)
+	mux.Handle("/admin/", requireRole(RoleAdmin)(adminMux))
+	// Moderators can read the audit log, which changes nothing; everything else under /admin/ needs an admin.
+	mux.Handle("/admin/audit", requireRole(RoleModerator)(http.HandlerFunc(adminAuditHandler)))
+	mux.Handle("/admin/audit/verify", requireRole(RoleModerator)(http.HandlerFunc(adminAuditVerifyHandler)))
+
 	// If /submit-contact is the POST target for the contact form handled by contactHandler:
 	// mux.HandleFunc("/submit-contact", contactHandler) // This is fine if contactHandler checks r.Method
 
 	// --- Important: Apply middleware ---
 	// sessionManager.LoadAndSave will wrap our entire mux.
-	// All requests will go through this middleware first.
-	handlerWithSession := sessionManager.LoadAndSave(mux)
-
-	// TODO: Add other middleware here if needed, e.g., CSRF protection, logging, etc.
-	// Example: handlerWithSessionAndCSRF := nosurf.New(handlerWithSession)
+	// All requests will go through this middleware first, then csrfProtect, which needs the session.
+	handlerWithSession := sessionManager.LoadAndSave(csrfProtect(mux))
 
 	fmt.Printf("Server starting on http://localhost:%s\n", local_port)
 	// Use the handler wrapped with middleware
@@ -10,15 +10,33 @@ type rowQuerier interface {
 	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
+// querier interface for functions that also need multi-row queries (satisfied by *sql.DB and *sql.Tx)
+type querier interface {
+	rowQuerier
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// execer interface for functions that only need to execute statements
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryExecer interface for functions that both read and write (satisfied by *sql.DB and *sql.Tx)
+type queryExecer interface {
+	querier
+	execer
+}
+
 // RaceInfo stores details about a single race.
 type RaceInfo struct {
 	Id              int
 	Name            string
 	Winner          string        // Name of the winning chicken
 	WinnerChickenID sql.NullInt64 // ID of the winning chicken from DB (can be NULL)
-	ChickenNames    []string      // Names of chickens participating (can be dynamic later)
+	ChickenNames    []string      // Names of chickens entered in the race (from race_entrants)
 	Date            time.Time     // Scheduled Start Time
-	Status          string        // 'Scheduled', 'Running', 'Finished'
+	Status          string        // 'Scheduled', 'Running', 'Finished', 'Cancelled'
+	CancelReason    string        // Why the race was cancelled, if it was
 }
 
 // Chicken represents a participant in a race.
@@ -26,9 +44,18 @@ type Chicken struct {
 	ID       int
 	Name     string
 	Color    string
-	Odds     float64
-	Lane     int
+	Odds     float64 // Starting price when loaded from race_entrants; the simulation draws the result from it
+	Lane     int     // 1-based lane number, see LaneTop for the track position
 	Progress float64
+
+	CurrentOdds float64 // Live fixed-odds win price from the odds engine (see race_book.go)
+	PlaceOdds   float64 // Fixed price for finishing in the top 2
+	ShowOdds    float64 // Fixed price for finishing in the top 3
+
+	// Racing attributes used by the simulation (see race_simulation.go)
+	Speed        float64 // Top speed in track units per second
+	Acceleration float64 // Track units per second squared
+	Stamina      float64 // Fraction of the track run before fatigue sets in
 }
 
 // ActiveRace holds information about the chickens in the currently active race (for display).
@@ -41,18 +68,26 @@ type User struct {
 	ID    int
 	Name  string
 	Email string
+	Role  string // RolePlayer, RoleModerator or RoleAdmin
 	Age   int
-	// Balance float64 // Consider adding Balance here if you fetch full user data often
+	// Balance Money // Consider adding Balance here if you fetch full user data often
 }
 
 // PageData is used to pass data to HTML templates.
 type PageData struct {
-	Title       string
-	UserData    User
-	UserBalance float64
-	Races       []RaceInfo // This is for the history list
-	Chickens    []Chicken  // This is availableChickens for betting selection
-	ActiveRace  ActiveRace // This is for displaying chickens on the track
+	Title               string
+	UserData            User
+	UserBalance         Money
+	Races               []RaceInfo               // This is for the history list
+	Market              *RaceMarket              // Betting market of the race open for betting (nil if none)
+	AccumulatorRaces    []AccumulatorRace        // Upcoming races accumulator legs can be picked from
+	BetHistory          *BetHistoryView          // The user's bets, for the My bets page
+	ResponsibleGambling *ResponsibleGamblingView // The user's limits, for the responsible gambling page
+	AdminDashboard      *AdminDashboard          // Race operations, for the admin dashboard
+	Stable              *StableView              // The chicken stable, for the admin stable page
+	AdminUsers          *AdminUsersView          // User search or the user being managed, for the user management console
+	Audit               *AuditView               // Audit events matching the filter, for the audit log page
+	ActiveRace          ActiveRace               // This is for displaying chickens on the track
 
 	InitialNextRaceTime    string
 	InitialStatusMessage   string
@@ -60,7 +95,7 @@ type PageData struct {
 	IsBettingInitiallyOpen bool
 	CurrentRaceDisplay     *RaceInfo // Details of the current/last race from race manager
 
-	PotentialWinnings float64
+	PotentialWinnings Money
 	Message           string // For bet responses, etc. (Good to have)
 	Success           bool   // For bet responses, etc. (Good to have)
 
@@ -71,21 +106,34 @@ type PageData struct {
 	WinnerID     int // Or string, if your Chicken IDs are strings. Must match type of Chicken.ID
 
 	IsLoggedIn      bool   // Useful for base template
+	IsAdmin         bool   // Shows the admin link in the base template
+	IsModerator     bool   // Moderators and admins; shows moderators the audit log link
 	CurrentUserName string // Useful for base template
 	CSRFToken       string // For CSRF protection
 }
 
 // WinningsCalc is used for calculating and displaying potential winnings.
 type WinningsCalc struct {
-	Amount    float64
-	ChickenID int
+	Amount    Money
+	Selection string // Chicken IDs of the bet, comma-separated in pick order
+	IsTote    bool   // Tote returns are only an estimate until the pool closes
+	Hint      string // Shown instead of an amount when the bet cannot be priced yet
+}
+
+// OpenBetsView is used for the open bets list, rendered by openBetsHandler and after a cancel or cash out.
+type OpenBetsView struct {
+	LoggedIn   bool
+	Bets       []*OpenBet
+	Message    string
+	Success    bool
+	NewBalance Money // -1 when the balance is not shown
 }
 
 // BetResponse is used for the HTMX response from placeBetHandler.
 type BetResponse struct {
 	Success     bool
 	Message     string
-	NewBalance  float64
-	BetAmount   float64
+	NewBalance  Money
+	BetAmount   Money
 	ChickenName string
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of credits held as integer cents, the minor unit stored in every money column.
+// Amounts are only ever added and subtracted as integers; multiplying by odds or rates goes through
+// MulOdds and MulRate, which round down to the cent so the house keeps the breakage.
+type Money int64
+
+// Credit is one whole credit.
+const Credit Money = 100
+
+var errInvalidMoney = errors.New("invalid amount")
+
+// maxParsedCredits is the most whole credits ParseMoney accepts, so that the cents and any two
+// decimals still fit in an int64.
+const maxParsedCredits = (math.MaxInt64 - 99) / int64(Credit)
+
+// Credits returns a whole number of credits.
+func Credits(n int64) Money {
+	return Money(n) * Credit
+}
+
+// MoneyFromFloat converts a credit amount from configuration or user-facing maths to Money,
+// rounding half away from zero to the nearest cent.
+func MoneyFromFloat(credits float64) Money {
+	return Money(math.Round(credits * float64(Credit)))
+}
+
+// ParseMoney parses a credit amount such as "12", "12.5" or "12.50". Amounts with more than two
+// decimals are rejected rather than rounded, so a stake is always exactly what the user typed.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, frac, _ := strings.Cut(s, ".")
+	if (whole == "" && frac == "") || len(frac) > 2 {
+		return 0, fmt.Errorf("%w: %q", errInvalidMoney, s)
+	}
+	var units int64
+	if whole != "" {
+		n, err := strconv.ParseUint(whole, 10, 64)
+		if err != nil || n > uint64(maxParsedCredits) {
+			return 0, fmt.Errorf("%w: %q", errInvalidMoney, s)
+		}
+		units = int64(n) * int64(Credit)
+	}
+	if frac != "" {
+		for len(frac) < 2 {
+			frac += "0"
+		}
+		n, err := strconv.ParseUint(frac, 10, 8)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", errInvalidMoney, s)
+		}
+		units += int64(n)
+	}
+	if negative {
+		units = -units
+	}
+	return Money(units), nil
+}
+
+// Float returns the amount in credits, for pricing maths and ratios only.
+func (m Money) Float() float64 {
+	return float64(m) / float64(Credit)
+}
+
+// String formats the amount in credits with two decimals, e.g. "12.50".
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign, m = "-", -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, int64(m/Credit), int64(m%Credit))
+}
+
+// MarshalJSON writes the amount as a JSON number of credits with exactly two decimals, so exports
+// never show float noise such as 12.499999.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// MulOdds returns the amount multiplied by decimal odds, rounded down to the cent.
+// Odds are quoted to two decimals, so the product is computed exactly in hundredths.
+func (m Money) MulOdds(odds float64) Money {
+	hundredths := int64(math.Round(odds * oddsPrecision))
+	return Money(floorDiv(int64(m)*hundredths, oddsPrecision))
+}
+
+// MulRate returns the amount multiplied by an arbitrary rate, such as a cash-out ratio or a
+// percentage, rounded down to the cent. The epsilon stops exact products from flooring a cent low.
+func (m Money) MulRate(rate float64) Money {
+	return Money(math.Floor(float64(m)*rate + 1e-9))
+}
+
+// Min returns the smaller of two amounts.
+func (m Money) Min(other Money) Money {
+	if other < m {
+		return other
+	}
+	return m
+}
+
+// floorDiv divides rounding towards negative infinity, so debits round the same way as credits.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		ok   bool
+	}{
+		{"12", 1200, true},
+		{"12.5", 1250, true},
+		{"12.05", 1205, true},
+		{" 0.10 ", 10, true},
+		{".5", 50, true},
+		{"-3.25", -325, true},
+		{"12.345", 0, false},
+		{"1e3", 0, false},
+		{"abc", 0, false},
+		{"", 0, false},
+		{".", 0, false},
+		{"92233720368547757.99", math.MaxInt64 - 8, true},
+		{"92233720368547758", 0, false},
+		{"100000000000000000", 0, false}, // Used to wrap to a negative amount
+		{"184467440737095517", 0, false}, // Used to wrap to 0.84
+		{"-184467440737095517", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMoneyMulOdds(t *testing.T) {
+	tests := []struct {
+		stake Money
+		odds  float64
+		want  Money
+	}{
+		{1000, 2.5, 2500},
+		{333, 1.15, 382}, // 382.95 rounds down: the house keeps the breakage
+		{1, 1.99, 1},
+		{10000000, 2.55, 25500000}, // No float error at 2.55
+		{-333, 1.15, -383},
+	}
+	for _, tt := range tests {
+		if got := tt.stake.MulOdds(tt.odds); got != tt.want {
+			t.Errorf("%v.MulOdds(%v) = %v, want %v", tt.stake, tt.odds, got, tt.want)
+		}
+	}
+	if got := Money(-1205).String(); got != "-12.05" {
+		t.Errorf("Money(-1205).String() = %q, want -12.05", got)
+	}
+}
+
+func TestMoneyColumnsMigration(t *testing.T) {
+	// A database from before money moved from REAL credits to INTEGER cents.
+	old, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	old.SetMaxOpenConns(1)
+	for _, stmt := range []string{`
+        CREATE TABLE users (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            username TEXT NOT NULL UNIQUE,
+            balance REAL DEFAULT 0.0 NOT NULL
+        )`, `
+        CREATE TABLE bets (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            bet_amount REAL NOT NULL CHECK (bet_amount > 0),
+            potential_payout REAL,
+            actual_payout REAL DEFAULT 0,
+            FOREIGN KEY (user_id) REFERENCES users (id)
+        )`,
+		`CREATE INDEX idx_bets_user ON bets (user_id)`, `
+        CREATE TABLE wallet_transactions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            kind TEXT NOT NULL,
+            amount REAL NOT NULL,
+            balance_after REAL NOT NULL,
+            FOREIGN KEY (user_id) REFERENCES users (id)
+        )`, `
+        CREATE TRIGGER wallet_transactions_no_update BEFORE UPDATE ON wallet_transactions
+        BEGIN
+            SELECT RAISE(ABORT, 'wallet_transactions is append-only');
+        END`,
+		`INSERT INTO users (username, balance) VALUES ('Punter', 12.5)`,
+		`INSERT INTO bets (user_id, bet_amount, potential_payout) VALUES (1, 0.3, 0.75)`,
+		`INSERT INTO wallet_transactions (user_id, kind, amount, balance_after) VALUES (1, 'Opening', 12.8, 12.8), (1, 'Stake', -0.3, 12.5)`,
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := old.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := convertMoneyColumns(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if needed, err := moneyColumnsNotCents(old); err != nil || needed {
+		t.Errorf("money columns still need converting after the migration: %v, %v", needed, err)
+	}
+	for _, col := range requiredColumnTypes {
+		var colType string
+		if err := old.QueryRow("SELECT type FROM pragma_table_info(?) WHERE name = ?", col.table, col.column).Scan(&colType); err != nil || colType != col.columnType {
+			t.Errorf("%s.%s has type %q, %v; want %s", col.table, col.column, colType, err, col.columnType)
+		}
+	}
+
+	var balance, stake, payout, settled, amount, after Money
+	if err := old.QueryRow("SELECT balance FROM users WHERE id = 1").Scan(&balance); err != nil || balance != 1250 {
+		t.Errorf("balance = %d, %v; want 1250", balance, err)
+	}
+	if err := old.QueryRow("SELECT bet_amount, potential_payout, actual_payout FROM bets WHERE id = 1").Scan(&stake, &payout, &settled); err != nil || stake != 30 || payout != 75 || settled != 0 {
+		t.Errorf("bet = %d, %d, %d, %v; want 30, 75, 0", stake, payout, settled, err)
+	}
+	if err := old.QueryRow("SELECT amount, balance_after FROM wallet_transactions WHERE id = 2").Scan(&amount, &after); err != nil || amount != -30 || after != 1250 {
+		t.Errorf("wallet entry = %d, %d, %v; want -30, 1250", amount, after, err)
+	}
+
+	if _, err := old.Exec("INSERT INTO bets (user_id, bet_amount) VALUES (1, 0)"); err == nil {
+		t.Error("the rebuilt bets table lost its CHECK constraint")
+	}
+	var indexes int
+	if err := old.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_bets_user'").Scan(&indexes); err != nil || indexes != 1 {
+		t.Errorf("idx_bets_user was not recreated: %d, %v", indexes, err)
+	}
+	if _, err := old.Exec("UPDATE wallet_transactions SET amount = 0"); err == nil {
+		t.Error("the rebuilt wallet_transactions table accepted an update")
+	}
+}
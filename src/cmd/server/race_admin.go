@@ -0,0 +1,310 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// maxRescheduleDelay is how far ahead a race can be rescheduled. scheduleNewRace cancels races scheduled
+// more than 10 minutes out as stale, so this stays under that.
+const maxRescheduleDelay = 9 * time.Minute
+
+var (
+	errRaceNotScheduled    = errors.New("race is not scheduled")
+	errRaceNotRunning      = errors.New("race is not the one running")
+	errRaceAlreadyRunning  = errors.New("another race is already running")
+	errRaceNotNext         = errors.New("race is not the next one due")
+	errInvalidRescheduling = errors.New("invalid start time")
+	errUnknownBetMode      = errors.New("unknown bet mode")
+	errBetModeLocked       = errors.New("race already has bets, so its bet mode cannot change")
+)
+
+// AdminEntrant is a chicken in a race on the admin dashboard, with the money riding on it.
+type AdminEntrant struct {
+	Chicken
+	Staked    Money   // Win money on the chicken
+	Liability Money   // The most the house loses if it wins; negative is a profit. Zero for tote races.
+	PoolShare float64 // Percent of the race's win money on the chicken
+}
+
+// AdminRace is a scheduled or running race on the admin dashboard.
+type AdminRace struct {
+	RaceInfo
+	BetMode         string
+	PendingBets     int   // Pending single bets on the race
+	PendingLegs     int   // Pending accumulator legs on the race
+	TotalStaked     Money // Win money taken on the race
+	WorstLiability  Money // The largest liability across the field
+	LiabilityCap    Money
+	Entrants        []AdminEntrant
+	IsCurrent       bool // The race the race loop is running
+	IsNext          bool // The earliest scheduled race, the only one that can be started
+	StartsInSeconds int  // Seconds until a scheduled race starts; 0 once due
+}
+
+// IsTote reports whether the race is settled from a pool rather than at fixed odds.
+func (r AdminRace) IsTote() bool {
+	return r.BetMode == BetModeTote
+}
+
+// AdminDashboard is the state of the race system shown on the admin dashboard.
+type AdminDashboard struct {
+	Current            *RaceInfo // The running or just-finished race the race loop knows about; nil for none
+	NextRaceStartTime  time.Time // Zero when no race is due
+	Races              []AdminRace
+	MaxRescheduleDelay int // Seconds
+	Now                time.Time
+}
+
+// getAdminDashboard loads the races that are scheduled or running, with their pending bets and liabilities.
+func getAdminDashboard(q querier, now time.Time) (*AdminDashboard, error) {
+	raceMutex.Lock()
+	dash := &AdminDashboard{NextRaceStartTime: nextRaceStartTime, Now: now, MaxRescheduleDelay: int(maxRescheduleDelay.Seconds())}
+	if currentRaceDetails != nil {
+		current := *currentRaceDetails
+		dash.Current = &current
+	}
+	raceMutex.Unlock()
+
+	rows, err := q.Query("SELECT id, name, date, status, bet_mode FROM races WHERE status IN (?, ?) ORDER BY date ASC, id ASC",
+		RaceStatusRunning, RaceStatusScheduled)
+	if err != nil {
+		return nil, fmt.Errorf("error querying active races: %w", err)
+	}
+	var races []AdminRace
+	for rows.Next() {
+		var race AdminRace
+		var date string
+		if err := rows.Scan(&race.Id, &race.Name, &date, &race.Status, &race.BetMode); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning active race: %w", err)
+		}
+		if race.Date, err = parseRaceDate(date); err != nil {
+			log.Printf("getAdminDashboard: Error parsing date '%s' of race %d: %v", date, race.Id, err)
+		}
+		races = append(races, race)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating active races: %w", err)
+	}
+
+	nextFound := false
+	for i := range races {
+		race := &races[i]
+		race.IsCurrent = dash.Current != nil && dash.Current.Id == race.Id && race.Status == RaceStatusRunning
+		if race.Status == RaceStatusScheduled && !nextFound {
+			race.IsNext, nextFound = true, true
+		}
+		if race.Status == RaceStatusScheduled && race.Date.After(now) {
+			race.StartsInSeconds = int(race.Date.Sub(now).Seconds())
+		}
+		if err := loadAdminRaceBook(q, race); err != nil {
+			return nil, err
+		}
+	}
+	dash.Races = races
+	return dash, nil
+}
+
+// loadAdminRaceBook fills in a race's pending bet counts, field and the money on each chicken.
+func loadAdminRaceBook(q querier, race *AdminRace) error {
+	err := q.QueryRow(`
+        SELECT COUNT(*) FROM bets b JOIN bet_statuses s ON b.bet_status_id = s.id
+        WHERE b.race_id = ? AND b.bet_type != 'Accumulator' AND s.status_name = 'Pending'
+    `, race.Id).Scan(&race.PendingBets)
+	if err != nil {
+		return fmt.Errorf("error counting pending bets of race %d: %w", race.Id, err)
+	}
+	err = q.QueryRow("SELECT COUNT(*) FROM bet_legs WHERE race_id = ? AND status = 'Pending'", race.Id).Scan(&race.PendingLegs)
+	if err != nil {
+		return fmt.Errorf("error counting pending accumulator legs of race %d: %w", race.Id, err)
+	}
+
+	entrants, err := getRaceEntrants(q, race.Id)
+	if err != nil {
+		return err
+	}
+	book, err := getRaceBook(q, race.Id)
+	if err != nil {
+		return err
+	}
+	race.TotalStaked = book.TotalStake
+	if !race.IsTote() {
+		race.LiabilityCap = maxRaceLiability
+	}
+	for i, ch := range entrants {
+		e := AdminEntrant{Chicken: ch, Staked: book.Stakes[ch.ID]}
+		if book.TotalStake > 0 {
+			e.PoolShare = 100 * e.Staked.Float() / book.TotalStake.Float()
+		}
+		if !race.IsTote() {
+			e.Liability = book.Liability(ch.ID)
+			if i == 0 || e.Liability > race.WorstLiability {
+				race.WorstLiability = e.Liability
+			}
+		}
+		race.Entrants = append(race.Entrants, e)
+	}
+	return nil
+}
+
+// adminStartRace starts a scheduled race now, ahead of its scheduled time. Races run in order, so only the
+// next one due can be started; the ones ahead of any other have to be cancelled or rescheduled first.
+func adminStartRace(db *sql.DB, raceID int) error {
+	raceMutex.Lock()
+	if currentRaceDetails != nil && currentRaceDetails.Status == RaceStatusRunning {
+		raceMutex.Unlock()
+		return errRaceAlreadyRunning
+	}
+	var status string
+	var nextID sql.NullInt64
+	err := db.QueryRow(`
+        SELECT status, (SELECT id FROM races WHERE status = ? ORDER BY date ASC, id ASC LIMIT 1)
+        FROM races WHERE id = ?
+    `, RaceStatusScheduled, raceID).Scan(&status, &nextID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) || (err == nil && status != RaceStatusScheduled):
+		err = errRaceNotScheduled
+	case err != nil:
+		err = fmt.Errorf("error checking race %d is next: %w", raceID, err)
+	case nextID.Int64 != int64(raceID):
+		err = errRaceNotNext
+	}
+	var raceName string
+	if err == nil {
+		if raceName, err = markRaceRunning(db, raceID); err != nil {
+			err = fmt.Errorf("%w: %v", errRaceNotScheduled, err)
+		}
+	}
+	raceMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	launchRace(db, raceID, raceName)
+	return nil
+}
+
+// adminFinishRace finishes the running race now instead of when its timer fires, and settles its bets.
+func adminFinishRace(db *sql.DB, raceID int) error {
+	raceMutex.Lock()
+	running := currentRaceDetails != nil && currentRaceDetails.Id == raceID && currentRaceDetails.Status == RaceStatusRunning
+	if running && raceEndTimer != nil {
+		raceEndTimer.Stop()
+	}
+	raceMutex.Unlock()
+	if !running {
+		return errRaceNotRunning
+	}
+	return finishRace(db, raceID)
+}
+
+// adminCancelRace cancels a scheduled or running race and refunds its bets. If it was the running race,
+// the race loop moves on to the next one.
+func adminCancelRace(db *sql.DB, raceID int, reason string) error {
+	raceMutex.Lock()
+	err := cancelRaceByID(db, raceID, reason)
+	wasCurrent := err == nil && currentRaceDetails != nil && currentRaceDetails.Id == raceID
+	if wasCurrent {
+		if raceEndTimer != nil {
+			raceEndTimer.Stop()
+		}
+		currentRaceDetails = nil
+	}
+	if err == nil {
+		// Let the race loop pick the next race to run from the database.
+		nextRaceStartTime = time.Time{}
+	}
+	raceMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if wasCurrent {
+		raceAnimationMutex.Lock()
+		if currentRaceAnimation != nil && currentRaceAnimation.RaceID == raceID {
+			currentRaceAnimation = nil
+		}
+		raceAnimationMutex.Unlock()
+	}
+	if raceTicker != nil {
+		raceTicker.Reset(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// adminRescheduleRace moves a scheduled race to start at startAt, which must be in the next
+// maxRescheduleDelay, and runs it in betMode (unchanged when empty). The bet mode can only change while
+// nobody has bet on the race. The race loop's next start time follows the earliest scheduled race.
+func adminRescheduleRace(db *sql.DB, raceID int, startAt time.Time, betMode string, now time.Time) error {
+	if !startAt.After(now) || startAt.Sub(now) > maxRescheduleDelay {
+		return errInvalidRescheduling
+	}
+	takeout, ok := betModeTakeout(betMode)
+	if betMode != "" && !ok {
+		return errUnknownBetMode
+	}
+	raceMutex.Lock()
+	defer raceMutex.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning rescheduling of race %d: %w", raceID, err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	result, err := tx.Exec("UPDATE races SET date = ? WHERE id = ? AND status = ?", startAt.Format(time.RFC3339), raceID, RaceStatusScheduled)
+	if err != nil {
+		return fmt.Errorf("error rescheduling race %d: %w", raceID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errRaceNotScheduled
+	}
+	if betMode != "" {
+		if err := setRaceBetMode(tx, raceID, betMode, takeout); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing rescheduling of race %d: %w", raceID, err)
+	}
+
+	var first string
+	if err := db.QueryRow("SELECT date FROM races WHERE status = ? ORDER BY date ASC, id ASC LIMIT 1", RaceStatusScheduled).Scan(&first); err != nil {
+		return fmt.Errorf("error finding the next race after rescheduling race %d: %w", raceID, err)
+	}
+	if currentRaceDetails == nil || currentRaceDetails.Status != RaceStatusRunning {
+		if nextRaceStartTime, err = parseRaceDate(first); err != nil {
+			return fmt.Errorf("error parsing date of the next race: %w", err)
+		}
+	}
+	return nil
+}
+
+// setRaceBetMode switches a race to betMode with its takeout, or returns errBetModeLocked if the race is in
+// another mode and already has bets or accumulator legs, which were struck under that mode.
+func setRaceBetMode(ex queryExecer, raceID int, betMode string, takeout float64) error {
+	result, err := ex.Exec(`
+        UPDATE races SET bet_mode = ?, takeout = ?
+        WHERE id = ? AND bet_mode != ?
+          AND NOT EXISTS (SELECT 1 FROM bets WHERE race_id = ?)
+          AND NOT EXISTS (SELECT 1 FROM bet_legs WHERE race_id = ?)
+    `, betMode, takeout, raceID, betMode, raceID, raceID)
+	if err != nil {
+		return fmt.Errorf("error setting bet mode of race %d: %w", raceID, err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+	var current string
+	if err := ex.QueryRow("SELECT bet_mode FROM races WHERE id = ?", raceID).Scan(&current); err != nil {
+		return fmt.Errorf("error checking bet mode of race %d: %w", raceID, err)
+	}
+	if current != betMode {
+		return errBetModeLocked
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// resetRaceState clears the race loop's state for a test, and stops any race the test started and
+// restores the state afterwards.
+func resetRaceState(t *testing.T) {
+	t.Helper()
+	prevRace, prevStart := currentRaceDetails, nextRaceStartTime
+	currentRaceDetails, nextRaceStartTime = nil, time.Time{}
+	t.Cleanup(func() {
+		if raceEndTimer != nil {
+			raceEndTimer.Stop()
+		}
+		raceAnimationMutex.Lock()
+		currentRaceAnimation = nil
+		raceAnimationMutex.Unlock()
+		currentRaceDetails, nextRaceStartTime = prevRace, prevStart
+	})
+}
+
+// raceStatus returns the status of a race.
+func raceStatus(t *testing.T, raceID int) string {
+	t.Helper()
+	status, _ := raceCancellation(t, raceID)
+	return status
+}
+
+// findAdminRace returns a race on the dashboard.
+func findAdminRace(t *testing.T, dash *AdminDashboard, raceID int) AdminRace {
+	t.Helper()
+	for _, race := range dash.Races {
+		if race.Id == raceID {
+			return race
+		}
+	}
+	t.Fatalf("race %d is not on the dashboard", raceID)
+	return AdminRace{}
+}
+
+func TestAdminStartRace(t *testing.T) {
+	setupTestDB(t)
+	resetRaceState(t)
+	later := scheduleTestRace(t, "Later Stakes") // Due after the sample race
+
+	for _, tc := range []struct {
+		name   string
+		raceID int
+		want   error
+	}{
+		{"race behind the next one", later, errRaceNotNext},
+		{"finished race", 1, errRaceNotScheduled},
+		{"unknown race", 999, errRaceNotScheduled},
+	} {
+		if err := adminStartRace(db, tc.raceID); !errors.Is(err, tc.want) {
+			t.Errorf("starting the %s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	if status := raceStatus(t, later); status != RaceStatusScheduled {
+		t.Errorf("refused race is %s, want %s", status, RaceStatusScheduled)
+	}
+
+	nextRaceStartTime = time.Now().Add(time.Minute)
+	if err := adminStartRace(db, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	if status := raceStatus(t, scheduledTestRace); status != RaceStatusRunning {
+		t.Errorf("started race is %s, want %s", status, RaceStatusRunning)
+	}
+	if currentRaceDetails == nil || currentRaceDetails.Id != scheduledTestRace || currentRaceDetails.Status != RaceStatusRunning {
+		t.Errorf("race loop's current race is %+v, want race %d running", currentRaceDetails, scheduledTestRace)
+	}
+	if !nextRaceStartTime.IsZero() {
+		t.Errorf("next start time is %v while a race runs, want none", nextRaceStartTime)
+	}
+
+	// Now next in line, but a race is running.
+	if err := adminStartRace(db, later); !errors.Is(err, errRaceAlreadyRunning) {
+		t.Errorf("starting a race while another runs: got %v, want errRaceAlreadyRunning", err)
+	}
+}
+
+func TestAdminFinishRace(t *testing.T) {
+	setupTestDB(t)
+	resetRaceState(t)
+	later := scheduleTestRace(t, "Later Stakes")
+
+	if err := adminFinishRace(db, scheduledTestRace); !errors.Is(err, errRaceNotRunning) {
+		t.Errorf("finishing a scheduled race: got %v, want errRaceNotRunning", err)
+	}
+	if err := adminStartRace(db, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	if err := adminFinishRace(db, later); !errors.Is(err, errRaceNotRunning) {
+		t.Errorf("finishing a race other than the running one: got %v, want errRaceNotRunning", err)
+	}
+
+	if err := adminFinishRace(db, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	if status := raceStatus(t, scheduledTestRace); status != RaceStatusFinished {
+		t.Errorf("finished race is %s, want %s", status, RaceStatusFinished)
+	}
+	if status, _ := betOutcome(t, 3); status == "Pending" {
+		t.Error("the sample bet on the finished race is still Pending")
+	}
+	if positions, err := getRaceResult(db, scheduledTestRace); err != nil || len(positions) == 0 {
+		t.Errorf("finished race has result %v, %v; want its finishing order", positions, err)
+	}
+	checkLedgerBalanced(t)
+
+	if err := adminFinishRace(db, scheduledTestRace); !errors.Is(err, errRaceNotRunning) {
+		t.Errorf("finishing a finished race: got %v, want errRaceNotRunning", err)
+	}
+}
+
+func TestAdminCancelRace(t *testing.T) {
+	setupTestDB(t)
+	resetRaceState(t)
+	later := scheduleTestRace(t, "Later Stakes")
+	betID := placeTestBet(t, 2, later, BetTypeWin, []int{1}, 50*Credit, 2.5)
+
+	// Cancelling the current race stops it and lets the race loop move on.
+	if err := adminStartRace(db, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	if err := adminCancelRace(db, scheduledTestRace, "Fox on the track"); err != nil {
+		t.Fatal(err)
+	}
+	if status, reason := raceCancellation(t, scheduledTestRace); status != RaceStatusCancelled || reason != "Fox on the track" {
+		t.Errorf("current race is %s (%q), want %s (%q)", status, reason, RaceStatusCancelled, "Fox on the track")
+	}
+	if currentRaceDetails != nil {
+		t.Errorf("race loop's current race is %+v after cancelling it, want none", currentRaceDetails)
+	}
+	raceAnimationMutex.Lock()
+	animation := currentRaceAnimation
+	raceAnimationMutex.Unlock()
+	if animation != nil {
+		t.Error("the cancelled race is still animated")
+	}
+	checkRefunded(t, 3, 100*Credit)
+
+	// A scheduled race can be cancelled too; a settled one cannot.
+	if err := adminCancelRace(db, later, "Rain"); err != nil {
+		t.Fatal(err)
+	}
+	checkRefunded(t, betID, 50*Credit)
+	if err := adminCancelRace(db, 1, "Too late"); !errors.Is(err, errRaceNotCancellable) {
+		t.Errorf("cancelling a finished race: got %v, want errRaceNotCancellable", err)
+	}
+	checkLedgerBalanced(t)
+}
+
+func TestAdminRescheduleRace(t *testing.T) {
+	setupTestDB(t)
+	resetRaceState(t)
+	later := scheduleTestRace(t, "Later Stakes")
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name    string
+		raceID  int
+		startAt time.Time
+		want    error
+	}{
+		{"past maxRescheduleDelay", later, now.Add(maxRescheduleDelay + time.Second), errInvalidRescheduling},
+		{"in the past", later, now.Add(-time.Minute), errInvalidRescheduling},
+		{"finished race", 1, now.Add(time.Minute), errRaceNotScheduled},
+	} {
+		if err := adminRescheduleRace(db, tc.raceID, tc.startAt, "", now); !errors.Is(err, tc.want) {
+			t.Errorf("rescheduling to %s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// Moving the sample race behind the later one makes that the next race due.
+	soon := now.Add(2 * time.Minute).Truncate(time.Second)
+	if err := adminRescheduleRace(db, later, soon, "", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := adminRescheduleRace(db, scheduledTestRace, soon.Add(time.Minute), "", now); err != nil {
+		t.Fatal(err)
+	}
+	if !nextRaceStartTime.Equal(soon) {
+		t.Errorf("next start time is %v, want %v", nextRaceStartTime, soon)
+	}
+	dash, err := getAdminDashboard(db, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !findAdminRace(t, dash, later).IsNext || findAdminRace(t, dash, scheduledTestRace).IsNext {
+		t.Error("the rescheduled race is not the next one due on the dashboard")
+	}
+	if err := adminStartRace(db, scheduledTestRace); !errors.Is(err, errRaceNotNext) {
+		t.Errorf("starting the race moved back: got %v, want errRaceNotNext", err)
+	}
+	if err := adminStartRace(db, later); err != nil {
+		t.Fatal(err)
+	}
+
+	// The race loop's next start time is left alone while a race runs.
+	if err := adminRescheduleRace(db, scheduledTestRace, now.Add(time.Minute), "", now); err != nil {
+		t.Fatal(err)
+	}
+	if !nextRaceStartTime.IsZero() {
+		t.Errorf("next start time is %v while a race runs, want none", nextRaceStartTime)
+	}
+}
+
+// raceBetMode returns a race's bet mode, takeout and start time.
+func raceBetMode(t *testing.T, raceID int) (string, float64, string) {
+	t.Helper()
+	var mode, date string
+	var takeout float64
+	if err := db.QueryRow("SELECT bet_mode, takeout, date FROM races WHERE id = ?", raceID).Scan(&mode, &takeout, &date); err != nil {
+		t.Fatal(err)
+	}
+	return mode, takeout, date
+}
+
+func TestAdminRescheduleSetsBetMode(t *testing.T) {
+	setupTestDB(t)
+	resetRaceState(t)
+	unbacked := scheduleTestRace(t, "Quiet Stakes")
+	legOnly := scheduleTestRace(t, "Second Leg Stakes") // Only the second leg of an accumulator runs in it
+	placeTestAccumulator(t, 2, 10*Credit,
+		AccumulatorLeg{RaceID: scheduleTestRace(t, "First Leg Stakes"), ChickenID: 1, Odds: 2.5}, AccumulatorLeg{RaceID: legOnly, ChickenID: 2, Odds: 1.8})
+	now := time.Now()
+	startAt := now.Add(time.Minute)
+
+	for _, mode := range []string{BetModeTote, BetModeFixedOdds} {
+		if err := adminRescheduleRace(db, unbacked, startAt, mode, now); err != nil {
+			t.Fatalf("switching to %s: %v", mode, err)
+		}
+		wantTakeout, _ := betModeTakeout(mode)
+		if got, takeout, _ := raceBetMode(t, unbacked); got != mode || takeout != wantTakeout {
+			t.Errorf("race without bets is %s with takeout %.2f, want %s with %.2f", got, takeout, mode, wantTakeout)
+		}
+	}
+
+	// Bets and accumulator legs were struck under the race's mode, which then stays.
+	for _, raceID := range []int{scheduledTestRace, legOnly} {
+		_, _, before := raceBetMode(t, raceID)
+		if err := adminRescheduleRace(db, raceID, startAt, BetModeTote, now); !errors.Is(err, errBetModeLocked) {
+			t.Errorf("switching race %d with bets: got %v, want errBetModeLocked", raceID, err)
+		}
+		if mode, takeout, date := raceBetMode(t, raceID); mode != BetModeFixedOdds || takeout != 0 || date != before {
+			t.Errorf("refused race %d is %s with takeout %.2f starting %s, want it unchanged", raceID, mode, takeout, date)
+		}
+		if err := adminRescheduleRace(db, raceID, startAt, BetModeFixedOdds, now); err != nil {
+			t.Errorf("rescheduling race %d in its own mode: %v", raceID, err)
+		}
+	}
+
+	if err := adminRescheduleRace(db, unbacked, startAt, "Spread", now); !errors.Is(err, errUnknownBetMode) {
+		t.Errorf("switching to an unknown mode: got %v, want errUnknownBetMode", err)
+	}
+}
+
+func TestAdminDashboardFigures(t *testing.T) {
+	setupTestDB(t)
+	resetRaceState(t)
+	race := scheduleTestRace(t, "Busy Stakes")
+	placeTestBet(t, 2, race, BetTypeWin, []int{1}, 50*Credit, 2.5) // Pays 125 if Henrietta wins
+	placeTestBet(t, 2, race, BetTypeWin, []int{2}, 20*Credit, 1.8) // Pays 36 if Cluck Norris wins
+	for _, status := range []string{"Cancelled", BetStatusCashedOut} {
+		betID := placeTestBet(t, 1, race, BetTypeWin, []int{1}, 40*Credit, 2.5)
+		if _, err := db.Exec("UPDATE bets SET bet_status_id = (SELECT id FROM bet_statuses WHERE status_name = ?) WHERE id = ?", status, betID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	placeTestAccumulator(t, 1, 20*Credit, // First leg pays 50 on to the second if Henrietta wins
+		AccumulatorLeg{RaceID: race, ChickenID: 1, Odds: 2.5}, AccumulatorLeg{RaceID: scheduleTestRace(t, "Second Leg Stakes"), ChickenID: 2, Odds: 1.8})
+
+	// The sample race is a tote race with the sample bet of 100 on Cluck Norris and 300 on Henrietta.
+	if _, err := db.Exec("UPDATE races SET bet_mode = ?, takeout = 0.15 WHERE id = ?", BetModeTote, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	addToteTestBet(t, 2, 1, 300*Credit, "Pending")
+
+	dash, err := getAdminDashboard(db, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy := findAdminRace(t, dash, race)
+	if busy.PendingBets != 2 || busy.PendingLegs != 1 {
+		t.Errorf("pending bets = %d + %d legs, want 2 + 1", busy.PendingBets, busy.PendingLegs)
+	}
+	if busy.TotalStaked != 70*Credit {
+		t.Errorf("win money = %s, want 70.00", busy.TotalStaked)
+	}
+	// The house has taken 90 on bets still live: it pays out 175 if Henrietta wins and 36 if Cluck Norris does.
+	wantLiability := map[int]Money{1: 85 * Credit, 2: -54 * Credit, 3: -90 * Credit, 4: -90 * Credit, 5: -90 * Credit}
+	for _, e := range busy.Entrants {
+		if e.Liability != wantLiability[e.ID] {
+			t.Errorf("liability on chicken %d = %s, want %s", e.ID, e.Liability, wantLiability[e.ID])
+		}
+	}
+	if busy.WorstLiability != 85*Credit || busy.LiabilityCap != maxRaceLiability {
+		t.Errorf("worst liability = %s of %s, want 85.00 of %s", busy.WorstLiability, busy.LiabilityCap, maxRaceLiability)
+	}
+	if share := busy.Entrants[0].PoolShare; math.Abs(share-100*50.0/70) > 1e-9 {
+		t.Errorf("Henrietta's share of the win money = %.2f%%, want 71.43%%", share)
+	}
+
+	tote := findAdminRace(t, dash, scheduledTestRace)
+	if !tote.IsTote() || tote.TotalStaked != 400*Credit || tote.WorstLiability != 0 || tote.LiabilityCap != 0 {
+		t.Errorf("tote race: staked %s, worst liability %s of %s; want 400.00 and no liability", tote.TotalStaked, tote.WorstLiability, tote.LiabilityCap)
+	}
+	for _, e := range tote.Entrants {
+		wantShare := map[int]float64{1: 75, 2: 25}[e.ID]
+		if e.Liability != 0 || math.Abs(e.PoolShare-wantShare) > 1e-9 {
+			t.Errorf("tote chicken %d: liability %s, share %.2f%%; want none and %.0f%%", e.ID, e.Liability, e.PoolShare, wantShare)
+		}
+	}
+	if !tote.IsNext || busy.IsNext || tote.IsCurrent {
+		t.Errorf("next race due: tote race %v, busy race %v; want only the tote race, not running", tote.IsNext, busy.IsNext)
+	}
+
+	// Once the sample race runs, the dashboard shows it as the race loop's and the busy race as next.
+	if err := adminStartRace(db, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	if dash, err = getAdminDashboard(db, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if dash.Current == nil || dash.Current.Id != scheduledTestRace || !findAdminRace(t, dash, scheduledTestRace).IsCurrent {
+		t.Errorf("dashboard's current race is %+v, want race %d", dash.Current, scheduledTestRace)
+	}
+	if !findAdminRace(t, dash, race).IsNext {
+		t.Error("the busy race is not next once the sample race runs")
+	}
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	_ "encoding/json"
-	_ "log"
-	"math/rand"
+	"html/template"
+	"log"
 	"net/http"
 	"strconv"
 	"sync"
@@ -20,16 +20,17 @@ type ChickenPosition struct {
 	IsWinner bool    `json:"isWinner"`
 }
 
-// RaceAnimationState tracks the state of the current race animation
+// RaceAnimationState tracks the state of the current race animation.
+// Positions are replayed from the precomputed simulation, so the animation always matches the result.
 type RaceAnimationState struct {
-	RaceID        int               `json:"raceId"`
-	RaceName      string            `json:"raceName"`
-	IsRunning     bool              `json:"isRunning"`
-	StartTime     time.Time         `json:"startTime"`
-	EndTime       time.Time         `json:"endTime"`
-	WinnerID      int               `json:"winnerId"`
-	Chickens      []ChickenPosition `json:"chickens"`
-	ProgressMutex sync.Mutex
+	RaceID     int               `json:"raceId"`
+	RaceName   string            `json:"raceName"`
+	IsRunning  bool              `json:"isRunning"`
+	StartTime  time.Time         `json:"startTime"`
+	EndTime    time.Time         `json:"endTime"`
+	WinnerID   int               `json:"winnerId"`
+	Chickens   []ChickenPosition `json:"chickens"`
+	Simulation *RaceSimulation   `json:"-"`
 }
 
 // Global race animation state
@@ -42,10 +43,20 @@ var (
 func initRaceAnimation(raceID int, raceName string) {
 	raceAnimationMutex.Lock()
 	defer raceAnimationMutex.Unlock()
+	initRaceAnimationLocked(raceID, raceName)
+}
+
+// initRaceAnimationLocked does the work of initRaceAnimation; the caller must hold raceAnimationMutex.
+func initRaceAnimationLocked(raceID int, raceName string) {
+	sim, err := loadRaceSimulation(db, raceID)
+	if err != nil {
+		log.Printf("initRaceAnimation: Error simulating race %d: %v", raceID, err)
+		sim = &RaceSimulation{}
+	}
 
-	// Create chicken positions based on available chickens
-	chickenPositions := make([]ChickenPosition, len(availableChickens))
-	for i, chicken := range availableChickens {
+	// Create chicken positions based on the race's entrants
+	chickenPositions := make([]ChickenPosition, len(sim.Entrants))
+	for i, chicken := range sim.Entrants {
 		chickenPositions[i] = ChickenPosition{
 			ID:       chicken.ID,
 			Name:     chicken.Name,
@@ -58,87 +69,57 @@ func initRaceAnimation(raceID int, raceName string) {
 
 	// Initialize race animation state
 	currentRaceAnimation = &RaceAnimationState{
-		RaceID:    raceID,
-		RaceName:  raceName,
-		IsRunning: true,
-		StartTime: time.Now(),
-		EndTime:   time.Now().Add(raceDuration),
-		WinnerID:  0,
-		Chickens:  chickenPositions,
+		RaceID:     raceID,
+		RaceName:   raceName,
+		IsRunning:  true,
+		StartTime:  time.Now(),
+		EndTime:    time.Now().Add(raceDuration),
+		WinnerID:   0,
+		Chickens:   chickenPositions,
+		Simulation: sim,
 	}
-
-	// Start the animation update goroutine
-	go updateRaceAnimation()
 }
 
-// updateRaceAnimation periodically updates chicken positions during a race
-func updateRaceAnimation() {
-	// Update positions every 100ms
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			raceAnimationMutex.Lock()
-			if currentRaceAnimation == nil || !currentRaceAnimation.IsRunning {
-				raceAnimationMutex.Unlock()
-				return
-			}
-
-			// Calculate race progress percentage (0-100%)
-			totalDuration := currentRaceAnimation.EndTime.Sub(currentRaceAnimation.StartTime)
-			elapsed := time.Since(currentRaceAnimation.StartTime)
-			raceProgress := float64(elapsed) / float64(totalDuration)
-
-			// Check if race is finished
-			if raceProgress >= 1.0 {
-				currentRaceAnimation.IsRunning = false
-				raceAnimationMutex.Unlock()
-				return
-			}
-
-			// Update each chicken's position
-			currentRaceAnimation.ProgressMutex.Lock()
-			for i := range currentRaceAnimation.Chickens {
-				// Each chicken has a slightly different speed
-				speedFactor := 0.8 + (rand.Float64() * 0.4) // 0.8-1.2 speed factor
-
-				// Calculate new progress based on race progress and chicken's speed
-				newProgress := raceProgress * 90 * speedFactor // Max progress is 90%
+// replayRaceAnimationLocked moves the chickens to where the simulation has them at the given time.
+// The caller must hold raceAnimationMutex.
+func replayRaceAnimationLocked(now time.Time) {
+	anim := currentRaceAnimation
+	if anim == nil || !anim.IsRunning || anim.Simulation == nil {
+		return
+	}
 
-				// Ensure progress doesn't exceed 90% (finish line)
-				if newProgress > 90 {
-					newProgress = 90
-				}
+	totalDuration := anim.EndTime.Sub(anim.StartTime)
+	fraction := 1.0
+	if totalDuration > 0 {
+		fraction = float64(now.Sub(anim.StartTime)) / float64(totalDuration)
+	}
 
-				currentRaceAnimation.Chickens[i].Progress = newProgress
-			}
-			currentRaceAnimation.ProgressMutex.Unlock()
-			raceAnimationMutex.Unlock()
+	distances := anim.Simulation.DistancesAt(fraction)
+	for i := range anim.Chickens {
+		if i < len(distances) {
+			anim.Chickens[i].Progress = distanceToTrackPercent(distances[i])
 		}
 	}
 }
 
-// finishRaceAnimation marks the race as finished and sets the winner
-func finishRaceAnimation(winnerID int) {
+// finishRaceAnimation marks the race as finished, shows the final positions and sets the winner
+func finishRaceAnimation(raceID int, sim *RaceSimulation) {
 	raceAnimationMutex.Lock()
 	defer raceAnimationMutex.Unlock()
 
-	if currentRaceAnimation == nil {
+	if currentRaceAnimation == nil || currentRaceAnimation.RaceID != raceID {
 		return
 	}
 
 	currentRaceAnimation.IsRunning = false
-	currentRaceAnimation.WinnerID = winnerID
+	currentRaceAnimation.WinnerID = sim.WinnerID()
 
-	// Mark the winner
+	final := sim.DistancesAt(1)
 	for i := range currentRaceAnimation.Chickens {
-		if currentRaceAnimation.Chickens[i].ID == winnerID {
-			currentRaceAnimation.Chickens[i].IsWinner = true
-			// Ensure winner is at the finish line
-			currentRaceAnimation.Chickens[i].Progress = 90
+		if i < len(final) {
+			currentRaceAnimation.Chickens[i].Progress = distanceToTrackPercent(final[i])
 		}
+		currentRaceAnimation.Chickens[i].IsWinner = currentRaceAnimation.Chickens[i].ID == currentRaceAnimation.WinnerID
 	}
 }
 
@@ -157,7 +138,7 @@ func raceUpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 		if raceDetails != nil && raceDetails.Status == RaceStatusRunning {
 			// Race is running but animation not initialized - initialize it
-			initRaceAnimation(raceDetails.Id, raceDetails.Name)
+			initRaceAnimationLocked(raceDetails.Id, raceDetails.Name)
 		} else {
 			// No race running
 			w.Write([]byte(`<div class="race-placeholder">Waiting for next race to start...</div>`))
@@ -167,7 +148,7 @@ func raceUpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 	// If we have a race animation, render the chickens
 	if currentRaceAnimation != nil {
-		currentRaceAnimation.ProgressMutex.Lock()
+		replayRaceAnimationLocked(time.Now())
 
 		// Generate HTML for each chicken
 		html := ""
@@ -184,12 +165,13 @@ func raceUpdateHandler(w http.ResponseWriter, r *http.Request) {
 				winnerClass = `class="chicken"`
 			}
 
-			html += `<div id="chicken-` + string(chicken.ID) + `" ` + winnerClass + ` ` + winnerAttr + ` style="top: ` + string(chicken.Lane) + `%; left: ` + strconv.FormatFloat(chicken.Progress, 'f', -1, 64) + `%; transition: left 0.5s ease-in-out;">
+			name := template.HTMLEscapeString(chicken.Name)
+			html += `<div id="chicken-` + strconv.Itoa(chicken.ID) + `" ` + winnerClass + ` ` + winnerAttr + ` data-chicken-id="` + strconv.Itoa(chicken.ID) + `" data-chicken-name="` + name + `" style="top: ` + strconv.Itoa(laneTopPercent(chicken.Lane)) + `%; left: ` + strconv.FormatFloat(chicken.Progress, 'f', 2, 64) + `%; transition: left 0.5s linear;">
 				` + winnerCrown + `
-				<div class="chicken-body" style="background-color: ` + chicken.Color + `"></div>
+				<div class="chicken-body" style="background-color: ` + template.HTMLEscapeString(chicken.Color) + `"></div>
 				<div class="chicken-wing"></div>
 				<div class="chicken-beak"></div>
-				<span class="chicken-name">` + chicken.Name + `</span>
+				<span class="chicken-name">` + name + `</span>
 			</div>`
 		}
 
@@ -201,7 +183,6 @@ func raceUpdateHandler(w http.ResponseWriter, r *http.Request) {
 			<div class="track-lane" style="top: 80%"></div>
 		`
 
-		currentRaceAnimation.ProgressMutex.Unlock()
 		w.Write([]byte(html))
 	}
 }
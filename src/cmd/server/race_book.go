@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// oddsBookDepth is the total staked on a race at which the money in the book weighs as much as the
+// starting price when pricing. Small books barely move the odds; large books dominate them.
+const oddsBookDepth = 500 * Credit
+
+// maxRaceLiability caps what the house can lose on a single race, whichever chicken wins.
+var maxRaceLiability = MoneyFromFloat(getEnvFloatOrDefault("MAX_RACE_LIABILITY", 5000))
+
+// errLiabilityCap is returned when accepting a bet would take the house over maxRaceLiability.
+var errLiabilityCap = errors.New("bet exceeds the liability the house can take on this race")
+
+// bookPlaces is how deep a finish the liability cap looks at: no bet type pays beyond third place.
+const bookPlaces = 3
+
+// RaceBook is the fixed-odds money taken on a race. Win money moves the win prices; every live
+// fixed-odds bet counts towards the liability cap.
+type RaceBook struct {
+	TotalStake Money         // Staked on win bets
+	Stakes     map[int]Money // Win money per chicken ID
+	Payouts    map[int]Money // Win payouts (stake included) if that chicken wins
+	Runners    []int         // Chicken IDs in the field
+	Bets       []bookBet     // Every live fixed-odds bet and accumulator leg the cap covers, win bets included
+
+	outcomes []bookOutcome // Filled on first use by bookOutcomes
+}
+
+// bookBet is a live fixed-odds bet as the liability cap sees it.
+type bookBet struct {
+	BetType   string
+	Selection []int
+	Stake     Money
+	Payout    Money // Paid out (stake included) if the selection comes in
+}
+
+// bookOutcome is one ordered top-three finish and what the house loses on it.
+type bookOutcome struct {
+	Positions map[int]int
+	Liability Money
+}
+
+// bookOutcomes works out the house's liability on every ordered top-three finish of the field:
+// the payouts of the bets that come in, less all the money taken.
+func (b *RaceBook) bookOutcomes() []bookOutcome {
+	if b.outcomes != nil {
+		return b.outcomes
+	}
+	var taken Money
+	for _, bet := range b.Bets {
+		taken += bet.Stake
+	}
+	places := min(bookPlaces, len(b.Runners))
+	var walk func(positions map[int]int)
+	walk = func(positions map[int]int) {
+		if len(positions) == places {
+			o := bookOutcome{Positions: make(map[int]int, places), Liability: -taken}
+			for chickenID, position := range positions {
+				o.Positions[chickenID] = position
+			}
+			for _, bet := range b.Bets {
+				if selectionWins(bet.BetType, bet.Selection, o.Positions) {
+					o.Liability += bet.Payout
+				}
+			}
+			b.outcomes = append(b.outcomes, o)
+			return
+		}
+		for _, chickenID := range b.Runners {
+			if _, placed := positions[chickenID]; !placed {
+				positions[chickenID] = len(positions) + 1
+				walk(positions)
+				delete(positions, chickenID)
+			}
+		}
+	}
+	walk(make(map[int]int, places))
+	return b.outcomes
+}
+
+// worstLiability returns the most the house can lose on a finish in which the selection comes in,
+// and false if the selection cannot come in at all.
+func (b *RaceBook) worstLiability(betType string, selection []int) (Money, bool) {
+	var worst Money
+	found := false
+	for _, o := range b.bookOutcomes() {
+		if selectionWins(betType, selection, o.Positions) && (!found || o.Liability > worst) {
+			worst, found = o.Liability, true
+		}
+	}
+	return worst, found
+}
+
+// Liability returns the most the house loses if the chicken wins: the payouts owed on the worst such
+// finish less all the money taken. A negative liability is a profit.
+func (b *RaceBook) Liability(chickenID int) Money {
+	liability, _ := b.worstLiability(BetTypeWin, []int{chickenID})
+	return liability
+}
+
+// checkLiability rejects a bet that would take the house over the liability cap on any finish the bet comes in on.
+func (b *RaceBook) checkLiability(betType string, selection []int, stake Money, odds float64, limit Money) error {
+	worst, ok := b.worstLiability(betType, selection)
+	if ok && worst+fixedOddsPayout(stake, odds)-stake > limit {
+		return errLiabilityCap
+	}
+	return nil
+}
+
+// maxAcceptedStake is the largest stake the liability cap allows on a selection at the given odds.
+func (b *RaceBook) maxAcceptedStake(betType string, selection []int, odds float64, limit Money) Money {
+	worst, _ := b.worstLiability(betType, selection)
+	return maxStakeForLiability(limit-worst, odds)
+}
+
+// maxStakeForLiability is the largest stake at the given odds whose winnings fit in headroom, rounded down to the cent.
+func maxStakeForLiability(headroom Money, odds float64) Money {
+	if headroom <= 0 {
+		return 0
+	}
+	// Winnings are floor(stake * (hundredths - 100) / 100), exactly as fixedOddsPayout rounds them.
+	profit := int64(math.Round(odds*oddsPrecision)) - oddsPrecision
+	if profit <= 0 {
+		return math.MaxInt64
+	}
+	return Money((int64(headroom+1)*oddsPrecision - 1) / profit)
+}
+
+// getRaceBook loads the field and the live fixed-odds bets of a race, combination bets and pending
+// accumulator legs included. Cancelled and cashed-out bets have been paid back and are left out.
+func getRaceBook(q querier, raceID int) (*RaceBook, error) {
+	return loadRaceBook(q, raceID, 0)
+}
+
+// loadRaceBook loads the book of a race as getRaceBook does, leaving out the bets of excludeUserID (none when 0).
+func loadRaceBook(q querier, raceID int, excludeUserID int) (*RaceBook, error) {
+	book := &RaceBook{Stakes: make(map[int]Money), Payouts: make(map[int]Money)}
+	entrants, err := getRaceEntrants(q, raceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ch := range entrants {
+		book.Runners = append(book.Runners, ch.ID)
+	}
+	selections, err := getRaceBetSelections(q, raceID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+        SELECT b.id, b.chicken_id, b.bet_type, b.bet_amount, COALESCE(b.potential_payout, 0)
+        FROM bets b
+        JOIN bet_statuses s ON b.bet_status_id = s.id
+        WHERE b.race_id = ? AND b.bet_type != ? AND b.user_id != ? AND s.status_name NOT IN ('Cancelled', 'Cashed Out')
+    `, raceID, BetTypeAccumulator, excludeUserID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying book for race %d: %w", raceID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var betID, chickenID int
+		var bet bookBet
+		if err := rows.Scan(&betID, &chickenID, &bet.BetType, &bet.Stake, &bet.Payout); err != nil {
+			return nil, fmt.Errorf("error scanning book for race %d: %w", raceID, err)
+		}
+		bet.Selection = selections[betID]
+		if len(bet.Selection) == 0 {
+			bet.Selection = []int{chickenID} // Placed before selections were recorded
+		}
+		book.Bets = append(book.Bets, bet)
+		if bet.BetType == BetTypeWin {
+			book.Stakes[chickenID] += bet.Stake
+			book.Payouts[chickenID] += bet.Payout
+			book.TotalStake += bet.Stake
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating book for race %d: %w", raceID, err)
+	}
+	legs, err := getLegBets(q, raceID, excludeUserID)
+	if err != nil {
+		return nil, err
+	}
+	book.Bets = append(book.Bets, legs...)
+	return book, nil
+}
+
+// repriceField works out live prices for a field from its starting prices and the book.
+// The starting-price probabilities are blended with each chicken's share of the money, so heavily
+// backed chickens shorten and the rest drift. Drift stops at the fair price of the starting-price
+// probability, so no price ever offers the punter an edge. A chicken whose liability nears the cap is
+// shortened further, down to minOdds once the cap is reached.
+func repriceField(entrants []Chicken, book *RaceBook, margin float64, limit Money) []float64 {
+	probs := winProbabilities(entrants)
+	weight := 0.0
+	if book.TotalStake > 0 {
+		weight = book.TotalStake.Float() / (book.TotalStake + oddsBookDepth).Float()
+	}
+
+	prices := make([]float64, len(entrants))
+	for i, ch := range entrants {
+		p := probs[i]
+		if weight > 0 {
+			p = (1-weight)*p + weight*book.Stakes[ch.ID].Float()/book.TotalStake.Float()
+		}
+		price := math.Min(priceFromProbability(p, margin), priceFromProbability(probs[i], 0))
+
+		if liability := book.Liability(ch.ID); liability > 0 && limit > 0 {
+			headroom := math.Max(0, 1-liability.Float()/limit.Float())
+			price = math.Floor((1+(price-1)*headroom)*oddsPrecision) / oddsPrecision
+		}
+		prices[i] = math.Max(price, minOdds)
+	}
+	return prices
+}
+
+// winPriceWithout is the live win price of a chicken as the book would have it without a user's own bets.
+// Cash-out offers are valued at it, so nobody can shorten a price with their own money and cash out against it.
+func winPriceWithout(q querier, raceID, chickenID, userID int) (float64, error) {
+	entrants, err := getRaceEntrants(q, raceID)
+	if err != nil {
+		return 0, err
+	}
+	book, err := loadRaceBook(q, raceID, userID)
+	if err != nil {
+		return 0, err
+	}
+	prices := repriceField(entrants, book, houseMargin, maxRaceLiability)
+	for i, ch := range entrants {
+		if ch.ID == chickenID {
+			return prices[i], nil
+		}
+	}
+	return 0, errEntrantNotFound
+}
+
+// updateCurrentOdds reprices a race's field from its book and stores the new live prices.
+// It runs in the same transaction as the bet that moved the book.
+func updateCurrentOdds(q queryExecer, raceID int) error {
+	entrants, err := getRaceEntrants(q, raceID)
+	if err != nil {
+		return err
+	}
+	book, err := getRaceBook(q, raceID)
+	if err != nil {
+		return err
+	}
+	prices := repriceField(entrants, book, houseMargin, maxRaceLiability)
+	for i, ch := range entrants {
+		_, err := q.Exec("UPDATE race_entrants SET current_odds = ? WHERE race_id = ? AND chicken_id = ?", prices[i], raceID, ch.ID)
+		if err != nil {
+			return fmt.Errorf("error updating odds of chicken %d in race %d: %w", ch.ID, raceID, err)
+		}
+	}
+	return nil
+}
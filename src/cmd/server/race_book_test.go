@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// testBook builds the book of a field from live fixed-odds bets, as getRaceBook does.
+func testBook(field []Chicken, bets ...bookBet) *RaceBook {
+	book := &RaceBook{Stakes: map[int]Money{}, Payouts: map[int]Money{}, Bets: bets}
+	for _, ch := range field {
+		book.Runners = append(book.Runners, ch.ID)
+	}
+	for _, bet := range bets {
+		if bet.BetType == BetTypeWin {
+			book.Stakes[bet.Selection[0]] += bet.Stake
+			book.Payouts[bet.Selection[0]] += bet.Payout
+			book.TotalStake += bet.Stake
+		}
+	}
+	return book
+}
+
+func TestRepriceFieldFollowsTheMoney(t *testing.T) {
+	field := testField()
+	priceField(field, houseMargin)
+	opening := repriceField(field, testBook(field), houseMargin, 0)
+	fair := winProbabilities(field)
+
+	// Chicken 4, the outsider, takes all the money.
+	const backed = 3
+	book := testBook(field, bookBet{BetType: BetTypeWin, Selection: []int{field[backed].ID}, Stake: 400 * Credit, Payout: fixedOddsPayout(400*Credit, field[backed].Odds)})
+	prices := repriceField(field, book, houseMargin, 0)
+
+	if prices[backed] >= opening[backed] {
+		t.Errorf("backed chicken priced at %.2f, want shorter than its opening %.2f", prices[backed], opening[backed])
+	}
+	for i := range field {
+		if i == backed {
+			continue
+		}
+		if prices[i] < opening[i] {
+			t.Errorf("chicken %d shortened from %.2f to %.2f without being backed", field[i].ID, opening[i], prices[i])
+		}
+		if limit := priceFromProbability(fair[i], 0); prices[i] > limit {
+			t.Errorf("chicken %d drifted to %.2f, past its fair price %.2f", field[i].ID, prices[i], limit)
+		}
+	}
+}
+
+func TestRepriceFieldLiabilityCap(t *testing.T) {
+	const limit = 1000 * Credit
+	field := testField()
+	priceField(field, houseMargin)
+	const backed = 1
+	uncapped := repriceField(field, testBook(field), houseMargin, limit)[backed]
+
+	for _, tc := range []struct {
+		name      string
+		liability Money
+	}{
+		{"no liability", 0},
+		{"half the cap", limit / 2},
+		{"just under the cap", limit - Credit},
+		{"at the cap", limit},
+		{"over the cap", 2 * limit},
+	} {
+		// A book with nothing on the other chickens and the given liability on the backed one.
+		book := testBook(field, bookBet{BetType: BetTypeWin, Selection: []int{field[backed].ID}, Payout: tc.liability})
+		prices := repriceField(field, book, houseMargin, limit)
+		for i, p := range prices {
+			if p < minOdds {
+				t.Errorf("%s: chicken %d priced at %.2f, below the minimum of %.2f", tc.name, field[i].ID, p, minOdds)
+			}
+		}
+		price := prices[backed]
+		switch {
+		case tc.liability == 0 && price != uncapped:
+			t.Errorf("%s: priced at %.2f, want the uncapped %.2f", tc.name, price, uncapped)
+		case tc.liability > 0 && tc.liability < limit && price >= uncapped:
+			t.Errorf("%s: priced at %.2f, want shorter than the uncapped %.2f", tc.name, price, uncapped)
+		case tc.liability >= limit && price != minOdds:
+			t.Errorf("%s: priced at %.2f, want the minimum %.2f", tc.name, price, minOdds)
+		}
+
+		// The largest stake accepted at that price keeps the house within the cap; a credit more does not.
+		maxStake := book.maxAcceptedStake(BetTypeWin, []int{field[backed].ID}, price, limit)
+		if err := book.checkLiability(BetTypeWin, []int{field[backed].ID}, maxStake, price, limit); maxStake > 0 && err != nil {
+			t.Errorf("%s: the largest accepted stake %s was refused: %v", tc.name, maxStake, err)
+		}
+		if err := book.checkLiability(BetTypeWin, []int{field[backed].ID}, maxStake+Credit, price, limit); err != errLiabilityCap {
+			t.Errorf("%s: a stake of %s at %.2f was accepted past the cap", tc.name, maxStake+Credit, price)
+		}
+	}
+}
+
+func TestLiabilityCapCoversPlaceAndShow(t *testing.T) {
+	book := &RaceBook{
+		Runners: []int{1, 2, 3, 4},
+		Bets:    []bookBet{{BetType: BetTypePlace, Selection: []int{1}, Stake: 50 * Credit, Payout: 100 * Credit}},
+	}
+	const limit = 100 * Credit
+
+	// Chickens 1 and 2 can both place, so a place bet on 2 shares the exposure of the bet on 1.
+	if err := book.checkLiability(BetTypePlace, []int{2}, 50*Credit, 2.0, limit); err != nil {
+		t.Errorf("place bet up to the cap refused: %v", err)
+	}
+	if err := book.checkLiability(BetTypePlace, []int{2}, 51*Credit, 2.0, limit); !errors.Is(err, errLiabilityCap) {
+		t.Errorf("place bet over the cap: got %v, want errLiabilityCap", err)
+	}
+	if max := book.maxAcceptedStake(BetTypePlace, []int{2}, 2.0, limit); max != 50*Credit {
+		t.Errorf("maxAcceptedStake = %s, want 50.00", max)
+	}
+	if err := book.checkLiability(BetTypeShow, []int{3}, 51*Credit, 2.0, limit); !errors.Is(err, errLiabilityCap) {
+		t.Errorf("show bet over the cap: got %v, want errLiabilityCap", err)
+	}
+	if got := book.Liability(1); got != 50*Credit {
+		t.Errorf("Liability(1) = %s, want 50.00", got)
+	}
+}
+
+func TestMarketOffersPlaceAndShowOnLargerFields(t *testing.T) {
+	for _, tc := range []struct {
+		runners int
+		place   bool
+		show    bool
+	}{
+		{2, false, false},
+		{3, true, false},
+		{4, true, true},
+	} {
+		m := &RaceMarket{BetMode: BetModeFixedOdds}
+		for i := range tc.runners {
+			m.Entrants = append(m.Entrants, MarketEntrant{Chicken: Chicken{ID: i + 1, Odds: 3}})
+		}
+		if got := m.Offers(BetTypePlace); got != tc.place {
+			t.Errorf("%d runners: Offers(Place) = %v, want %v", tc.runners, got, tc.place)
+		}
+		if got := m.Offers(BetTypeShow); got != tc.show {
+			t.Errorf("%d runners: Offers(Show) = %v, want %v", tc.runners, got, tc.show)
+		}
+		if _, err := m.OddsFor(BetTypeShow, []int{1}); tc.show == (err != nil) {
+			t.Errorf("%d runners: OddsFor(Show) error = %v", tc.runners, err)
+		}
+	}
+}
+
+func TestLiabilityCapCoversCombinationBets(t *testing.T) {
+	book := &RaceBook{
+		Runners: []int{1, 2, 3, 4, 5},
+		Bets: []bookBet{
+			{BetType: BetTypeExacta, Selection: []int{1, 2}, Stake: 10 * Credit, Payout: 60 * Credit},
+			{BetType: BetTypeWin, Selection: []int{1}, Stake: 20 * Credit, Payout: 50 * Credit},
+		},
+	}
+	const limit = 100 * Credit
+
+	// 1 then 2 pays both bets: 110 out, 30 in.
+	if got, _ := book.worstLiability(BetTypeQuinella, []int{2, 1}); got != 80*Credit {
+		t.Errorf("worst liability of a 1-2 quinella = %s, want 80.00", got)
+	}
+	// The quinella also comes in on 2 then 1, but the 1-2 finish is the worst.
+	if err := book.checkLiability(BetTypeQuinella, []int{2, 1}, 6*Credit, 5.0, limit); !errors.Is(err, errLiabilityCap) {
+		t.Errorf("quinella over the cap: got %v, want errLiabilityCap", err)
+	}
+	if max := book.maxAcceptedStake(BetTypeQuinella, []int{2, 1}, 5.0, limit); max != 5*Credit {
+		t.Errorf("maxAcceptedStake = %s, want 5.00", max)
+	}
+	// An exacta the other way round never pays alongside the 1-2 exacta or the win bet on 1.
+	if err := book.checkLiability(BetTypeExacta, []int{2, 1}, 20*Credit, 5.0, limit); err != nil {
+		t.Errorf("2-1 exacta refused: %v", err)
+	}
+	if err := book.checkLiability(BetTypeTrifecta, []int{1, 2, 3}, 2*Credit, 50.0, limit); !errors.Is(err, errLiabilityCap) {
+		t.Errorf("trifecta over the cap: got %v, want errLiabilityCap", err)
+	}
+}
+
+// strikeTestBet places a fixed-odds bet on the sample Scheduled race at the live price and reprices
+// the race, as placeBetHandler does, and returns the bet's ID and price.
+func strikeTestBet(t *testing.T, userID, chickenID int, stake Money) (int, float64) {
+	t.Helper()
+	ch, err := findRaceEntrant(db, scheduledTestRace, chickenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.Exec("INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id, odds, potential_payout) VALUES (?, ?, ?, ?, (SELECT id FROM bet_statuses WHERE status_name = 'Pending'), ?, ?)",
+		userID, scheduledTestRace, chickenID, stake, ch.CurrentOdds, fixedOddsPayout(stake, ch.CurrentOdds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateCurrentOdds(db, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id), ch.CurrentOdds
+}
+
+func TestStruckPriceSurvivesRepricing(t *testing.T) {
+	setupTestDB(t)
+	first, struck := strikeTestBet(t, 1, 1, 300*Credit)
+	_, second := strikeTestBet(t, 2, 1, 300*Credit)
+	now, err := findRaceEntrant(db, scheduledTestRace, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second >= struck || now.CurrentOdds >= second {
+		t.Fatalf("chicken 1 priced %.2f, %.2f, then %.2f; want it to shorten as it is backed", struck, second, now.CurrentOdds)
+	}
+
+	var odds float64
+	var potential Money
+	if err := db.QueryRow("SELECT odds, potential_payout FROM bets WHERE id = ?", first).Scan(&odds, &potential); err != nil {
+		t.Fatal(err)
+	}
+	want := fixedOddsPayout(300*Credit, struck)
+	if odds != struck || potential != want {
+		t.Errorf("first bet holds odds %.2f paying %s, want the struck %.2f paying %s", odds, potential, struck, want)
+	}
+
+	settleTestRace(t, scheduledTestRace, 1, 2, 3, 4, 5)
+	if _, paid := betOutcome(t, first); paid != want {
+		t.Errorf("first bet paid %s, want %s at the struck price", paid, want)
+	}
+}
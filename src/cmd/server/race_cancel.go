@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// errRaceNotCancellable is returned when cancelling a race that has already finished or been cancelled.
+var errRaceNotCancellable = errors.New("only scheduled or running races can be cancelled")
+
+// cancelRace moves a scheduled or running race to 'Cancelled', recording why, and voids everything
+// riding on it: each pending bet is refunded in full and marked 'Cancelled', and each accumulator
+// with a leg in the race is voided. It returns how many bets were refunded.
+func cancelRace(tx *sql.Tx, raceID int, reason string) (int, error) {
+	var previousStatus string
+	if err := tx.QueryRow("SELECT status FROM races WHERE id = ?", raceID).Scan(&previousStatus); errors.Is(err, sql.ErrNoRows) {
+		return 0, errRaceNotCancellable
+	} else if err != nil {
+		return 0, fmt.Errorf("error loading status of race %d: %w", raceID, err)
+	}
+	res, err := tx.Exec("UPDATE races SET status = ?, winner_chicken_id = NULL, cancel_reason = ? WHERE id = ? AND status IN (?, ?)",
+		RaceStatusCancelled, reason, raceID, RaceStatusScheduled, RaceStatusRunning)
+	if err != nil {
+		return 0, fmt.Errorf("error cancelling race %d: %w", raceID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, errRaceNotCancellable
+	}
+
+	pendingStatusID, err := getPendingBetStatusID(tx)
+	if err != nil {
+		return 0, err
+	}
+	cancelledStatusID, err := getBetStatusID(tx, "Cancelled")
+	if err != nil {
+		return 0, err
+	}
+
+	type refund struct {
+		betID, userID int
+		amount        Money
+	}
+	rows, err := tx.Query("SELECT id, user_id, bet_amount FROM bets WHERE race_id = ? AND bet_status_id = ? AND bet_type != ?",
+		raceID, pendingStatusID, BetTypeAccumulator)
+	if err != nil {
+		return 0, fmt.Errorf("error querying pending bets for race %d: %w", raceID, err)
+	}
+	var refunds []refund
+	for rows.Next() {
+		var r refund
+		if err := rows.Scan(&r.betID, &r.userID, &r.amount); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning pending bet for race %d: %w", raceID, err)
+		}
+		refunds = append(refunds, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating pending bets for race %d: %w", raceID, err)
+	}
+
+	for _, r := range refunds {
+		if _, err := postWalletTransaction(tx, WalletTransaction{
+			UserID: r.userID, Kind: WalletTxRefund, Amount: r.amount, BetID: r.betID, RaceID: raceID, Note: "Race cancelled",
+		}); err != nil {
+			return 0, fmt.Errorf("failed to refund user %d for bet %d: %w", r.userID, r.betID, err)
+		}
+		if _, err := tx.Exec("UPDATE bets SET bet_status_id = ?, settled_at = CURRENT_TIMESTAMP, actual_payout = ? WHERE id = ?", cancelledStatusID, r.amount, r.betID); err != nil {
+			return 0, fmt.Errorf("failed to update status for bet %d: %w", r.betID, err)
+		}
+		log.Printf("cancelRace: Bet %d (User %d) on race %d cancelled. Refunded %s.", r.betID, r.userID, raceID, r.amount)
+	}
+
+	voided, err := voidRaceAccumulators(tx, raceID)
+	if err != nil {
+		return 0, err
+	}
+
+	event := newAuditEvent(systemActor, AuditRaceCancelled, "race", raceID)
+	event.Before = auditValue(map[string]string{"status": previousStatus})
+	event.After = auditValue(map[string]interface{}{"status": RaceStatusCancelled, "bets_refunded": len(refunds), "accumulators_voided": voided})
+	event.Reason = reason
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		return 0, err
+	}
+	return len(refunds) + voided, nil
+}
+
+// cancelRaceByID cancels a race in its own transaction. Callers that track the race in memory
+// (currentRaceDetails, the animation, the end timer) must update that state themselves.
+func cancelRaceByID(db *sql.DB, raceID int, reason string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // No-op once committed
+
+	refunded, err := cancelRace(tx, raceID, reason)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Race ID %d cancelled (%s). %d bets refunded.", raceID, reason, refunded)
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// raceCancellation returns a race's status and the reason it was cancelled, if it was.
+func raceCancellation(t *testing.T, raceID int) (string, string) {
+	t.Helper()
+	var status, reason string
+	if err := db.QueryRow("SELECT status, COALESCE(cancel_reason, '') FROM races WHERE id = ?", raceID).Scan(&status, &reason); err != nil {
+		t.Fatalf("loading race %d: %v", raceID, err)
+	}
+	return status, reason
+}
+
+// checkRefunded checks that a bet was cancelled and its whole stake paid back through a Refund ledger entry.
+func checkRefunded(t *testing.T, betID int, stake Money) {
+	t.Helper()
+	if status, paid := betOutcome(t, betID); status != "Cancelled" || paid != stake {
+		t.Errorf("bet %d is %s paying %s, want Cancelled refunding %s", betID, status, paid, stake)
+	}
+	var refunded Money
+	err := db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM wallet_transactions WHERE bet_id = ? AND kind = ?", betID, WalletTxRefund).Scan(&refunded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded != stake {
+		t.Errorf("bet %d has %s of Refund ledger entries, want %s", betID, refunded, stake)
+	}
+}
+
+func TestCancelRaceRefundsEveryBet(t *testing.T) {
+	setupTestDB(t)
+	const sampleBet = 3 // User 1's 100 on chicken 2
+	win := placeTestBet(t, 2, scheduledTestRace, BetTypeWin, []int{1}, 50*Credit, 2.5)
+	place := placeTestBet(t, 2, scheduledTestRace, BetTypePlace, []int{3}, 30*Credit, 1.8)
+	cancelled := addToteTestBet(t, 1, 3, 200*Credit, "Cancelled") // Refunded already; must not be paid twice
+	acca := placeTestAccumulator(t, 2, 20*Credit,
+		AccumulatorLeg{RaceID: scheduledTestRace, ChickenID: 1, Odds: 2.5}, AccumulatorLeg{RaceID: scheduleTestRace(t, "Second Leg Stakes"), ChickenID: 2, Odds: 1.8})
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	refunded, err := cancelRace(tx, scheduledTestRace, "Track flooded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if refunded != 4 {
+		t.Errorf("refunded %d bets, want 4", refunded)
+	}
+	if status, reason := raceCancellation(t, scheduledTestRace); status != RaceStatusCancelled || reason != "Track flooded" {
+		t.Errorf("race is %s (%q), want %s (%q)", status, reason, RaceStatusCancelled, "Track flooded")
+	}
+	checkRefunded(t, sampleBet, 100*Credit)
+	checkRefunded(t, win, 50*Credit)
+	checkRefunded(t, place, 30*Credit)
+	checkRefunded(t, acca, 20*Credit)
+	if _, paid := betOutcome(t, cancelled); paid != 0 {
+		t.Errorf("already-cancelled bet paid %s again", paid)
+	}
+	checkBalance(t, 1, (1000+100)*Credit)
+	checkBalance(t, 2, 1000*Credit)
+	checkLedgerBalanced(t)
+
+	if err := cancelRaceByID(db, scheduledTestRace, "Again"); !errors.Is(err, errRaceNotCancellable) {
+		t.Errorf("cancelling a cancelled race: got %v, want errRaceNotCancellable", err)
+	}
+	if err := cancelRaceByID(db, 1, "Too late"); !errors.Is(err, errRaceNotCancellable) {
+		t.Errorf("cancelling a finished race: got %v, want errRaceNotCancellable", err)
+	}
+}
+
+func TestScheduleNewRaceCancelsStaleRunningRace(t *testing.T) {
+	setupTestDB(t)
+	prevRace, prevStart := currentRaceDetails, nextRaceStartTime
+	t.Cleanup(func() { currentRaceDetails, nextRaceStartTime = prevRace, prevStart })
+	currentRaceDetails = nil
+
+	betID := placeTestBet(t, 2, scheduledTestRace, BetTypeWin, []int{1}, 50*Credit, 2.5)
+	if _, err := db.Exec("UPDATE races SET status = ? WHERE id = ?", RaceStatusRunning, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := scheduleNewRace(db); err != nil {
+		t.Fatal(err)
+	}
+	// The race was never finished, so it has no result: it is cancelled, not marked Finished.
+	status, reason := raceCancellation(t, scheduledTestRace)
+	if status != RaceStatusCancelled || !strings.HasPrefix(reason, "Stale") {
+		t.Errorf("stale running race is %s (%q), want %s with a stale reason", status, reason, RaceStatusCancelled)
+	}
+	checkRefunded(t, 3, 100*Credit)
+	checkRefunded(t, betID, 50*Credit)
+	checkBalance(t, 1, (1000+100)*Credit)
+	checkBalance(t, 2, 1000*Credit)
+	checkLedgerBalanced(t)
+}
+
+func TestCleanupCancelsFarFutureRaces(t *testing.T) {
+	setupTestDB(t)
+	farRace := scheduleTestRace(t, "Someday Stakes")
+	if _, err := db.Exec("UPDATE races SET date = ? WHERE id = ?", time.Now().Add(5000*time.Hour).Format(time.RFC3339), farRace); err != nil {
+		t.Fatal(err)
+	}
+	betID := placeTestBet(t, 2, farRace, BetTypeWin, []int{1}, 40*Credit, 2.5)
+
+	if err := cleanupStaleScheduledRaces(db); err != nil {
+		t.Fatal(err)
+	}
+	// The race is kept, cancelled with its reason, rather than deleted along with its bets.
+	status, reason := raceCancellation(t, farRace)
+	if status != RaceStatusCancelled || !strings.HasPrefix(reason, "Stale: scheduled more than") {
+		t.Errorf("far-future race is %s (%q), want %s with a stale reason", status, reason, RaceStatusCancelled)
+	}
+	checkRefunded(t, betID, 40*Credit)
+	checkBalance(t, 2, 1000*Credit)
+	checkLedgerBalanced(t)
+	if status, _ := raceCancellation(t, scheduledTestRace); status != RaceStatusScheduled {
+		t.Errorf("race due soon is %s after cleanup, want it left %s", status, RaceStatusScheduled)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// maxEntrantsPerRace is the number of lanes on the track (see the track-lane dividers in races.gohtml).
+const maxEntrantsPerRace = 5
+
+// errEntrantNotFound is returned when a chicken is not entered in the requested race.
+var errEntrantNotFound = errors.New("chicken is not entered in this race")
+
+// laneTopPercent converts a 1-based lane number into the vertical track position used by the templates.
+func laneTopPercent(lane int) int {
+	laneHeight := 100 / maxEntrantsPerRace
+	return (lane-1)*laneHeight + laneHeight/2
+}
+
+// LaneTop returns the vertical track position (in %) of the chicken's lane.
+func (c Chicken) LaneTop() int {
+	return laneTopPercent(c.Lane)
+}
+
+// pickRaceEntrants draws a random field of chickens for a new race from the stable and assigns them lanes.
+// Retired chickens are left out.
+func pickRaceEntrants(q querier) ([]Chicken, error) {
+	rows, err := q.Query("SELECT id, name, color, odds, speed, acceleration, stamina FROM chickens WHERE retired_at IS NULL ORDER BY RANDOM() LIMIT ?", maxEntrantsPerRace)
+	if err != nil {
+		return nil, fmt.Errorf("error querying chickens for race field: %w", err)
+	}
+	defer rows.Close()
+
+	var entrants []Chicken
+	for rows.Next() {
+		var ch Chicken
+		if err := rows.Scan(&ch.ID, &ch.Name, &ch.Color, &ch.Odds, &ch.Speed, &ch.Acceleration, &ch.Stamina); err != nil {
+			return nil, fmt.Errorf("error scanning chicken for race field: %w", err)
+		}
+		ch.Lane = len(entrants) + 1
+		entrants = append(entrants, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chickens for race field: %w", err)
+	}
+	return entrants, nil
+}
+
+// insertRaceEntrants stores the field for a race. Odds are frozen as the starting price, which is also the first live price.
+func insertRaceEntrants(ex execer, raceID int, entrants []Chicken) error {
+	for _, ch := range entrants {
+		_, err := ex.Exec("INSERT INTO race_entrants (race_id, chicken_id, lane, color, starting_odds, current_odds, place_odds, show_odds) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			raceID, ch.ID, ch.Lane, ch.Color, ch.Odds, ch.Odds, ch.PlaceOdds, ch.ShowOdds)
+		if err != nil {
+			return fmt.Errorf("error entering chicken %d into race %d: %w", ch.ID, raceID, err)
+		}
+	}
+	return nil
+}
+
+// getRaceEntrants returns the chickens entered in a race, ordered by lane.
+func getRaceEntrants(q querier, raceID int) ([]Chicken, error) {
+	rows, err := q.Query(`
+        SELECT c.id, c.name, e.color, e.starting_odds, e.current_odds, e.place_odds, e.show_odds, e.lane, c.speed, c.acceleration, c.stamina
+        FROM race_entrants e
+        JOIN chickens c ON e.chicken_id = c.id
+        WHERE e.race_id = ?
+        ORDER BY e.lane ASC
+    `, raceID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying entrants for race %d: %w", raceID, err)
+	}
+	defer rows.Close()
+
+	var entrants []Chicken
+	for rows.Next() {
+		var ch Chicken
+		if err := rows.Scan(&ch.ID, &ch.Name, &ch.Color, &ch.Odds, &ch.CurrentOdds, &ch.PlaceOdds, &ch.ShowOdds, &ch.Lane, &ch.Speed, &ch.Acceleration, &ch.Stamina); err != nil {
+			return nil, fmt.Errorf("error scanning entrant for race %d: %w", raceID, err)
+		}
+		entrants = append(entrants, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating entrants for race %d: %w", raceID, err)
+	}
+	return entrants, nil
+}
+
+// findRaceEntrant looks up a single chicken in a race's field.
+func findRaceEntrant(q rowQuerier, raceID int, chickenID int) (Chicken, error) {
+	var ch Chicken
+	err := q.QueryRow(`
+        SELECT c.id, c.name, e.color, e.starting_odds, e.current_odds, e.place_odds, e.show_odds, e.lane
+        FROM race_entrants e
+        JOIN chickens c ON e.chicken_id = c.id
+        WHERE e.race_id = ? AND e.chicken_id = ?
+    `, raceID, chickenID).Scan(&ch.ID, &ch.Name, &ch.Color, &ch.Odds, &ch.CurrentOdds, &ch.PlaceOdds, &ch.ShowOdds, &ch.Lane)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Chicken{}, errEntrantNotFound
+		}
+		return Chicken{}, fmt.Errorf("error fetching entrant %d for race %d: %w", chickenID, raceID, err)
+	}
+	return ch, nil
+}
+
+// getEntrantNamesByRace returns the entrant names of every race, keyed by race ID, for the history list.
+func getEntrantNamesByRace(q querier) map[int][]string {
+	rows, err := q.Query(`
+        SELECT e.race_id, c.name
+        FROM race_entrants e
+        JOIN chickens c ON e.chicken_id = c.id
+        ORDER BY e.race_id, e.lane
+    `)
+	if err != nil {
+		log.Printf("getEntrantNamesByRace: Failed to query entrants: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	names := make(map[int][]string)
+	for rows.Next() {
+		var raceID int
+		var name string
+		if err := rows.Scan(&raceID, &name); err != nil {
+			log.Printf("getEntrantNamesByRace: Failed to scan row: %v", err)
+			continue
+		}
+		names[raceID] = append(names[raceID], name)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("getEntrantNamesByRace: Error iterating through rows: %v", err)
+	}
+	return names
+}
+
+// recordRaceResult stores the full finishing order of a race (chicken IDs, winner first).
+func recordRaceResult(ex execer, raceID int, finishOrder []int) error {
+	for i, chickenID := range finishOrder {
+		_, err := ex.Exec("INSERT INTO race_results (race_id, chicken_id, position) VALUES (?, ?, ?)", raceID, chickenID, i+1)
+		if err != nil {
+			return fmt.Errorf("error recording position %d of race %d: %w", i+1, raceID, err)
+		}
+	}
+	return nil
+}
+
+// getRaceResult returns the finishing position (1-based) of each chicken in a race, keyed by chicken ID.
+func getRaceResult(q querier, raceID int) (map[int]int, error) {
+	rows, err := q.Query("SELECT chicken_id, position FROM race_results WHERE race_id = ?", raceID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying result of race %d: %w", raceID, err)
+	}
+	defer rows.Close()
+
+	positions := make(map[int]int)
+	for rows.Next() {
+		var chickenID, position int
+		if err := rows.Scan(&chickenID, &position); err != nil {
+			return nil, fmt.Errorf("error scanning result of race %d: %w", raceID, err)
+		}
+		positions[chickenID] = position
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating result of race %d: %w", raceID, err)
+	}
+	return positions, nil
+}
+
+// loadRaceSimulation replays the simulation of a race from its stored seed and field.
+func loadRaceSimulation(q querier, raceID int) (*RaceSimulation, error) {
+	var seed int64
+	if err := q.QueryRow("SELECT sim_seed FROM races WHERE id = ?", raceID).Scan(&seed); err != nil {
+		return nil, fmt.Errorf("error fetching simulation seed for race %d: %w", raceID, err)
+	}
+	entrants, err := getRaceEntrants(q, raceID)
+	if err != nil {
+		return nil, err
+	}
+	return simulateRace(entrants, seed), nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+)
+
+// setupTestDB points db at a fresh in-memory database holding init_database.sql's schema and sample data.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	schema, err := os.ReadFile("../../internal/database/init_database.sql")
+	if err != nil {
+		t.Fatalf("reading schema: %v", err)
+	}
+	testDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	testDB.SetMaxOpenConns(1) // Every connection to :memory: is a separate database
+	if _, err := testDB.Exec(string(schema)); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	prevDB := db
+	db = testDB
+	t.Cleanup(func() {
+		testDB.Close()
+		db = prevDB
+	})
+}
+
+// createTestUser adds a user with the given balance and returns their ID.
+func createTestUser(t *testing.T, name, email string, balance Money) int {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO users (name, email, password_hash, date_of_birth, balance) VALUES (?, ?, 'x', '1990-01-01', 0)", name, email)
+	if err != nil {
+		t.Fatalf("creating user %s: %v", email, err)
+	}
+	userID, _ := result.LastInsertId()
+	if _, err := postWalletTransaction(db, WalletTransaction{UserID: int(userID), Kind: WalletTxOpening, Amount: balance}); err != nil {
+		t.Fatalf("funding user %s: %v", email, err)
+	}
+	return int(userID)
+}
+
+// addTestChicken adds a chicken to the stable and returns its ID.
+func addTestChicken(t *testing.T, name string) int {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO chickens (name, odds, color) VALUES (?, 3.0, '#000000')", name)
+	if err != nil {
+		t.Fatalf("adding chicken %s: %v", name, err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+func TestRaceFieldComesFromRaceEntrants(t *testing.T) {
+	setupTestDB(t)
+	// The sample Scheduled race (ID 3) was entered from the five sample chickens.
+	const earlierRace = 3
+	for _, name := range []string{"Late Bloomer", "Reserve Hen"} {
+		addTestChicken(t, name)
+	}
+
+	result, err := db.Exec("INSERT INTO races (name, date, status) VALUES ('Test Race', '2025-06-02 14:00:00', ?)", RaceStatusScheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raceID64, _ := result.LastInsertId()
+	raceID := int(raceID64)
+	field, err := pickRaceEntrants(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(field) != maxEntrantsPerRace {
+		t.Fatalf("picked %d entrants from 7 chickens, want %d", len(field), maxEntrantsPerRace)
+	}
+	priceField(field, houseMargin)
+	if err := insertRaceEntrants(db, raceID, field); err != nil {
+		t.Fatal(err)
+	}
+	newcomer := addTestChicken(t, "Newcomer")
+
+	entrants, err := getRaceEntrants(db, raceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entrants) != len(field) {
+		t.Fatalf("race %d has %d entrants, want the %d entered", raceID, len(entrants), len(field))
+	}
+	for i, ch := range entrants {
+		if ch.ID != field[i].ID || ch.Lane != i+1 {
+			t.Errorf("lane %d: chicken %d in lane %d, want chicken %d", i+1, ch.ID, ch.Lane, field[i].ID)
+		}
+	}
+
+	earlier, err := getRaceEntrants(db, earlierRace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(earlier) != 5 {
+		t.Errorf("race %d, scheduled before chickens were added, has %d entrants, want 5", earlierRace, len(earlier))
+	}
+	for _, raceID := range []int{raceID, earlierRace} {
+		if _, err := findRaceEntrant(db, raceID, newcomer); !errors.Is(err, errEntrantNotFound) {
+			t.Errorf("race %d: chicken added after scheduling found as an entrant: %v", raceID, err)
+		}
+	}
+
+	race, err := getRaceDetails(db, raceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(race.ChickenNames) != len(field) {
+		t.Errorf("race details list %v, want the %d entrants", race.ChickenNames, len(field))
+	}
+}
+
+func TestRetiredChickensAreNotEntered(t *testing.T) {
+	setupTestDB(t)
+	// The sample Scheduled race (ID 3) was entered from the five sample chickens before any retired.
+	const earlierRace = 3
+	addTestChicken(t, "Late Bloomer")
+	retired := []int{1, 2}
+	for _, chickenID := range retired {
+		if err := setChickenRetired(db, testAdmin, chickenID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range 20 { // The draw is random, so try it a few times
+		field, err := pickRaceEntrants(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(field) != 4 {
+			t.Fatalf("picked %d entrants from 4 racing chickens, want 4", len(field))
+		}
+		for _, ch := range field {
+			if ch.ID == retired[0] || ch.ID == retired[1] {
+				t.Fatalf("retired chicken %d drawn for a new race", ch.ID)
+			}
+		}
+	}
+
+	// Races already drawn keep their retired entrants.
+	for _, chickenID := range retired {
+		if _, err := findRaceEntrant(db, earlierRace, chickenID); err != nil {
+			t.Errorf("retired chicken %d is no longer entered in race %d: %v", chickenID, earlierRace, err)
+		}
+	}
+
+	if err := setChickenRetired(db, testAdmin, retired[0], false); err != nil {
+		t.Fatal(err)
+	}
+	field, err := pickRaceEntrants(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(field) != maxEntrantsPerRace {
+		t.Errorf("picked %d entrants once a chicken came back, want %d", len(field), maxEntrantsPerRace)
+	}
+}
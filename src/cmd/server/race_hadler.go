@@ -2,9 +2,12 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,35 +15,18 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	data := PageData{
 		Title: "Scramble Run",
 	}
-
-	err := homeTemplate.ExecuteTemplate(w, "base.gohtml", data)
-	if err != nil {
-		log.Printf("raceHandler: Template execution error: %v", err)
-
+	var err error
+	if data.UserData, data.UserBalance, _, err = authenticatedUser(db, r); err != nil {
+		log.Printf("homeHandler: %v. Displaying as guest.", err)
 	}
+
+	renderTemplateWithStatus(w, r, http.StatusOK, homeTemplate, "base.gohtml", data)
 }
 
 func raceHandler(w http.ResponseWriter, r *http.Request) {
-	currentUserID := 1 // <<<< --- !!! !!! --- >>>
-	var currentUser User
-	var userBalance float64
-
-	if currentUserID != 0 {
-		errDb := db.QueryRow("SELECT id, name, email, balance FROM users WHERE id = ?", currentUserID).Scan(&currentUser.ID, &currentUser.Name, &currentUser.Email, &userBalance)
-		if errDb != nil {
-			if errDb == sql.ErrNoRows {
-				log.Printf("homeHandler: User ID %d not found. Displaying as Guest.", currentUserID)
-				currentUser.Name = "Guest"
-				userBalance = 0
-			} else {
-				log.Printf("homeHandler: Error fetching user data for ID %d: %v", currentUserID, errDb)
-				currentUser.Name = "Error"
-				userBalance = 0
-			}
-		}
-	} else {
-		currentUser.Name = "Guest"
-		userBalance = 0
+	currentUser, userBalance, _, err := authenticatedUser(db, r)
+	if err != nil {
+		log.Printf("raceHandler: %v. Displaying as guest.", err)
 	}
 
 	raceMutex.Lock()
@@ -91,25 +77,8 @@ func raceHandler(w http.ResponseWriter, r *http.Request) {
 		// We determine RaceFinished based on pageCurrentRaceDetails status if it's "Finished"
 		if pageCurrentRaceDetails != nil && pageCurrentRaceDetails.Status == RaceStatusFinished {
 			isRaceActuallyFinished = true
-			// === How to get Winner ID? ===
-			// Option A: If RaceInfo struct has WinnerChickenID
-			// actualWinnerID = pageCurrentRaceDetails.WinnerChickenID
-
-			// Option B: If RaceInfo.Winner is the NAME, and you need to look up the ID
-			// from availableChickens or by another DB query (less ideal for handler).
-			// For now, let's assume you have a way to get this ID. If not, the template logic needs adjustment.
-			// If `pageCurrentRaceDetails.Winner` (string name) is reliable:
-			if pageCurrentRaceDetails.Winner != "" {
-				// Find the chicken in availableChickens that matches the winner name
-				for _, chk := range availableChickens { // availableChickens should be populated by this point
-					if chk.Name == pageCurrentRaceDetails.Winner {
-						actualWinnerID = chk.ID // Assuming chk.ID is int
-						break
-					}
-				}
-				if actualWinnerID == 0 {
-					log.Printf("homeHandler: Could not find ID for winner name '%s' in availableChickens", pageCurrentRaceDetails.Winner)
-				}
+			if pageCurrentRaceDetails.WinnerChickenID.Valid {
+				actualWinnerID = int(pageCurrentRaceDetails.WinnerChickenID.Int64)
 			}
 		}
 
@@ -124,17 +93,8 @@ func raceHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Race is finished
 		isRaceActuallyFinished = true
-		// === How to get Winner ID? (Same logic as above) ===
-		if pageCurrentRaceDetails.Winner != "" {
-			for _, chk := range availableChickens {
-				if chk.Name == pageCurrentRaceDetails.Winner {
-					actualWinnerID = chk.ID
-					break
-				}
-			}
-			if actualWinnerID == 0 {
-				log.Printf("homeHandler: Could not find ID for winner name '%s' in availableChickens (finished block)", pageCurrentRaceDetails.Winner)
-			}
+		if pageCurrentRaceDetails.WinnerChickenID.Valid {
+			actualWinnerID = int(pageCurrentRaceDetails.WinnerChickenID.Int64)
 		}
 
 	} else { // No current race, no next race imminently, or error
@@ -153,22 +113,44 @@ func raceHandler(w http.ResponseWriter, r *http.Request) {
 		actualWinnerID = 0
 	}
 
-	var activeRaceForTemplate ActiveRace
-	// Ensure availableChickens is populated *before* trying to find winner ID from it
-	// availableChickens is used for ActiveRace.Chickens and for betting options
-	// It should represent the chickens participating in the *current* or *next* race
-	// This might be different from the chickens that participated in a *past* finished race.
-	// For simplicity, the current availableChickens list is used to find the winner.
-	// This assumes the winner of the last race is among the currently "available" chickens,
-	// which might be true if they are persistent.
-	activeRaceForTemplate = ActiveRace{Chickens: availableChickens}
+	// The betting panel lists the market of the race open for betting.
+	var bettingRaceID int
+	var bettingEntrants []Chicken
+	bettingMarket, errMarket := getBettingMarket(db)
+	if errMarket != nil {
+		log.Printf("raceHandler: No betting market available: %v", errMarket)
+	} else {
+		bettingRaceID = bettingMarket.RaceID
+		for _, e := range bettingMarket.Entrants {
+			bettingEntrants = append(bettingEntrants, e.Chicken)
+		}
+	}
+
+	// The track shows the running/just-finished race, or the upcoming field if there is none.
+	trackEntrants := bettingEntrants
+	if pageCurrentRaceDetails != nil && pageCurrentRaceDetails.Id != bettingRaceID &&
+		(pageCurrentRaceDetails.Status == RaceStatusRunning || pageCurrentRaceDetails.Status == RaceStatusFinished) {
+		currentEntrants, err := getRaceEntrants(db, pageCurrentRaceDetails.Id)
+		if err != nil {
+			log.Printf("raceHandler: Error loading entrants for race %d: %v", pageCurrentRaceDetails.Id, err)
+		} else {
+			trackEntrants = currentEntrants
+		}
+	}
+	activeRaceForTemplate := ActiveRace{Chickens: trackEntrants}
+
+	accumulatorRaces, errAcca := getAccumulatorRaces(db)
+	if errAcca != nil {
+		log.Printf("raceHandler: Error loading accumulator races: %v", errAcca)
+	}
 
 	data := PageData{
 		Title:                  "Scramble Run",
 		UserData:               currentUser,
 		UserBalance:            userBalance,
 		Races:                  get_races(db),         // History
-		Chickens:               availableChickens,     // For betting panel
+		Market:                 bettingMarket,         // For betting panel
+		AccumulatorRaces:       accumulatorRaces,      // For the accumulator panel
 		ActiveRace:             activeRaceForTemplate, // For track display
 		PotentialWinnings:      0.0,
 		InitialNextRaceTime:    calculatedTimeStr,
@@ -186,45 +168,14 @@ func raceHandler(w http.ResponseWriter, r *http.Request) {
 		Success: false,
 	}
 
-	err := raceTemplate.ExecuteTemplate(w, "base.gohtml", data)
-	if err != nil {
-		log.Printf("raceHandler: Template execution error: %v", err)
-
-	}
+	renderTemplateWithStatus(w, r, http.StatusOK, raceTemplate, "base.gohtml", data)
 }
 
 // nextRaceInfoHandler provides HTMX updates for the race timer/status display.
 func nextRaceInfoHandler(w http.ResponseWriter, r *http.Request) {
-	// --- 1. Get User ID ---
-	// This is CRUCIAL. You need a reliable way to get the current user's ID.
-	// For now, I'll use a placeholder like in homeHandler, but this
-	// MUST be replaced with your actual session/authentication logic.
-	// Example: currentUserID := app.sessionManager.GetInt(r.Context(), "userID")
-	currentUserID := sessionManager.GetInt(r.Context(), sessionUserIDKey)
-	// If you have a session manager:
-	// currentUserID = sessionManager.GetInt(r.Context(), "userID") // Assuming sessionManager is accessible
-
-	var currentUserBalance float64
-	userLoggedIn := false
-
-	if currentUserID != 0 {
-		// --- 2. Fetch User Balance Correctly ---
-		errDb := db.QueryRow("SELECT balance FROM users WHERE id = ?", currentUserID).Scan(&currentUserBalance)
-		if errDb != nil {
-			if errDb == sql.ErrNoRows {
-				log.Printf("nextRaceInfoHandler: User ID %d not found when fetching balance.", currentUserID)
-				// currentUserBalance remains 0.0, userLoggedIn remains false
-			} else {
-				log.Printf("nextRaceInfoHandler: Error fetching balance for user ID %d: %v", currentUserID, errDb)
-				// currentUserBalance remains 0.0, userLoggedIn remains false
-			}
-		} else {
-			// Balance fetched successfully
-			userLoggedIn = true
-		}
-	} else {
-		log.Println("nextRaceInfoHandler: No user ID found (or user is guest), not fetching balance.")
-		// currentUserBalance remains 0.0, userLoggedIn remains false
+	_, currentUserBalance, userLoggedIn, err := authenticatedUser(db, r)
+	if err != nil {
+		log.Printf("nextRaceInfoHandler: %v", err)
 	}
 
 	// --- Race Logic (copied from your existing code, assumed correct) ---
@@ -301,8 +252,8 @@ func nextRaceInfoHandler(w http.ResponseWriter, r *http.Request) {
 		RaceName           string
 		IsBettingOpen      bool
 		IsRaceRunning      bool
-		UserLoggedIn       bool    // Now correctly determined
-		CurrentUserBalance float64 // Now correctly fetched
+		UserLoggedIn       bool  // Now correctly determined
+		CurrentUserBalance Money // Now correctly fetched
 	}{
 		CountdownStr:       countdownStr,
 		StatusMsg:          statusMsg,
@@ -320,7 +271,7 @@ func nextRaceInfoHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	err := raceInfoTemplate.Execute(w, data)
+	err = raceInfoTemplate.Execute(w, data)
 	if err != nil {
 		log.Printf("nextRaceInfoHandler: Error executing template: %v", err)
 	}
@@ -339,13 +290,17 @@ func handleTriggerRaceCycle(w http.ResponseWriter, r *http.Request) {
 	raceMutex.Unlock()
 
 	forcedAction := "No specific action forced, triggering scheduler."
+	forcedRaceID := 0
+	var forcedErr error
 
 	if currentRace != nil && currentRace.Status == RaceStatusRunning {
 		log.Printf("ADMIN: Forcing finish for currently running race ID %d: %s", currentRace.Id, currentRace.Name)
 		if raceEndTimer != nil {
 			raceEndTimer.Stop()
 		}
+		forcedRaceID = currentRace.Id
 		err := finishRace(db, currentRace.Id)
+		forcedErr = err
 		if err != nil {
 			log.Printf("ADMIN: Error force-finishing race %d: %v", currentRace.Id, err)
 			forcedAction = fmt.Sprintf("Error force-finishing race %d: %v", currentRace.Id, err)
@@ -362,7 +317,9 @@ func handleTriggerRaceCycle(w http.ResponseWriter, r *http.Request) {
 
 		if err == nil {
 			log.Printf("ADMIN: Forcing start for next scheduled race ID %d: %s", raceToStartID, raceToStartName)
+			forcedRaceID = raceToStartID
 			errStart := startRace(db, raceToStartID)
+			forcedErr = errStart
 			if errStart != nil {
 				log.Printf("ADMIN: Error force-starting race %d: %v", raceToStartID, errStart)
 				forcedAction = fmt.Sprintf("Error force-starting race %d: %v", raceToStartID, errStart)
@@ -373,6 +330,7 @@ func handleTriggerRaceCycle(w http.ResponseWriter, r *http.Request) {
 			log.Println("ADMIN: No race running and no race scheduled to force-start.")
 			forcedAction = "No race running or scheduled to force."
 			scheduled, sErr := scheduleNewRace(db)
+			forcedErr = sErr
 			if sErr != nil {
 				forcedAction += " Error scheduling new: " + sErr.Error()
 			}
@@ -382,8 +340,10 @@ func handleTriggerRaceCycle(w http.ResponseWriter, r *http.Request) {
 		} else {
 			log.Printf("ADMIN: Error finding race to force-start: %v", err)
 			forcedAction = "Error finding race to force: " + err.Error()
+			forcedErr = err
 		}
 	}
+	auditAdminRaceAction(r, AuditAdminRaceCycle, forcedRaceID, "trigger-race-cycle", "", forcedErr, forcedAction)
 
 	if raceTicker != nil {
 		log.Println("ADMIN: Resetting raceTicker to re-evaluate scheduling post-trigger.")
@@ -394,3 +354,37 @@ func handleTriggerRaceCycle(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Race cycle triggered. Action: %s. Check server logs.\n", forcedAction)
 	log.Printf("ADMIN: Manual race cycle trigger processed. Action: %s", forcedAction)
 }
+
+// handleCancelRace cancels a scheduled or running race on an administrator's request, refunding its bets.
+// The reason given is recorded on the race.
+func handleCancelRace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	raceID, err := strconv.Atoi(r.FormValue("raceID"))
+	if err != nil {
+		http.Error(w, "Invalid race ID", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		reason = "Cancelled by an administrator"
+	}
+	log.Printf("ADMIN: Cancel requested for race ID %d (%s).", raceID, reason)
+
+	err = adminCancelRace(db, raceID, reason)
+	auditAdminRaceAction(r, AuditAdminRaceAction, raceID, "cancel", reason, err, fmt.Sprintf("Race %d cancelled: %s.", raceID, reason))
+	if errors.Is(err, errRaceNotCancellable) {
+		http.Error(w, fmt.Sprintf("Race %d is not scheduled or running.", raceID), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("ADMIN: Error cancelling race %d: %v", raceID, err)
+		http.Error(w, "Error cancelling race", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Race %d cancelled: %s. Pending bets refunded.\n", raceID, reason)
+}
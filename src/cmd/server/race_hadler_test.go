@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/gob"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// setupHandlerTest points the package globals at a fresh database (see setupTestDB), the real page
+// templates and a new session manager.
+func setupHandlerTest(t *testing.T) {
+	t.Helper()
+	setupTestDB(t)
+	base := template.Must(template.ParseFiles("../../web/templates/base.gohtml"))
+	parse := func(file string) *template.Template {
+		return template.Must(template.Must(base.Clone()).ParseFiles("../../web/templates/" + file))
+	}
+
+	gob.Register(time.Time{})
+	prevHome, prevRace, prevSessions := homeTemplate, raceTemplate, sessionManager
+	homeTemplate, raceTemplate = parse("home.gohtml"), parse("races.gohtml")
+	sessionManager = scs.New()
+	t.Cleanup(func() {
+		homeTemplate, raceTemplate, sessionManager = prevHome, prevRace, prevSessions
+	})
+}
+
+// loginCookie returns the session cookie of a session logged in as the user.
+func loginCookie(t *testing.T, userID int, name string) *http.Cookie {
+	t.Helper()
+	login := sessionManager.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionManager.Put(r.Context(), sessionUserIDKey, userID)
+		sessionManager.Put(r.Context(), sessionUserNameKey, name)
+	}))
+	rec := httptest.NewRecorder()
+	login.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionManager.Cookie.Name {
+			return c
+		}
+	}
+	t.Fatalf("no session cookie set for user %d", userID)
+	return nil
+}
+
+// get serves a GET request through the session middleware and returns the response body.
+func get(t *testing.T, handler http.HandlerFunc, path string, cookie *http.Cookie) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	sessionManager.LoadAndSave(handler).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d", path, rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestHandlersShowEachSessionItsOwnUser(t *testing.T) {
+	setupHandlerTest(t)
+	alice := createTestUser(t, "Alice", "alice@example.com", 123*Credit+45)
+	bob := createTestUser(t, "Bob", "bob@example.com", 678*Credit+90)
+	aliceCookie := loginCookie(t, alice, "Alice")
+	bobCookie := loginCookie(t, bob, "Bob")
+
+	for _, tc := range []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/races", raceHandler},
+		{"/", homeHandler},
+		{"/next-race-info", nextRaceInfoHandler},
+	} {
+		aliceBody := get(t, tc.handler, tc.path, aliceCookie)
+		if !strings.Contains(aliceBody, "123.45") || strings.Contains(aliceBody, "678.90") {
+			t.Errorf("GET %s as Alice: want her balance 123.45 and not Bob's", tc.path)
+		}
+		bobBody := get(t, tc.handler, tc.path, bobCookie)
+		if !strings.Contains(bobBody, "678.90") || strings.Contains(bobBody, "123.45") {
+			t.Errorf("GET %s as Bob: want his balance 678.90 and not Alice's", tc.path)
+		}
+	}
+}
+
+func TestRaceHandlerRendersGuests(t *testing.T) {
+	setupHandlerTest(t)
+	createTestUser(t, "Alice", "alice@example.com", 123*Credit+45)
+
+	body := get(t, raceHandler, "/races", nil)
+	if strings.Contains(body, "123.45") || strings.Contains(body, "1000.00") {
+		t.Error("guest sees a user's balance")
+	}
+	if strings.Contains(body, "user-balance-display") || strings.Contains(body, `action="/logout"`) {
+		t.Error("guest is shown the logged-in navigation")
+	}
+	if !strings.Contains(body, `href="/login"`) {
+		t.Error("guest is not offered a login link")
+	}
+}
+
+func TestAuthenticatedUserTreatsDeletedUsersAsGuests(t *testing.T) {
+	setupHandlerTest(t)
+	cookie := loginCookie(t, 9999, "Ghost")
+
+	var got User
+	var ok bool
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if got, _, ok, err = authenticatedUser(db, r); err != nil {
+			t.Errorf("authenticatedUser: %v", err)
+		}
+	}
+	get(t, handler, "/", cookie)
+	if ok || got.ID != 0 || got.Name != "Guest" {
+		t.Errorf("authenticatedUser for a deleted user = %+v, %v; want a guest", got, ok)
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"time"
 )
 
+// scheduledRacesAhead is how many races the scheduler keeps published, so accumulators can span several of them.
+var scheduledRacesAhead = getEnvIntOrDefault("SCHEDULED_RACES_AHEAD", 3)
+
 // generateRaceName creates a whimsical name for a race.
 func generateRaceName() string {
 	adjectives := []string{"Speedy", "Thunder", "Golden", "Lightning", "Cosmic", "農場 (Farm)", "Feathered", "Clucky"}
@@ -16,7 +19,8 @@ func generateRaceName() string {
 	return fmt.Sprintf("%s %s #%d", adjectives[rand.Intn(len(adjectives))], nouns[rand.Intn(len(nouns))], rand.Intn(1000))
 }
 
-// scheduleNewRace attempts to schedule a new race if no active (Scheduled or Running) race exists.
+// scheduleNewRace tops the schedule up to scheduledRacesAhead races, each starting an interval after the last.
+// Nothing is scheduled while a race is running.
 func scheduleNewRace(db *sql.DB) (bool, error) {
 	raceMutex.Lock()
 	defer raceMutex.Unlock()
@@ -32,16 +36,17 @@ func scheduleNewRace(db *sql.DB) (bool, error) {
 	// Check specifically for any race that might be stuck in 'Running' state
 	errStale := db.QueryRow("SELECT id FROM races WHERE status = ? ORDER BY date ASC LIMIT 1", RaceStatusRunning).Scan(&staleRaceID)
 	if errStale == nil {
-		// Found a race in 'Running' state. Assume it's stale from a previous session.
-		log.Printf("scheduleNewRace: Found stale race ID %d in 'Running' state. Marking as Finished.", staleRaceID)
-		_, errUpdateStale := db.Exec("UPDATE races SET status = ?, winner_chicken_id = NULL WHERE id = ? AND status = ?", RaceStatusFinished, staleRaceID, RaceStatusRunning)
-		if errUpdateStale != nil {
-			log.Printf("scheduleNewRace: Error marking stale running race ID %d as Finished: %v", staleRaceID, errUpdateStale)
+		// Found a race in 'Running' state. Assume it's stale from a previous session: it was never
+		// finished, so it is cancelled and its bets refunded.
+		log.Printf("scheduleNewRace: Found stale race ID %d in 'Running' state. Cancelling it.", staleRaceID)
+		errCancelStale := cancelRaceByID(db, staleRaceID, "Stale: still running when the race manager restarted")
+		if errCancelStale != nil {
+			log.Printf("scheduleNewRace: Error cancelling stale running race ID %d: %v", staleRaceID, errCancelStale)
 		} else {
-			log.Printf("scheduleNewRace: Stale running race ID %d successfully marked as Finished.", staleRaceID)
+			log.Printf("scheduleNewRace: Stale running race ID %d successfully cancelled.", staleRaceID)
 		}
 		if currentRaceDetails != nil && currentRaceDetails.Id == staleRaceID {
-			currentRaceDetails.Status = RaceStatusFinished
+			currentRaceDetails.Status = RaceStatusCancelled
 			currentRaceDetails.Winner = "N/A (Stale)"
 			currentRaceDetails.WinnerChickenID = sql.NullInt64{Valid: false}
 		}
@@ -74,18 +79,22 @@ func scheduleNewRace(db *sql.DB) (bool, error) {
 			// IMPORTANT FIX: Check if the parsedTime is unreasonably far in the future
 			if status == RaceStatusScheduled && time.Until(parsedTime) > 10*time.Minute {
 				log.Printf("scheduleNewRace: Found a race scheduled too far in the future (%v). Rescheduling it.", parsedTime)
-				// Delete this race and continue to schedule a new one
-				_, delErr := db.Exec("DELETE FROM races WHERE id = ? AND status = ?", raceID, RaceStatusScheduled)
-				if delErr != nil {
-					log.Printf("scheduleNewRace: Error deleting far-future race: %v", delErr)
+				// Cancel this race, refunding its bets, and continue to schedule a new one
+				cancelErr := cancelRaceByID(db, raceID, "Stale: scheduled too far in the future")
+				if cancelErr != nil {
+					log.Printf("scheduleNewRace: Error cancelling far-future race: %v", cancelErr)
 					// Continue anyway
+				} else {
+					existingRaceCount--
 				}
 			} else if status == RaceStatusScheduled {
 				nextRaceStartTime = parsedTime
 				currentRaceDetails = nil
-				log.Printf("scheduleNewRace: A race (ID %d) is already scheduled for %v (%v from now). No new race created.",
-					raceID, nextRaceStartTime, time.Until(nextRaceStartTime))
-				return false, nil
+				if existingRaceCount >= scheduledRacesAhead {
+					log.Printf("scheduleNewRace: %d races already scheduled, the next (ID %d) for %v (%v from now). No new race created.",
+						existingRaceCount, raceID, nextRaceStartTime, time.Until(nextRaceStartTime))
+					return false, nil
+				}
 			} else { // RaceStatusRunning
 				if currentRaceDetails == nil || currentRaceDetails.Id != raceID || currentRaceDetails.Status != RaceStatusRunning {
 					fetchedRaceDetails, rdErr := getRaceDetails(db, raceID)
@@ -109,47 +118,141 @@ func scheduleNewRace(db *sql.DB) (bool, error) {
 	// Change this interval to be much shorter for testing/debugging
 	raceInterval := 30 * time.Second // Use short intervals initially, can increase once fixed
 
-	// If no 'Scheduled' or 'Running' races exist, schedule a new one.
+	// New races queue up behind the last one already scheduled.
 	scheduledTime := time.Now().Add(raceInterval)
-	raceName := generateRaceName()
+	var lastDateStr sql.NullString
+	if err := db.QueryRow("SELECT MAX(date) FROM races WHERE status = ?", RaceStatusScheduled).Scan(&lastDateStr); err != nil {
+		log.Printf("scheduleNewRace: Error fetching the last scheduled race: %v", err)
+		return false, err
+	}
+	if lastDateStr.Valid {
+		if lastTime, pErr := parseRaceDate(lastDateStr.String); pErr == nil && lastTime.Add(raceInterval).After(scheduledTime) {
+			scheduledTime = lastTime.Add(raceInterval)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("scheduleNewRace: Failed to begin transaction: %v", err)
+		return false, err
+	}
+	defer tx.Rollback() // No-op once committed
+
+	firstScheduled := time.Time{}
+	for n := existingRaceCount; n < max(scheduledRacesAhead, 1); n++ {
+		if _, err := insertScheduledRace(tx, scheduledTime); err != nil {
+			return false, err
+		}
+		if firstScheduled.IsZero() {
+			firstScheduled = scheduledTime
+		}
+		scheduledTime = scheduledTime.Add(raceInterval)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("scheduleNewRace: Error committing new races: %v", err)
+		return false, err
+	}
+	if nextRaceStartTime.IsZero() || firstScheduled.Before(nextRaceStartTime) {
+		nextRaceStartTime = firstScheduled
+	}
+	currentRaceDetails = nil
+	return true, nil
+}
 
+// insertScheduledRace creates a race starting at scheduledTime, with a freshly drawn and priced field.
+func insertScheduledRace(tx *sql.Tx, scheduledTime time.Time) (int64, error) {
+	raceName := generateRaceName()
 	log.Printf("scheduleNewRace: Creating new race '%s' scheduled for %v (%v from now)",
 		raceName, scheduledTime, time.Until(scheduledTime))
 
-	result, err := db.Exec("INSERT INTO races (name, date, status) VALUES (?, ?, ?)",
-		raceName, scheduledTime.Format(time.RFC3339), RaceStatusScheduled)
+	entrants, err := pickRaceEntrants(tx)
+	if err != nil {
+		log.Printf("scheduleNewRace: Error picking entrants: %v", err)
+		return 0, err
+	}
+	if len(entrants) == 0 {
+		log.Println("scheduleNewRace: No chickens in the stable. Cannot schedule a race.")
+		return 0, fmt.Errorf("no chickens available to enter a race")
+	}
+	priceField(entrants, houseMargin)
+	betMode, takeout := newRaceBetMode()
+
+	result, err := tx.Exec("INSERT INTO races (name, date, status, sim_seed, bet_mode, takeout) VALUES (?, ?, ?, ?, ?, ?)",
+		raceName, scheduledTime.Format(time.RFC3339), RaceStatusScheduled, rand.Int63(), betMode, takeout)
 	if err != nil {
 		log.Printf("scheduleNewRace: Error inserting new race: %v", err)
-		return false, err
+		return 0, err
 	}
 	newRaceID64, _ := result.LastInsertId()
-	nextRaceStartTime = scheduledTime
-	currentRaceDetails = nil
 
-	log.Printf("Scheduled new race: ID %d, Name: '%s', StartTime: %v",
-		newRaceID64, raceName, scheduledTime)
-	return true, nil
+	if err := insertRaceEntrants(tx, int(newRaceID64), entrants); err != nil {
+		log.Printf("scheduleNewRace: Error inserting entrants for race %d: %v", newRaceID64, err)
+		return 0, err
+	}
+
+	entrantIDs := make([]int, len(entrants))
+	for i, ch := range entrants {
+		entrantIDs[i] = ch.ID
+	}
+	event := newAuditEvent(systemActor, AuditRaceScheduled, "race", int(newRaceID64))
+	event.After = auditValue(map[string]interface{}{"status": RaceStatusScheduled, "name": raceName, "date": scheduledTime.Format(time.RFC3339), "entrants": entrantIDs, "bet_mode": betMode})
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		log.Printf("scheduleNewRace: %v", err)
+		return 0, err
+	}
+
+	log.Printf("Scheduled new race: ID %d, Name: '%s', StartTime: %v, Entrants: %d, Bet mode: %s",
+		newRaceID64, raceName, scheduledTime, len(entrants), betMode)
+	return newRaceID64, nil
 }
 
 // startRace marks a scheduled race as 'Running' and sets up its end timer.
 func startRace(db *sql.DB, raceID int) error {
 	raceMutex.Lock()
+	raceName, err := markRaceRunning(db, raceID)
+	raceMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	launchRace(db, raceID, raceName)
+	return nil
+}
 
+// markRaceRunning moves a scheduled race to 'Running' and makes it the current race, returning its name.
+// The caller must hold raceMutex, and call launchRace once it is released.
+func markRaceRunning(db *sql.DB, raceID int) (string, error) {
 	log.Printf("Attempting to start race ID: %d", raceID)
-	res, err := db.Exec("UPDATE races SET status = ? WHERE id = ? AND status = ?", RaceStatusRunning, raceID, RaceStatusScheduled)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("startRace: Error beginning transaction for race %d: %v", raceID, err)
+		return "", err
+	}
+	defer tx.Rollback() // No-op once committed
+
+	res, err := tx.Exec("UPDATE races SET status = ? WHERE id = ? AND status = ?", RaceStatusRunning, raceID, RaceStatusScheduled)
 	if err != nil {
-		raceMutex.Unlock()
 		log.Printf("startRace: Error updating race %d to Running: %v", raceID, err)
-		return err
+		return "", err
 	}
 	rowsAffected, _ := res.RowsAffected()
 	if rowsAffected == 0 {
-		raceMutex.Unlock()
 		log.Printf("startRace: Race ID %d not found in 'Scheduled' state or already started.", raceID)
 		var currentStatus string
-		db.QueryRow("SELECT status FROM races WHERE id = ?", raceID).Scan(&currentStatus)
+		tx.QueryRow("SELECT status FROM races WHERE id = ?", raceID).Scan(&currentStatus)
 		log.Printf("startRace: Current status of race %d is '%s'", raceID, currentStatus)
-		return fmt.Errorf("race %d not in 'Scheduled' state", raceID)
+		return "", fmt.Errorf("race %d not in 'Scheduled' state", raceID)
+	}
+
+	event := newAuditEvent(systemActor, AuditRaceStarted, "race", raceID)
+	event.Before = auditValue(map[string]string{"status": RaceStatusScheduled})
+	event.After = auditValue(map[string]string{"status": RaceStatusRunning})
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		log.Printf("startRace: %v", err)
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("startRace: Error committing start of race %d: %v", raceID, err)
+		return "", err
 	}
 
 	raceInfo, err := getRaceDetails(db, raceID)
@@ -159,16 +262,16 @@ func startRace(db *sql.DB, raceID int) error {
 	} else {
 		currentRaceDetails = raceInfo
 	}
-	if currentRaceDetails != nil {
-		currentRaceDetails.Status = RaceStatusRunning
-	}
+	currentRaceDetails.Status = RaceStatusRunning
 
 	log.Printf("Race ID: %d (%s) started. Will finish in %v.", raceID, currentRaceDetails.Name, raceDuration)
 	nextRaceStartTime = time.Time{}
-	raceMutex.Unlock()
+	return currentRaceDetails.Name, nil
+}
 
-	// Initialize race animation
-	initRaceAnimation(raceID, currentRaceDetails.Name)
+// launchRace starts the animation of a race markRaceRunning has just started, and the timer that finishes it.
+func launchRace(db *sql.DB, raceID int, raceName string) {
+	initRaceAnimation(raceID, raceName)
 
 	if raceEndTimer != nil {
 		raceEndTimer.Stop()
@@ -180,10 +283,29 @@ func startRace(db *sql.DB, raceID int) error {
 			log.Printf("Error auto-finishing race %d: %v", raceID, err)
 		}
 	})
-	return nil
 }
 
-// finishRace marks a running race as 'Finished', determines a winner, and settles bets.
+// finishRaceWithoutWinner marks a race with no entrants as finished, recording the event in the same transaction.
+func finishRaceWithoutWinner(db *sql.DB, raceID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // No-op once committed
+
+	if _, err := tx.Exec("UPDATE races SET status = ?, winner_chicken_id = NULL WHERE id = ?", RaceStatusFinished, raceID); err != nil {
+		return err
+	}
+	event := newAuditEvent(systemActor, AuditRaceFinished, "race", raceID)
+	event.Before = auditValue(map[string]string{"status": RaceStatusRunning})
+	event.After = auditValue(map[string]interface{}{"status": RaceStatusFinished, "winner": nil})
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// finishRace marks a running race as 'Finished', takes the winner from the race simulation, and settles bets.
 func finishRace(db *sql.DB, raceID int) error {
 	raceMutex.Lock()
 
@@ -210,9 +332,17 @@ func finishRace(db *sql.DB, raceID int) error {
 		return fmt.Errorf("race %d is not 'Running', status is %s", raceID, currentStatus)
 	}
 
-	if len(availableChickens) == 0 {
-		log.Printf("finishRace: No available chickens to determine a winner for race %d.", raceID)
-		_, errDb := db.Exec("UPDATE races SET status = ?, winner_chicken_id = NULL WHERE id = ?", RaceStatusFinished, raceID)
+	// The simulation is deterministic for a race's seed and field, so this is the same race the animation replays.
+	sim, err := loadRaceSimulation(db, raceID)
+	if err != nil {
+		raceMutex.Unlock()
+		log.Printf("finishRace: Error simulating race %d: %v", raceID, err)
+		return err
+	}
+
+	if len(sim.Entrants) == 0 {
+		log.Printf("finishRace: No entrants to determine a winner for race %d.", raceID)
+		errDb := finishRaceWithoutWinner(db, raceID)
 		if errDb != nil {
 			log.Printf("finishRace: Error updating race %d to Finished with no winner: %v", raceID, errDb)
 		}
@@ -225,11 +355,17 @@ func finishRace(db *sql.DB, raceID int) error {
 		return errDb
 	}
 
-	winnerChicken := availableChickens[rand.Intn(len(availableChickens))]
-	log.Printf("Race ID: %d finished. Winner: %s (ID: %d)", raceID, winnerChicken.Name, winnerChicken.ID)
+	var winnerChicken Chicken
+	for _, ch := range sim.Entrants {
+		if ch.ID == sim.WinnerID() {
+			winnerChicken = ch
+			break
+		}
+	}
+	log.Printf("Race ID: %d finished. Winner: %s (ID: %d). Finishing order: %v", raceID, winnerChicken.Name, winnerChicken.ID, sim.FinishOrder)
 
-	// Finish race animation with winner
-	finishRaceAnimation(winnerChicken.ID)
+	// Finish race animation with the simulated result
+	finishRaceAnimation(raceID, sim)
 
 	tx, errTx := db.Begin()
 	if errTx != nil {
@@ -256,13 +392,28 @@ func finishRace(db *sql.DB, raceID int) error {
 		return err
 	}
 
-	errSettle := settleBetsForRace(tx, raceID, winnerChicken.ID)
+	if err := recordRaceResult(tx, raceID, sim.FinishOrder); err != nil {
+		raceMutex.Unlock()
+		log.Printf("finishRace: Error recording finishing order for race %d: %v", raceID, err)
+		return err
+	}
+
+	errSettle := settleBetsForRace(tx, raceID)
 	if errSettle != nil {
 		raceMutex.Unlock()
 		log.Printf("finishRace: Error settling bets for race %d: %v", raceID, errSettle)
 		return errSettle
 	}
 
+	event := newAuditEvent(systemActor, AuditRaceFinished, "race", raceID)
+	event.Before = auditValue(map[string]string{"status": RaceStatusRunning})
+	event.After = auditValue(map[string]interface{}{"status": RaceStatusFinished, "winner": winnerChicken.ID, "finish_order": sim.FinishOrder})
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		raceMutex.Unlock()
+		log.Printf("finishRace: %v", err)
+		return err
+	}
+
 	errCommit := tx.Commit()
 	if errCommit != nil {
 		raceMutex.Unlock()
@@ -289,12 +440,51 @@ func finishRace(db *sql.DB, raceID int) error {
 	return nil
 }
 
-// settleBetsForRace processes 'Pending' bets for a finished race.
-func settleBetsForRace(tx *sql.Tx, raceID int, winningChickenID int) error {
-	log.Printf("Settling bets for Race ID: %d, Winning Chicken ID: %d", raceID, winningChickenID)
+// settleBetsForRace processes 'Pending' bets for a finished race against its recorded finishing order.
+// Fixed-odds bets are paid at their price; tote bets share the race's pool, less the takeout.
+// Accumulator legs run in the race are settled leg by leg by settleAccumulatorLegs.
+func settleBetsForRace(tx *sql.Tx, raceID int) error {
+	positions, err := getRaceResult(tx, raceID)
+	if err != nil {
+		return err
+	}
+	var winningChickenID int
+	for chickenID, position := range positions {
+		if position == 1 {
+			winningChickenID = chickenID
+		}
+	}
+	log.Printf("Settling bets for Race ID: %d, Winning Chicken ID: %d, Positions: %v", raceID, winningChickenID, positions)
+
+	selections, err := getRaceBetSelections(tx, raceID)
+	if err != nil {
+		return err
+	}
+
+	betMode, takeout, err := getRaceBetMode(tx, raceID)
+	if err != nil {
+		return err
+	}
+	var toteDividendPaid float64
+	toteRefund := false
+	if betMode == BetModeTote {
+		stakes, err := getRacePoolStakes(tx, raceID)
+		if err != nil {
+			return err
+		}
+		var poolTotal Money
+		for _, s := range stakes {
+			poolTotal += s
+		}
+		toteDividendPaid = toteDividend(poolTotal, takeout, stakes[winningChickenID])
+		// Nobody backed the winner: the pool cannot be shared, so every ticket is refunded.
+		toteRefund = poolTotal > 0 && stakes[winningChickenID] == 0
+		log.Printf("settleBetsForRace: Tote pool for race %d: %s (takeout %.2f), winning stake %s, dividend %.2f",
+			raceID, poolTotal, takeout, stakes[winningChickenID], toteDividendPaid)
+	}
 
-	var wonStatusID, lostStatusID int
-	err := tx.QueryRow("SELECT id FROM bet_statuses WHERE status_name = 'Won'").Scan(&wonStatusID)
+	var wonStatusID, lostStatusID, cancelledStatusID int
+	err = tx.QueryRow("SELECT id FROM bet_statuses WHERE status_name = 'Won'").Scan(&wonStatusID)
 	if err != nil {
 		return fmt.Errorf("could not find 'Won' bet status ID: %w", err)
 	}
@@ -302,17 +492,22 @@ func settleBetsForRace(tx *sql.Tx, raceID int, winningChickenID int) error {
 	if err != nil {
 		return fmt.Errorf("could not find 'Lost' bet status ID: %w", err)
 	}
+	err = tx.QueryRow("SELECT id FROM bet_statuses WHERE status_name = 'Cancelled'").Scan(&cancelledStatusID)
+	if err != nil {
+		return fmt.Errorf("could not find 'Cancelled' bet status ID: %w", err)
+	}
 	pendingStatusID, err := getPendingBetStatusID(tx)
 	if err != nil {
 		return fmt.Errorf("could not find 'Pending' bet status ID for settling: %w", err)
 	}
 
 	rows, err := tx.Query(`
-        SELECT b.id, b.user_id, b.chicken_id, b.bet_amount, c.odds
+        SELECT b.id, b.user_id, b.chicken_id, b.bet_type, b.bet_amount,
+               COALESCE(b.odds, CASE b.bet_type WHEN 'Place' THEN e.place_odds WHEN 'Show' THEN e.show_odds ELSE e.starting_odds END)
         FROM bets b
-        JOIN chickens c ON b.chicken_id = c.id
-        WHERE b.race_id = ? AND b.bet_status_id = ?
-    `, raceID, pendingStatusID)
+        JOIN race_entrants e ON e.race_id = b.race_id AND e.chicken_id = b.chicken_id
+        WHERE b.race_id = ? AND b.bet_status_id = ? AND b.bet_type != ?
+    `, raceID, pendingStatusID, BetTypeAccumulator)
 	if err != nil {
 		return fmt.Errorf("error querying pending bets for race %d: %w", raceID, err)
 	}
@@ -322,46 +517,66 @@ func settleBetsForRace(tx *sql.Tx, raceID int, winningChickenID int) error {
 	for rows.Next() {
 		betsProcessedCount++
 		var betID, userID, betChickenID int
-		var betAmount, chickenOdds float64
-		if err := rows.Scan(&betID, &userID, &betChickenID, &betAmount, &chickenOdds); err != nil {
+		var betType string
+		var betAmount Money
+		var chickenOdds float64
+		if err := rows.Scan(&betID, &userID, &betChickenID, &betType, &betAmount, &chickenOdds); err != nil {
 			log.Printf("settleBetsForRace: Error scanning bet row for race %d: %v", raceID, err)
 			continue
 		}
 
-		var payout float64 = 0
+		var payout Money = 0
 		newStatusID := lostStatusID
 
-		if betChickenID == winningChickenID {
+		// Bets placed before selections were recorded name just their chicken_id.
+		selection, ok := selections[betID]
+		if !ok {
+			selection = []int{betChickenID}
+		}
+
+		if toteRefund || selectionWins(betType, selection, positions) {
 			// Calculate total payout: original bet + winnings
-			winnings := betAmount * (chickenOdds - 1) // Just the profit
-			payout = betAmount + winnings             // Original bet + profit
+			switch {
+			case toteRefund:
+				payout = betAmount
+				newStatusID = cancelledStatusID
+			case betMode == BetModeTote:
+				payout = toteTicketPayout(betAmount, toteDividendPaid)
+				chickenOdds = toteDividendPaid
+				newStatusID = wonStatusID
+			default:
+				payout = fixedOddsPayout(betAmount, chickenOdds) // Original bet + profit, at the price the bet was struck at
+				newStatusID = wonStatusID
+			}
+			winnings := payout - betAmount // Just the profit
 
-			newStatusID = wonStatusID
-			log.Printf("Bet ID %d (User %d) on chicken %d WON. Bet: %.2f, Odds: %.2f, Payout: %.2f (returning bet + %.2f winnings)",
-				betID, userID, betChickenID, betAmount, chickenOdds, payout, winnings)
+			log.Printf("Bet ID %d (User %d) %s on chickens %v settled (status %d). Bet: %s, Odds: %.2f, Payout: %s (returning bet + %s winnings)",
+				betID, userID, betType, selection, newStatusID, betAmount, chickenOdds, payout, winnings)
 
-			// Update user balance with total payout (bet + winnings)
-			result, errUpdateBalance := tx.Exec("UPDATE users SET balance = balance + ? WHERE id = ?", payout, userID)
-			if errUpdateBalance != nil {
+			// Credit the total payout (bet + winnings) through the wallet ledger
+			entry := WalletTransaction{UserID: userID, Kind: WalletTxPayout, Amount: payout, BetID: betID, RaceID: raceID, Note: betType + " bet won"}
+			if toteRefund {
+				entry.Kind, entry.Note = WalletTxRefund, "Tote pool refunded: no winning tickets"
+			}
+			if _, errUpdateBalance := postWalletTransaction(tx, entry); errUpdateBalance != nil {
 				log.Printf("settleBetsForRace: Failed to update balance for user %d after winning bet %d: %v", userID, betID, errUpdateBalance)
 				return fmt.Errorf("failed to update balance for user %d on win: %w", userID, errUpdateBalance)
 			}
-
-			// Verify the update actually affected a row
-			rowsAffected, _ := result.RowsAffected()
-			if rowsAffected == 0 {
-				log.Printf("settleBetsForRace: WARNING - Update balance query for user %d (bet %d) affected 0 rows", userID, betID)
-			}
 		} else {
-			log.Printf("Bet ID %d (User %d) on chicken %d LOST. Winning chicken was %d.",
-				betID, userID, betChickenID, winningChickenID)
+			log.Printf("Bet ID %d (User %d) %s on chickens %v LOST. Winning chicken was %d.",
+				betID, userID, betType, selection, winningChickenID)
 		}
 
-		_, errUpdateBet := tx.Exec("UPDATE bets SET bet_status_id = ?, actual_payout = ? WHERE id = ?", newStatusID, payout, betID)
+		_, errUpdateBet := tx.Exec("UPDATE bets SET bet_status_id = ?, settled_at = CURRENT_TIMESTAMP, actual_payout = ? WHERE id = ?", newStatusID, payout, betID)
 		if errUpdateBet != nil {
 			log.Printf("settleBetsForRace: Failed to update status for bet %d: %v", betID, errUpdateBet)
 			return fmt.Errorf("failed to update status for bet %d: %w", betID, errUpdateBet)
 		}
+		if newStatusID != cancelledStatusID {
+			if err := recordBonusWagering(tx, userID, betAmount); err != nil {
+				return err
+			}
+		}
 	}
 	if err := rows.Err(); err != nil {
 		return fmt.Errorf("error iterating bet rows for race %d: %w", raceID, err)
@@ -372,7 +587,8 @@ func settleBetsForRace(tx *sql.Tx, raceID int, winningChickenID int) error {
 	} else {
 		log.Printf("settleBetsForRace: Processed %d pending bets for race %d.", betsProcessedCount, raceID)
 	}
-	return nil
+	rows.Close()
+	return settleAccumulatorLegs(tx, raceID, positions)
 }
 
 // raceLoop is the main goroutine for managing the race lifecycle.
@@ -452,7 +668,7 @@ func raceLoop(db *sql.DB) {
 			// If no race is running, and (either no race is scheduled OR it wasn't time to start one yet OR finding/starting failed)
 			// then try to schedule a new one.
 			// The previous block handles starting due races. If it fell through, it means no race was started.
-			if rnCurrentRace == nil || rnCurrentRace.Status == RaceStatusFinished {
+			if rnCurrentRace == nil || rnCurrentRace.Status == RaceStatusFinished || rnCurrentRace.Status == RaceStatusCancelled {
 				// If rnNextRaceStartTime is set but the race failed to start (e.g. ErrNoRows from query above),
 				// we might want to clear rnNextRaceStartTime before calling scheduleNewRace
 				// to ensure it doesn't just find the same "stuck" race again.
@@ -514,16 +730,30 @@ func cleanupStaleScheduledRaces(db *sql.DB) error {
 		log.Printf("cleanupStaleScheduledRaces: Error querying for stale scheduled races (threshold: >%v from now): %v", cleanupThresholdDuration, err)
 		return err
 	}
-	defer rows.Close()
-
-	staleCount := 0
+	// The races are collected first: cancelling one writes to the database, which cannot commit
+	// while this query still holds its read.
+	type staleRace struct {
+		id            int
+		name, dateStr string
+	}
+	var staleRaces []staleRace
 	for rows.Next() {
-		var raceID int
-		var raceName, dateStr string
-		if err := rows.Scan(&raceID, &raceName, &dateStr); err != nil {
+		var race staleRace
+		if err := rows.Scan(&race.id, &race.name, &race.dateStr); err != nil {
 			log.Printf("cleanupStaleScheduledRaces: Error scanning race row: %v", err)
 			continue // Skip this row, try next
 		}
+		staleRaces = append(staleRaces, race)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil { // Check for errors encountered during iteration
+		log.Printf("cleanupStaleScheduledRaces: Error after iterating rows: %v", err)
+		return err // Return this error as it might indicate a problem with the result set
+	}
+
+	staleCount := 0
+	for _, race := range staleRaces {
+		raceID, raceName, dateStr := race.id, race.name, race.dateStr
 
 		// Log details about the race being cleaned up.
 		// parseRaceDate is assumed to correctly parse the dateStr from the DB.
@@ -533,30 +763,25 @@ func cleanupStaleScheduledRaces(db *sql.DB) error {
 		logMessagePrefix := fmt.Sprintf("cleanupStaleScheduledRaces: Race ID %d ('%s')", raceID, raceName)
 
 		if pErr != nil {
-			log.Printf("%s: Error parsing date '%s': %v. Query selected it as stale (scheduled beyond %v from now). Proceeding with removal.",
+			log.Printf("%s: Error parsing date '%s': %v. Query selected it as stale (scheduled beyond %v from now). Proceeding with cancellation.",
 				logMessagePrefix, dateStr, pErr, cleanupThresholdDuration)
 		} else {
-			log.Printf("%s: Found scheduled too far in the future. Scheduled for: %v (%v from now). Threshold is >%v from now. Removing.",
+			log.Printf("%s: Found scheduled too far in the future. Scheduled for: %v (%v from now). Threshold is >%v from now. Cancelling.",
 				logMessagePrefix, parsedTime, time.Until(parsedTime), cleanupThresholdDuration)
 		}
 
-		_, delErr := db.Exec("DELETE FROM races WHERE id = ? AND status = ?", raceID, RaceStatusScheduled)
-		if delErr != nil {
-			log.Printf("%s: Error deleting stale race: %v", logMessagePrefix, delErr)
+		cancelErr := cancelRaceByID(db, raceID, fmt.Sprintf("Stale: scheduled more than %v ahead", cleanupThresholdDuration))
+		if cancelErr != nil {
+			log.Printf("%s: Error cancelling stale race: %v", logMessagePrefix, cancelErr)
 			// Potentially return error here or continue to try cleaning others
 		} else {
-			log.Printf("%s: Successfully removed.", logMessagePrefix)
+			log.Printf("%s: Successfully cancelled.", logMessagePrefix)
 			staleCount++
 		}
 	}
 
-	if err := rows.Err(); err != nil { // Check for errors encountered during iteration
-		log.Printf("cleanupStaleScheduledRaces: Error after iterating rows: %v", err)
-		return err // Return this error as it might indicate a problem with the result set
-	}
-
 	if staleCount > 0 {
-		log.Printf("cleanupStaleScheduledRaces: Finished cleanup. Removed %d stale scheduled races (older than %v from now).", staleCount, cleanupThresholdDuration)
+		log.Printf("cleanupStaleScheduledRaces: Finished cleanup. Cancelled %d stale scheduled races (older than %v from now).", staleCount, cleanupThresholdDuration)
 	}
 	// Optional: log when no stale races are found for verbosity
 	// else {
@@ -0,0 +1,141 @@
+package main
+
+import "testing"
+
+// scheduledTestRace is the race init_database.sql opens for betting, with chickens 1 to 5 entered.
+// Its sample bet is user 1's 100 on chicken 2.
+const scheduledTestRace = 3
+
+// placeTestBet books a fixed-odds bet the way placeBetHandler does and returns its ID.
+func placeTestBet(t *testing.T, userID, raceID int, betType string, selection []int, stake Money, odds float64) int {
+	t.Helper()
+	pendingStatusID, err := getPendingBetStatusID(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.Exec("INSERT INTO bets (user_id, race_id, chicken_id, bet_type, bet_amount, bet_status_id, odds, potential_payout) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, raceID, selection[0], betType, stake, pendingStatusID, odds, fixedOddsPayout(stake, odds))
+	if err != nil {
+		t.Fatalf("placing %s bet on %v: %v", betType, selection, err)
+	}
+	betID, _ := result.LastInsertId()
+	if err := insertBetSelections(db, betID, selection); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := postWalletTransaction(db, WalletTransaction{UserID: userID, Kind: WalletTxStake, Amount: -stake, BetID: int(betID), RaceID: raceID}); err != nil {
+		t.Fatal(err)
+	}
+	return int(betID)
+}
+
+// settleTestRace records a race's finishing order, first to last, and settles its bets.
+func settleTestRace(t *testing.T, raceID int, order ...int) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := recordRaceResult(tx, raceID, order); err != nil {
+		t.Fatal(err)
+	}
+	if err := settleBetsForRace(tx, raceID); err != nil {
+		t.Fatalf("settling race %d: %v", raceID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// betOutcome returns the status of a bet and what it paid out.
+func betOutcome(t *testing.T, betID int) (string, Money) {
+	t.Helper()
+	var status string
+	var payout Money
+	err := db.QueryRow(`
+        SELECT s.status_name, COALESCE(b.actual_payout, 0)
+        FROM bets b JOIN bet_statuses s ON b.bet_status_id = s.id
+        WHERE b.id = ?
+    `, betID).Scan(&status, &payout)
+	if err != nil {
+		t.Fatalf("loading bet %d: %v", betID, err)
+	}
+	return status, payout
+}
+
+// checkBalance compares a user's balance.
+func checkBalance(t *testing.T, userID int, want Money) {
+	t.Helper()
+	var balance Money
+	if err := db.QueryRow("SELECT balance FROM users WHERE id = ?", userID).Scan(&balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance != want {
+		t.Errorf("user %d: balance %s, want %s", userID, balance, want)
+	}
+}
+
+func TestSettlePlaceAndShowBets(t *testing.T) {
+	setupTestDB(t)
+	const punter = 2 // Jane Smith, with 1000 credits
+
+	bets := []struct {
+		betType    string
+		chickenID  int
+		odds       float64
+		wantStatus string
+		wantPayout Money
+	}{
+		{BetTypePlace, 2, 1.40, "Won", 14 * Credit}, // First
+		{BetTypePlace, 5, 1.60, "Won", 16 * Credit}, // Second
+		{BetTypePlace, 1, 1.75, "Lost", 0},          // Third
+		{BetTypeShow, 1, 1.30, "Won", 13 * Credit},  // Third
+		{BetTypeShow, 3, 1.50, "Lost", 0},           // Fourth
+		{BetTypeWin, 5, 2.20, "Lost", 0},            // Second
+	}
+	ids := make([]int, len(bets))
+	for i, b := range bets {
+		ids[i] = placeTestBet(t, punter, scheduledTestRace, b.betType, []int{b.chickenID}, 10*Credit, b.odds)
+	}
+	settleTestRace(t, scheduledTestRace, 2, 5, 1, 3, 4)
+
+	for i, b := range bets {
+		status, payout := betOutcome(t, ids[i])
+		if status != b.wantStatus || payout != b.wantPayout {
+			t.Errorf("%s on chicken %d: %s paying %s, want %s paying %s", b.betType, b.chickenID, status, payout, b.wantStatus, b.wantPayout)
+		}
+	}
+	checkBalance(t, punter, (1000-60+43)*Credit)
+}
+
+func TestSettleCombinationBets(t *testing.T) {
+	setupTestDB(t)
+	const punter = 2
+
+	bets := []struct {
+		betType    string
+		selection  []int
+		odds       float64
+		wantStatus string
+		wantPayout Money
+	}{
+		{BetTypeExacta, []int{4, 2}, 12.0, "Won", 60 * Credit},
+		{BetTypeExacta, []int{2, 4}, 8.0, "Lost", 0},
+		{BetTypeQuinella, []int{2, 4}, 5.0, "Won", 25 * Credit},
+		{BetTypeQuinella, []int{4, 1}, 6.0, "Lost", 0},
+		{BetTypeTrifecta, []int{4, 2, 1}, 40.0, "Won", 200 * Credit},
+		{BetTypeTrifecta, []int{4, 1, 2}, 40.0, "Lost", 0},
+	}
+	ids := make([]int, len(bets))
+	for i, b := range bets {
+		ids[i] = placeTestBet(t, punter, scheduledTestRace, b.betType, b.selection, 5*Credit, b.odds)
+	}
+	settleTestRace(t, scheduledTestRace, 4, 2, 1, 5, 3)
+
+	for i, b := range bets {
+		status, payout := betOutcome(t, ids[i])
+		if status != b.wantStatus || payout != b.wantPayout {
+			t.Errorf("%s on %v: %s paying %s, want %s paying %s", b.betType, b.selection, status, payout, b.wantStatus, b.wantPayout)
+		}
+	}
+}
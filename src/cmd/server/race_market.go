@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Bet modes, stored per race in races.bet_mode.
+const (
+	BetModeFixedOdds = "FixedOdds" // Winners are paid at the price they bet at
+	BetModeTote      = "Tote"      // Pari-mutuel: winners share the pool, less the takeout
+)
+
+// toteMinDividend is the smallest tote dividend paid per credit staked, so a winning ticket never loses money.
+const toteMinDividend = 1.0
+
+var (
+	// defaultBetMode is the mode given to newly scheduled races. Admins can switch a race to the other mode
+	// from the dashboard until it takes its first bet.
+	defaultBetMode = getEnvOrDefault("DEFAULT_BET_MODE", BetModeFixedOdds)
+	// toteTakeout is the share of each tote pool kept by the house, frozen on the race when it is scheduled.
+	toteTakeout = getEnvFloatOrDefault("TOTE_TAKEOUT", 0.15)
+)
+
+// MarketEntrant is a chicken in the betting market, with its share of the tote pool.
+type MarketEntrant struct {
+	Chicken
+	PoolStake Money   // Total staked on this chicken (tote races)
+	Dividend  float64 // Approximate tote dividend per credit staked, 0 while nobody has backed the chicken
+}
+
+// RaceMarket is the betting market of a race: its field, how it is settled and, for tote races, the pool.
+type RaceMarket struct {
+	RaceID    int
+	BetMode   string
+	Takeout   float64
+	PoolTotal Money
+	Entrants  []MarketEntrant
+}
+
+// IsTote reports whether the race is settled from a pari-mutuel pool.
+func (m *RaceMarket) IsTote() bool {
+	return m.BetMode == BetModeTote
+}
+
+// TakeoutPercent returns the takeout as a percentage, for display.
+func (m *RaceMarket) TakeoutPercent() float64 {
+	return m.Takeout * 100
+}
+
+// Entrant looks up a chicken in the market.
+func (m *RaceMarket) Entrant(chickenID int) (MarketEntrant, bool) {
+	for _, e := range m.Entrants {
+		if e.ID == chickenID {
+			return e, true
+		}
+	}
+	return MarketEntrant{}, false
+}
+
+// Offers reports whether the market takes bets of the given type. Tote races only run a win pool, and a
+// bet that pays on more places than there are other runners is not offered, as every runner would collect.
+func (m *RaceMarket) Offers(betType string) bool {
+	if m.IsTote() {
+		return betType == BetTypeWin
+	}
+	return len(m.Entrants) > placesPaid(betType)
+}
+
+// selectionIndices validates a bet's selection against the market and returns the entrants' indices, in order.
+func (m *RaceMarket) selectionIndices(betType string, selection []int) ([]int, error) {
+	if !m.Offers(betType) {
+		return nil, errBetTypeNotOffered
+	}
+	if err := checkSelection(betType, selection); err != nil {
+		return nil, err
+	}
+	indices := make([]int, len(selection))
+	for i, chickenID := range selection {
+		indices[i] = -1
+		for j, e := range m.Entrants {
+			if e.ID == chickenID {
+				indices[i] = j
+			}
+		}
+		if indices[i] < 0 {
+			return nil, errEntrantNotFound
+		}
+	}
+	return indices, nil
+}
+
+// OddsFor returns the fixed-odds price currently offered on a selection.
+// Combination bets are priced from the starting-price probabilities with the Harville model.
+func (m *RaceMarket) OddsFor(betType string, selection []int) (float64, error) {
+	indices, err := m.selectionIndices(betType, selection)
+	if err != nil {
+		return 0, err
+	}
+	if !isCombinationBet(betType) {
+		return m.Entrants[indices[0]].OddsFor(betType), nil
+	}
+	field := make([]Chicken, len(m.Entrants))
+	for i, e := range m.Entrants {
+		field[i] = e.Chicken
+	}
+	return priceFromProbability(combinationProbability(winProbabilities(field), betType, indices), houseMargin), nil
+}
+
+// SelectionNames returns the names of the chickens in a selection, in order.
+func (m *RaceMarket) SelectionNames(selection []int) []string {
+	names := make([]string, 0, len(selection))
+	for _, chickenID := range selection {
+		if e, ok := m.Entrant(chickenID); ok {
+			names = append(names, e.Name)
+		}
+	}
+	return names
+}
+
+// ApproxReturn estimates the total return of a winning bet if it were placed now.
+// Tote returns include the bet's own effect on the pool but will still move with later bets.
+func (m *RaceMarket) ApproxReturn(betType string, selection []int, stake Money) (Money, error) {
+	if !m.IsTote() {
+		odds, err := m.OddsFor(betType, selection)
+		if err != nil {
+			return 0, err
+		}
+		return fixedOddsPayout(stake, odds), nil
+	}
+	indices, err := m.selectionIndices(betType, selection)
+	if err != nil {
+		return 0, err
+	}
+	e := m.Entrants[indices[0]]
+	return toteTicketPayout(stake, toteDividend(m.PoolTotal+stake, m.Takeout, e.PoolStake+stake)), nil
+}
+
+// toteDividend is the return per credit on the winner: the pool less the takeout, shared by the winning stakes.
+// Dividends are rounded down to 2 decimals; the breakage stays with the house.
+func toteDividend(poolTotal Money, takeout float64, winningStake Money) float64 {
+	if winningStake <= 0 {
+		return 0
+	}
+	// The epsilon keeps exact dividends such as 2.55 from flooring to 2.54 through float error.
+	dividend := math.Floor(float64(poolTotal)*(1-takeout)/float64(winningStake)*oddsPrecision+1e-9) / oddsPrecision
+	return math.Max(dividend, toteMinDividend)
+}
+
+// toteTicketPayout is the payout of a winning tote ticket, rounded down to the cent.
+func toteTicketPayout(stake Money, dividend float64) Money {
+	return stake.MulOdds(dividend)
+}
+
+// getRaceBetMode returns how a race is settled and its tote takeout.
+func getRaceBetMode(q rowQuerier, raceID int) (string, float64, error) {
+	var mode string
+	var takeout float64
+	err := q.QueryRow("SELECT bet_mode, takeout FROM races WHERE id = ?", raceID).Scan(&mode, &takeout)
+	if err != nil {
+		return "", 0, fmt.Errorf("error fetching bet mode for race %d: %w", raceID, err)
+	}
+	return mode, takeout, nil
+}
+
+// getRacePoolStakes returns the total staked on each chicken in a race, keyed by chicken ID.
+// Cancelled bets have been refunded and are not part of the pool.
+func getRacePoolStakes(q querier, raceID int) (map[int]Money, error) {
+	rows, err := q.Query(`
+        SELECT b.chicken_id, SUM(b.bet_amount)
+        FROM bets b
+        JOIN bet_statuses s ON b.bet_status_id = s.id
+        WHERE b.race_id = ? AND b.bet_type = 'Win' AND s.status_name NOT IN ('Cancelled', 'Cashed Out')
+        GROUP BY b.chicken_id
+    `, raceID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying pool for race %d: %w", raceID, err)
+	}
+	defer rows.Close()
+
+	stakes := make(map[int]Money)
+	for rows.Next() {
+		var chickenID int
+		var total Money
+		if err := rows.Scan(&chickenID, &total); err != nil {
+			return nil, fmt.Errorf("error scanning pool for race %d: %w", raceID, err)
+		}
+		stakes[chickenID] = total
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating pool for race %d: %w", raceID, err)
+	}
+	return stakes, nil
+}
+
+// getRaceMarket loads the betting market of a race.
+func getRaceMarket(q querier, raceID int) (*RaceMarket, error) {
+	mode, takeout, err := getRaceBetMode(q, raceID)
+	if err != nil {
+		return nil, err
+	}
+	entrants, err := getRaceEntrants(q, raceID)
+	if err != nil {
+		return nil, err
+	}
+	market := &RaceMarket{RaceID: raceID, BetMode: mode, Takeout: takeout}
+
+	stakes := map[int]Money{}
+	if market.IsTote() {
+		if stakes, err = getRacePoolStakes(q, raceID); err != nil {
+			return nil, err
+		}
+		for _, s := range stakes {
+			market.PoolTotal += s
+		}
+	}
+	for _, ch := range entrants {
+		e := MarketEntrant{Chicken: ch, PoolStake: stakes[ch.ID]}
+		if market.IsTote() {
+			e.Dividend = toteDividend(market.PoolTotal, takeout, e.PoolStake)
+		}
+		market.Entrants = append(market.Entrants, e)
+	}
+	return market, nil
+}
+
+// getBettingMarket returns the market of the race currently open for betting.
+func getBettingMarket(q querier) (*RaceMarket, error) {
+	raceID, err := getActiveRaceID(q)
+	if err != nil {
+		return nil, err
+	}
+	return getRaceMarket(q, raceID)
+}
+
+// newRaceBetMode returns the bet mode and takeout for a race being scheduled.
+func newRaceBetMode() (string, float64) {
+	if defaultBetMode == BetModeTote {
+		return BetModeTote, toteTakeout
+	}
+	return BetModeFixedOdds, 0
+}
+
+// betModeTakeout returns the takeout a race in the given bet mode is run with, and false for an unknown mode.
+func betModeTakeout(betMode string) (float64, bool) {
+	switch betMode {
+	case BetModeTote:
+		return toteTakeout, true
+	case BetModeFixedOdds:
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToteDividend(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		pool         Money
+		takeout      float64
+		winningStake Money
+		want         float64
+	}{
+		{"no takeout", 500 * Credit, 0, 250 * Credit, 2.00},
+		{"takeout comes off the pool", 1000 * Credit, 0.15, 400 * Credit, 2.12},
+		{"exact dividend is not floored a cent", 300 * Credit, 0.15, 100 * Credit, 2.55},
+		{"breakage is floored", 1000 * Credit, 0.10, 700 * Credit, 1.28},
+		{"favourite everyone backed pays the minimum", 100 * Credit, 0.15, 100 * Credit, toteMinDividend},
+		{"nobody backed the winner", 100 * Credit, 0.15, 0, 0},
+	} {
+		if got := toteDividend(tc.pool, tc.takeout, tc.winningStake); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: toteDividend(%s, %.2f, %s) = %.4f, want %.2f", tc.name, tc.pool, tc.takeout, tc.winningStake, got, tc.want)
+		}
+	}
+
+	// A quote includes the bet's own stake in the pool and on the chicken.
+	market := &RaceMarket{BetMode: BetModeTote, Takeout: 0.1, PoolTotal: 900 * Credit, Entrants: []MarketEntrant{
+		{Chicken: Chicken{ID: 1}, PoolStake: 300 * Credit},
+	}}
+	if got, err := market.ApproxReturn(BetTypeWin, []int{1}, 100*Credit); err != nil || got != 225*Credit {
+		t.Errorf("ApproxReturn = %s, %v, want 225.00 (1000 less 10%%, shared by 400)", got, err)
+	}
+}
+
+// addToteTestBet adds a bet on the scheduled test race in the given status.
+func addToteTestBet(t *testing.T, userID, chickenID int, amount Money, status string) int {
+	t.Helper()
+	result, err := db.Exec("INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id) VALUES (?, ?, ?, ?, (SELECT id FROM bet_statuses WHERE status_name = ?))",
+		userID, scheduledTestRace, chickenID, amount, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+// settleToteTestRace makes the scheduled test race a tote race and settles it in the given finishing order.
+func settleToteTestRace(t *testing.T, takeout float64, order ...int) {
+	t.Helper()
+	if _, err := db.Exec("UPDATE races SET bet_mode = ?, takeout = ? WHERE id = ?", BetModeTote, takeout, scheduledTestRace); err != nil {
+		t.Fatal(err)
+	}
+	settleTestRace(t, scheduledTestRace, order...)
+}
+
+// checkToteTestBet compares a settled bet's status and payout.
+func checkToteTestBet(t *testing.T, betID int, wantStatus string, wantPayout Money) {
+	t.Helper()
+	if status, payout := betOutcome(t, betID); status != wantStatus || payout != wantPayout {
+		t.Errorf("bet %d: %s paying %s, want %s paying %s", betID, status, payout, wantStatus, wantPayout)
+	}
+}
+
+func TestGetRacePoolStakes(t *testing.T) {
+	setupTestDB(t)
+	addToteTestBet(t, 2, 2, 50*Credit, "Pending")
+	addToteTestBet(t, 2, 1, 150*Credit, "Pending")
+	addToteTestBet(t, 1, 3, 200*Credit, "Cancelled")       // Refunded, so out of the pool
+	addToteTestBet(t, 2, 4, 80*Credit, BetStatusCashedOut) // Paid back early, so out of the pool too
+
+	stakes, err := getRacePoolStakes(db, scheduledTestRace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]Money{1: 150 * Credit, 2: 150 * Credit}
+	if len(stakes) != len(want) || stakes[1] != want[1] || stakes[2] != want[2] {
+		t.Errorf("pool stakes %v, want %v", stakes, want)
+	}
+}
+
+func TestSettleToteRace(t *testing.T) {
+	setupTestDB(t)
+	const sampleBet = 3 // User 1's 100 on chicken 2
+	second := addToteTestBet(t, 2, 2, 50*Credit, "Pending")
+	loser := addToteTestBet(t, 2, 1, 150*Credit, "Pending")
+	cancelled := addToteTestBet(t, 1, 3, 200*Credit, "Cancelled")
+
+	// Pool 300 less 10% is 270, shared by the 150 on chicken 2: a 1.80 dividend.
+	settleToteTestRace(t, 0.10, 2, 1, 3, 4, 5)
+	checkToteTestBet(t, sampleBet, "Won", 180*Credit)
+	checkToteTestBet(t, second, "Won", 90*Credit)
+	checkToteTestBet(t, loser, "Lost", 0)
+	checkToteTestBet(t, cancelled, "Cancelled", 0)
+	checkBalance(t, 1, (1000+180)*Credit)
+	checkBalance(t, 2, (1000+90)*Credit)
+}
+
+func TestSettleToteRaceNobodyBackedTheWinner(t *testing.T) {
+	setupTestDB(t)
+	const sampleBet = 3
+	other := addToteTestBet(t, 2, 1, 150*Credit, "Pending")
+
+	// Nobody backed chicken 4, so the pool cannot be shared and every ticket is refunded.
+	settleToteTestRace(t, 0.15, 4, 1, 2, 3, 5)
+	checkToteTestBet(t, sampleBet, "Cancelled", 100*Credit)
+	checkToteTestBet(t, other, "Cancelled", 150*Credit)
+	checkBalance(t, 1, (1000+100)*Credit)
+	checkBalance(t, 2, (1000+150)*Credit)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Odds tuning
+const (
+	minOdds       = 1.01 // Shortest price ever offered
+	oddsPrecision = 100  // Prices are rounded down to 2 decimals
+)
+
+// houseMargin is the overround built into every price: a 0.05 margin means a 95% return to player.
+var houseMargin = getEnvFloatOrDefault("HOUSE_MARGIN", 0.05)
+
+// impliedProbabilities turns a field of decimal odds into win probabilities that sum to 1.
+// Any overround in the odds is removed proportionally.
+func impliedProbabilities(odds []float64) []float64 {
+	probs := make([]float64, len(odds))
+	total := 0.0
+	for i, o := range odds {
+		if o < 1 {
+			o = 1
+		}
+		probs[i] = 1 / o
+		total += probs[i]
+	}
+	if total == 0 {
+		return probs
+	}
+	for i := range probs {
+		probs[i] /= total
+	}
+	return probs
+}
+
+// priceFromProbability quotes decimal odds for an outcome with probability p, including the house margin.
+// Prices are rounded down so rounding never works against the house.
+func priceFromProbability(p float64, margin float64) float64 {
+	if p <= 0 {
+		return minOdds
+	}
+	fair := 1 / (p * (1 + margin))
+	price := math.Floor(fair*oddsPrecision) / oddsPrecision
+	return math.Max(price, minOdds)
+}
+
+// winProbabilities returns the win probability of each entrant, derived from the quoted prices.
+func winProbabilities(entrants []Chicken) []float64 {
+	odds := make([]float64, len(entrants))
+	for i, ch := range entrants {
+		odds[i] = ch.Odds
+	}
+	return impliedProbabilities(odds)
+}
+
+// priceField sets each entrant's starting win, place and show prices from the chickens' base odds and the house margin.
+// The base odds only express relative strength; the field is re-normalized because it changes race to race.
+func priceField(entrants []Chicken, margin float64) {
+	probs := winProbabilities(entrants)
+	placeProbs := topFinishProbabilities(probs, placesPaid(BetTypePlace))
+	showProbs := topFinishProbabilities(probs, placesPaid(BetTypeShow))
+	for i := range entrants {
+		entrants[i].Odds = priceFromProbability(probs[i], margin)
+		entrants[i].PlaceOdds = priceFromProbability(placeProbs[i], margin)
+		entrants[i].ShowOdds = priceFromProbability(showProbs[i], margin)
+	}
+}
+
+// orderProbability is the Harville probability that the chickens at the given indices finish
+// first, second, third... in that order: each place is won in proportion to the win probabilities
+// of the chickens still running.
+func orderProbability(probs []float64, order []int) float64 {
+	p := 1.0
+	remaining := 1.0
+	for _, i := range order {
+		if remaining <= 0 {
+			return 0
+		}
+		p *= probs[i] / remaining
+		remaining -= probs[i]
+	}
+	return p
+}
+
+// combinationProbability is the Harville probability that a combination bet on the chickens at the
+// given indices comes in.
+func combinationProbability(probs []float64, betType string, order []int) float64 {
+	if betType == BetTypeQuinella && len(order) == 2 {
+		return orderProbability(probs, order) + orderProbability(probs, []int{order[1], order[0]})
+	}
+	return orderProbability(probs, order)
+}
+
+// topFinishProbabilities returns the probability of each chicken finishing in the first places,
+// by summing the Harville probability of every ordered top-places finish it appears in.
+func topFinishProbabilities(probs []float64, places int) []float64 {
+	out := make([]float64, len(probs))
+	if places >= len(probs) {
+		for i := range out {
+			out[i] = 1
+		}
+		return out
+	}
+	var walk func(order []int, used []bool)
+	walk = func(order []int, used []bool) {
+		if len(order) == places {
+			p := orderProbability(probs, order)
+			for _, i := range order {
+				out[i] += p
+			}
+			return
+		}
+		for i := range probs {
+			if used[i] {
+				continue
+			}
+			used[i] = true
+			walk(append(order, i), used)
+			used[i] = false
+		}
+	}
+	walk(make([]int, 0, places), make([]bool, len(probs)))
+	return out
+}
+
+// drawFinishingOrder draws a complete finishing order (as indices into probs).
+// Each place is drawn in proportion to the win probabilities of the chickens still running (Harville model).
+func drawFinishingOrder(probs []float64, rng *rand.Rand) []int {
+	remaining := make([]int, len(probs))
+	for i := range remaining {
+		remaining[i] = i
+	}
+	order := make([]int, 0, len(probs))
+	for len(remaining) > 0 {
+		total := 0.0
+		for _, i := range remaining {
+			total += probs[i]
+		}
+		pick := len(remaining) - 1
+		if total > 0 {
+			r := rng.Float64() * total
+			for k, i := range remaining {
+				r -= probs[i]
+				if r < 0 {
+					pick = k
+					break
+				}
+			}
+		} else {
+			pick = rng.Intn(len(remaining))
+		}
+		order = append(order, remaining[pick])
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+	}
+	return order
+}
+
+// fixedOddsPayout is the total return (stake included) of a winning fixed-odds bet, rounded down to the cent.
+func fixedOddsPayout(stake Money, odds float64) Money {
+	return stake.MulOdds(odds)
+}
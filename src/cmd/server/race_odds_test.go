@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// testField mirrors the chickens seeded by init_database.sql.
+func testField() []Chicken {
+	return []Chicken{
+		{ID: 1, Name: "Henrietta", Odds: 2.5, Lane: 1, Speed: 6.2, Acceleration: 2.0, Stamina: 0.75},
+		{ID: 2, Name: "Cluck Norris", Odds: 1.8, Lane: 2, Speed: 6.6, Acceleration: 2.4, Stamina: 0.80},
+		{ID: 3, Name: "Foghorn Leghorn Jr.", Odds: 3.0, Lane: 3, Speed: 6.0, Acceleration: 1.8, Stamina: 0.70},
+		{ID: 4, Name: "The Eggsecutioner", Odds: 4.5, Lane: 4, Speed: 5.6, Acceleration: 2.6, Stamina: 0.60},
+		{ID: 5, Name: "Speedy Gonzales", Odds: 2.2, Lane: 5, Speed: 6.4, Acceleration: 2.8, Stamina: 0.65},
+	}
+}
+
+func TestPriceFieldAppliesHouseMargin(t *testing.T) {
+	const margin = 0.05
+	field := testField()
+	priceField(field, margin)
+
+	book := 0.0
+	for _, ch := range field {
+		if ch.Odds < minOdds {
+			t.Errorf("chicken %d priced at %.2f, below the minimum of %.2f", ch.ID, ch.Odds, minOdds)
+		}
+		book += 1 / ch.Odds
+	}
+	// Rounding prices down can only add to the overround.
+	if book < 1+margin-1e-9 || book > 1+margin+0.01 {
+		t.Errorf("book overround = %.4f, want about %.4f", book, 1+margin)
+	}
+}
+
+func TestTopFinishProbabilities(t *testing.T) {
+	probs := winProbabilities(testField())
+	for places := 1; places <= 3; places++ {
+		top := topFinishProbabilities(probs, places)
+		sum := 0.0
+		for i, p := range top {
+			if p < probs[i]-1e-12 || p > 1 {
+				t.Errorf("places %d: chicken %d has probability %.4f, want between %.4f and 1", places, i, p, probs[i])
+			}
+			sum += p
+		}
+		// Exactly `places` chickens finish in the first `places` positions.
+		if math.Abs(sum-float64(places)) > 1e-9 {
+			t.Errorf("places %d: probabilities sum to %.6f, want %d", places, sum, places)
+		}
+	}
+}
+
+func TestCombinationProbability(t *testing.T) {
+	probs := winProbabilities(testField())
+	n := len(probs)
+
+	// Every ordered finish is counted once, so each kind of bet sums to 1 over all its selections.
+	var exactas, quinellas, trifectas float64
+	for a := range n {
+		for b := range n {
+			if b == a {
+				continue
+			}
+			exacta := combinationProbability(probs, BetTypeExacta, []int{a, b})
+			exactas += exacta
+			quinella := combinationProbability(probs, BetTypeQuinella, []int{a, b})
+			if reverse := combinationProbability(probs, BetTypeExacta, []int{b, a}); math.Abs(quinella-exacta-reverse) > 1e-12 {
+				t.Errorf("quinella %d-%d = %.6f, want the two exactas %.6f + %.6f", a, b, quinella, exacta, reverse)
+			}
+			if a < b {
+				quinellas += quinella
+			}
+			for c := range n {
+				if c != a && c != b {
+					trifectas += combinationProbability(probs, BetTypeTrifecta, []int{a, b, c})
+				}
+			}
+		}
+	}
+	for name, sum := range map[string]float64{"exacta": exactas, "quinella": quinellas, "trifecta": trifectas} {
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("%s probabilities sum to %.6f, want 1", name, sum)
+		}
+	}
+
+	// The favourite leading is likelier than the same pair the other way round.
+	if fav, outsider := 1, 3; combinationProbability(probs, BetTypeExacta, []int{fav, outsider}) <= combinationProbability(probs, BetTypeExacta, []int{outsider, fav}) {
+		t.Error("exacta led by the outsider is priced as likely as the one led by the favourite")
+	}
+}
+
+func TestSimulationTrajectoryMatchesFinishingOrder(t *testing.T) {
+	field := testField()
+	priceField(field, houseMargin)
+
+	for seed := int64(1); seed <= 200; seed++ {
+		sim := simulateRace(field, seed)
+		if len(sim.FinishOrder) != len(field) {
+			t.Fatalf("seed %d: finishing order has %d chickens, want %d", seed, len(sim.FinishOrder), len(field))
+		}
+		final := sim.DistancesAt(1)
+		for i, d := range final {
+			if math.Abs(d-simTrackLength) > 1e-6 {
+				t.Fatalf("seed %d: chicken %d ends at %.3f, not on the finish line", seed, field[i].ID, d)
+			}
+		}
+		// Every chicken must cross the line in the order the simulation reports.
+		byID := make(map[int]float64)
+		for i, ch := range field {
+			byID[ch.ID] = sim.FinishTimes[i]
+		}
+		for place := 1; place < len(sim.FinishOrder); place++ {
+			if byID[sim.FinishOrder[place-1]] > byID[sim.FinishOrder[place]] {
+				t.Fatalf("seed %d: chicken %d placed ahead of %d but finished later", seed, sim.FinishOrder[place-1], sim.FinishOrder[place])
+			}
+		}
+	}
+}
+
+// TestReturnToPlayer simulates many races with a unit stake on every chicken and checks that
+// each chicken, and the book as a whole, returns about 1/(1+margin) of the money staked.
+func TestReturnToPlayer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("statistical test skipped in short mode")
+	}
+	const races = 20000
+	const margin = 0.05
+	field := testField()
+	priceField(field, margin)
+
+	returned := make(map[int]float64)
+	for seed := int64(1); seed <= races; seed++ {
+		sim := simulateRace(field, seed)
+		for _, ch := range field {
+			if ch.ID == sim.WinnerID() {
+				returned[ch.ID] += fixedOddsPayout(Credit, ch.Odds).Float()
+			}
+		}
+	}
+
+	want := 1 / (1 + margin)
+	total := 0.0
+	for _, ch := range field {
+		rtp := returned[ch.ID] / races
+		total += returned[ch.ID]
+		// About three standard deviations for the longest price in the field.
+		if math.Abs(rtp-want) > 0.06 {
+			t.Errorf("chicken %d (odds %.2f): return to player %.3f, want %.3f +/- 0.06", ch.ID, ch.Odds, rtp, want)
+		}
+	}
+	overall := total / (races * float64(len(field)))
+	if math.Abs(overall-want) > 0.02 {
+		t.Errorf("overall return to player %.4f, want %.4f +/- 0.02", overall, want)
+	}
+	if overall >= 1 {
+		t.Errorf("overall return to player %.4f: the house loses money", overall)
+	}
+	t.Logf("return to player over %d races: %.4f (target %.4f)", races, overall, want)
+}
+
+func TestCashOutValue(t *testing.T) {
+	tests := []struct {
+		name          string
+		stake         Money
+		struck, price float64
+		want          Money
+	}{
+		{"price unchanged", 1000, 3, 3, 950},
+		{"price drifted", 1000, 3, 6, 475},
+		{"price shortened pays more than the stake", 1000, 3, 1.5, 1900},
+		{"rounded down to the cent", 1001, 3, 7, 407},
+	}
+	for _, tt := range tests {
+		if got := cashOutValue(tt.stake, tt.struck, tt.price, 0.05); got != tt.want {
+			t.Errorf("%s: cashOutValue(%v, %v, %v) = %v, want %v", tt.name, tt.stake, tt.struck, tt.price, got, tt.want)
+		}
+	}
+}
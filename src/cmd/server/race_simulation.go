@@ -0,0 +1,244 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Simulation tuning. Distances are in track units, times in simulated seconds.
+const (
+	simTrackLength     = 100.0
+	simTickSeconds     = 0.1
+	simMaxTicks        = 3000  // Safety net: a field that cannot finish in 300s is stopped
+	simStumbleChance   = 0.004 // Per tick chance of a stumble
+	simStumbleSpeed    = 0.35  // Fraction of speed kept after a stumble
+	simBurstChance     = 0.003 // Per tick chance of a burst of speed
+	simBurstTicks      = 15    // Length of a burst in ticks
+	simBurstFactor     = 1.25  // Top speed multiplier during a burst
+	simFatigueFactor   = 0.6   // Top speed lost when running the full track on empty
+	simFormSpread      = 0.05  // Day-to-day form varies top speed by +/- this fraction
+	simTickNoiseSpread = 0.04  // Per tick noise on the target speed
+	simMinSpeed        = 0.5   // A chicken never stops completely
+	simFinishSentinel  = -1.0  // FinishTimes value for a chicken that did not finish
+	trackFinishPercent = 90.0  // Horizontal track position (in %) of the finish line
+)
+
+// RaceSimulation is the full, deterministic trajectory of a race, computed before it is shown.
+// The finishing order is drawn from the odds; the physics decide how the race unfolds on the way there.
+type RaceSimulation struct {
+	Seed        int64
+	Entrants    []Chicken
+	Frames      [][]float64 // Frames[tick][i] is the distance covered by Entrants[i] after tick
+	FinishTimes []float64   // Simulated seconds at which Entrants[i] crossed the line
+	FinishOrder []int       // Chicken IDs in finishing order
+}
+
+// simRunner is the per-chicken state while a simulation is running.
+type simRunner struct {
+	position   float64
+	velocity   float64
+	form       float64
+	burstTicks int
+	finishTime float64
+}
+
+// simulateRace runs the physics simulation tick by tick. The same entrants and seed always give the same race.
+//
+// The physics do not decide the result. The finishing order is drawn from the odds-implied win
+// probabilities first, and warpToOrder then re-times the simulated trajectories to fit it. Speed,
+// acceleration, stamina, stumbles and bursts shape how the race unfolds on the track, not who wins.
+func simulateRace(entrants []Chicken, seed int64) *RaceSimulation {
+	rng := rand.New(rand.NewSource(seed))
+	sim := &RaceSimulation{
+		Seed:        seed,
+		Entrants:    entrants,
+		FinishTimes: make([]float64, len(entrants)),
+	}
+
+	// The result is drawn from the win probabilities implied by the quoted odds, so prices and outcomes agree.
+	targetOrder := drawFinishingOrder(winProbabilities(entrants), rng)
+
+	runners := make([]simRunner, len(entrants))
+	for i := range runners {
+		runners[i].form = 1 + (rng.Float64()*2-1)*simFormSpread
+		runners[i].finishTime = simFinishSentinel
+	}
+
+	sim.Frames = append(sim.Frames, make([]float64, len(entrants)))
+	finished := 0
+	for tick := 1; tick <= simMaxTicks && finished < len(entrants); tick++ {
+		frame := make([]float64, len(entrants))
+		for i, ch := range entrants {
+			r := &runners[i]
+			if r.finishTime != simFinishSentinel {
+				frame[i] = simTrackLength
+				continue
+			}
+			stepRunner(r, ch, rng)
+
+			if r.position >= simTrackLength {
+				// Interpolate the crossing time inside the tick so close finishes are ordered correctly.
+				overshoot := (r.position - simTrackLength) / math.Max(r.velocity, simMinSpeed)
+				r.finishTime = float64(tick)*simTickSeconds - overshoot
+				r.position = simTrackLength
+				finished++
+			}
+			frame[i] = r.position
+		}
+		sim.Frames = append(sim.Frames, frame)
+	}
+
+	for i := range runners {
+		sim.FinishTimes[i] = runners[i].finishTime
+	}
+	sim.warpToOrder(targetOrder)
+	sim.FinishOrder = finishOrderFromTimes(entrants, sim.FinishTimes, sim.Frames[len(sim.Frames)-1])
+	return sim
+}
+
+// warpToOrder re-times each trajectory so the chickens cross the line in targetOrder (indices into Entrants).
+// The simulated finishing times are handed out in target order and each trajectory is stretched or
+// compressed in time to match, which keeps the shape of the run (starts, stumbles, bursts) intact.
+func (s *RaceSimulation) warpToOrder(targetOrder []int) {
+	if len(targetOrder) != len(s.Entrants) || len(s.Entrants) == 0 {
+		return
+	}
+	for _, t := range s.FinishTimes {
+		if t == simFinishSentinel || t <= 0 {
+			return // Cannot warp a field where someone did not finish
+		}
+	}
+
+	sortedTimes := append([]float64(nil), s.FinishTimes...)
+	sort.Float64s(sortedTimes)
+	newTimes := make([]float64, len(s.FinishTimes))
+	for place, i := range targetOrder {
+		newTimes[i] = sortedTimes[place]
+	}
+
+	original := s.Frames
+	lastTick := int(math.Ceil(sortedTimes[len(sortedTimes)-1]/simTickSeconds + 1e-9))
+	frames := make([][]float64, lastTick+1)
+	for tick := range frames {
+		frame := make([]float64, len(s.Entrants))
+		for i := range s.Entrants {
+			if float64(tick)*simTickSeconds >= newTimes[i] {
+				frame[i] = simTrackLength
+				continue
+			}
+			scale := s.FinishTimes[i] / newTimes[i]
+			frame[i] = distanceAtTick(original, i, float64(tick)*scale)
+		}
+		frames[tick] = frame
+	}
+	s.Frames = frames
+	s.FinishTimes = newTimes
+}
+
+// distanceAtTick interpolates an entrant's distance at a fractional tick of a trajectory.
+func distanceAtTick(frames [][]float64, entrant int, tick float64) float64 {
+	if tick <= 0 {
+		return frames[0][entrant]
+	}
+	lo := int(tick)
+	if lo >= len(frames)-1 {
+		return frames[len(frames)-1][entrant]
+	}
+	t := tick - float64(lo)
+	return frames[lo][entrant] + (frames[lo+1][entrant]-frames[lo][entrant])*t
+}
+
+// stepRunner advances one chicken by a single tick.
+func stepRunner(r *simRunner, ch Chicken, rng *rand.Rand) {
+	target := ch.Speed * r.form * (1 + (rng.Float64()*2-1)*simTickNoiseSpread)
+
+	// Fatigue sets in once the chicken has run past its stamina.
+	staminaDistance := ch.Stamina * simTrackLength
+	if r.position > staminaDistance {
+		target *= 1 - simFatigueFactor*(r.position-staminaDistance)/simTrackLength
+	}
+
+	// Random events
+	if r.burstTicks > 0 {
+		target *= simBurstFactor
+		r.burstTicks--
+	} else if rng.Float64() < simBurstChance {
+		r.burstTicks = simBurstTicks
+	}
+	if rng.Float64() < simStumbleChance {
+		r.velocity *= simStumbleSpeed
+	}
+
+	maxChange := ch.Acceleration * simTickSeconds
+	if r.velocity < target {
+		r.velocity = math.Min(r.velocity+maxChange, target)
+	} else {
+		r.velocity = math.Max(r.velocity-maxChange, target)
+	}
+	r.velocity = math.Max(r.velocity, simMinSpeed)
+	r.position += r.velocity * simTickSeconds
+}
+
+// finishOrderFromTimes sorts chicken IDs by finishing time. Non-finishers are ranked by distance covered.
+func finishOrderFromTimes(entrants []Chicken, finishTimes []float64, distances []float64) []int {
+	idx := make([]int, len(entrants))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		ta, tb := finishTimes[idx[a]], finishTimes[idx[b]]
+		aDone, bDone := ta != simFinishSentinel, tb != simFinishSentinel
+		switch {
+		case aDone && bDone:
+			return ta < tb
+		case aDone != bDone:
+			return aDone
+		default:
+			return distances[idx[a]] > distances[idx[b]]
+		}
+	})
+	order := make([]int, len(idx))
+	for i, j := range idx {
+		order[i] = entrants[j].ID
+	}
+	return order
+}
+
+// Duration returns the simulated time until the last frame.
+func (s *RaceSimulation) Duration() float64 {
+	return float64(len(s.Frames)-1) * simTickSeconds
+}
+
+// DistancesAt returns each entrant's distance at a point of the race, where fraction 0 is the start and 1 the last frame.
+func (s *RaceSimulation) DistancesAt(fraction float64) []float64 {
+	if len(s.Frames) == 0 {
+		return nil
+	}
+	fraction = math.Max(0, math.Min(1, fraction))
+	pos := fraction * float64(len(s.Frames)-1)
+	lo := int(pos)
+	if lo >= len(s.Frames)-1 {
+		return append([]float64(nil), s.Frames[len(s.Frames)-1]...)
+	}
+	// Linear interpolation between the two surrounding ticks keeps the replay smooth.
+	t := pos - float64(lo)
+	out := make([]float64, len(s.Entrants))
+	for i := range out {
+		out[i] = s.Frames[lo][i] + (s.Frames[lo+1][i]-s.Frames[lo][i])*t
+	}
+	return out
+}
+
+// WinnerID returns the chicken ID of the winner, or 0 for an empty field.
+func (s *RaceSimulation) WinnerID() int {
+	if len(s.FinishOrder) == 0 {
+		return 0
+	}
+	return s.FinishOrder[0]
+}
+
+// distanceToTrackPercent converts a simulated distance into the horizontal position used on the track.
+func distanceToTrackPercent(distance float64) float64 {
+	return distance / simTrackLength * trackFinishPercent
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// evenField returns a field of five evenly matched chickens.
+func evenField() []Chicken {
+	field := make([]Chicken, 5)
+	for i := range field {
+		field[i] = Chicken{ID: i + 1, Lane: i + 1, Speed: 6.0, Acceleration: 2.0, Stamina: 0.7}
+	}
+	return field
+}
+
+func TestSimulateRaceIsDeterministic(t *testing.T) {
+	first := simulateRace(evenField(), 42)
+	again := simulateRace(evenField(), 42)
+	if !reflect.DeepEqual(first, again) {
+		t.Fatal("the same field and seed gave two different races")
+	}
+
+	differs := false
+	for seed := int64(43); seed < 53 && !differs; seed++ {
+		differs = !reflect.DeepEqual(simulateRace(evenField(), seed).Frames, first.Frames)
+	}
+	if !differs {
+		t.Error("ten other seeds all replayed the race run with seed 42")
+	}
+}
+
+func TestSimulateRaceFinishOrder(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		sim := simulateRace(evenField(), seed)
+		if len(sim.FinishOrder) != 5 {
+			t.Fatalf("seed %d: finishing order %v, want all 5 entrants", seed, sim.FinishOrder)
+		}
+		// The order is the order the trajectories cross the line in.
+		timeOf := make(map[int]float64)
+		for i, ch := range sim.Entrants {
+			if sim.FinishTimes[i] == simFinishSentinel {
+				t.Fatalf("seed %d: chicken %d did not finish", seed, ch.ID)
+			}
+			timeOf[ch.ID] = sim.FinishTimes[i]
+		}
+		for i := 1; i < len(sim.FinishOrder); i++ {
+			if timeOf[sim.FinishOrder[i]] < timeOf[sim.FinishOrder[i-1]] {
+				t.Errorf("seed %d: chicken %d placed behind chicken %d but finished first", seed, sim.FinishOrder[i], sim.FinishOrder[i-1])
+			}
+		}
+		if sim.WinnerID() != sim.FinishOrder[0] {
+			t.Errorf("seed %d: winner %d, want %d", seed, sim.WinnerID(), sim.FinishOrder[0])
+		}
+	}
+
+	// The physics do not decide the result: the order is drawn from the odds before the race is run, and
+	// a chicken twice as fast as the rest but priced as an outsider finishes wherever the draw puts it.
+	fastWins := 0
+	for seed := int64(1); seed <= 20; seed++ {
+		field := evenField()
+		for i := range field {
+			field[i].Odds = 2.0
+		}
+		field[3].Speed, field[3].Odds = 12.0, 50.0
+		drawn := drawFinishingOrder(winProbabilities(field), rand.New(rand.NewSource(seed)))
+		sim := simulateRace(field, seed)
+		for place, i := range drawn {
+			if sim.FinishOrder[place] != field[i].ID {
+				t.Fatalf("seed %d: finishing order %v, want the drawn order of entrants %v", seed, sim.FinishOrder, drawn)
+			}
+		}
+		if sim.WinnerID() == field[3].ID {
+			fastWins++
+		}
+	}
+	if fastWins == 20 {
+		t.Error("the fast outsider won every race; the draw should decide the winner")
+	}
+}
+
+func TestRaceSimulationReplay(t *testing.T) {
+	sim := simulateRace(evenField(), 7)
+	for tick := 1; tick < len(sim.Frames); tick++ {
+		for i := range sim.Entrants {
+			if sim.Frames[tick][i] < sim.Frames[tick-1][i] || sim.Frames[tick][i] > simTrackLength {
+				t.Fatalf("tick %d: chicken %d went from %.2f to %.2f", tick, sim.Entrants[i].ID, sim.Frames[tick-1][i], sim.Frames[tick][i])
+			}
+		}
+	}
+	if start := sim.DistancesAt(0); !reflect.DeepEqual(start, sim.Frames[0]) {
+		t.Errorf("DistancesAt(0) = %v, want the start %v", start, sim.Frames[0])
+	}
+	if end := sim.DistancesAt(1); !reflect.DeepEqual(end, sim.Frames[len(sim.Frames)-1]) {
+		t.Errorf("DistancesAt(1) = %v, want the last frame %v", end, sim.Frames[len(sim.Frames)-1])
+	}
+	half := sim.DistancesAt(0.5)
+	for i := range half {
+		if half[i] <= 0 || half[i] >= simTrackLength {
+			t.Errorf("halfway through, chicken %d is at %.2f", sim.Entrants[i].ID, half[i])
+		}
+	}
+}
+
+func TestLoadRaceSimulationReplaysStoredSeed(t *testing.T) {
+	setupTestDB(t)
+	const raceID = 3 // The sample Scheduled race
+	first, err := loadRaceSimulation(db, raceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := loadRaceSimulation(db, raceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Seed != 20250601 || !reflect.DeepEqual(first, again) {
+		t.Errorf("loading race %d twice gave different races (seeds %d and %d)", raceID, first.Seed, again.Seed)
+	}
+	if len(first.Entrants) != 5 {
+		t.Errorf("simulated %d entrants, want the race's 5", len(first.Entrants))
+	}
+}
@@ -9,12 +9,12 @@ import (
 	"log"
 	"net/http"
 	"net/mail"
+	"net/url"
 	"strings"
 	"time"
 
 	_ "github.com/alexedwards/scs/v2" // Corrected: Import SCS for direct use
 	"golang.org/x/crypto/bcrypt"
-	// "your_project/internal/middleware" // Hypothetical for CSRF or MaxBytesReader
 )
 
 // --- Configuration Constants ---
@@ -26,9 +26,11 @@ const (
 	sessionUserIDKey       = "userID"
 	sessionUserNameKey     = "userName"
 	sessionAuthTimeKey     = "authenticatedAt"
+	sessionRedirectKey     = "redirect_after_login"
 	sessionIdleTimeout     = 30 * time.Minute // Example: log out after 30 mins of inactivity
 	sessionAbsoluteTimeout = 12 * time.Hour   // Example: force re-login after 12 hours regardless of activity
 	maxFormMemory          = 1 * 1024 * 1024  // 1MB for form parsing in memory, adjust as needed
+	maxUploadBytes         = 3 * 1024 * 1024  // Largest multipart body, e.g. a chicken portrait upload
 )
 
 // --- Utility Functions ---
@@ -49,12 +51,16 @@ func renderTemplateWithStatus(w http.ResponseWriter, r *http.Request, status int
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	data.IsLoggedIn = sessionManager.Exists(r.Context(), sessionUserIDKey)
+	userID := authenticatedUserID(r)
+	data.IsLoggedIn = userID != 0
 	if data.IsLoggedIn {
 		data.CurrentUserName = sessionManager.GetString(r.Context(), sessionUserNameKey)
+		if role, err := getUserRole(db, userID); err == nil {
+			data.IsAdmin = hasRole(role, RoleAdmin)
+			data.IsModerator = hasRole(role, RoleModerator)
+		}
 	}
-	// TODO: Populate CSRF token here if using nosurf or similar
-	// data.CSRFToken = nosurf.Token(r)
+	data.CSRFToken = csrfToken(r.Context())
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(status)
@@ -64,6 +70,35 @@ func renderTemplateWithStatus(w http.ResponseWriter, r *http.Request, status int
 	}
 }
 
+// authenticatedUserID returns the ID of the user logged in to the request's session, or 0 for guests.
+// Handlers get the current user through it (or authenticatedUser) rather than reading the session directly.
+func authenticatedUserID(r *http.Request) int {
+	return sessionManager.GetInt(r.Context(), sessionUserIDKey)
+}
+
+// authenticatedUser loads the user logged in to the request's session and their balance. ok is false
+// for guests and for sessions whose user no longer exists; both are shown the site as a guest.
+func authenticatedUser(q rowQuerier, r *http.Request) (user User, balance Money, ok bool, err error) {
+	guest := User{Name: "Guest"}
+	userID := authenticatedUserID(r)
+	if userID == 0 {
+		return guest, 0, false, nil
+	}
+	err = q.QueryRow("SELECT id, name, email, role, balance FROM users WHERE id = ?", userID).Scan(&user.ID, &user.Name, &user.Email, &user.Role, &balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("authenticatedUser: Session user %d no longer exists. Treating as guest.", userID)
+		return guest, 0, false, nil
+	}
+	if err != nil {
+		return guest, 0, false, fmt.Errorf("error loading user %d: %w", userID, err)
+	}
+	user.Age, err = getUserAge(q, userID, time.Now())
+	if err != nil && !errors.Is(err, errDateOfBirthUnknown) {
+		return guest, 0, false, err
+	}
+	return user, balance, true, nil
+}
+
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	if sessionManager == nil {
 		log.Println("loginHandler: CRITICAL: sessionManager is nil.")
@@ -71,7 +106,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if sessionManager.Exists(r.Context(), sessionUserIDKey) {
+	if authenticatedUserID(r) != 0 {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
@@ -84,8 +119,6 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodPost {
-		// TODO: Validate CSRF token here.
-
 		// Limit request body size before parsing the form.
 		// This is a good place for it if not handled by a global middleware.
 		r.Body = http.MaxBytesReader(w, r.Body, maxFormMemory)
@@ -126,13 +159,15 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		var storedPasswordHash string
 		var userID int
 		var userName string
+		var locked bool
 
 		ctxDB, cancelDB := context.WithTimeout(r.Context(), dbTimeout)
 		defer cancelDB()
 
-		err := db.QueryRowContext(ctxDB, "SELECT id, name, password_hash FROM users WHERE email = ?", email).Scan(&userID, &userName, &storedPasswordHash)
+		err := db.QueryRowContext(ctxDB, "SELECT id, name, password_hash, locked_at IS NOT NULL FROM users WHERE email = ?", email).Scan(&userID, &userName, &storedPasswordHash, &locked)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
+				auditLoginFailure(r, email, 0, "unknown email")
 				data.Message = "Invalid email or password."
 			} else if errors.Is(err, context.DeadlineExceeded) {
 				log.Printf("loginHandler: DB query timeout for email %s: %v", email, err)
@@ -162,12 +197,22 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		case err := <-matchCh:
 			if err != nil {
+				auditLoginFailure(r, email, userID, "wrong password")
 				data.Message = "Invalid email or password."
 				renderTemplateWithStatus(w, r, http.StatusUnauthorized, loginTemplate, "base.gohtml", data)
 				return
 			}
 		}
 
+		// Checked after the password, so only the account's owner learns it is locked.
+		if locked {
+			log.Printf("loginHandler: Refused login of locked user ID %d.", userID)
+			auditLoginFailure(r, email, userID, "account locked")
+			data.Message = "This account is locked. Please contact support."
+			renderTemplateWithStatus(w, r, http.StatusForbidden, loginTemplate, "base.gohtml", data)
+			return
+		}
+
 		if err := sessionManager.RenewToken(r.Context()); err != nil {
 			log.Printf("loginHandler: Error renewing session token for user ID %d: %v", userID, err)
 			data.Message = "An error occurred during login. Please try again."
@@ -178,22 +223,34 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		sessionManager.Put(r.Context(), sessionUserIDKey, userID)
 		sessionManager.Put(r.Context(), sessionUserNameKey, userName)
 		sessionManager.Put(r.Context(), sessionAuthTimeKey, time.Now())
+		sessionManager.Remove(r.Context(), sessionCSRFTokenKey) // A new token for the logged-in session
 
 		log.Printf("User %s (ID: %d) logged in successfully.", userName, userID)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		logAuditEvent(newAuditEvent(AuditActor{ID: userID, Name: userName, IP: getIPAddress(r)}, AuditLoginSucceeded, "user", userID))
+		grantLoginTopUp(r.Context(), userID)
+		// Send the user back to the page requireAuthentication turned them away from.
+		http.Redirect(w, r, localRedirectTarget(sessionManager.PopString(r.Context(), sessionRedirectKey)), http.StatusSeeOther)
 		return
 	}
 
 	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 }
 
+// auditLoginFailure records a refused login. userID is 0 when no account has the email.
+func auditLoginFailure(r *http.Request, email string, userID int, reason string) {
+	event := newAuditEvent(requestActor(r), AuditLoginFailed, "user", userID)
+	event.After = auditValue(map[string]string{"email": email})
+	event.Reason = reason
+	logAuditEvent(event)
+}
+
 func signupHandler(w http.ResponseWriter, r *http.Request) {
 	if sessionManager == nil {
 		log.Println("signupHandler: CRITICAL: sessionManager is nil.")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	if sessionManager.Exists(r.Context(), sessionUserIDKey) {
+	if authenticatedUserID(r) != 0 {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
@@ -205,8 +262,6 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodPost {
-		// TODO: Validate CSRF token here.
-
 		r.Body = http.MaxBytesReader(w, r.Body, maxFormMemory)
 		if err := r.ParseForm(); err != nil {
 			if _, ok := err.(*http.MaxBytesError); ok {
@@ -225,6 +280,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		email := normalizeEmail(r.FormValue("email"))
 		password := r.FormValue("password")
 		confirmPassword := r.FormValue("confirm_password")
+		dateOfBirth := strings.TrimSpace(r.FormValue("date_of_birth"))
 
 		if name == "" || email == "" || password == "" {
 			data.Message = "All fields (Name, Email, Password) are required."
@@ -256,11 +312,18 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 			renderTemplateWithStatus(w, r, http.StatusBadRequest, signupTemplate, "base.gohtml", data)
 			return
 		}
+		// Under-age users can still sign up and play the free parts of the site; checkBetLimits stops them betting.
+		dob, err := parseDateOfBirth(dateOfBirth, time.Now())
+		if err != nil {
+			data.Message = "Please enter a valid date of birth."
+			renderTemplateWithStatus(w, r, http.StatusBadRequest, signupTemplate, "base.gohtml", data)
+			return
+		}
 
 		ctxDBCheck, cancelDBCheck := context.WithTimeout(r.Context(), dbTimeout)
 		defer cancelDBCheck()
 		var count int
-		err := db.QueryRowContext(ctxDBCheck, "SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&count)
+		err = db.QueryRowContext(ctxDBCheck, "SELECT COUNT(*) FROM users WHERE email = ?", email).Scan(&count)
 		if err != nil {
 			if errors.Is(err, context.DeadlineExceeded) {
 				log.Printf("signupHandler: DB query timeout checking email %s: %v", email, err)
@@ -311,8 +374,29 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 
 		ctxDBInsert, cancelDBInsert := context.WithTimeout(r.Context(), dbTimeout)
 		defer cancelDBInsert()
-		_, err = db.ExecContext(ctxDBInsert, "INSERT INTO users (name, email, password_hash, created_at, updated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
-			name, email, string(hashedPassword))
+		err = func() error {
+			// The account starts empty; its starting balance is the first entry in the wallet ledger.
+			tx, err := db.BeginTx(ctxDBInsert, nil)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			result, err := tx.ExecContext(ctxDBInsert, "INSERT INTO users (name, email, password_hash, date_of_birth, balance, created_at, updated_at) VALUES (?, ?, ?, ?, 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
+				name, email, string(hashedPassword), dob.Format(dateOfBirthLayout))
+			if err != nil {
+				return err
+			}
+			userID, _ := result.LastInsertId()
+			if _, err := postWalletTransaction(tx, WalletTransaction{
+				UserID: int(userID), Kind: WalletTxOpening, Amount: startingBalance, Note: "Starting balance",
+			}); err != nil {
+				return err
+			}
+			if _, err := grantSignupBonuses(tx, int(userID)); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}()
 		if err != nil {
 			if errors.Is(err, context.DeadlineExceeded) {
 				log.Printf("signupHandler: DB insert timeout for email %s: %v", email, err)
@@ -344,9 +428,7 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Validate CSRF token for logout POST requests.
-
-	userID := sessionManager.GetInt(r.Context(), sessionUserIDKey)
+	userID := authenticatedUserID(r)
 
 	if err := sessionManager.Destroy(r.Context()); err != nil {
 		log.Printf("logoutHandler: Error destroying session for user ID %d: %v", userID, err)
@@ -356,6 +438,18 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// localRedirectTarget returns target if it is a path on this site, and "/" otherwise, so a stored
+// redirect can never send a user to another host.
+func localRedirectTarget(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+// requireAuthentication sends guests to /login and brings them back afterwards. Full page loads get a
+// 303 redirect. HTMX requests get an HX-Redirect header instead, since a redirect would only swap the
+// login page into the fragment; they come back to the page the request was made from, not the endpoint.
 func requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if sessionManager == nil {
@@ -363,8 +457,19 @@ func requireAuthentication(next http.Handler) http.Handler {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError) // Or redirect to an error page
 			return
 		}
-		if !sessionManager.Exists(r.Context(), sessionUserIDKey) {
-			sessionManager.Put(r.Context(), "redirect_after_login", r.URL.Path)
+		if authenticatedUserID(r) == 0 {
+			if r.Header.Get("HX-Request") == "true" {
+				if current, err := url.Parse(r.Header.Get("HX-Current-URL")); err == nil && current.Path != "" {
+					sessionManager.Put(r.Context(), sessionRedirectKey, current.RequestURI())
+				}
+				log.Printf("requireAuthentication: Guest HTMX request to %s sent to login.", r.URL.Path)
+				w.Header().Set("HX-Redirect", "/login")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method == http.MethodGet {
+				sessionManager.Put(r.Context(), sessionRedirectKey, r.URL.RequestURI())
+			}
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// serve sends a request through the session middleware and returns the response.
+func serve(handler http.Handler, req *http.Request, cookie *http.Cookie) *httptest.ResponseRecorder {
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	sessionManager.LoadAndSave(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+// sessionCookie returns the session cookie set by a response, or nil.
+func sessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionManager.Cookie.Name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestRequireAuthentication(t *testing.T) {
+	setupHandlerTest(t)
+	userID := createTestUser(t, "Alice", "alice@example.com", 100*Credit)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if _, err := db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(hash), userID); err != nil {
+		t.Fatal(err)
+	}
+
+	reached := false
+	protected := requireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	htmx := httptest.NewRequest(http.MethodPost, "/place-bet", strings.NewReader("betAmount=10"))
+	htmx.Header.Set("HX-Request", "true")
+	htmx.Header.Set("HX-Current-URL", "http://localhost:6969/races?tab=acca")
+
+	for _, tc := range []struct {
+		name         string
+		req          *http.Request
+		wantStatus   int
+		wantHeader   string // Header pointing at /login
+		wantReturnTo string // Where login sends the user afterwards
+	}{
+		{"page load", httptest.NewRequest(http.MethodGet, "/my-bets?status=won", nil), http.StatusSeeOther, "Location", "/my-bets?status=won"},
+		{"htmx request", htmx, http.StatusUnauthorized, "HX-Redirect", "/races?tab=acca"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reached = false
+			rec := serve(protected, tc.req, nil)
+			if reached {
+				t.Fatal("guest reached the protected handler")
+			}
+			if rec.Code != tc.wantStatus || rec.Header().Get(tc.wantHeader) != "/login" {
+				t.Fatalf("got status %d and %s %q, want %d and /login", rec.Code, tc.wantHeader, rec.Header().Get(tc.wantHeader), tc.wantStatus)
+			}
+
+			form := url.Values{"email": {"alice@example.com"}, "password": {"correct horse"}}
+			login := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			login.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec = serve(http.HandlerFunc(loginHandler), login, sessionCookie(rec))
+			if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != tc.wantReturnTo {
+				t.Fatalf("login: got status %d to %q, want a redirect back to %q", rec.Code, rec.Header().Get("Location"), tc.wantReturnTo)
+			}
+
+			serve(protected, httptest.NewRequest(http.MethodGet, "/my-bets", nil), sessionCookie(rec))
+			if !reached {
+				t.Error("logged-in user did not reach the protected handler")
+			}
+		})
+	}
+}
+
+func TestLocalRedirectTarget(t *testing.T) {
+	for target, want := range map[string]string{
+		"/my-bets?page=2":       "/my-bets?page=2",
+		"":                      "/",
+		"https://evil.example":  "/",
+		"//evil.example/phish":  "/",
+		"/\\evil.example":       "/",
+		"javascript:alert(1)":   "/",
+		"/responsible-gambling": "/responsible-gambling",
+	} {
+		if got := localRedirectTarget(target); got != want {
+			t.Errorf("localRedirectTarget(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Gambling limit kinds, stored in gambling_limits.kind.
+const (
+	LimitDailyLoss   = "DailyLoss"   // Net loss since midnight
+	LimitWeeklyLoss  = "WeeklyLoss"  // Net loss since Monday midnight
+	LimitMonthlyLoss = "MonthlyLoss" // Net loss since the first of the month
+	LimitStakePerBet = "StakePerBet" // Largest single stake
+	LimitDailyStake  = "DailyStake"  // Total staked since midnight, not counting cancelled bets
+)
+
+// limitKinds lists every limit kind in the order the settings page shows them.
+var limitKinds = []string{LimitDailyLoss, LimitWeeklyLoss, LimitMonthlyLoss, LimitStakePerBet, LimitDailyStake}
+
+// limitLabels names each limit kind for users.
+var limitLabels = map[string]string{
+	LimitDailyLoss:   "Daily loss limit",
+	LimitWeeklyLoss:  "Weekly loss limit",
+	LimitMonthlyLoss: "Monthly loss limit",
+	LimitStakePerBet: "Maximum stake per bet",
+	LimitDailyStake:  "Daily stake limit",
+}
+
+// limitCoolingOff is how long a raised or removed limit waits before it takes effect.
+// Lowering a limit, or setting one for the first time, applies straight away.
+const limitCoolingOff = 24 * time.Hour
+
+// selfExclusionPeriods are the self-exclusion lengths users can choose from.
+var selfExclusionPeriods = []struct {
+	Label    string
+	Duration time.Duration
+}{
+	{"24 hours", 24 * time.Hour},
+	{"7 days", 7 * 24 * time.Hour},
+	{"30 days", 30 * 24 * time.Hour},
+	{"6 months", 183 * 24 * time.Hour},
+}
+
+// limitTimestampLayout matches the CURRENT_TIMESTAMP text SQLite stores in created_at columns (UTC),
+// so period starts can be compared with them directly.
+const limitTimestampLayout = "2006-01-02 15:04:05"
+
+// SelfExcludedError blocks betting and bonuses until a self-exclusion ends.
+type SelfExcludedError struct {
+	Until time.Time
+}
+
+func (e *SelfExcludedError) Error() string {
+	return fmt.Sprintf("self-excluded until %s", e.Until.Format(time.RFC3339))
+}
+
+// LimitExceededError blocks a bet that would break one of the user's limits.
+type LimitExceededError struct {
+	Kind      string
+	Limit     Money
+	Remaining Money // What can still be staked under the limit, never negative
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s limit of %s exceeded (%s remaining)", e.Kind, e.Limit, e.Remaining)
+}
+
+// gamblingLimitMessage turns an error from checkBetLimits into a message for the punter,
+// or returns "" if the error is not a limit.
+func gamblingLimitMessage(err error) string {
+	switch {
+	case errors.Is(err, errUnderAge):
+		return fmt.Sprintf("You must be %d or over to place bets.", minimumBettingAge)
+	case errors.Is(err, errDateOfBirthUnknown):
+		return "We need your date of birth before you can place bets. Please contact support to add it."
+	}
+	var excluded *SelfExcludedError
+	if errors.As(err, &excluded) {
+		return fmt.Sprintf("You are self-excluded until %s. Betting and bonuses are unavailable until then.",
+			excluded.Until.Local().Format("Jan 2, 2006 15:04"))
+	}
+	var exceeded *LimitExceededError
+	if !errors.As(err, &exceeded) {
+		return ""
+	}
+	if exceeded.Kind == LimitStakePerBet {
+		return fmt.Sprintf("Your maximum stake per bet is %s credits.", exceeded.Limit)
+	}
+	if exceeded.Remaining <= 0 {
+		return fmt.Sprintf("You have reached your %s of %s credits. No more bets can be placed until it resets.",
+			strings.ToLower(limitLabels[exceeded.Kind]), exceeded.Limit)
+	}
+	return fmt.Sprintf("This bet would break your %s of %s credits. You can stake at most %s credits more.",
+		strings.ToLower(limitLabels[exceeded.Kind]), exceeded.Limit, exceeded.Remaining)
+}
+
+// GamblingLimit is one of a user's limits, with any raise or removal still cooling off.
+type GamblingLimit struct {
+	Kind          string
+	Amount        Money
+	Set           bool          // False when no limit is in force
+	PendingAmount sql.NullInt64 // Limit that replaces Amount at PendingFrom; NULL to remove it
+	PendingFrom   sql.NullTime  // NULL when no change is pending
+}
+
+// Label names the limit for users.
+func (l GamblingLimit) Label() string {
+	return limitLabels[l.Kind]
+}
+
+// PendingLabel describes the change waiting out the cooling-off period.
+func (l GamblingLimit) PendingLabel() string {
+	if !l.PendingFrom.Valid {
+		return ""
+	}
+	from := l.PendingFrom.Time.Local().Format("Jan 2 15:04")
+	if !l.PendingAmount.Valid {
+		return "Removed from " + from
+	}
+	return fmt.Sprintf("Changes to %s from %s", Money(l.PendingAmount.Int64), from)
+}
+
+// FormValue returns the limit for the settings form.
+func (l GamblingLimit) FormValue() string {
+	if !l.Set {
+		return ""
+	}
+	return l.Amount.String()
+}
+
+// getGamblingLimits returns every limit kind for the user, applying changes whose cooling-off has ended.
+func getGamblingLimits(q querier, userID int, now time.Time) (map[string]GamblingLimit, error) {
+	limits := make(map[string]GamblingLimit, len(limitKinds))
+	for _, kind := range limitKinds {
+		limits[kind] = GamblingLimit{Kind: kind}
+	}
+
+	rows, err := q.Query("SELECT kind, amount, pending_amount, pending_from FROM gambling_limits WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying gambling limits of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l GamblingLimit
+		var amount sql.NullInt64
+		if err := rows.Scan(&l.Kind, &amount, &l.PendingAmount, &l.PendingFrom); err != nil {
+			return nil, fmt.Errorf("error scanning gambling limit of user %d: %w", userID, err)
+		}
+		if l.PendingFrom.Valid && !now.Before(l.PendingFrom.Time) {
+			amount, l.PendingAmount, l.PendingFrom = l.PendingAmount, sql.NullInt64{}, sql.NullTime{}
+		}
+		l.Amount, l.Set = Money(amount.Int64), amount.Valid
+		limits[l.Kind] = l
+	}
+	return limits, rows.Err()
+}
+
+// setGamblingLimit changes one of the user's limits; set is false to remove it. Tighter limits apply at
+// once; looser ones wait limitCoolingOff, so a limit cannot be lifted in the heat of the moment.
+// It returns the limit as it now stands.
+func setGamblingLimit(ex queryExecer, userID int, kind string, amount Money, set bool, now time.Time) (GamblingLimit, error) {
+	limits, err := getGamblingLimits(ex, userID, now)
+	if err != nil {
+		return GamblingLimit{}, err
+	}
+	current, ok := limits[kind]
+	if !ok {
+		return GamblingLimit{}, fmt.Errorf("unknown gambling limit %q", kind)
+	}
+
+	next := GamblingLimit{Kind: kind, Amount: amount, Set: set}
+	tighter := set && (!current.Set || amount <= current.Amount)
+	if !tighter {
+		if !current.Set {
+			return current, nil // Removing a limit that is not set
+		}
+		next = current
+		next.PendingAmount = sql.NullInt64{Int64: int64(amount), Valid: set}
+		next.PendingFrom = sql.NullTime{Time: now.Add(limitCoolingOff), Valid: true}
+	}
+
+	_, err = ex.Exec(`
+        INSERT INTO gambling_limits (user_id, kind, amount, pending_amount, pending_from, updated_at)
+        VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+        ON CONFLICT (user_id, kind) DO UPDATE SET amount = excluded.amount, pending_amount = excluded.pending_amount,
+                                                 pending_from = excluded.pending_from, updated_at = CURRENT_TIMESTAMP`,
+		userID, kind, sql.NullInt64{Int64: int64(next.Amount), Valid: next.Set}, next.PendingAmount, next.PendingFrom)
+	if err != nil {
+		return GamblingLimit{}, fmt.Errorf("error saving %s limit of user %d: %w", kind, userID, err)
+	}
+	return next, nil
+}
+
+// getSelfExclusion returns when the user's self-exclusion ends, or the zero time if they are not excluded.
+func getSelfExclusion(q rowQuerier, userID int, now time.Time) (time.Time, error) {
+	var until sql.NullTime
+	if err := q.QueryRow("SELECT self_excluded_until FROM users WHERE id = ?", userID).Scan(&until); err != nil {
+		return time.Time{}, fmt.Errorf("error reading self-exclusion of user %d: %w", userID, err)
+	}
+	if !until.Valid || !now.Before(until.Time) {
+		return time.Time{}, nil
+	}
+	return until.Time, nil
+}
+
+// selfExclude stops the user betting or claiming bonuses for the given period. An exclusion can be
+// extended but never shortened.
+func selfExclude(ex queryExecer, userID int, period time.Duration, now time.Time) (time.Time, error) {
+	current, err := getSelfExclusion(ex, userID, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	until := now.Add(period)
+	if current.After(until) {
+		return current, nil
+	}
+	if _, err := ex.Exec("UPDATE users SET self_excluded_until = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", until, userID); err != nil {
+		return time.Time{}, fmt.Errorf("error saving self-exclusion of user %d: %w", userID, err)
+	}
+	return until, nil
+}
+
+// checkSelfExclusion returns a *SelfExcludedError while the user is self-excluded.
+func checkSelfExclusion(q rowQuerier, userID int, now time.Time) error {
+	until, err := getSelfExclusion(q, userID, now)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		return &SelfExcludedError{Until: until}
+	}
+	return nil
+}
+
+// limitPeriodStart returns when the period a limit kind covers began, in the UTC text form of created_at.
+func limitPeriodStart(kind string, now time.Time) string {
+	local := now.Local()
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+	switch kind {
+	case LimitWeeklyLoss:
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7)) // Back to Monday
+	case LimitMonthlyLoss:
+		start = start.AddDate(0, 0, 1-start.Day())
+	}
+	return start.UTC().Format(limitTimestampLayout)
+}
+
+// getNetLoss returns how much the user has lost on bets since the start of a period: stakes less payouts,
+// refunds and cash-outs. Bonuses and other credits do not count. It is negative when the user is ahead.
+func getNetLoss(q rowQuerier, userID int, since string) (Money, error) {
+	var loss Money
+	err := q.QueryRow(`
+        SELECT COALESCE(-SUM(amount), 0) FROM wallet_transactions
+        WHERE user_id = ? AND kind IN (?, ?, ?, ?) AND created_at >= ?`,
+		userID, WalletTxStake, WalletTxPayout, WalletTxRefund, WalletTxCashOut, since).Scan(&loss)
+	if err != nil {
+		return 0, fmt.Errorf("error summing net loss of user %d: %w", userID, err)
+	}
+	return loss, nil
+}
+
+// getStakedSince returns the total stake of the user's bets placed since a time, leaving out cancelled bets.
+func getStakedSince(q rowQuerier, userID int, since string) (Money, error) {
+	var staked Money
+	err := q.QueryRow(`
+        SELECT COALESCE(SUM(b.bet_amount), 0)
+        FROM bets b
+        JOIN bet_statuses s ON b.bet_status_id = s.id
+        WHERE b.user_id = ? AND b.created_at >= ? AND s.status_name != 'Cancelled'`, userID, since).Scan(&staked)
+	if err != nil {
+		return 0, fmt.Errorf("error summing stakes of user %d: %w", userID, err)
+	}
+	return staked, nil
+}
+
+// checkBetLimits checks a new stake against the user's age, self-exclusion and limits. It returns
+// errUnderAge, errDateOfBirthUnknown, a *SelfExcludedError or a *LimitExceededError when the bet must
+// be refused, and is meant to run inside the transaction that places the bet, so the stakes it counts
+// cannot change underneath it.
+func checkBetLimits(q querier, userID int, stake Money, now time.Time) error {
+	if err := checkBettingAge(q, userID, now); err != nil {
+		return err
+	}
+	if err := checkSelfExclusion(q, userID, now); err != nil {
+		return err
+	}
+	limits, err := getGamblingLimits(q, userID, now)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range limitKinds {
+		l := limits[kind]
+		if !l.Set {
+			continue
+		}
+		var used Money
+		switch kind {
+		case LimitStakePerBet:
+			if stake > l.Amount {
+				return &LimitExceededError{Kind: kind, Limit: l.Amount, Remaining: l.Amount}
+			}
+			continue
+		case LimitDailyStake:
+			used, err = getStakedSince(q, userID, limitPeriodStart(kind, now))
+		default:
+			used, err = getNetLoss(q, userID, limitPeriodStart(kind, now))
+		}
+		if err != nil {
+			return err
+		}
+		if used+stake > l.Amount {
+			remaining := l.Amount - used
+			if remaining < 0 {
+				remaining = 0
+			}
+			return &LimitExceededError{Kind: kind, Limit: l.Amount, Remaining: remaining}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionRemindersShownKey counts the session time reminders already shown in this session.
+const sessionRemindersShownKey = "sessionRemindersShown"
+
+// sessionReminderOptions are the reminder intervals, in minutes, users can choose from; 0 turns reminders off.
+var sessionReminderOptions = []int{0, 15, 30, 60, 120}
+
+// sessionReminderTemplate renders the session time reminder banner.
+var sessionReminderTemplate = template.Must(template.New("sessionReminder").Parse(`
+    <div class="alert alert-info session-reminder">
+        You have been logged in for {{.Elapsed}}.
+        {{if gt .NetLoss 0}}You are down {{.NetLoss}} credits{{else if lt .NetLoss 0}}You are up {{.NetWin}} credits{{else}}You are even{{end}} since logging in.
+        Time for a break? <a href="/responsible-gambling">Set limits</a>
+        <button type="button" class="btn btn-secondary" onclick="this.parentElement.remove()">Dismiss</button>
+    </div>
+`))
+
+// ResponsibleGamblingView is the data of the responsible gambling page.
+type ResponsibleGamblingView struct {
+	Limits            []GamblingLimit
+	SelfExcludedUntil time.Time // Zero when not excluded
+	ReminderMinutes   int       // 0 when reminders are off
+	ReminderOptions   []int
+	ExclusionPeriods  []string
+}
+
+// getResponsibleGamblingView loads a user's limits, self-exclusion and reminder setting.
+func getResponsibleGamblingView(q querier, userID int, now time.Time) (*ResponsibleGamblingView, error) {
+	limits, err := getGamblingLimits(q, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	view := &ResponsibleGamblingView{ReminderOptions: sessionReminderOptions}
+	for _, kind := range limitKinds {
+		view.Limits = append(view.Limits, limits[kind])
+	}
+	for _, p := range selfExclusionPeriods {
+		view.ExclusionPeriods = append(view.ExclusionPeriods, p.Label)
+	}
+	if view.SelfExcludedUntil, err = getSelfExclusion(q, userID, now); err != nil {
+		return nil, err
+	}
+	var reminder sql.NullInt64
+	if err := q.QueryRow("SELECT session_reminder_minutes FROM users WHERE id = ?", userID).Scan(&reminder); err != nil {
+		return nil, fmt.Errorf("error reading session reminder of user %d: %w", userID, err)
+	}
+	view.ReminderMinutes = int(reminder.Int64)
+	return view, nil
+}
+
+// saveGamblingLimits applies the limits submitted on the settings page. Limits left as they are keep any
+// change that is still cooling off. It returns a message describing what happened.
+func saveGamblingLimits(r *http.Request, userID int, now time.Time) (string, bool) {
+	type change struct {
+		kind   string
+		amount Money
+		set    bool
+	}
+	var changes []change
+	for _, kind := range limitKinds {
+		value := strings.TrimSpace(r.FormValue(kind))
+		if value == "" {
+			changes = append(changes, change{kind: kind})
+			continue
+		}
+		amount, err := ParseMoney(value)
+		if err != nil || amount <= 0 {
+			return fmt.Sprintf("%s must be a positive amount, or empty for no limit.", limitLabels[kind]), false
+		}
+		changes = append(changes, change{kind: kind, amount: amount, set: true})
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("saveGamblingLimits: Failed to begin transaction: %v", err)
+		return "Could not save your limits. Please try again.", false
+	}
+	defer tx.Rollback() // No-op once committed
+
+	current, err := getGamblingLimits(tx, userID, now)
+	if err != nil {
+		log.Printf("saveGamblingLimits: %v", err)
+		return "Could not save your limits. Please try again.", false
+	}
+	var pending []string
+	for _, c := range changes {
+		if cur := current[c.kind]; cur.Set == c.set && cur.Amount == c.amount {
+			continue
+		}
+		l, err := setGamblingLimit(tx, userID, c.kind, c.amount, c.set, now)
+		if err != nil {
+			log.Printf("saveGamblingLimits: %v", err)
+			return "Could not save your limits. Please try again.", false
+		}
+		if l.PendingFrom.Valid {
+			pending = append(pending, l.Label())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("saveGamblingLimits: Error committing limits of user %d: %v", userID, err)
+		return "Could not save your limits. Please try again.", false
+	}
+	log.Printf("saveGamblingLimits: User %d updated their gambling limits (%d cooling off).", userID, len(pending))
+
+	if len(pending) > 0 {
+		return fmt.Sprintf("Limits saved. Raising or removing a limit takes %d hours to apply: %s.",
+			int(limitCoolingOff.Hours()), strings.Join(pending, ", ")), true
+	}
+	return "Limits saved and in force now.", true
+}
+
+// responsibleGamblingHandler shows and saves a user's gambling limits, session reminder and self-exclusion.
+// It is registered behind requireAuthentication.
+func responsibleGamblingHandler(w http.ResponseWriter, r *http.Request) {
+	currentUserID := authenticatedUserID(r)
+	data := PageData{Title: "Responsible Gambling - Scramble Run"}
+	now := time.Now()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormMemory)
+		if err := r.ParseForm(); err != nil {
+			log.Printf("responsibleGamblingHandler: Error parsing form: %v", err)
+			http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+			return
+		}
+		switch r.FormValue("action") {
+		case "limits":
+			data.Message, data.Success = saveGamblingLimits(r, currentUserID, now)
+		case "reminder":
+			minutes, err := strconv.Atoi(r.FormValue("reminderMinutes"))
+			valid := false
+			for _, option := range sessionReminderOptions {
+				valid = valid || (err == nil && minutes == option)
+			}
+			if !valid {
+				data.Message = "Choose one of the reminder intervals."
+				break
+			}
+			_, err = db.Exec("UPDATE users SET session_reminder_minutes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+				sql.NullInt64{Int64: int64(minutes), Valid: minutes > 0}, currentUserID)
+			if err != nil {
+				log.Printf("responsibleGamblingHandler: Error saving session reminder of user %d: %v", currentUserID, err)
+				data.Message = "Could not save your reminder. Please try again."
+				break
+			}
+			sessionManager.Remove(r.Context(), sessionRemindersShownKey)
+			data.Message, data.Success = "Session reminder saved.", true
+		case "exclude":
+			period, err := strconv.Atoi(r.FormValue("period"))
+			if err != nil || period < 0 || period >= len(selfExclusionPeriods) {
+				data.Message = "Choose a self-exclusion period."
+				break
+			}
+			if r.FormValue("confirm") != "on" {
+				data.Message = "Tick the box to confirm. A self-exclusion cannot be undone."
+				break
+			}
+			tx, err := db.Begin()
+			if err != nil {
+				log.Printf("responsibleGamblingHandler: Failed to begin transaction: %v", err)
+				data.Message = "Could not save your self-exclusion. Please try again."
+				break
+			}
+			until, err := selfExclude(tx, currentUserID, selfExclusionPeriods[period].Duration, now)
+			if err == nil {
+				err = tx.Commit()
+			}
+			if err != nil {
+				tx.Rollback()
+				log.Printf("responsibleGamblingHandler: Error self-excluding user %d: %v", currentUserID, err)
+				data.Message = "Could not save your self-exclusion. Please try again."
+				break
+			}
+			log.Printf("responsibleGamblingHandler: User %d self-excluded until %s.", currentUserID, until.Format(time.RFC3339))
+			data.Message, data.Success = fmt.Sprintf("You are self-excluded until %s.", until.Local().Format("Jan 2, 2006 15:04")), true
+		default:
+			data.Message = "Unknown action."
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := db.QueryRow("SELECT balance FROM users WHERE id = ?", currentUserID).Scan(&data.UserBalance); err != nil {
+		log.Printf("responsibleGamblingHandler: Error fetching balance of user %d: %v", currentUserID, err)
+	}
+	view, err := getResponsibleGamblingView(db, currentUserID, now)
+	if err != nil {
+		log.Printf("responsibleGamblingHandler: Error loading settings of user %d: %v", currentUserID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data.ResponsibleGambling = view
+	renderTemplateWithStatus(w, r, http.StatusOK, responsibleGamblingTemplate, "base.gohtml", data)
+}
+
+// sessionReminderHandler is polled by every page while a user is logged in. Once per reminder interval it
+// returns a banner telling the user how long they have been logged in and how they have done since.
+func sessionReminderHandler(w http.ResponseWriter, r *http.Request) {
+	currentUserID := authenticatedUserID(r)
+	if currentUserID == 0 {
+		return
+	}
+	var minutes sql.NullInt64
+	if err := db.QueryRow("SELECT session_reminder_minutes FROM users WHERE id = ?", currentUserID).Scan(&minutes); err != nil {
+		log.Printf("sessionReminderHandler: Error reading session reminder of user %d: %v", currentUserID, err)
+		return
+	}
+	loggedInAt := sessionManager.GetTime(r.Context(), sessionAuthTimeKey)
+	if !minutes.Valid || minutes.Int64 <= 0 || loggedInAt.IsZero() {
+		return
+	}
+
+	elapsed := time.Since(loggedInAt)
+	due := int(elapsed / (time.Duration(minutes.Int64) * time.Minute))
+	if due <= sessionManager.GetInt(r.Context(), sessionRemindersShownKey) {
+		return
+	}
+	sessionManager.Put(r.Context(), sessionRemindersShownKey, due)
+
+	netLoss, err := getNetLoss(db, currentUserID, loggedInAt.UTC().Format(limitTimestampLayout))
+	if err != nil {
+		log.Printf("sessionReminderHandler: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	err = sessionReminderTemplate.Execute(w, struct {
+		Elapsed         string
+		NetLoss, NetWin Money
+	}{strings.TrimSuffix(elapsed.Truncate(time.Minute).String(), "0s"), netLoss, -netLoss})
+	if err != nil {
+		log.Printf("sessionReminderHandler: Template execution error: %v", err)
+	}
+}
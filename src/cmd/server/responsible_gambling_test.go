@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimitPeriodStart(t *testing.T) {
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, time.Local)
+	}
+	for _, tc := range []struct {
+		name string
+		kind string
+		now  time.Time
+		want time.Time
+	}{
+		{"day", LimitDailyLoss, at(time.March, 11, 15), at(time.March, 11, 0)},
+		{"daily stake", LimitDailyStake, at(time.March, 11, 0), at(time.March, 11, 0)},
+		{"midweek", LimitWeeklyLoss, at(time.March, 11, 15), at(time.March, 9, 0)},
+		{"Monday", LimitWeeklyLoss, at(time.March, 9, 0), at(time.March, 9, 0)},
+		{"Sunday", LimitWeeklyLoss, at(time.March, 15, 23), at(time.March, 9, 0)},
+		{"week started last month", LimitWeeklyLoss, at(time.March, 1, 12), at(time.February, 23, 0)},
+		{"month", LimitMonthlyLoss, at(time.March, 11, 15), at(time.March, 1, 0)},
+		{"first of the month", LimitMonthlyLoss, at(time.March, 1, 0), at(time.March, 1, 0)},
+	} {
+		if got, want := limitPeriodStart(tc.kind, tc.now), tc.want.UTC().Format(limitTimestampLayout); got != want {
+			t.Errorf("%s: limitPeriodStart(%s, %s) = %s, want %s", tc.name, tc.kind, tc.now, got, want)
+		}
+	}
+}
+
+func TestSetGamblingLimitCoolingOff(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Punter", "punter@example.com", 0)
+	now := time.Date(2026, 3, 11, 15, 0, 0, 0, time.Local)
+
+	for _, tc := range []struct {
+		name        string
+		amount      Money
+		set         bool
+		at          time.Duration // After now
+		want        Money         // In force at the time of the change
+		wantSet     bool
+		wantPending bool
+	}{
+		{"removing an unset limit", 0, false, 0, 0, false, false},
+		{"first limit applies at once", 50 * Credit, true, 0, 50 * Credit, true, false},
+		{"lowering applies at once", 30 * Credit, true, time.Minute, 30 * Credit, true, false},
+		{"the same amount applies at once", 30 * Credit, true, 2 * time.Minute, 30 * Credit, true, false},
+		{"raising waits", 80 * Credit, true, time.Hour, 30 * Credit, true, true},
+		{"lowering cancels a pending raise", 20 * Credit, true, 2 * time.Hour, 20 * Credit, true, false},
+		{"raising again waits", 40 * Credit, true, 3 * time.Hour, 20 * Credit, true, true},
+	} {
+		l, err := setGamblingLimit(db, userID, LimitDailyLoss, tc.amount, tc.set, now.Add(tc.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Amount != tc.want || l.Set != tc.wantSet || l.PendingFrom.Valid != tc.wantPending {
+			t.Errorf("%s: limit %s (set %v, pending %v), want %s (set %v, pending %v)",
+				tc.name, l.Amount, l.Set, l.PendingFrom.Valid, tc.want, tc.wantSet, tc.wantPending)
+		}
+		if tc.wantPending && !l.PendingFrom.Time.Equal(now.Add(tc.at+limitCoolingOff)) {
+			t.Errorf("%s: pending from %s, want %s later", tc.name, l.PendingFrom.Time, limitCoolingOff)
+		}
+	}
+
+	checkLimit := func(at time.Duration, want Money, wantSet bool) {
+		t.Helper()
+		limits, err := getGamblingLimits(db, userID, now.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := limits[LimitDailyLoss]; l.Amount != want || l.Set != wantSet {
+			t.Errorf("at +%s: limit %s (set %v), want %s (set %v)", at, l.Amount, l.Set, want, wantSet)
+		}
+	}
+	// The raise made at +3h takes effect 24 hours later, to the second.
+	checkLimit(27*time.Hour-time.Second, 20*Credit, true)
+	checkLimit(27*time.Hour, 40*Credit, true)
+
+	l, err := setGamblingLimit(db, userID, LimitDailyLoss, 0, false, now.Add(30*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Set || l.Amount != 40*Credit || l.PendingAmount.Valid || !l.PendingFrom.Valid {
+		t.Errorf("removing: limit %s (set %v), pending %v from %v; want 40.00 until the removal", l.Amount, l.Set, l.PendingAmount, l.PendingFrom)
+	}
+	checkLimit(54*time.Hour-time.Second, 40*Credit, true)
+	checkLimit(54*time.Hour, 0, false)
+}
+
+func TestCheckBetLimits(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Punter", "punter@example.com", 1000*Credit)
+	now := time.Date(2026, 3, 11, 15, 0, 0, 0, time.Local) // A Wednesday
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, time.Local)
+	}
+
+	// The ledger is append-only; the test moves entries back in time as if they had been made then.
+	if _, err := db.Exec("DROP TRIGGER wallet_transactions_no_update"); err != nil {
+		t.Fatal(err)
+	}
+	backdate := func(betID int, when time.Time) {
+		t.Helper()
+		stamp := when.UTC().Format(limitTimestampLayout)
+		if betID != 0 {
+			setBetPlacedAt(t, betID, when)
+		}
+		if _, err := db.Exec("UPDATE wallet_transactions SET created_at = ? WHERE id = (SELECT MAX(id) FROM wallet_transactions)", stamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bet := func(stake Money, when time.Time) int {
+		t.Helper()
+		betID := placeTestBet(t, userID, scheduledTestRace, BetTypeWin, []int{1}, stake, 2.5)
+		backdate(betID, when)
+		return betID
+	}
+	credit := func(kind string, amount Money, betID int, when time.Time) {
+		t.Helper()
+		if _, err := postWalletTransaction(db, WalletTransaction{UserID: userID, Kind: kind, Amount: amount, BetID: betID}); err != nil {
+			t.Fatal(err)
+		}
+		backdate(0, when)
+	}
+
+	bet(70*Credit, at(time.February, 27, 12)) // Last month
+	bet(50*Credit, at(time.March, 8, 20))     // This month, last week
+	bet(100*Credit, at(time.March, 9, 10))    // This week, not today
+	won := bet(40*Credit, at(time.March, 11, 9))
+	credit(WalletTxPayout, 60*Credit, won, at(time.March, 11, 12))
+	cancelled := bet(30*Credit, at(time.March, 11, 10))
+	credit(WalletTxRefund, 30*Credit, cancelled, at(time.March, 11, 10))
+	if _, err := db.Exec("UPDATE bets SET bet_status_id = (SELECT id FROM bet_statuses WHERE status_name = 'Cancelled') WHERE id = ?", cancelled); err != nil {
+		t.Fatal(err)
+	}
+	credit(WalletTxBonus, 200*Credit, 0, at(time.March, 11, 11)) // Bonuses are not winnings
+	// Net loss: today -20 (ahead), this week 80, this month 130. Staked today: 40, the cancelled bet aside.
+
+	type limit struct {
+		kind   string
+		amount Money
+		setAt  time.Time
+	}
+	for _, tc := range []struct {
+		name          string
+		limits        []limit
+		stake         Money
+		wantKind      string // Empty when the bet is allowed
+		wantRemaining Money
+	}{
+		{"no limits", nil, 500 * Credit, "", 0},
+		{"stake per bet, at the limit", []limit{{LimitStakePerBet, 25 * Credit, at(time.March, 1, 0)}}, 25 * Credit, "", 0},
+		{"stake per bet, over", []limit{{LimitStakePerBet, 25 * Credit, at(time.March, 1, 0)}}, 26 * Credit, LimitStakePerBet, 25 * Credit},
+		{"daily loss counts winnings back", []limit{{LimitDailyLoss, 10 * Credit, at(time.March, 1, 0)}}, 30 * Credit, "", 0},
+		{"daily loss, over", []limit{{LimitDailyLoss, 10 * Credit, at(time.March, 1, 0)}}, 31 * Credit, LimitDailyLoss, 30 * Credit},
+		{"weekly loss starts on Monday", []limit{{LimitWeeklyLoss, 100 * Credit, at(time.March, 1, 0)}}, 20 * Credit, "", 0},
+		{"weekly loss, over", []limit{{LimitWeeklyLoss, 100 * Credit, at(time.March, 1, 0)}}, 21 * Credit, LimitWeeklyLoss, 20 * Credit},
+		{"monthly loss already reached", []limit{{LimitMonthlyLoss, 100 * Credit, at(time.March, 1, 0)}}, Credit, LimitMonthlyLoss, 0},
+		{"daily stake leaves out cancelled bets", []limit{{LimitDailyStake, 50 * Credit, at(time.March, 1, 0)}}, 10 * Credit, "", 0},
+		{"daily stake, over", []limit{{LimitDailyStake, 50 * Credit, at(time.March, 1, 0)}}, 11 * Credit, LimitDailyStake, 10 * Credit},
+		{"raise still cooling off", []limit{
+			{LimitWeeklyLoss, 100 * Credit, at(time.March, 1, 0)},
+			{LimitWeeklyLoss, 500 * Credit, now.Add(-limitCoolingOff + time.Minute)},
+		}, 50 * Credit, LimitWeeklyLoss, 20 * Credit},
+		{"raise past its cooling-off", []limit{
+			{LimitWeeklyLoss, 100 * Credit, at(time.March, 1, 0)},
+			{LimitWeeklyLoss, 500 * Credit, now.Add(-limitCoolingOff)},
+		}, 50 * Credit, "", 0},
+		{"lowering applies at once", []limit{
+			{LimitWeeklyLoss, 500 * Credit, at(time.March, 1, 0)},
+			{LimitWeeklyLoss, 90 * Credit, now},
+		}, 20 * Credit, LimitWeeklyLoss, 10 * Credit},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := db.Exec("DELETE FROM gambling_limits WHERE user_id = ?", userID); err != nil {
+				t.Fatal(err)
+			}
+			for _, l := range tc.limits {
+				if _, err := setGamblingLimit(db, userID, l.kind, l.amount, true, l.setAt); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := checkBetLimits(db, userID, tc.stake, now)
+			var exceeded *LimitExceededError
+			switch {
+			case tc.wantKind == "" && err != nil:
+				t.Errorf("checkBetLimits = %v, want the bet allowed", err)
+			case tc.wantKind != "" && !errors.As(err, &exceeded):
+				t.Errorf("checkBetLimits = %v, want the %s limit exceeded", err, tc.wantKind)
+			case tc.wantKind != "" && (exceeded.Kind != tc.wantKind || exceeded.Remaining != tc.wantRemaining):
+				t.Errorf("checkBetLimits = %v, want the %s limit exceeded with %s remaining", err, tc.wantKind, tc.wantRemaining)
+			}
+		})
+	}
+}
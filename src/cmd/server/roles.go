@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Roles, from least to most trusted. Each role can do everything the ones before it can.
+const (
+	RolePlayer    = "player"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// roles lists every role in order of trust.
+var roles = []string{RolePlayer, RoleModerator, RoleAdmin}
+
+var errUnknownRole = errors.New("unknown role")
+
+// roleRank returns a role's position in roles, or -1 for unknown roles.
+func roleRank(role string) int {
+	for i, r := range roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasRole reports whether a user with the given role may act as the required role.
+func hasRole(role, required string) bool {
+	rank := roleRank(role)
+	return rank >= 0 && rank >= roleRank(required)
+}
+
+// getUserRole returns a user's role.
+func getUserRole(q rowQuerier, userID int) (string, error) {
+	var role string
+	if err := q.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role); err != nil {
+		return "", fmt.Errorf("error reading role of user %d: %w", userID, err)
+	}
+	return role, nil
+}
+
+// setUserRole changes a user's role.
+func setUserRole(ex execer, userID int, role string) error {
+	if roleRank(role) < 0 {
+		return fmt.Errorf("%w: %q", errUnknownRole, role)
+	}
+	result, err := ex.Exec("UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", role, userID)
+	if err != nil {
+		return fmt.Errorf("error setting role of user %d: %w", userID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("error setting role of user %d: %w", userID, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// requireRole returns middleware that lets through only users with at least the given role. Guests are
+// sent to log in as by requireAuthentication; logged-in users without the role get 403 Forbidden. The role
+// is read from the database on every request, so a demotion takes effect straight away.
+func requireRole(required string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return requireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := authenticatedUserID(r)
+			role, err := getUserRole(db, userID)
+			if err != nil {
+				log.Printf("requireRole: %v", err)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if !hasRole(role, required) {
+				log.Printf("requireRole: User %d (%s) denied %s %s, which needs %s.", userID, role, r.Method, r.URL.Path, required)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
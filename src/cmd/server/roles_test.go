@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHasRole(t *testing.T) {
+	for _, tc := range []struct {
+		role, required string
+		want           bool
+	}{
+		{RolePlayer, RolePlayer, true},
+		{RolePlayer, RoleModerator, false},
+		{RoleModerator, RoleModerator, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleAdmin, RoleModerator, true},
+		{RoleAdmin, RoleAdmin, true},
+		{"root", RolePlayer, false},
+		{"", RolePlayer, false},
+	} {
+		if got := hasRole(tc.role, tc.required); got != tc.want {
+			t.Errorf("hasRole(%q, %q) = %v, want %v", tc.role, tc.required, got, tc.want)
+		}
+	}
+}
+
+func TestRequireRoleGuardsAdminRoutes(t *testing.T) {
+	setupHandlerTest(t)
+	accounts := map[string]*http.Cookie{}
+	var adminID int
+	for _, role := range roles {
+		userID := createTestUser(t, role, role+"@example.com", 0)
+		adminID = userID // roles ends with RoleAdmin
+		if err := setUserRole(db, userID, role); err != nil {
+			t.Fatal(err)
+		}
+		accounts[role] = loginCookie(t, userID, role)
+	}
+
+	reached := false
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/admin/trigger-race-cycle", func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})
+	admin := requireRole(RoleAdmin)(adminMux)
+
+	for _, tc := range []struct {
+		name       string
+		cookie     *http.Cookie
+		wantStatus int
+		wantReach  bool
+	}{
+		{"guest", nil, http.StatusSeeOther, false},
+		{RolePlayer, accounts[RolePlayer], http.StatusForbidden, false},
+		{RoleModerator, accounts[RoleModerator], http.StatusForbidden, false},
+		{RoleAdmin, accounts[RoleAdmin], http.StatusOK, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reached = false
+			rec := serve(admin, httptest.NewRequest(http.MethodPost, "/admin/trigger-race-cycle", nil), tc.cookie)
+			if rec.Code != tc.wantStatus || reached != tc.wantReach {
+				t.Errorf("got status %d (reached %v), want %d (reached %v)", rec.Code, reached, tc.wantStatus, tc.wantReach)
+			}
+		})
+	}
+
+	// Demoting an admin takes effect on their next request.
+	if err := setUserRole(db, adminID, RolePlayer); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(admin, httptest.NewRequest(http.MethodPost, "/admin/trigger-race-cycle", nil), accounts[RoleAdmin]); rec.Code != http.StatusForbidden {
+		t.Errorf("demoted admin got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if err := setUserRole(db, adminID, "root"); err == nil {
+		t.Error("setUserRole accepted an unknown role")
+	}
+}
+
+func TestModeratorsCanReadTheAuditLog(t *testing.T) {
+	setupHandlerTest(t)
+	base := template.Must(template.ParseFiles("../../web/templates/base.gohtml"))
+	prevAudit := adminAuditTemplate
+	adminAuditTemplate = template.Must(template.Must(base.Clone()).ParseFiles("../../web/templates/admin-audit.gohtml"))
+	t.Cleanup(func() { adminAuditTemplate = prevAudit })
+
+	if _, err := recordAuditEvent(db, newAuditEvent(testAdmin, AuditUserLocked, "user", 1)); err != nil {
+		t.Fatal(err)
+	}
+	accounts := map[string]*http.Cookie{}
+	for _, role := range roles {
+		userID := createTestUser(t, role, role+"@example.com", 0)
+		if err := setUserRole(db, userID, role); err != nil {
+			t.Fatal(err)
+		}
+		accounts[role] = loginCookie(t, userID, role)
+	}
+	auditLog := requireRole(RoleModerator)(http.HandlerFunc(adminAuditHandler))
+
+	for _, tc := range []struct {
+		role         string
+		wantStatus   int
+		wantConsole  bool // Links to the rest of the admin console, which moderators cannot open
+		wantNavEntry string
+	}{
+		{RolePlayer, http.StatusForbidden, false, ""},
+		{RoleModerator, http.StatusOK, false, `<a href="/admin/audit">Audit log</a>`},
+		{RoleAdmin, http.StatusOK, true, `<a href="/admin/">Admin</a>`},
+	} {
+		rec := serve(auditLog, httptest.NewRequest(http.MethodGet, "/admin/audit", nil), accounts[tc.role])
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%s: status %d, want %d", tc.role, rec.Code, tc.wantStatus)
+			continue
+		}
+		if tc.wantStatus != http.StatusOK {
+			continue
+		}
+		body := rec.Body.String()
+		if got := strings.Contains(body, `href="/admin/users/view?id=1"`); got != tc.wantConsole {
+			t.Errorf("%s: links to the user console = %v, want %v", tc.role, got, tc.wantConsole)
+		}
+		if !strings.Contains(body, tc.wantNavEntry) {
+			t.Errorf("%s: page has no %s link", tc.role, tc.wantNavEntry)
+		}
+	}
+}
+
+func TestSetRoleCommand(t *testing.T) {
+	setupTestDB(t)
+	userID := createTestUser(t, "Staff", "staff@example.com", 0)
+
+	for _, tc := range []struct {
+		args     []string
+		wantCode int
+		wantRole string
+		wantOut  string
+	}{
+		{[]string{"set-role", "staff@example.com", RoleModerator}, 0, RoleModerator, "promoted from player to moderator"},
+		{[]string{"promote-admin", "Staff@Example.com"}, 0, RoleAdmin, "promoted from moderator to admin"},
+		{[]string{"set-role", "staff@example.com", RoleAdmin}, 0, RoleAdmin, "already has the admin role"},
+		{[]string{"set-role", "staff@example.com", RolePlayer}, 0, RolePlayer, "demoted from admin to player"},
+		{[]string{"set-role", "staff@example.com", "root"}, 2, RolePlayer, "usage: set-role <email> <player|moderator|admin>"},
+		{[]string{"set-role", "nobody@example.com", RoleAdmin}, 1, RolePlayer, "No user with email"},
+	} {
+		var out bytes.Buffer
+		if code := runCommand(db, tc.args, &out); code != tc.wantCode {
+			t.Errorf("%v: exit code %d, want %d", tc.args, code, tc.wantCode)
+		}
+		if !strings.Contains(out.String(), tc.wantOut) {
+			t.Errorf("%v: printed %q, want %q", tc.args, out.String(), tc.wantOut)
+		}
+		if role, err := getUserRole(db, userID); err != nil || role != tc.wantRole {
+			t.Errorf("%v: role %q, %v; want %q", tc.args, role, err, tc.wantRole)
+		}
+	}
+
+	// Each change of role is audited; the no-op and failed commands are not.
+	events, err := getTargetAuditEvents(db, "admin", "user", userID)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("got %d role audit events, %v; want 3", len(events), err)
+	}
+	if last := events[0]; last.Action != AuditAdminRoleChanged || last.Before != `{"role":"admin"}` || last.After != `{"role":"player"}` {
+		t.Errorf("last role event = %+v", last)
+	}
+}
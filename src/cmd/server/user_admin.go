@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	adminUserSearchLimit     = 50
+	adminRecentLedgerEntries = 20
+	maxBalanceAdjustment     = 100000 * Credit // Guards against typos; larger corrections are made in steps
+)
+
+var (
+	errAccountNotFound   = errors.New("user not found")
+	errReasonRequired    = errors.New("a reason is required")
+	errInvalidAdjustment = errors.New("invalid balance adjustment")
+	errOwnAccount        = errors.New("administrators cannot act on their own account")
+)
+
+// likeEscaper escapes the wildcards of a LIKE pattern, for use with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// AdminUserRow is a user in the admin user search.
+type AdminUserRow struct {
+	ID        int
+	Name      string
+	Email     string
+	Role      string
+	Balance   Money
+	Locked    bool
+	CreatedAt time.Time
+}
+
+// AdminUserDetail is everything the user management console shows about one user.
+type AdminUserDetail struct {
+	AdminUserRow
+	DateOfBirth       string    // Empty when none was recorded
+	SelfExcludedUntil time.Time // Zero when not self-excluded
+	Sessions          int       // Logged-in sessions
+	Bets              *BetHistoryView
+	Ledger            []WalletTransaction // Latest entries first
+	Actions           []AuditEvent        // Actions administrators took on the account, latest first
+}
+
+const adminUserQuery = "SELECT id, name, email, role, balance, locked_at IS NOT NULL, created_at FROM users"
+
+// scanAdminUser scans a row of adminUserQuery.
+func scanAdminUser(scan func(dest ...interface{}) error) (AdminUserRow, error) {
+	var u AdminUserRow
+	err := scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.Balance, &u.Locked, &u.CreatedAt)
+	return u, err
+}
+
+// searchUsers finds users whose name or email contains term, or whose ID is term. An empty term
+// lists the newest accounts.
+func searchUsers(q querier, term string) ([]AdminUserRow, error) {
+	query := adminUserQuery
+	var args []interface{}
+	if term = strings.TrimSpace(term); term != "" {
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		id, _ := strconv.Atoi(term)
+		query += ` WHERE name LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\' OR id = ?`
+		args = append(args, pattern, pattern, id)
+	}
+	rows, err := q.Query(query+" ORDER BY id DESC LIMIT ?", append(args, adminUserSearchLimit)...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching users for %q: %w", term, err)
+	}
+	defer rows.Close()
+
+	var users []AdminUserRow
+	for rows.Next() {
+		u, err := scanAdminUser(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+	return users, nil
+}
+
+// getAdminUserDetail loads a user with their first page of bets, latest ledger entries and the
+// actions administrators have taken on the account.
+func getAdminUserDetail(ctx context.Context, q querier, userID int) (*AdminUserDetail, error) {
+	u, err := scanAdminUser(q.QueryRow(adminUserQuery+" WHERE id = ?", userID).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errAccountNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading user %d: %w", userID, err)
+	}
+	detail := &AdminUserDetail{AdminUserRow: u}
+
+	var dob sql.NullString
+	if err := q.QueryRow("SELECT date_of_birth FROM users WHERE id = ?", userID).Scan(&dob); err != nil {
+		return nil, fmt.Errorf("error loading date of birth of user %d: %w", userID, err)
+	}
+	detail.DateOfBirth = dob.String
+	if detail.SelfExcludedUntil, err = getSelfExclusion(q, userID, time.Now()); err != nil {
+		return nil, err
+	}
+	if detail.Sessions, err = countUserSessions(ctx, userID); err != nil {
+		return nil, err
+	}
+	if detail.Bets, err = getBetHistory(q, userID, BetHistoryFilter{Page: 1}); err != nil {
+		return nil, err
+	}
+	if detail.Ledger, err = getRecentWalletTransactions(q, userID, adminRecentLedgerEntries); err != nil {
+		return nil, err
+	}
+	if detail.Actions, err = getTargetAuditEvents(q, "user", "user", userID); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// getRecentWalletTransactions returns the user's latest wallet ledger entries, newest first.
+func getRecentWalletTransactions(q querier, userID, limit int) ([]WalletTransaction, error) {
+	rows, err := q.Query(`
+        SELECT id, kind, amount, balance_after, COALESCE(bet_id, 0), COALESCE(race_id, 0), COALESCE(note, ''), created_at
+        FROM wallet_transactions WHERE user_id = ? ORDER BY id DESC LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying wallet ledger of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var entries []WalletTransaction
+	for rows.Next() {
+		t := WalletTransaction{UserID: userID}
+		if err := rows.Scan(&t.ID, &t.Kind, &t.Amount, &t.BalanceAfter, &t.BetID, &t.RaceID, &t.Note, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning wallet ledger of user %d: %w", userID, err)
+		}
+		entries = append(entries, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating wallet ledger of user %d: %w", userID, err)
+	}
+	return entries, nil
+}
+
+// checkAccountAction validates an admin action on a user's account and returns the trimmed reason.
+// Admins cannot act on their own account, so every change has a second person behind it.
+func checkAccountAction(q rowQuerier, adminID, userID int, reason string) (string, error) {
+	if reason = strings.TrimSpace(reason); reason == "" {
+		return "", errReasonRequired
+	}
+	if adminID == userID {
+		return "", errOwnAccount
+	}
+	var exists int
+	if err := q.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", userID).Scan(&exists); err != nil {
+		return "", fmt.Errorf("error checking user %d: %w", userID, err)
+	}
+	if exists == 0 {
+		return "", errAccountNotFound
+	}
+	return reason, nil
+}
+
+// adjustBalance credits or debits a user's wallet through the wallet ledger and returns the new balance.
+// Debits cannot take the balance below zero.
+func adjustBalance(db *sql.DB, actor AuditActor, userID int, amount Money, reason string) (Money, error) {
+	if amount == 0 || amount > maxBalanceAdjustment || amount < -maxBalanceAdjustment {
+		return 0, errInvalidAdjustment
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if reason, err = checkAccountAction(tx, actor.ID, userID, reason); err != nil {
+		return 0, err
+	}
+	balance, err := postWalletTransaction(tx, WalletTransaction{UserID: userID, Kind: WalletTxAdjustment, Amount: amount, Note: reason})
+	if err != nil {
+		return 0, err
+	}
+	event := newAuditEvent(actor, AuditUserBalanceAdjusted, "user", userID)
+	event.Before = auditValue(map[string]Money{"balance": balance - amount})
+	event.After = auditValue(map[string]Money{"balance": balance, "amount": amount})
+	event.Reason = reason
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing balance adjustment of user %d: %w", userID, err)
+	}
+	return balance, nil
+}
+
+// setAccountLocked locks or unlocks a user's account. Locking also ends every session the user is logged
+// in with; it returns how many.
+func setAccountLocked(ctx context.Context, db *sql.DB, actor AuditActor, userID int, locked bool, reason string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if reason, err = checkAccountAction(tx, actor.ID, userID, reason); err != nil {
+		return 0, err
+	}
+	action, update := AuditUserUnlocked, "UPDATE users SET locked_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND locked_at IS NOT NULL"
+	if locked {
+		action, update = AuditUserLocked, "UPDATE users SET locked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND locked_at IS NULL"
+	}
+	result, err := tx.Exec(update, userID)
+	if err != nil {
+		return 0, fmt.Errorf("error changing lock of user %d: %w", userID, err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		event := newAuditEvent(actor, action, "user", userID)
+		event.Before = auditValue(map[string]bool{"locked": !locked})
+		event.After = auditValue(map[string]bool{"locked": locked})
+		event.Reason = reason
+		if _, err := recordAuditEvent(tx, event); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing lock of user %d: %w", userID, err)
+	}
+	if !locked {
+		return 0, nil
+	}
+	return destroyUserSessions(ctx, userID)
+}
+
+// forceLogout ends every session a user is logged in with and returns how many.
+func forceLogout(ctx context.Context, db *sql.DB, actor AuditActor, userID int, reason string) (int, error) {
+	reason, err := checkAccountAction(db, actor.ID, userID, reason)
+	if err != nil {
+		return 0, err
+	}
+	ended, err := destroyUserSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	event := newAuditEvent(actor, AuditUserLoggedOut, "user", userID)
+	event.Before = auditValue(map[string]int{"sessions": ended})
+	event.After = auditValue(map[string]int{"sessions": 0})
+	event.Reason = reason
+	if _, err := appendAuditEvent(db, event); err != nil {
+		return ended, err
+	}
+	return ended, nil
+}
+
+// resetPassword replaces a user's password with a random temporary one, which it returns so the admin can
+// pass it on, and ends the user's sessions. The temporary password is not stored or logged anywhere else.
+func resetPassword(ctx context.Context, db *sql.DB, actor AuditActor, userID int, reason string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating temporary password: %w", err)
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("error hashing temporary password: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if reason, err = checkAccountAction(tx, actor.ID, userID, reason); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", string(hash), userID); err != nil {
+		return "", fmt.Errorf("error resetting password of user %d: %w", userID, err)
+	}
+	// Neither the password nor its hash goes in the audit log.
+	event := newAuditEvent(actor, AuditUserPasswordReset, "user", userID)
+	event.Reason = reason
+	if _, err := recordAuditEvent(tx, event); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing password reset of user %d: %w", userID, err)
+	}
+	if _, err := destroyUserSessions(ctx, userID); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// countUserSessions returns how many sessions in the session store are logged in as the user.
+func countUserSessions(ctx context.Context, userID int) (int, error) {
+	count := 0
+	err := sessionManager.Iterate(ctx, func(ctx context.Context) error {
+		if sessionManager.GetInt(ctx, sessionUserIDKey) == userID {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error counting sessions of user %d: %w", userID, err)
+	}
+	return count, nil
+}
+
+// destroyUserSessions destroys every session in the session store that is logged in as the user and
+// returns how many. The user's next request is treated as a guest's.
+func destroyUserSessions(ctx context.Context, userID int) (int, error) {
+	ended := 0
+	err := sessionManager.Iterate(ctx, func(ctx context.Context) error {
+		if sessionManager.GetInt(ctx, sessionUserIDKey) != userID {
+			return nil
+		}
+		ended++
+		return sessionManager.Destroy(ctx)
+	})
+	if err != nil {
+		return ended, fmt.Errorf("error ending sessions of user %d: %w", userID, err)
+	}
+	return ended, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// AdminUsersView is the data of the user management console.
+type AdminUsersView struct {
+	Query  string
+	Users  []AdminUserRow
+	Detail *AdminUserDetail // The user being managed; nil on the search page
+}
+
+// userAdminErrorMessage turns an error from an account action into a message for the operator.
+func userAdminErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errAccountNotFound):
+		return "That user no longer exists."
+	case errors.Is(err, errReasonRequired):
+		return "Enter a reason. It is recorded with the action."
+	case errors.Is(err, errOwnAccount):
+		return "You cannot take this action on your own account. Ask another administrator."
+	case errors.Is(err, errInvalidAdjustment):
+		return fmt.Sprintf("Enter a non-zero amount of at most %s either way, like 25 or -12.50.", Money(maxBalanceAdjustment))
+	case errors.Is(err, errInsufficientFunds):
+		return "The debit is larger than the user's balance."
+	default:
+		return fmt.Sprintf("The action failed: %v", err)
+	}
+}
+
+// adminUsersHandler renders the user search at /admin/users.
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	users, err := searchUsers(db, query)
+	if err != nil {
+		log.Printf("adminUsersHandler: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderAdminUsersPage(w, r, &AdminUsersView{Query: query, Users: users})
+}
+
+// adminUserSearchHandler renders the search results. The search box reloads them as the operator types.
+func adminUserSearchHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := searchUsers(db, r.URL.Query().Get("q"))
+	if err != nil {
+		log.Printf("adminUserSearchHandler: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := adminUsersTemplate.ExecuteTemplate(w, "admin-users-results", users); err != nil {
+		log.Printf("adminUserSearchHandler: Template execution error: %v", err)
+	}
+}
+
+// adminUserHandler renders the console for the user in ?id=.
+func adminUserHandler(w http.ResponseWriter, r *http.Request) {
+	detail, ok := loadAdminUserDetail(w, r)
+	if !ok {
+		return
+	}
+	renderAdminUsersPage(w, r, &AdminUsersView{Detail: detail})
+}
+
+// adminUserPanelHandler renders the account panel of the console, which reloads after every action.
+func adminUserPanelHandler(w http.ResponseWriter, r *http.Request) {
+	detail, ok := loadAdminUserDetail(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := adminUsersTemplate.ExecuteTemplate(w, "admin-user-panel", detail); err != nil {
+		log.Printf("adminUserPanelHandler: Template execution error: %v", err)
+	}
+}
+
+// loadAdminUserDetail loads the user in ?id=, answering with an error itself when it cannot.
+func loadAdminUserDetail(w http.ResponseWriter, r *http.Request) (*AdminUserDetail, bool) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	detail, err := getAdminUserDetail(r.Context(), db, userID)
+	if errors.Is(err, errAccountNotFound) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("loadAdminUserDetail: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return detail, true
+}
+
+// adminUserExportHandler streams the bets or wallet ledger of the user in ?id=, taking the same query
+// parameters as /my-bets/export. Each export is recorded in the audit log.
+func adminUserExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", userID).Scan(&exists); err != nil {
+		log.Printf("adminUserExportHandler: Error looking up user %d: %v", userID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if exists == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	data, format, ok := parseExportRequest(w, r)
+	if !ok {
+		return
+	}
+	event := newAuditEvent(requestActor(r), AuditUserExported, "user", userID)
+	event.After = auditValue(map[string]string{"data": data, "format": format, "query": r.URL.RawQuery})
+	logAuditEvent(event)
+	writeExport(w, r, userID, data, format)
+}
+
+// renderAdminUsersPage renders the console page with the search or a user.
+func renderAdminUsersPage(w http.ResponseWriter, r *http.Request, view *AdminUsersView) {
+	data := PageData{Title: "Users - Scramble Run", AdminUsers: view}
+	var err error
+	data.UserData, data.UserBalance, _, err = authenticatedUser(db, r)
+	if err != nil {
+		log.Printf("renderAdminUsersPage: %v", err)
+	}
+	renderTemplateWithStatus(w, r, http.StatusOK, adminUsersTemplate, "base.gohtml", data)
+}
+
+// adminUserActionHandler adjusts the balance of, locks, unlocks, logs out or resets the password of the
+// user in the id field. It answers with the outcome and triggers userChanged so the account panel reloads.
+func adminUserActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := requestActor(r)
+	adminID := actor.ID
+	action := r.FormValue("action")
+	reason := r.FormValue("reason")
+	userID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		renderAdminFeedback(w, AdminFeedback{Message: "Invalid user ID."})
+		return
+	}
+	log.Printf("ADMIN: User %d requested %s of user %d.", adminID, action, userID)
+
+	var feedback AdminFeedback
+	switch action {
+	case "adjust":
+		amount, parseErr := ParseMoney(r.FormValue("amount"))
+		if parseErr != nil {
+			err = errInvalidAdjustment
+			break
+		}
+		var balance Money
+		if balance, err = adjustBalance(db, actor, userID, amount, reason); err == nil {
+			feedback.Message = fmt.Sprintf("Adjusted the balance of user %d by %s. New balance: %s.", userID, amount, balance)
+		}
+	case "lock":
+		var ended int
+		if ended, err = setAccountLocked(r.Context(), db, actor, userID, true, reason); err == nil {
+			feedback.Message = fmt.Sprintf("User %d locked and logged out of %d session(s).", userID, ended)
+		}
+	case "unlock":
+		if _, err = setAccountLocked(r.Context(), db, actor, userID, false, reason); err == nil {
+			feedback.Message = fmt.Sprintf("User %d unlocked.", userID)
+		}
+	case "logout":
+		var ended int
+		if ended, err = forceLogout(r.Context(), db, actor, userID, reason); err == nil {
+			feedback.Message = fmt.Sprintf("User %d logged out of %d session(s).", userID, ended)
+		}
+	case "reset-password":
+		var password string
+		if password, err = resetPassword(r.Context(), db, actor, userID, reason); err == nil {
+			feedback.Message = fmt.Sprintf("Password of user %d reset and their sessions ended. Temporary password: %s (shown once; pass it on over a verified channel).", userID, password)
+		}
+	default:
+		renderAdminFeedback(w, AdminFeedback{Message: "Unknown action."})
+		return
+	}
+
+	if err != nil {
+		log.Printf("ADMIN: %s of user %d by user %d failed: %v", action, userID, adminID, err)
+		feedback.Message = userAdminErrorMessage(err)
+	} else {
+		// Not feedback.Message, which can hold a temporary password.
+		log.Printf("ADMIN: User %d: %s of user %d done.", adminID, action, userID)
+		feedback.Success = true
+	}
+	w.Header().Set("HX-Trigger", "userChanged")
+	renderAdminFeedback(w, feedback)
+}
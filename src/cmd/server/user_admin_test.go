@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUserAdminActions(t *testing.T) {
+	setupHandlerTest(t)
+	ctx := context.Background()
+	adminID := createTestUser(t, "Admin", "admin@example.com", 0)
+	admin := AuditActor{ID: adminID, Name: "Admin", IP: "203.0.113.7"}
+	playerID := createTestUser(t, "Player", "player@example.com", Credits(100))
+	otherID := createTestUser(t, "Other", "other@example.com", 0)
+	loginCookie(t, adminID, "Admin")
+	loginCookie(t, playerID, "Player")
+	loginCookie(t, playerID, "Player") // A second device
+	loginCookie(t, otherID, "Other")
+
+	for _, tc := range []struct {
+		name   string
+		actor  AuditActor
+		amount Money
+		reason string
+		want   error
+	}{
+		{"no reason", admin, Credits(10), "  ", errReasonRequired},
+		{"zero", admin, 0, "Goodwill", errInvalidAdjustment},
+		{"too large", admin, maxBalanceAdjustment + 1, "Goodwill", errInvalidAdjustment},
+		{"own account", AuditActor{ID: playerID, Name: "Player"}, Credits(10), "Goodwill", errOwnAccount},
+		{"overdraw", admin, -Credits(101), "Chargeback", errInsufficientFunds},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := adjustBalance(db, tc.actor, playerID, tc.amount, tc.reason); !errors.Is(err, tc.want) {
+				t.Errorf("adjustBalance = %v, want %v", err, tc.want)
+			}
+		})
+	}
+	balance, err := adjustBalance(db, admin, playerID, -Credits(25), " Chargeback ")
+	if err != nil || balance != Credits(75) {
+		t.Fatalf("adjustBalance = %s, %v; want %s", balance, err, Credits(75))
+	}
+	if drifts, err := reconcileWallets(db); err != nil || len(drifts) != 0 {
+		t.Errorf("adjustment left the ledger out of balance: %v, %v", drifts, err)
+	}
+
+	// Locking ends only the locked user's sessions.
+	ended, err := setAccountLocked(ctx, db, admin, playerID, true, "Suspected fraud")
+	if err != nil || ended != 2 {
+		t.Fatalf("setAccountLocked = %d, %v; want 2 sessions ended", ended, err)
+	}
+	for id, want := range map[int]int{playerID: 0, adminID: 1, otherID: 1} {
+		if got, err := countUserSessions(ctx, id); err != nil || got != want {
+			t.Errorf("user %d has %d sessions (%v), want %d", id, got, err, want)
+		}
+	}
+
+	if ended, err := forceLogout(ctx, db, admin, otherID, "Shared device"); err != nil || ended != 1 {
+		t.Errorf("forceLogout = %d, %v; want 1 session ended", ended, err)
+	}
+
+	password, err := resetPassword(ctx, db, admin, playerID, "Owner verified by phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hash string
+	if err := db.QueryRow("SELECT password_hash FROM users WHERE id = ?", playerID).Scan(&hash); err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		t.Error("temporary password does not match the stored hash")
+	}
+
+	detail, err := getAdminUserDetail(ctx, db, playerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !detail.Locked || detail.Balance != Credits(75) || detail.Ledger[0].Kind != WalletTxAdjustment || detail.Ledger[0].Note != "Chargeback" {
+		t.Errorf("detail = %+v", detail.AdminUserRow)
+	}
+	var actions []string
+	for _, a := range detail.Actions {
+		actions = append(actions, a.Action+" by "+a.Actor+" from "+a.IP)
+	}
+	want := []string{"user.password_reset by Admin from 203.0.113.7", "user.locked by Admin from 203.0.113.7", "user.balance_adjusted by Admin from 203.0.113.7"}
+	if len(actions) != len(want) || actions[0] != want[0] || actions[1] != want[1] || actions[2] != want[2] {
+		t.Errorf("account actions = %v, want %v", actions, want)
+	}
+	if adjusted := detail.Actions[2]; adjusted.Before != `{"balance":100.00}` || adjusted.After != `{"amount":-25.00,"balance":75.00}` {
+		t.Errorf("balance adjustment recorded %s -> %s", adjusted.Before, adjusted.After)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	setupHandlerTest(t)
+	createTestUser(t, "Henny Penny", "henny@example.com", 0)
+	createTestUser(t, "Rooster 100%", "rooster@example.org", 0)
+
+	for _, tc := range []struct {
+		term string
+		want int
+	}{
+		{"PENNY", 1},
+		{"example.org", 1},
+		{"100%", 1},
+		{"%", 1}, // A literal percent sign, not a wildcard
+		{"_", 0},
+		{"nobody", 0},
+	} {
+		users, err := searchUsers(db, tc.term)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != tc.want {
+			t.Errorf("searchUsers(%q) found %d users, want %d", tc.term, len(users), tc.want)
+		}
+	}
+}
+
+func TestAdminUserExport(t *testing.T) {
+	setupHandlerTest(t)
+	adminID := createTestUser(t, "Admin", "admin@example.com", 10*Credit)
+	playerID := createTestUser(t, "Player", "player@example.com", 100*Credit)
+	placeTestBet(t, adminID, scheduledTestRace, BetTypeWin, []int{1}, Credit, 2.5)
+	for range 3 {
+		placeTestBet(t, playerID, scheduledTestRace, BetTypeWin, []int{2}, Credit, 1.8)
+	}
+	cookie := loginCookie(t, adminID, "Admin")
+	export := func(query string) *httptest.ResponseRecorder {
+		return serve(http.HandlerFunc(adminUserExportHandler), httptest.NewRequest(http.MethodGet, "/admin/users/export?"+query, nil), cookie)
+	}
+
+	rec := export("format=json&id=" + strconv.Itoa(playerID))
+	var bets []struct {
+		BetID int `json:"bet_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &bets); err != nil {
+		t.Fatalf("%v in %s", err, rec.Body)
+	}
+	if len(bets) != 3 {
+		t.Errorf("exported %d bets, want the player's 3 and not the admin's", len(bets))
+	}
+
+	rec = export("data=wallet&id=" + strconv.Itoa(playerID))
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 { // The header, the opening balance and 3 stakes
+		t.Errorf("wallet export has %d lines, want 5", len(records))
+	}
+
+	for _, query := range []string{"id=999", "id=abc", ""} {
+		if rec := export(query); rec.Code != http.StatusNotFound {
+			t.Errorf("exporting %q: status %d, want 404", query, rec.Code)
+		}
+	}
+	if rec := export("format=xml&id=" + strconv.Itoa(playerID)); rec.Code != http.StatusBadRequest {
+		t.Errorf("exporting an unknown format: status %d, want 400", rec.Code)
+	}
+
+	// Only the exports that were served are audited.
+	events, err := getTargetAuditEvents(db, "user", "user", playerID)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("got %d export audit events, %v; want 2", len(events), err)
+	}
+	if last := events[0]; last.Action != AuditUserExported || last.ActorID != adminID ||
+		last.After != `{"data":"wallet","format":"csv","query":"data=wallet\u0026id=`+strconv.Itoa(playerID)+`"}` {
+		t.Errorf("last export event = %+v", last)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Wallet transaction kinds, stored in wallet_transactions.kind.
+// Every entry moves credits between a user's wallet and a house account, and postWalletTransaction
+// records both sides, so the ledger as a whole always sums to zero.
+const (
+	WalletTxOpening    = "Opening"    // Starting balance given at signup
+	WalletTxStake      = "Stake"      // Debit when a bet is placed
+	WalletTxPayout     = "Payout"     // Credit when a bet wins
+	WalletTxRefund     = "Refund"     // Credit when a bet is cancelled or its race is not run
+	WalletTxCashOut    = "CashOut"    // Credit when a bet is cashed out early
+	WalletTxBonus      = "Bonus"      // Promotional credit
+	WalletTxAdjustment = "Adjustment" // Manual correction by an administrator
+)
+
+// House accounts, stored in house_transactions.account. Each wallet entry is balanced against one.
+const (
+	HouseAccountBetting     = "Betting"     // Stakes taken and winnings, refunds and cash-outs paid
+	HouseAccountPromotions  = "Promotions"  // Opening balances and bonuses given away
+	HouseAccountAdjustments = "Adjustments" // Manual corrections
+)
+
+// houseAccountFor returns the house account that takes the other side of a wallet entry of the given kind.
+func houseAccountFor(kind string) string {
+	switch kind {
+	case WalletTxOpening, WalletTxBonus:
+		return HouseAccountPromotions
+	case WalletTxAdjustment:
+		return HouseAccountAdjustments
+	default:
+		return HouseAccountBetting
+	}
+}
+
+// startingBalance is credited to every new account as its opening ledger entry.
+const startingBalance = 1000 * Credit
+
+var (
+	errInsufficientFunds = errors.New("insufficient funds")
+	errWalletUserUnknown = errors.New("user not found")
+)
+
+// WalletTransaction is an entry in the append-only wallet ledger.
+type WalletTransaction struct {
+	ID           int64
+	UserID       int
+	Kind         string
+	Amount       Money // Credit to the wallet when positive, debit when negative
+	BalanceAfter Money
+	BetID        int // 0 when the entry is not tied to a bet
+	RaceID       int // 0 when the entry is not tied to a race
+	Note         string
+	CreatedAt    time.Time
+}
+
+// nullableID stores a zero ID as NULL.
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// postWalletTransaction applies an entry to the user's balance and appends it to the ledger together
+// with the house's side, returning the new balance. It is the only place users.balance may change,
+// and must run in the same transaction as the bet or race update that caused it. Debits that would
+// overdraw the wallet fail with errInsufficientFunds.
+func postWalletTransaction(ex queryExecer, t WalletTransaction) (Money, error) {
+	result, err := ex.Exec("UPDATE users SET balance = balance + ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND balance + ? >= 0",
+		t.Amount, t.UserID, t.Amount)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post %s of %s to user %d: %w", t.Kind, t.Amount, t.UserID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists int
+		if err := ex.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", t.UserID).Scan(&exists); err != nil {
+			return 0, fmt.Errorf("failed to check user %d: %w", t.UserID, err)
+		}
+		if exists == 0 {
+			return 0, fmt.Errorf("failed to post %s to user %d: %w", t.Kind, t.UserID, errWalletUserUnknown)
+		}
+		return 0, errInsufficientFunds
+	}
+
+	var balance Money
+	if err := ex.QueryRow("SELECT balance FROM users WHERE id = ?", t.UserID).Scan(&balance); err != nil {
+		return 0, fmt.Errorf("failed to read balance of user %d: %w", t.UserID, err)
+	}
+	result, err = ex.Exec("INSERT INTO wallet_transactions (user_id, kind, amount, balance_after, bet_id, race_id, note) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		t.UserID, t.Kind, t.Amount, balance, nullableID(t.BetID), nullableID(t.RaceID), t.Note)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record %s of %s for user %d: %w", t.Kind, t.Amount, t.UserID, err)
+	}
+	entryID, _ := result.LastInsertId()
+
+	// The balance update above holds the write lock, so the house account's last balance cannot move under us.
+	account := houseAccountFor(t.Kind)
+	var houseBalance Money
+	err = ex.QueryRow("SELECT balance_after FROM house_transactions WHERE account = ? ORDER BY id DESC LIMIT 1", account).Scan(&houseBalance)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to read balance of house account %s: %w", account, err)
+	}
+	_, err = ex.Exec("INSERT INTO house_transactions (wallet_transaction_id, account, amount, balance_after) VALUES (?, ?, ?, ?)",
+		entryID, account, -t.Amount, houseBalance-t.Amount)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record house side of %s of %s for user %d: %w", t.Kind, t.Amount, t.UserID, err)
+	}
+	return balance, nil
+}
+
+// WalletDrift is a user whose stored balance does not match their ledger.
+type WalletDrift struct {
+	UserID        int
+	Name          string
+	Balance       Money // users.balance
+	LedgerBalance Money // Sum of the user's ledger entries
+	BrokenEntryID int64 // First entry whose balance_after does not follow from the ones before it, 0 if none
+}
+
+// Difference returns how far the stored balance is from the ledger.
+func (d WalletDrift) Difference() Money {
+	return d.Balance - d.LedgerBalance
+}
+
+// reconcileWallets rebuilds every user's balance from the ledger and returns those that do not match
+// users.balance, or whose running balance_after breaks somewhere along the ledger.
+func reconcileWallets(q querier) ([]WalletDrift, error) {
+	type ledger struct {
+		sum    Money
+		broken int64
+	}
+	ledgers := make(map[int]*ledger)
+
+	rows, err := q.Query("SELECT id, user_id, amount, balance_after FROM wallet_transactions ORDER BY user_id, id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying wallet ledger: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var userID int
+		var amount, balanceAfter Money
+		if err := rows.Scan(&id, &userID, &amount, &balanceAfter); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning wallet ledger: %w", err)
+		}
+		l, ok := ledgers[userID]
+		if !ok {
+			l = &ledger{}
+			ledgers[userID] = l
+		}
+		l.sum += amount
+		if l.broken == 0 && l.sum != balanceAfter {
+			l.broken = id
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating wallet ledger: %w", err)
+	}
+
+	users, err := q.Query("SELECT id, name, balance FROM users ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying user balances: %w", err)
+	}
+	defer users.Close()
+
+	var drifts []WalletDrift
+	for users.Next() {
+		var d WalletDrift
+		if err := users.Scan(&d.UserID, &d.Name, &d.Balance); err != nil {
+			return nil, fmt.Errorf("error scanning user balance: %w", err)
+		}
+		if l, ok := ledgers[d.UserID]; ok {
+			d.LedgerBalance = l.sum
+			d.BrokenEntryID = l.broken
+		}
+		if d.Difference() != 0 || d.BrokenEntryID != 0 {
+			drifts = append(drifts, d)
+		}
+	}
+	if err := users.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user balances: %w", err)
+	}
+	return drifts, nil
+}
+
+// LedgerImbalance is a wallet or house entry that breaks double entry.
+type LedgerImbalance struct {
+	Table   string // wallet_transactions or house_transactions
+	EntryID int64
+	Problem string
+}
+
+// reconcileHouse checks the house side of the ledger: every wallet entry must be balanced by exactly one
+// house entry for the opposite amount, and each house account's running balance must add up.
+func reconcileHouse(q querier) ([]LedgerImbalance, error) {
+	var imbalances []LedgerImbalance
+	rows, err := q.Query(`
+        SELECT w.id, w.amount, h.amount
+        FROM wallet_transactions w
+        LEFT JOIN house_transactions h ON h.wallet_transaction_id = w.id
+        WHERE h.id IS NULL OR h.amount != -w.amount
+        ORDER BY w.id
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("error querying unbalanced wallet entries: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var amount Money
+		var houseAmount sql.NullInt64
+		if err := rows.Scan(&id, &amount, &houseAmount); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning unbalanced wallet entry: %w", err)
+		}
+		problem := fmt.Sprintf("wallet entry of %s has no house side", amount)
+		if houseAmount.Valid {
+			problem = fmt.Sprintf("wallet entry of %s is balanced by %s", amount, Money(houseAmount.Int64))
+		}
+		imbalances = append(imbalances, LedgerImbalance{Table: "wallet_transactions", EntryID: id, Problem: problem})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating unbalanced wallet entries: %w", err)
+	}
+
+	rows, err = q.Query("SELECT id, account, amount, balance_after FROM house_transactions ORDER BY account, id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying house ledger: %w", err)
+	}
+	defer rows.Close()
+	sums := make(map[string]Money)
+	broken := make(map[string]bool)
+	for rows.Next() {
+		var id int64
+		var account string
+		var amount, balanceAfter Money
+		if err := rows.Scan(&id, &account, &amount, &balanceAfter); err != nil {
+			return nil, fmt.Errorf("error scanning house ledger: %w", err)
+		}
+		sums[account] += amount
+		if !broken[account] && sums[account] != balanceAfter {
+			broken[account] = true
+			imbalances = append(imbalances, LedgerImbalance{Table: "house_transactions", EntryID: id, Problem: fmt.Sprintf("running balance of house account %s breaks", account)})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating house ledger: %w", err)
+	}
+	return imbalances, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// ledgerTotal sums both sides of the ledger, which double entry keeps at zero.
+func ledgerTotal(t *testing.T) Money {
+	t.Helper()
+	var total Money
+	err := db.QueryRow("SELECT (SELECT COALESCE(SUM(amount), 0) FROM wallet_transactions) + (SELECT COALESCE(SUM(amount), 0) FROM house_transactions)").Scan(&total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
+
+// checkLedgerBalanced checks that the wallet ledger matches every balance and is matched by the house side.
+func checkLedgerBalanced(t *testing.T) {
+	t.Helper()
+	if total := ledgerTotal(t); total != 0 {
+		t.Errorf("wallet and house sides sum to %s, want 0", total)
+	}
+	if drifts, err := reconcileWallets(db); err != nil || len(drifts) != 0 {
+		t.Errorf("reconcileWallets = %+v, %v; want no drift", drifts, err)
+	}
+	if imbalances, err := reconcileHouse(db); err != nil || len(imbalances) != 0 {
+		t.Errorf("reconcileHouse = %+v, %v; want no imbalance", imbalances, err)
+	}
+}
+
+// houseAccountBalance returns the running balance of a house account.
+func houseAccountBalance(t *testing.T, account string) Money {
+	t.Helper()
+	var balance Money
+	err := db.QueryRow("SELECT COALESCE((SELECT balance_after FROM house_transactions WHERE account = ? ORDER BY id DESC LIMIT 1), 0)", account).Scan(&balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+func TestWalletTransactionsRecordTheHouseSide(t *testing.T) {
+	setupTestDB(t)
+	const punter = 2
+	for _, tx := range []WalletTransaction{
+		{UserID: punter, Kind: WalletTxStake, Amount: -10 * Credit},
+		{UserID: punter, Kind: WalletTxPayout, Amount: 25 * Credit},
+		{UserID: punter, Kind: WalletTxAdjustment, Amount: -5 * Credit},
+	} {
+		if _, err := postWalletTransaction(db, tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := postWalletTransaction(db, WalletTransaction{UserID: punter, Kind: WalletTxStake, Amount: -5000 * Credit}); err != errInsufficientFunds {
+		t.Fatalf("overdrawing stake: got %v, want errInsufficientFunds", err)
+	}
+
+	checkLedgerBalanced(t)
+	checkBalance(t, punter, (1000-10+25-5)*Credit)
+	if got := houseAccountBalance(t, HouseAccountBetting); got != -15*Credit {
+		t.Errorf("betting account balance %s, want -15.00", got)
+	}
+	if got := houseAccountBalance(t, HouseAccountAdjustments); got != 5*Credit {
+		t.Errorf("adjustments account balance %s, want 5.00", got)
+	}
+	if _, err := db.Exec("DELETE FROM house_transactions"); err == nil {
+		t.Error("house_transactions accepted a delete")
+	}
+}
+
+func TestReconcileDetectsDrift(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		tamper      string
+		wantDrift   bool
+		wantProblem string
+	}{
+		{"balance changed outside the ledger", "UPDATE users SET balance = balance + 500 WHERE id = 2", true, ""},
+		{"wallet entry without a house side",
+			"INSERT INTO wallet_transactions (user_id, kind, amount, balance_after) SELECT id, 'Bonus', 500, balance + 500 FROM users WHERE id = 2",
+			true, "has no house side"},
+		{"house entry for the wrong amount", `
+            INSERT INTO wallet_transactions (user_id, kind, amount, balance_after) SELECT id, 'Adjustment', 0, balance FROM users WHERE id = 2;
+            INSERT INTO house_transactions (wallet_transaction_id, account, amount, balance_after) SELECT MAX(id), 'Adjustments', -700, -700 FROM wallet_transactions`,
+			false, "is balanced by -7.00"},
+		{"house account running balance broken", `
+            INSERT INTO wallet_transactions (user_id, kind, amount, balance_after) SELECT id, 'Adjustment', 0, balance FROM users WHERE id = 2;
+            INSERT INTO house_transactions (wallet_transaction_id, account, amount, balance_after) SELECT MAX(id), 'Adjustments', 0, 900 FROM wallet_transactions`,
+			false, "running balance of house account Adjustments breaks"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupTestDB(t)
+			var out bytes.Buffer
+			if code := reconcileCommand(db, nil, &out); code != 0 {
+				t.Fatalf("reconcile before tampering exited %d: %s", code, out.String())
+			}
+			if _, err := db.Exec(tc.tamper); err != nil {
+				t.Fatal(err)
+			}
+
+			drifts, err := reconcileWallets(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(drifts) > 0; got != tc.wantDrift {
+				t.Errorf("reconcileWallets = %+v, want drift %v", drifts, tc.wantDrift)
+			}
+			imbalances, err := reconcileHouse(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantProblem == "" && len(imbalances) > 0 || tc.wantProblem != "" && (len(imbalances) != 1 || !strings.Contains(imbalances[0].Problem, tc.wantProblem)) {
+				t.Errorf("reconcileHouse = %+v, want problem %q", imbalances, tc.wantProblem)
+			}
+			out.Reset()
+			if code := reconcileCommand(db, nil, &out); code != 1 {
+				t.Errorf("reconcile after tampering exited %d, want 1: %s", code, out.String())
+			}
+		})
+	}
+}
+
+func TestAddHouseLedgerMigration(t *testing.T) {
+	setupTestDB(t)
+	if _, err := postWalletTransaction(db, WalletTransaction{UserID: 2, Kind: WalletTxStake, Amount: -10 * Credit}); err != nil {
+		t.Fatal(err)
+	}
+	// A database from before the house side was recorded.
+	if _, err := db.Exec("DROP TABLE house_transactions"); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := migrateDatabase(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkLedgerBalanced(t)
+}
@@ -1,4 +1,17 @@
+-- Creates a new database. init_database only runs this on an empty database; existing ones are
+-- migrated in place by migrateDatabase (db_migrations.go), which must cover every change made here.
 -- Drop tables if they exist to start fresh
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS date_of_birth_corrections;
+DROP TABLE IF EXISTS gambling_limits;
+DROP TABLE IF EXISTS bonus_claims;
+DROP TABLE IF EXISTS promotions;
+DROP TABLE IF EXISTS house_transactions;
+DROP TABLE IF EXISTS wallet_transactions;
+DROP TABLE IF EXISTS race_results;
+DROP TABLE IF EXISTS bet_legs;
+DROP TABLE IF EXISTS bet_selections;
+DROP TABLE IF EXISTS race_entrants;
 DROP TABLE IF EXISTS bets;
 DROP TABLE IF EXISTS races;
 DROP TABLE IF EXISTS users;
@@ -10,7 +23,12 @@ CREATE TABLE IF NOT EXISTS chickens (
                                         id INTEGER PRIMARY KEY AUTOINCREMENT,
                                         name TEXT NOT NULL UNIQUE,
                                         odds REAL NOT NULL DEFAULT 2.0 CHECK (odds >= 1.0), -- Odds for the chicken, e.g., 2.0 means 2:1
-    -- You can add other chicken-specific attributes here (e.g., color, breed, image_url)
+                                        color TEXT NOT NULL DEFAULT '#f59e0b', -- Silks colour shown on the track
+                                        speed REAL NOT NULL DEFAULT 6.0 CHECK (speed > 0),               -- Top speed (track units/second)
+                                        acceleration REAL NOT NULL DEFAULT 2.0 CHECK (acceleration > 0), -- Track units/second^2
+                                        stamina REAL NOT NULL DEFAULT 0.7 CHECK (stamina BETWEEN 0 AND 1), -- Share of the track run before tiring
+                                        image_path TEXT, -- Portrait under /static/images/chickens/, NULL for none
+                                        retired_at TIMESTAMP, -- Retired chickens are not entered in new races; NULL while racing
                                         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                                         updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -21,7 +39,12 @@ CREATE TABLE IF NOT EXISTS users (
                                      name TEXT NOT NULL,
                                      email TEXT UNIQUE NOT NULL,
                                      password_hash TEXT NOT NULL,
-                                     balance REAL DEFAULT 1000.0 NOT NULL,
+                                     role TEXT NOT NULL DEFAULT 'player' CHECK (role IN ('player', 'moderator', 'admin')), -- See roles.go; promote the first admin with the promote-admin command
+                                     date_of_birth TEXT, -- YYYY-MM-DD; NULL for accounts created before it was collected, who cannot bet until it is added
+                                     balance INTEGER DEFAULT 0 NOT NULL, -- Cents; only ever changed together with a wallet_transactions entry
+                                     self_excluded_until TIMESTAMP,    -- No betting or bonuses until then; NULL when not excluded
+                                     session_reminder_minutes INTEGER, -- Remind the user how long they have been logged in every this many minutes; NULL for never
+                                     locked_at TIMESTAMP, -- Locked accounts cannot log in; NULL when not locked (see user_admin.go)
                                      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                                      updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -34,11 +57,44 @@ CREATE TABLE IF NOT EXISTS races (
                                      winner_chicken_id INTEGER,     -- FK to chickens table (ID of the winning chicken)
                                      winner TEXT,                   -- Name of the winning chicken (can be derived or stored)
                                      status TEXT NOT NULL DEFAULT 'Scheduled', -- 'Scheduled', 'Running', 'Finished', 'Cancelled'
+                                     sim_seed INTEGER NOT NULL DEFAULT 0, -- Seed for the deterministic race simulation
+                                     bet_mode TEXT NOT NULL DEFAULT 'FixedOdds' CHECK (bet_mode IN ('FixedOdds', 'Tote')), -- How bets on this race are settled
+                                     takeout REAL NOT NULL DEFAULT 0 CHECK (takeout >= 0 AND takeout < 1), -- Share of a tote pool kept by the house
+                                     cancel_reason TEXT,            -- Why the race was cancelled (its bets are refunded)
                                      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                                      updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                                      FOREIGN KEY (winner_chicken_id) REFERENCES chickens(id)
 );
 
+-- Race Entrants Table (the field of chickens running in each race)
+CREATE TABLE IF NOT EXISTS race_entrants (
+                                             id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                             race_id INTEGER NOT NULL,
+                                             chicken_id INTEGER NOT NULL,
+                                             lane INTEGER NOT NULL CHECK (lane >= 1), -- 1-based lane on the track
+                                             color TEXT NOT NULL,                     -- Colour used for this race
+                                             starting_odds REAL NOT NULL CHECK (starting_odds >= 1.0), -- Price when the race was scheduled
+                                             current_odds REAL NOT NULL CHECK (current_odds >= 1.0),  -- Live price, moved by the odds engine while betting is open
+                                             place_odds REAL NOT NULL CHECK (place_odds >= 1.0),      -- Price for a top 2 finish
+                                             show_odds REAL NOT NULL CHECK (show_odds >= 1.0),        -- Price for a top 3 finish
+                                             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                             UNIQUE (race_id, chicken_id),
+                                             UNIQUE (race_id, lane),
+                                             FOREIGN KEY (race_id) REFERENCES races (id),
+                                             FOREIGN KEY (chicken_id) REFERENCES chickens (id)
+);
+
+-- Race Results Table (the full finishing order of each finished race)
+CREATE TABLE IF NOT EXISTS race_results (
+                                            race_id INTEGER NOT NULL,
+                                            chicken_id INTEGER NOT NULL,
+                                            position INTEGER NOT NULL CHECK (position >= 1), -- 1 is the winner
+                                            PRIMARY KEY (race_id, position),
+                                            UNIQUE (race_id, chicken_id),
+                                            FOREIGN KEY (race_id) REFERENCES races (id),
+                                            FOREIGN KEY (chicken_id) REFERENCES chickens (id)
+);
+
 -- Bet Statuses Table
 CREATE TABLE IF NOT EXISTS bet_statuses (
                                             id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -51,10 +107,13 @@ CREATE TABLE IF NOT EXISTS bets (
                                     user_id INTEGER NOT NULL,
                                     race_id INTEGER NOT NULL,
                                     chicken_id INTEGER NOT NULL,
-                                    bet_amount REAL NOT NULL CHECK (bet_amount > 0),
+                                    bet_type TEXT NOT NULL DEFAULT 'Win' CHECK (bet_type IN ('Win', 'Place', 'Show', 'Exacta', 'Quinella', 'Trifecta', 'Accumulator')),
+                                    bet_amount INTEGER NOT NULL CHECK (bet_amount > 0), -- Money columns are integer cents (see money.go)
                                     bet_status_id INTEGER NOT NULL,
-                                    potential_payout REAL, 
-                                    actual_payout REAL DEFAULT 0,
+                                    odds REAL,                     -- Fixed-odds price the bet was struck at (NULL for tote bets; the legs' combined price for accumulators)
+                                    potential_payout INTEGER,
+                                    actual_payout INTEGER DEFAULT 0,
+                                    settled_at TIMESTAMP,          -- When the bet left Pending (won, lost, cancelled or cashed out)
                                     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                                     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                                     FOREIGN KEY (user_id) REFERENCES users (id),
@@ -63,6 +122,163 @@ CREATE TABLE IF NOT EXISTS bets (
                                     FOREIGN KEY (bet_status_id) REFERENCES bet_statuses (id)
 );
 
+-- Bet Selections Table (the chickens a bet names, in pick order; order matters for exacta and trifecta)
+CREATE TABLE IF NOT EXISTS bet_selections (
+                                              bet_id INTEGER NOT NULL,
+                                              pick INTEGER NOT NULL CHECK (pick >= 1), -- 1 is the first chicken picked
+                                              chicken_id INTEGER NOT NULL,
+                                              PRIMARY KEY (bet_id, pick),
+                                              UNIQUE (bet_id, chicken_id),
+                                              FOREIGN KEY (bet_id) REFERENCES bets (id),
+                                              FOREIGN KEY (chicken_id) REFERENCES chickens (id)
+);
+
+-- Bet Legs Table (the win picks of an accumulator, one race per leg; the parent bet names the first leg)
+CREATE TABLE IF NOT EXISTS bet_legs (
+                                        bet_id INTEGER NOT NULL,
+                                        leg INTEGER NOT NULL CHECK (leg >= 1), -- Legs settle in race order
+                                        race_id INTEGER NOT NULL,
+                                        chicken_id INTEGER NOT NULL,
+                                        odds REAL NOT NULL CHECK (odds >= 1.0), -- Win price taken for this leg
+                                        status TEXT NOT NULL DEFAULT 'Pending' CHECK (status IN ('Pending', 'Won', 'Lost', 'Void')),
+                                        PRIMARY KEY (bet_id, leg),
+                                        UNIQUE (bet_id, race_id),
+                                        FOREIGN KEY (bet_id) REFERENCES bets (id),
+                                        FOREIGN KEY (race_id) REFERENCES races (id),
+                                        FOREIGN KEY (chicken_id) REFERENCES chickens (id)
+);
+
+-- Wallet Transactions Table (append-only ledger of every balance change; users.balance must equal the sum of a user's entries)
+CREATE TABLE IF NOT EXISTS wallet_transactions (
+                                                   id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                                   user_id INTEGER NOT NULL,
+                                                   kind TEXT NOT NULL CHECK (kind IN ('Opening', 'Stake', 'Payout', 'Refund', 'CashOut', 'Bonus', 'Adjustment')),
+                                                   amount INTEGER NOT NULL, -- Cents; credit to the wallet when positive, debit when negative; house_transactions records the other side
+                                                   balance_after INTEGER NOT NULL,
+                                                   bet_id INTEGER,  -- Bet the entry settles, if any
+                                                   race_id INTEGER, -- Race the entry belongs to, if any
+                                                   note TEXT,
+                                                   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                                   FOREIGN KEY (user_id) REFERENCES users (id),
+                                                   FOREIGN KEY (bet_id) REFERENCES bets (id),
+                                                   FOREIGN KEY (race_id) REFERENCES races (id)
+);
+
+-- Ledger entries are never changed; mistakes are corrected with an Adjustment entry
+CREATE TRIGGER IF NOT EXISTS wallet_transactions_no_update BEFORE UPDATE ON wallet_transactions
+BEGIN
+    SELECT RAISE(ABORT, 'wallet_transactions is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS wallet_transactions_no_delete BEFORE DELETE ON wallet_transactions
+BEGIN
+    SELECT RAISE(ABORT, 'wallet_transactions is append-only');
+END;
+
+-- House Transactions Table (the house's side of every wallet entry, so each entry balances to zero; see wallet.go)
+CREATE TABLE IF NOT EXISTS house_transactions (
+                                                  id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                                  wallet_transaction_id INTEGER NOT NULL UNIQUE, -- The wallet entry this balances
+                                                  account TEXT NOT NULL CHECK (account IN ('Betting', 'Promotions', 'Adjustments')),
+                                                  amount INTEGER NOT NULL, -- Cents; always the wallet entry's amount negated
+                                                  balance_after INTEGER NOT NULL, -- Running balance of the house account
+                                                  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                                  FOREIGN KEY (wallet_transaction_id) REFERENCES wallet_transactions (id)
+);
+
+CREATE TRIGGER IF NOT EXISTS house_transactions_no_update BEFORE UPDATE ON house_transactions
+BEGIN
+    SELECT RAISE(ABORT, 'house_transactions is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS house_transactions_no_delete BEFORE DELETE ON house_transactions
+BEGIN
+    SELECT RAISE(ABORT, 'house_transactions is append-only');
+END;
+
+-- Promotions Table (bonuses users can claim; see bonus.go)
+CREATE TABLE IF NOT EXISTS promotions (
+                                          id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                          kind TEXT NOT NULL CHECK (kind IN ('Signup', 'Daily', 'PromoCode')),
+                                          code TEXT UNIQUE,  -- Upper-case code users redeem; NULL unless kind is PromoCode
+                                          name TEXT NOT NULL, -- Shown to users and used as the ledger note
+                                          amount INTEGER NOT NULL CHECK (amount > 0), -- Cents credited per claim
+                                          wagering_multiplier REAL NOT NULL DEFAULT 0 CHECK (wagering_multiplier >= 0), -- Stake to settle before the next promo code, times amount
+                                          max_claims_per_user INTEGER, -- NULL for no limit; Daily promotions are also limited to one claim a day
+                                          max_claims_total INTEGER,    -- NULL for no limit
+                                          starts_at TIMESTAMP,         -- NULL to start straight away
+                                          ends_at TIMESTAMP,           -- NULL to run until switched off
+                                          active INTEGER NOT NULL DEFAULT 1,
+                                          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Bonus Claims Table (every promotion a user has received, with its wagering progress)
+CREATE TABLE IF NOT EXISTS bonus_claims (
+                                            id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                            promotion_id INTEGER NOT NULL,
+                                            user_id INTEGER NOT NULL,
+                                            amount INTEGER NOT NULL,                      -- Cents granted
+                                            wagering_required INTEGER NOT NULL DEFAULT 0, -- Cents of settled stakes needed to clear the bonus
+                                            wagered INTEGER NOT NULL DEFAULT 0,           -- Cents of settled stakes counted so far
+                                            claim_day TEXT, -- Local date of a Daily claim; NULL for other kinds
+                                            claim_number INTEGER, -- 1 for the user's first claim of a Signup or PromoCode promotion, 2 for the next; NULL for Daily claims
+                                            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                            UNIQUE (promotion_id, user_id, claim_day),
+                                            FOREIGN KEY (promotion_id) REFERENCES promotions (id),
+                                            FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- Date of Birth Corrections Table (every override of a date of birth after signup; see age.go)
+CREATE TABLE IF NOT EXISTS date_of_birth_corrections (
+                                                         id INTEGER PRIMARY KEY AUTOINCREMENT,
+                                                         user_id INTEGER NOT NULL,
+                                                         previous_date_of_birth TEXT, -- NULL when none was recorded
+                                                         date_of_birth TEXT NOT NULL,
+                                                         reason TEXT NOT NULL,
+                                                         corrected_by TEXT NOT NULL,
+                                                         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                                         FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- Audit Events Table (append-only, hash-chained log of privileged and financial actions; see audit.go)
+CREATE TABLE IF NOT EXISTS audit_events (
+                                            id INTEGER PRIMARY KEY,  -- Sequential, assigned by recordAuditEvent; a gap means an event was removed
+                                            created_at TEXT NOT NULL, -- RFC 3339 in UTC, as hashed
+                                            actor_id INTEGER NOT NULL DEFAULT 0, -- User ID; 0 for the system and the command line
+                                            actor TEXT NOT NULL,     -- Name of the user at the time, 'system' or 'cli:<os user>'
+                                            ip TEXT NOT NULL DEFAULT '',
+                                            action TEXT NOT NULL,    -- e.g. 'race.finished'; see the Audit constants
+                                            target_type TEXT NOT NULL DEFAULT '',
+                                            target_id TEXT NOT NULL DEFAULT '',
+                                            before_value TEXT NOT NULL DEFAULT '', -- JSON
+                                            after_value TEXT NOT NULL DEFAULT '',  -- JSON
+                                            reason TEXT NOT NULL DEFAULT '',
+                                            prev_hash TEXT NOT NULL, -- hash of the event before; 64 zeros for the first
+                                            hash TEXT NOT NULL UNIQUE -- SHA-256 over the fields above
+);
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+-- Gambling Limits Table (limits users set on themselves; see responsible_gambling.go)
+CREATE TABLE IF NOT EXISTS gambling_limits (
+                                               user_id INTEGER NOT NULL,
+                                               kind TEXT NOT NULL CHECK (kind IN ('DailyLoss', 'WeeklyLoss', 'MonthlyLoss', 'StakePerBet', 'DailyStake')),
+                                               amount INTEGER,         -- Cents; NULL when no limit is in force
+                                               pending_amount INTEGER, -- Raised limit waiting out the cooling-off period; NULL with pending_from set removes the limit
+                                               pending_from TIMESTAMP, -- When the pending change takes effect; NULL when nothing is pending
+                                               updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                                               PRIMARY KEY (user_id, kind),
+                                               FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
 CREATE TABLE IF NOT EXISTS contact_messages
 (
     id         INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -75,22 +291,34 @@ CREATE TABLE IF NOT EXISTS contact_messages
 
 -- Insert sample data for bet_statuses
 -- Make sure these align with what your Go code expects (Pending, Won, Lost)
-INSERT INTO bet_statuses (status_name) VALUES ('Pending'), ('Won'), ('Lost'), ('Cancelled');
+INSERT INTO bet_statuses (status_name) VALUES ('Pending'), ('Won'), ('Lost'), ('Cancelled'), ('Cashed Out');
 -- The old 'Completed' might be ambiguous; 'Won'/'Lost' are more specific for betting.
 
--- Insert sample data for users
-INSERT INTO users (name, email, password_hash, balance) VALUES
-                                                            ('John Doe', 'john.doe@example.com', '$2a$10$abcdefghijklmnopqrstuvwx', 1000.0),
-                                                            ('Jane Smith', 'jane.smith@example.com', '$2a$10$zyxwvutsrqponmlkjihgfedcb', 1000.0);
+-- Insert sample data for users (balances in cents)
+INSERT INTO users (name, email, password_hash, date_of_birth, balance) VALUES
+                                                            ('John Doe', 'john.doe@example.com', '$2a$10$abcdefghijklmnopqrstuvwx', '1985-04-12', 100000),
+                                                            ('Jane Smith', 'jane.smith@example.com', '$2a$10$zyxwvutsrqponmlkjihgfedcb', '1992-11-03', 100000);
+
+-- Opening ledger entries for the sample users (the sample bets below predate the ledger and are not posted)
+INSERT INTO wallet_transactions (user_id, kind, amount, balance_after, note)
+SELECT id, 'Opening', balance, balance, 'Starting balance' FROM users;
+INSERT INTO house_transactions (wallet_transaction_id, account, amount, balance_after)
+SELECT w.id, 'Promotions', -w.amount, -(SELECT SUM(p.amount) FROM wallet_transactions p WHERE p.id <= w.id) FROM wallet_transactions w ORDER BY w.id;
+
+-- Sample promotions (amounts in cents)
+INSERT INTO promotions (kind, code, name, amount, wagering_multiplier, max_claims_per_user) VALUES
+                                                                                               ('Signup', NULL, 'Welcome bonus', 25000, 1, 1),
+                                                                                               ('Daily', NULL, 'Daily top-up', 10000, 0, NULL),
+                                                                                               ('PromoCode', 'EGGSTRA', 'EGGSTRA promo code', 20000, 3, 1);
 
 -- Insert sample data for chickens
--- Ensure availableChickens in your Go code can be populated from this or matches this
-INSERT INTO chickens (name, odds) VALUES
-                                      ('Henrietta', 2.5),          -- ID 1
-                                      ('Cluck Norris', 1.8),       -- ID 2
-                                      ('Foghorn Leghorn Jr.', 3.0), -- ID 3
-                                      ('The Eggsecutioner', 4.5),  -- ID 4
-                                      ('Speedy Gonzales', 2.2);    -- ID 5 (just kidding, it's a chicken race!)
+-- Race fields are drawn from this table by scheduleNewRace (see race_entrants)
+INSERT INTO chickens (name, odds, color, speed, acceleration, stamina) VALUES
+                                                                             ('Henrietta', 2.5, '#ef4444', 6.2, 2.0, 0.75),           -- ID 1
+                                                                             ('Cluck Norris', 1.8, '#3b82f6', 6.6, 2.4, 0.80),        -- ID 2
+                                                                             ('Foghorn Leghorn Jr.', 3.0, '#22c55e', 6.0, 1.8, 0.70), -- ID 3
+                                                                             ('The Eggsecutioner', 4.5, '#a855f7', 5.6, 2.6, 0.60),   -- ID 4
+                                                                             ('Speedy Gonzales', 2.2, '#f59e0b', 6.4, 2.8, 0.65);     -- ID 5 (just kidding, it's a chicken race!)
 
 -- Insert sample data for races (past/completed examples)
 -- Assume bet_statuses: Pending=1, Won=2, Lost=3, Cancelled=4 (based on insertion order)
@@ -100,22 +328,33 @@ INSERT INTO races (name, date, winner_chicken_id, winner, status) VALUES
                                                                       ('Feathered Fury Derby', '2025-02-14 15:30:00', 2, 'Cluck Norris', 'Finished');
 
 -- Add a new race that is open for betting (no winner yet, future date)
-INSERT INTO races (name, date, status) VALUES
-    ('Upcoming Eggstravaganza', '2025-06-01 14:00:00', 'Scheduled');
+INSERT INTO races (name, date, status, sim_seed) VALUES
+    ('Upcoming Eggstravaganza', '2025-06-01 14:00:00', 'Scheduled', 20250601);
+
+-- Insert sample fields for the races above (lane, colour and starting odds are fixed per race)
+-- Place and show prices use the traditional fractions of the win odds; scheduled races are priced by the server
+INSERT INTO race_entrants (race_id, chicken_id, lane, color, starting_odds, current_odds, place_odds, show_odds)
+SELECT r.id, c.id, c.id, c.color, c.odds, c.odds, ROUND(1 + (c.odds - 1) / 2, 2), ROUND(1 + (c.odds - 1) / 4, 2)
+FROM races r CROSS JOIN chickens c;
+
+-- Insert sample results for the finished races
+INSERT INTO race_results (race_id, chicken_id, position) VALUES
+                                                             (1, 1, 1), (1, 2, 2), (1, 5, 3), (1, 3, 4), (1, 4, 5),
+                                                             (2, 2, 1), (2, 5, 2), (2, 1, 3), (2, 3, 4), (2, 4, 5);
 
 -- Insert sample data for bets
 -- User 1 (John Doe) bet on Henrietta (Chicken ID 1) for Race 1 (The Grand Cluck Off). Henrietta won.
-INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id, actual_payout)
-VALUES (1, 1, 1, 50.0, (SELECT id FROM bet_statuses WHERE status_name = 'Won'), 50.0 * (SELECT odds FROM chickens WHERE id = 1) ); -- Payout = bet * odds
+INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id, actual_payout, settled_at)
+VALUES (1, 1, 1, 5000, (SELECT id FROM bet_statuses WHERE status_name = 'Won'), CAST(5000 * (SELECT odds FROM chickens WHERE id = 1) AS INTEGER), CURRENT_TIMESTAMP); -- Payout = bet * odds, in cents
 
 -- User 2 (Jane Smith) bet on Foghorn (Chicken ID 3) for Race 2 (Feathered Fury Derby). Foghorn lost to Cluck Norris.
-INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id, actual_payout)
-VALUES (2, 2, 3, 25.0, (SELECT id FROM bet_statuses WHERE status_name = 'Lost'), 0.0);
+INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id, actual_payout, settled_at)
+VALUES (2, 2, 3, 2500, (SELECT id FROM bet_statuses WHERE status_name = 'Lost'), 0, CURRENT_TIMESTAMP);
 
 -- Example of a pending bet for the "Upcoming Eggstravaganza" race (Race ID 3)
 -- User 1 (John Doe) bets on Cluck Norris (Chicken ID 2) for Race 3. (Status: Pending)
 INSERT INTO bets (user_id, race_id, chicken_id, bet_amount, bet_status_id)
-VALUES (1, 3, 2, 100.0, (SELECT id FROM bet_statuses WHERE status_name = 'Pending'));
+VALUES (1, 3, 2, 10000, (SELECT id FROM bet_statuses WHERE status_name = 'Pending'));
 
 
 -- Create indexes
@@ -124,4 +363,13 @@ CREATE INDEX IF NOT EXISTS idx_bets_race_id ON bets (race_id);
 CREATE INDEX IF NOT EXISTS idx_bets_chicken_id ON bets (chicken_id); -- Index for chicken_id in bets
 CREATE INDEX IF NOT EXISTS idx_users_email ON users (email);
 CREATE INDEX IF NOT EXISTS idx_races_status_date ON races (status, date); -- Useful for finding races to start/bet on
-CREATE INDEX IF NOT EXISTS idx_chickens_name ON chickens (name);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_chickens_name ON chickens (name);
+CREATE INDEX IF NOT EXISTS idx_race_entrants_race_id ON race_entrants (race_id);
+CREATE INDEX IF NOT EXISTS idx_bet_legs_race_id ON bet_legs (race_id);
+CREATE INDEX IF NOT EXISTS idx_wallet_transactions_user_id ON wallet_transactions (user_id);
+CREATE INDEX IF NOT EXISTS idx_wallet_transactions_bet_id ON wallet_transactions (bet_id); -- Balance after each bet in the history and exports
+CREATE INDEX IF NOT EXISTS idx_bonus_claims_user_id ON bonus_claims (user_id);
+-- UNIQUE (promotion_id, user_id, claim_day) cannot catch a second Signup or PromoCode claim, whose claim_day is NULL
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bonus_claims_number ON bonus_claims (promotion_id, user_id, claim_number) WHERE claim_day IS NULL;
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
\ No newline at end of file